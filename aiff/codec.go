@@ -0,0 +1,125 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package aiff
+
+import (
+	"bufio"
+	"io"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Codec is the aiff codec.Codec, registered with package codec when
+// package aiff is imported.
+var Codec codec.Codec = aiffCodec{}
+
+func init() {
+	codec.RegisterCodec(Codec)
+}
+
+type aiffCodec struct {
+	codec.NullCodec
+}
+
+var (
+	_ codec.Prober            = aiffCodec{}
+	_ codec.ConfidenceSniffer = aiffCodec{}
+	_ codec.Describer         = aiffCodec{}
+)
+
+// Describe implements codec.Describer.  Encoding requires the destination
+// to be a WriteSeekerCloser.
+func (c aiffCodec) Describe() codec.Description {
+	return codec.Description{
+		Name:         "aiff",
+		MIMETypes:    []string{"audio/aiff", "audio/x-aiff"},
+		Capabilities: codec.CanDecode | codec.CanSeek | codec.CanEncode,
+		SampleCodecs: sampleCodecs}
+}
+
+// sampleCodecs lists the sample codecs which can be encoded.
+var sampleCodecs = []sample.Codec{
+	sample.SByte,
+	sample.SInt16B, sample.SInt24B, sample.SInt32B,
+	sample.SInt16L, sample.SInt24L, sample.SInt32L,
+	sample.SFloat32B, sample.SFloat64B}
+
+func (c aiffCodec) Extensions() []string {
+	return []string{".aif", ".aiff", ".aifc"}
+}
+
+func (c aiffCodec) Sniff(br *bufio.Reader) bool {
+	return c.SniffConfidence(br) > codec.SniffNone
+}
+
+// SniffConfidence implements codec.ConfidenceSniffer.
+func (c aiffCodec) SniffConfidence(br *bufio.Reader) int {
+	buf, err := br.Peek(formHdrSize)
+	if err != nil {
+		return codec.SniffNone
+	}
+	if string(buf[:4]) != "FORM" {
+		return codec.SniffNone
+	}
+	if typ := string(buf[8:12]); typ != "AIFF" && typ != "AIFC" {
+		return codec.SniffNone
+	}
+	return codec.SniffStrong
+}
+
+func (c aiffCodec) DefaultSampleCodec() sample.Codec {
+	return sample.SInt16B
+}
+
+func (c aiffCodec) Decoder(r io.ReadCloser) (sound.Source, sample.Codec, error) {
+	d, err := NewStreamDecoder(r)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	return d, d.Codec(), nil
+}
+
+func (c aiffCodec) SeekingDecoder(r codec.IoReadSeekCloser) (sound.SourceSeeker, sample.Codec, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	return d, d.Codec(), nil
+}
+
+func (c aiffCodec) Encoder(w io.WriteCloser, v sound.Form, sc sample.Codec) (sound.Sink, error) {
+	ws, ok := w.(WriteSeekerCloser)
+	if !ok {
+		return nil, codec.ErrUnsupportedFunction
+	}
+	if sc == codec.AnySampleCodec {
+		sc = c.DefaultSampleCodec()
+	}
+	return NewEncoder(ws, v, sc)
+}
+
+// Probe implements codec.Prober, reading all the chunks of r but not the
+// sample data.
+func (c aiffCodec) Probe(r io.ReadSeeker) (*codec.StreamInfo, error) {
+	h, err := readHeader(r, true)
+	if err != nil {
+		return nil, err
+	}
+	container := "aiff"
+	if h.form == "AIFC" {
+		container = "aifc"
+	}
+	frames := h.f.frames
+	if n := h.size / int64(h.f.bpf()); n < frames {
+		frames = n
+	}
+	return &codec.StreamInfo{
+		Container:   container,
+		Channels:    h.f.Channels(),
+		SampleRate:  h.f.SampleRate(),
+		SampleCodec: h.f.codec,
+		Frames:      frames}, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package aiff
+
+import (
+	"bytes"
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+func TestConformance(t *testing.T) {
+	codectest.Run(t, Codec, nil)
+}
+
+func TestRegistered(t *testing.T) {
+	for _, ext := range []string{".aif", ".aiff", ".aifc"} {
+		if _, err := codec.CodecFor("x"+ext, nil); err != nil {
+			t.Errorf("%s: %v", ext, err)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	for _, tc := range []struct {
+		sc        sample.Codec
+		container string
+	}{
+		{sample.SInt24B, "aiff"},
+		{sample.SByte, "aiff"},
+		{sample.SInt16L, "aifc"},
+		{sample.SFloat64B, "aifc"},
+	} {
+		w := codectest.NewFile(nil)
+		v := sound.StereoCd()
+		e, err := NewEncoder(w, v, tc.sc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// an odd number of bytes, for SByte.
+		if err := e.Send(make([]float64, 2*441)); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Send(make([]float64, 2)); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if len(w.Bytes())&1 != 0 {
+			t.Errorf("%s: odd file size %d", tc.sc, len(w.Bytes()))
+		}
+		info, err := codec.Probe(bytes.NewReader(w.Bytes()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Container != tc.container {
+			t.Errorf("%s: container %q != %q", tc.sc, info.Container, tc.container)
+		}
+		if info.Channels != 2 || info.SampleRate != 44100*freq.Hertz {
+			t.Errorf("%s: form %d channels at %s", tc.sc, info.Channels, info.SampleRate)
+		}
+		if info.SampleCodec != tc.sc {
+			t.Errorf("%s: sample codec %s", tc.sc, info.SampleCodec)
+		}
+		if info.Frames != 442 {
+			t.Errorf("%s: frames %d != 442", tc.sc, info.Frames)
+		}
+	}
+}
+
+func TestInvalidForm(t *testing.T) {
+	for _, v := range []sound.Form{
+		sound.NewForm(44100*freq.Hertz, 0),
+		sound.NewForm(44100*freq.Hertz, 1<<15),
+		sound.NewForm(0, 1),
+		sound.NewForm(-44100*freq.Hertz, 1),
+	} {
+		if _, err := NewEncoder(codectest.NewFile(nil), v, sample.SInt16B); err == nil {
+			t.Errorf("%d channels at %s: no error", v.Channels(), v.SampleRate())
+		}
+	}
+}
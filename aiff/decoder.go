@@ -0,0 +1,329 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package aiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Decoder decodes an AIFF or AIFF-C file.
+type Decoder struct {
+	*header
+	r    io.Reader
+	c    io.Closer
+	s    io.Seeker // nil if not seekable
+	base int64     // offset in r of the start of the file
+
+	buf  []byte    // encoded frames
+	tmp  []float64 // interleaved decoded frames
+	pos  int64     // frame position
+	nFrm int64     // number of frames
+}
+
+// ReadSeekerCloser is the source of a seeking Decoder.
+type ReadSeekerCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// header holds the information in the chunks of a file.
+type header struct {
+	form    string
+	f       *format
+	data    int64 // offset of the sample data
+	size    int64 // size of the sample data, or -1 if unknown
+	markers []Marker
+	inst    *Instrument
+}
+
+// NewDecoder creates a decoder from an AIFF or AIFF-C file, which starts
+// at the current offset of r.
+//
+// NewDecoder reads all the chunks of the file, so the markers and
+// instrument are available wherever their chunks are.
+func NewDecoder(r ReadSeekerCloser) (*Decoder, error) {
+	base, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readHeader(r, true)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(base+h.data, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return newDecoder(h, r, r, r, base), nil
+}
+
+// NewStreamDecoder creates a decoder from an AIFF or AIFF-C file read
+// sequentially from r.  The decoder cannot seek, and only has the markers
+// and instrument of chunks preceding the sample data.
+func NewStreamDecoder(r io.ReadCloser) (*Decoder, error) {
+	h, err := readHeader(struct{ io.Reader }{r}, false)
+	if err != nil {
+		return nil, err
+	}
+	return newDecoder(h, r, r, nil, 0), nil
+}
+
+func newDecoder(h *header, r io.Reader, c io.Closer, s io.Seeker, base int64) *Decoder {
+	bpf := h.f.bpf()
+	bufFrames := maxChunkAlloc / bpf
+	if bufFrames > 1024 {
+		bufFrames = 1024
+	}
+	nFrm := h.f.frames
+	if h.size >= 0 && h.size/int64(bpf) < nFrm {
+		nFrm = h.size / int64(bpf)
+	}
+	return &Decoder{
+		header: h,
+		r:      r,
+		c:      c,
+		s:      s,
+		base:   base,
+		buf:    make([]byte, bufFrames*bpf),
+		nFrm:   nFrm}
+}
+
+// readHeader reads the chunks of a file from r, which is positioned at
+// its start.  If seekable, all chunks are read, otherwise reading stops
+// at the start of the sample data.
+func readHeader(r io.Reader, seekable bool) (*header, error) {
+	form, end, err := readForm(r)
+	if err != nil {
+		return nil, err
+	}
+	h := &header{form: form, data: -1}
+	off := int64(formHdrSize)
+	for off+chunkHdrSize <= end {
+		c, err := readChunk(r, off)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		off = c.end()
+		switch c.id {
+		case "SSND":
+			if h.data >= 0 {
+				return nil, errs.Decode(codec.ErrCorrupt, c.start, c.id, "second sound data chunk")
+			}
+			var b [8]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return nil, errs.Read(err, c.data(), c.id)
+			}
+			dOff := int64(binary.BigEndian.Uint32(b[:4]))
+			h.data = c.data() + 8 + dOff
+			h.size = c.size - 8 - dOff
+			if !seekable {
+				if h.f == nil {
+					return nil, errs.Decode(codec.ErrUnsupportedFormat, c.start, c.id, "sound data precedes common chunk in unseekable input")
+				}
+				if err := skip(r, dOff); err != nil {
+					return nil, errs.Read(err, c.data()+8, c.id)
+				}
+				// the size is only used to limit reading, which
+				// stops at the end of the data anyway.
+				h.size = -1
+				return h, nil
+			}
+			if err := skip(r, c.end()-c.data()-8); err != nil {
+				return nil, errs.Read(err, c.data()+8, c.id)
+			}
+			continue
+		case "COMM":
+			if h.f != nil {
+				return nil, errs.Decode(codec.ErrCorrupt, c.start, c.id, "second common chunk")
+			}
+			d, err := readChunkData(r, c, commSize+4+256)
+			if err != nil {
+				return nil, err
+			}
+			if h.f, err = parseComm(d, c.data(), form == "AIFC"); err != nil {
+				return nil, err
+			}
+			continue
+		case "MARK", "INST":
+			if c.size > maxChunkAlloc {
+				break
+			}
+			d, err := readChunkData(r, c, maxChunkAlloc)
+			if err != nil {
+				return nil, err
+			}
+			if c.id == "MARK" {
+				h.markers, err = parseMark(d, c.data())
+			} else {
+				h.inst, err = parseInst(d, c.data())
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := skip(r, c.end()-c.data()); err != nil {
+			return nil, errs.Read(err, c.data(), c.id)
+		}
+	}
+	if h.f == nil {
+		return nil, errs.Decode(codec.ErrTruncated, off, "", "no common chunk")
+	}
+	if h.data < 0 {
+		return nil, errs.Decode(codec.ErrTruncated, off, "", "no sound data chunk")
+	}
+	if h.size < 0 {
+		return nil, errs.Decode(codec.ErrCorrupt, h.data, "SSND", "data offset beyond chunk")
+	}
+	return h, nil
+}
+
+// readChunkData reads the data of c, of which at most max bytes are
+// returned and the rest skipped, along with any pad byte.
+func readChunkData(r io.Reader, c *chunk, max int64) ([]byte, error) {
+	n := c.size
+	if n > max {
+		n = max
+	}
+	d := make([]byte, n)
+	if _, err := io.ReadFull(r, d); err != nil {
+		return nil, errs.Read(err, c.data(), c.id)
+	}
+	if err := skip(r, c.end()-c.data()-n); err != nil {
+		return nil, errs.Read(err, c.data()+n, c.id)
+	}
+	return d, nil
+}
+
+var _ sound.SourceSeeker = (*Decoder)(nil)
+
+// Codec returns the sample codec of the data, or codec.AnySampleCodec for
+// mu-law and A-law.
+func (d *Decoder) Codec() sample.Codec {
+	return d.f.codec
+}
+
+// Compression returns the AIFF-C compression type, which is "NONE" for
+// AIFF files.
+func (d *Decoder) Compression() string {
+	return d.f.comp
+}
+
+// AIFC returns whether the file is AIFF-C.
+func (d *Decoder) AIFC() bool {
+	return d.form == "AIFC"
+}
+
+// Markers returns the markers of the MARK chunk.
+func (d *Decoder) Markers() []Marker {
+	return d.markers
+}
+
+// Instrument returns the instrument of the INST chunk, or nil if there is
+// none.
+func (d *Decoder) Instrument() *Instrument {
+	return d.inst
+}
+
+func (d *Decoder) SampleRate() freq.T {
+	return d.f.SampleRate()
+}
+
+func (d *Decoder) Channels() int {
+	return d.f.Channels()
+}
+
+func (d *Decoder) Receive(dst []float64) (int, error) {
+	nC := d.Channels()
+	if len(dst)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := int64(len(dst) / nC)
+	if rem := d.nFrm - d.pos; nF > rem {
+		nF = rem
+	}
+	if nF <= 0 {
+		return 0, io.EOF
+	}
+	if cap(d.tmp) < int(nF)*nC {
+		d.tmp = make([]float64, int(nF)*nC)
+	}
+	tmp := d.tmp[:int(nF)*nC]
+	bpf := d.f.bpf()
+	n := 0
+	for n < int(nF) {
+		m := int(nF) - n
+		if mx := len(d.buf) / bpf; m > mx {
+			m = mx
+		}
+		k, err := io.ReadFull(d.r, d.buf[:m*bpf])
+		k /= bpf
+		d.f.decode(tmp[n*nC:(n+k)*nC], d.buf[:k*bpf])
+		n += k
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// the sound data chunk is truncated.
+			d.nFrm = d.pos + int64(n)
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	for f := 0; f < n; f++ {
+		for c := 0; c < nC; c++ {
+			dst[c*n+f] = tmp[f*nC+c]
+		}
+	}
+	d.pos += int64(n)
+	return n, nil
+}
+
+// Len returns the number of frames.  For a decoder from NewStreamDecoder,
+// it is the number in the common chunk, which a truncated file may not
+// contain.
+func (d *Decoder) Len() int64 {
+	return d.nFrm
+}
+
+func (d *Decoder) Pos() int64 {
+	return d.pos
+}
+
+func (d *Decoder) Duration() time.Duration {
+	return time.Duration(d.Len()) * d.SampleRate().Period()
+}
+
+// Seek seeks to frame f, returning an error if f is negative or the
+// decoder cannot seek.  Seeking beyond the end is allowed, after which
+// Receive returns io.EOF.
+func (d *Decoder) Seek(f int64) error {
+	if d.s == nil {
+		return codec.ErrUnsupportedFunction
+	}
+	if f < 0 {
+		return fmt.Errorf("aiff: seek to negative frame %d", f)
+	}
+	if _, err := d.s.Seek(d.base+d.data+f*int64(d.f.bpf()), io.SeekStart); err != nil {
+		return err
+	}
+	d.pos = f
+	return nil
+}
+
+func (d *Decoder) Close() error {
+	return d.c.Close()
+}
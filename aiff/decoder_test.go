@@ -0,0 +1,218 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package aiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// chunkBytes returns a chunk with data d, padded to even length.
+func chunkBytes(id string, d []byte) []byte {
+	b := make([]byte, chunkHdrSize, chunkHdrSize+len(d)+1)
+	copy(b, id)
+	binary.BigEndian.PutUint32(b[4:8], uint32(len(d)))
+	b = append(b, d...)
+	if len(d)&1 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// fileBytes returns a FORM of type form containing chunks.
+func fileBytes(form string, chunks ...[]byte) []byte {
+	b := append([]byte("FORM\x00\x00\x00\x00"), form...)
+	for _, c := range chunks {
+		b = append(b, c...)
+	}
+	binary.BigEndian.PutUint32(b[4:8], uint32(len(b)-chunkHdrSize))
+	return b
+}
+
+// commBytes returns a COMM chunk, which is AIFF-C if comp is not empty.
+func commBytes(channels, frames, bits int, rate float64, comp string) []byte {
+	d := make([]byte, commSize)
+	binary.BigEndian.PutUint16(d[0:2], uint16(channels))
+	binary.BigEndian.PutUint32(d[2:6], uint32(frames))
+	binary.BigEndian.PutUint16(d[6:8], uint16(bits))
+	encodeExtended(d[8:18], rate)
+	if comp != "" {
+		d = append(d, comp...)
+		d = append(d, 0, 0)
+	}
+	return chunkBytes("COMM", d)
+}
+
+// ssndBytes returns an SSND chunk with data d preceded by off bytes.
+func ssndBytes(off int, d []byte) []byte {
+	b := make([]byte, 8+off, 8+off+len(d))
+	binary.BigEndian.PutUint32(b[0:4], uint32(off))
+	return chunkBytes("SSND", append(b, d...))
+}
+
+func decodeAll(t *testing.T, d *Decoder) []float64 {
+	t.Helper()
+	var res []float64
+	buf := make([]float64, 3*d.Channels())
+	for {
+		n, err := d.Receive(buf)
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, buf[:n*d.Channels()]...)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	for _, tc := range []struct {
+		comp  string
+		bits  int
+		data  []byte
+		want  []float64
+		codec sample.Codec
+	}{
+		{"", 16, []byte{0x40, 0, 0xc0, 0}, []float64{.5, -.5}, sample.SInt16B},
+		{"NONE", 8, []byte{0x40, 0xc0}, []float64{.5, -.5}, sample.SByte},
+		{"twos", 24, []byte{0x40, 0, 0, 0xc0, 0, 0}, []float64{.5, -.5}, sample.SInt24B},
+		{"sowt", 16, []byte{0, 0x40, 0, 0xc0}, []float64{.5, -.5}, sample.SInt16L},
+		{"fl32", 32, []byte{0x3f, 0, 0, 0, 0xbf, 0, 0, 0}, []float64{.5, -.5}, sample.SFloat32B},
+		{"fl64", 64, []byte{0x3f, 0xe0, 0, 0, 0, 0, 0, 0, 0xbf, 0xe0, 0, 0, 0, 0, 0, 0}, []float64{.5, -.5}, sample.SFloat64B},
+		{"ulaw", 16, []byte{0xff, 0x80}, []float64{0, 32124. / 32768}, codec.AnySampleCodec},
+		{"alaw", 16, []byte{0xd5, 0x2a}, []float64{8. / 32768, -32256. / 32768}, codec.AnySampleCodec},
+	} {
+		form := "AIFC"
+		if tc.comp == "" {
+			form = "AIFF"
+		}
+		data := fileBytes(form, commBytes(1, len(tc.want), tc.bits, 8000, tc.comp), ssndBytes(0, tc.data))
+		d, err := NewDecoder(codectest.NewFile(data))
+		if err != nil {
+			t.Errorf("%q: %v", tc.comp, err)
+			continue
+		}
+		if d.Codec() != tc.codec {
+			t.Errorf("%q: codec %s != %s", tc.comp, d.Codec(), tc.codec)
+		}
+		if d.AIFC() != (form == "AIFC") {
+			t.Errorf("%q: AIFC %t", tc.comp, d.AIFC())
+		}
+		if d.SampleRate() != 8000*freq.Hertz {
+			t.Errorf("%q: sample rate %s", tc.comp, d.SampleRate())
+		}
+		got := decodeAll(t, d)
+		if len(got) != len(tc.want) {
+			t.Errorf("%q: got %v want %v", tc.comp, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i]-tc.want[i]) > 1e-9 {
+				t.Errorf("%q: got %v want %v", tc.comp, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestMarkers(t *testing.T) {
+	mark := []byte{0, 2,
+		0, 1, 0, 0, 0, 0, 5, 's', 't', 'a', 'r', 't',
+		0, 2, 0, 0, 0, 3, 3, 'e', 'n', 'd'}
+	inst := []byte{60, 0xfe, 0, 127, 1, 127, 0xff, 0xfa,
+		0, 1, 0, 1, 0, 2,
+		0, 0, 0, 0, 0, 0}
+	data := fileBytes("AIFF",
+		chunkBytes("MARK", mark),
+		commBytes(2, 4, 8, 44100, ""),
+		chunkBytes("INST", inst),
+		ssndBytes(4, make([]byte, 8)),
+		chunkBytes("ANNO", []byte("trailing")))
+	d, err := NewDecoder(codectest.NewFile(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMarkers := []Marker{{ID: 1, Pos: 0, Name: "start"}, {ID: 2, Pos: 3, Name: "end"}}
+	if !reflect.DeepEqual(d.Markers(), wantMarkers) {
+		t.Errorf("markers %+v != %+v", d.Markers(), wantMarkers)
+	}
+	wantInst := &Instrument{
+		BaseNote: 60, Detune: -2, HighNote: 127, LowVelocity: 1, HighVelocity: 127, Gain: -6,
+		Sustain: Loop{Mode: ForwardLooping, Begin: 1, End: 2}}
+	if !reflect.DeepEqual(d.Instrument(), wantInst) {
+		t.Errorf("instrument %+v != %+v", d.Instrument(), wantInst)
+	}
+	if d.Len() != 4 {
+		t.Errorf("len %d != 4", d.Len())
+	}
+	if n := len(decodeAll(t, d)); n != 8 {
+		t.Errorf("decoded %d samples not 8", n)
+	}
+}
+
+func TestSoundDataFirst(t *testing.T) {
+	data := fileBytes("AIFF",
+		ssndBytes(0, []byte{0, 1, 0, 2, 0, 3}),
+		commBytes(1, 3, 16, 8000, ""))
+	d, err := NewDecoder(codectest.NewFile(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Seek(2); err != nil {
+		t.Fatal(err)
+	}
+	got := decodeAll(t, d)
+	if len(got) != 1 || got[0] != 3./32768 {
+		t.Errorf("got %v after seek to frame 2", got)
+	}
+	_, err = NewStreamDecoder(ioutil.NopCloser(bytes.NewReader(data)))
+	if !errors.Is(err, codec.ErrUnsupportedFormat) {
+		t.Errorf("stream decoder gave %v", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	comm := commBytes(1, 1, 16, 8000, "")
+	ssnd := ssndBytes(0, []byte{0, 0})
+	for _, tc := range []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"not iff", []byte("RIFF\x00\x00\x00\x04WAVE"), codec.ErrUnsupportedFormat},
+		{"form type", fileBytes("8SVX"), codec.ErrUnsupportedFormat},
+		{"short", []byte("FORM"), codec.ErrTruncated},
+		{"no comm", fileBytes("AIFF", ssnd), codec.ErrTruncated},
+		{"no ssnd", fileBytes("AIFF", comm), codec.ErrTruncated},
+		{"comm size", fileBytes("AIFF", chunkBytes("COMM", make([]byte, 10)), ssnd), codec.ErrCorrupt},
+		{"channels", fileBytes("AIFF", commBytes(0, 1, 16, 8000, ""), ssnd), codec.ErrCorrupt},
+		{"rate", fileBytes("AIFF", commBytes(1, 1, 16, 0, ""), ssnd), codec.ErrCorrupt},
+		{"bits", fileBytes("AIFF", commBytes(1, 1, 33, 8000, ""), ssnd), codec.ErrUnsupportedFormat},
+		{"compression", fileBytes("AIFC", commBytes(1, 1, 16, 8000, "ima4"), ssnd), codec.ErrUnsupportedFormat},
+		{"two comm", fileBytes("AIFF", comm, comm, ssnd), codec.ErrCorrupt},
+		{"ssnd offset", fileBytes("AIFF", comm, chunkBytes("SSND", []byte{0, 0, 1, 0, 0, 0, 0, 0})), codec.ErrCorrupt},
+	} {
+		_, err := NewDecoder(codectest.NewFile(tc.data))
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: got %v not %v", tc.name, err, tc.err)
+			continue
+		}
+		var de *codec.DecodeError
+		if !errors.As(err, &de) || de.Codec != "aiff" {
+			t.Errorf("%s: %v is not an aiff *codec.DecodeError", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package aiff provides decoding and encoding of AIFF and AIFF-C audio files.
+//
+// Package aiff supports uncompressed data, AIFF-C data with the compression
+// types "NONE", "twos", "sowt", "fl32", "fl64", "ulaw" and "alaw", and
+// reads the markers and loops of MARK and INST chunks.  Importing package
+// aiff registers it with zikichombo.org/codec for the extensions .aif, .aiff
+// and .aifc.
+//
+// Package aiff is part of http://zikichombo.org
+package aiff /* import "zikichombo.org/codec/aiff" */
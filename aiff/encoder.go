@@ -0,0 +1,147 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package aiff
+
+import (
+	"encoding/binary"
+	"io"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Encoder encodes an AIFF or AIFF-C file.
+type Encoder struct {
+	w    WriteSeekerCloser
+	f    *format
+	base int64 // offset in w of the start of the file
+	comm int64 // offset of the COMM chunk
+	ssnd int64 // offset of the SSND chunk
+	buf  []byte
+	tmp  []float64
+}
+
+// WriteSeekerCloser is the destination of an Encoder, which seeks back to
+// the headers on Close.
+type WriteSeekerCloser interface {
+	io.WriteSeeker
+	io.Closer
+}
+
+// aifcVersion is the timestamp of the FVER chunk of AIFF-C version 1.
+const aifcVersion = 0xa2805140
+
+// NewEncoder creates an encoder of sound of form v with sample codec sc to
+// w, starting at its current offset.
+//
+// Big endian integer sample codecs and SByte are encoded as AIFF, little
+// endian integer sample codecs as AIFF-C "sowt", and big endian floating
+// point sample codecs as AIFF-C "fl32" and "fl64".  Other sample codecs
+// give codec.ErrUnsupportedSampleCodec.
+func NewEncoder(w WriteSeekerCloser, v sound.Form, sc sample.Codec) (*Encoder, error) {
+	f, err := newFormat(v.Channels(), v.SampleRate(), sc)
+	if err != nil {
+		return nil, err
+	}
+	base, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	var hdr []byte
+	form := "AIFF"
+	if f.aifc() {
+		form = "AIFC"
+	}
+	hdr = append(hdr, "FORM\x00\x00\x00\x00"+form...)
+	if f.aifc() {
+		var fver [chunkHdrSize + 4]byte
+		copy(fver[:], "FVER")
+		binary.BigEndian.PutUint32(fver[4:8], 4)
+		binary.BigEndian.PutUint32(fver[8:], aifcVersion)
+		hdr = append(hdr, fver[:]...)
+	}
+	e := &Encoder{w: w, f: f, base: base, comm: int64(len(hdr))}
+	hdr = append(hdr, f.commBytes()...)
+	e.ssnd = int64(len(hdr))
+	// the SSND chunk, with zero offset and block size.
+	hdr = append(hdr, "SSND\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00"...)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Codec returns the sample codec of the encoding.
+func (e *Encoder) Codec() sample.Codec {
+	return e.f.codec
+}
+
+func (e *Encoder) Channels() int {
+	return e.f.channels
+}
+
+func (e *Encoder) SampleRate() freq.T {
+	return e.f.rate
+}
+
+func (e *Encoder) Send(src []float64) error {
+	nC := e.Channels()
+	if len(src)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	n := len(src) / nC
+	if cap(e.tmp) < len(src) {
+		e.tmp = make([]float64, len(src))
+	}
+	tmp := e.tmp[:len(src)]
+	for f := 0; f < n; f++ {
+		for c := 0; c < nC; c++ {
+			tmp[f*nC+c] = src[c*n+f]
+		}
+	}
+	sz := len(src) * e.f.bytes()
+	if cap(e.buf) < sz {
+		e.buf = make([]byte, sz)
+	}
+	buf := e.buf[:sz]
+	e.f.codec.Encode(buf, tmp)
+	if _, err := e.w.Write(buf); err != nil {
+		return err
+	}
+	e.f.frames += int64(n)
+	return nil
+}
+
+// Close writes the sizes in the headers and closes the underlying writer.
+func (e *Encoder) Close() error {
+	size := e.f.frames * int64(e.f.bpf())
+	if size&1 != 0 {
+		if _, err := e.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	dataEnd := e.ssnd + chunkHdrSize + 8 + size
+	for _, p := range []struct {
+		off int64
+		v   uint32
+	}{
+		{4, uint32(dataEnd + size&1 - chunkHdrSize)},
+		{e.comm + chunkHdrSize + 2, uint32(e.f.frames)},
+		{e.ssnd + 4, uint32(8 + size)},
+	} {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], p.v)
+		if _, err := e.w.Seek(e.base+p.off, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := e.w.Write(b[:]); err != nil {
+			return err
+		}
+	}
+	if _, err := e.w.Seek(e.base+dataEnd+size&1, io.SeekStart); err != nil {
+		return err
+	}
+	return e.w.Close()
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package aiff
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// The sample rate of an AIFF file is an 80 bit IEEE 754 extended precision
+// number: a sign bit, a 15 bit exponent biased by 16383, and a 64 bit
+// mantissa with an explicit integer bit.
+
+const extendedBias = 16383
+
+// decodeExtended decodes the 10 byte extended precision number in b.
+func decodeExtended(b []byte) float64 {
+	se := binary.BigEndian.Uint16(b[:2])
+	mant := binary.BigEndian.Uint64(b[2:10])
+	exp := int(se & 0x7fff)
+	var f float64
+	switch {
+	case exp == 0 && mant == 0:
+		f = 0
+	case exp == 0x7fff:
+		f = math.Inf(1)
+		if mant<<1 != 0 {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(float64(mant), exp-extendedBias-63)
+	}
+	if se&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
+
+// encodeExtended encodes the finite number f in 10 bytes of b.
+func encodeExtended(b []byte, f float64) {
+	var se uint16
+	if f < 0 {
+		se = 0x8000
+		f = -f
+	}
+	var mant uint64
+	if f != 0 {
+		frac, exp := math.Frexp(f)
+		se |= uint16(exp - 1 + extendedBias)
+		mant = uint64(math.Ldexp(frac, 64))
+	}
+	binary.BigEndian.PutUint16(b[:2], se)
+	binary.BigEndian.PutUint64(b[2:10], mant)
+}
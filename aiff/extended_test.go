@@ -0,0 +1,32 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package aiff
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExtended(t *testing.T) {
+	for _, tc := range []struct {
+		f float64
+		b []byte
+	}{
+		{44100, []byte{0x40, 0x0e, 0xac, 0x44, 0, 0, 0, 0, 0, 0}},
+		{48000, []byte{0x40, 0x0e, 0xbb, 0x80, 0, 0, 0, 0, 0, 0}},
+		{8000, []byte{0x40, 0x0b, 0xfa, 0, 0, 0, 0, 0, 0, 0}},
+		{22254.545454545454, []byte{0x40, 0x0d, 0xad, 0xdd, 0x17, 0x45, 0xd1, 0x74, 0x58, 0x00}},
+		{0, make([]byte, 10)},
+		{-1, []byte{0xbf, 0xff, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+	} {
+		b := make([]byte, 10)
+		encodeExtended(b, tc.f)
+		if !bytes.Equal(b, tc.b) {
+			t.Errorf("%f encoded as % x not % x", tc.f, b, tc.b)
+		}
+		if f := decodeExtended(tc.b); f != tc.f {
+			t.Errorf("% x decoded as %f not %f", tc.b, f, tc.f)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package aiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/internal/g711"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Compression types of AIFF-C.  AIFF files are treated as compression
+// type compNone.
+const (
+	compNone = "NONE"
+	compTwos = "twos"
+	compSowt = "sowt"
+	compFl32 = "fl32"
+	compFl64 = "fl64"
+	compULaw = "ulaw"
+	compALaw = "alaw"
+)
+
+// compNames gives the compression names written by the encoder.
+var compNames = map[string]string{
+	compNone: "not compressed",
+	compSowt: "little endian",
+	compFl32: "32-bit floating point",
+	compFl64: "64-bit floating point"}
+
+// format describes the sample data of an AIFF file, from its COMM chunk.
+type format struct {
+	channels int
+	frames   int64
+	bits     int
+	rate     freq.T
+	comp     string
+	codec    sample.Codec // AnySampleCodec for ulaw and alaw
+	law      g711.Law
+}
+
+func (f *format) SampleRate() freq.T {
+	return f.rate
+}
+
+func (f *format) Channels() int {
+	return f.channels
+}
+
+// bytes returns the number of bytes per sample.
+func (f *format) bytes() int {
+	if f.codec == codec.AnySampleCodec {
+		return 1
+	}
+	return f.codec.Bytes()
+}
+
+// bpf returns the number of bytes per frame.
+func (f *format) bpf() int {
+	return f.bytes() * f.channels
+}
+
+// decode decodes len(dst) samples from src.
+func (f *format) decode(dst []float64, src []byte) {
+	if f.codec == codec.AnySampleCodec {
+		f.law.Decode(dst, src)
+		return
+	}
+	f.codec.Decode(dst, src)
+}
+
+// commSize is the size of the COMM chunk of AIFF.  AIFF-C adds the
+// compression type and name.
+const commSize = 18
+
+// maxBlockAlign is the largest frame size accepted, which bounds the
+// decoder's buffer.
+const maxBlockAlign = 1<<16 - 1
+
+// parseComm parses a COMM chunk with data d at offset off.  aifc is true
+// for AIFF-C files.
+func parseComm(d []byte, off int64, aifc bool) (*format, error) {
+	commErr := func(err error, k int, format string, args ...interface{}) error {
+		return errs.Decode(err, off+int64(k), "COMM", format, args...)
+	}
+	if len(d) < commSize || aifc && len(d) < commSize+4 {
+		return nil, commErr(codec.ErrCorrupt, -4, "chunk too small: %d", len(d))
+	}
+	f := &format{
+		channels: int(binary.BigEndian.Uint16(d[0:2])),
+		frames:   int64(binary.BigEndian.Uint32(d[2:6])),
+		bits:     int(binary.BigEndian.Uint16(d[6:8])),
+		comp:     compNone}
+	rate := decodeExtended(d[8:18])
+	if f.channels <= 0 || f.channels > math.MaxInt16 {
+		return nil, commErr(codec.ErrCorrupt, 0, "%d channels", int16(f.channels))
+	}
+	if !(rate >= 1 && rate < 1<<32) {
+		return nil, commErr(codec.ErrCorrupt, 8, "sample rate %g", rate)
+	}
+	f.rate = freq.T(math.Round(rate * float64(freq.Hertz)))
+	if aifc {
+		f.comp = string(d[18:22])
+	}
+	var sc sample.Codec
+	switch f.comp {
+	case compNone, compTwos, compSowt:
+		if f.bits < 1 || f.bits > 32 {
+			return nil, commErr(codec.ErrUnsupportedFormat, 6, "unsupported sample size: %d", f.bits)
+		}
+		be := f.comp != compSowt
+		switch (f.bits + 7) / 8 {
+		case 1:
+			sc = sample.SByte
+		case 2:
+			sc = pick(be, sample.SInt16B, sample.SInt16L)
+		case 3:
+			sc = pick(be, sample.SInt24B, sample.SInt24L)
+		case 4:
+			sc = pick(be, sample.SInt32B, sample.SInt32L)
+		}
+	case compFl32, "FL32":
+		sc = sample.SFloat32B
+	case compFl64, "FL64":
+		sc = sample.SFloat64B
+	case compULaw, "ULAW":
+		sc, f.law = codec.AnySampleCodec, g711.ULaw
+	case compALaw, "ALAW":
+		sc, f.law = codec.AnySampleCodec, g711.ALaw
+	default:
+		return nil, commErr(codec.ErrUnsupportedFormat, 18, "unsupported compression type %q", f.comp)
+	}
+	f.codec = sc
+	if f.bpf() > maxBlockAlign {
+		return nil, commErr(codec.ErrCorrupt, 0, "%d channels of %d bytes exceed the largest frame", f.channels, f.bytes())
+	}
+	return f, nil
+}
+
+func pick(be bool, b, l sample.Codec) sample.Codec {
+	if be {
+		return b
+	}
+	return l
+}
+
+// newFormat returns the format for encoding with sample codec sc, or
+// codec.ErrUnsupportedSampleCodec.
+func newFormat(channels int, rate freq.T, sc sample.Codec) (*format, error) {
+	// The COMM chunk holds the channel count in a signed 16 bit field.
+	if channels < 1 || channels > math.MaxInt16 {
+		return nil, fmt.Errorf("aiff: unsupported channel count %d", channels)
+	}
+	if rate <= 0 {
+		return nil, fmt.Errorf("aiff: unsupported sample rate %s", rate)
+	}
+	f := &format{channels: channels, rate: rate, codec: sc, comp: compNone}
+	switch sc {
+	case sample.SByte, sample.SInt16B, sample.SInt24B, sample.SInt32B:
+	case sample.SInt16L, sample.SInt24L, sample.SInt32L:
+		f.comp = compSowt
+	case sample.SFloat32B:
+		f.comp = compFl32
+	case sample.SFloat64B:
+		f.comp = compFl64
+	default:
+		return nil, codec.ErrUnsupportedSampleCodec
+	}
+	f.bits = sc.Bits()
+	return f, nil
+}
+
+// aifc returns whether f requires AIFF-C.
+func (f *format) aifc() bool {
+	return f.comp != compNone
+}
+
+// commBytes returns the COMM chunk, including its header, for f.
+func (f *format) commBytes() []byte {
+	n := commSize
+	name := compNames[f.comp]
+	if f.aifc() {
+		n += 4 + pstringSize(name)
+	}
+	b := make([]byte, chunkHdrSize+n)
+	copy(b[:4], "COMM")
+	binary.BigEndian.PutUint32(b[4:8], uint32(n))
+	d := b[chunkHdrSize:]
+	binary.BigEndian.PutUint16(d[0:2], uint16(f.channels))
+	binary.BigEndian.PutUint32(d[2:6], uint32(f.frames))
+	binary.BigEndian.PutUint16(d[6:8], uint16(f.bits))
+	encodeExtended(d[8:18], float64(f.rate)/float64(freq.Hertz))
+	if f.aifc() {
+		copy(d[18:22], f.comp)
+		putPstring(d[22:], name)
+	}
+	return b
+}
+
+func (f *format) String() string {
+	return fmt.Sprintf("%d channels at %s, %d bits %s", f.channels, f.rate, f.bits, f.comp)
+}
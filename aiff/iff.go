@@ -0,0 +1,121 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package aiff
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"zikichombo.org/codec"
+)
+
+const (
+	chunkHdrSize = 8
+	formHdrSize  = 12
+
+	// maxChunkAlloc is the largest number of bytes allocated to read a
+	// chunk.  Larger chunks of metadata are skipped.
+	maxChunkAlloc = 1 << 16
+)
+
+// chunk is the header of an IFF chunk.
+type chunk struct {
+	id    string
+	start int64 // offset of the header
+	size  int64 // size of the data, excluding any pad byte
+}
+
+// data returns the offset of the chunk data.
+func (c *chunk) data() int64 {
+	return c.start + chunkHdrSize
+}
+
+// end returns the offset after the chunk, which is padded to even length.
+func (c *chunk) end() int64 {
+	return c.data() + c.size + c.size&1
+}
+
+// readChunk reads the header of a chunk at offset off.  It returns io.EOF
+// if there is no data, and a *codec.DecodeError if the header is truncated.
+func readChunk(r io.Reader, off int64) (*chunk, error) {
+	var buf [chunkHdrSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, errs.Read(err, off, "")
+	}
+	return &chunk{
+		id:    string(buf[:4]),
+		start: off,
+		size:  int64(binary.BigEndian.Uint32(buf[4:]))}, nil
+}
+
+// readForm reads the FORM header, returning the form type and the offset
+// of the end of the form.
+func readForm(r io.Reader) (string, int64, error) {
+	var buf [formHdrSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return "", 0, errs.Read(err, 0, "")
+	}
+	if string(buf[:4]) != "FORM" {
+		return "", 0, errs.Decode(codec.ErrUnsupportedFormat, 0, "", "not an IFF file")
+	}
+	typ := string(buf[8:12])
+	if typ != "AIFF" && typ != "AIFC" {
+		return "", 0, errs.Decode(codec.ErrUnsupportedFormat, 8, "FORM", "form type %q is not AIFF or AIFC", typ)
+	}
+	return typ, chunkHdrSize + int64(binary.BigEndian.Uint32(buf[4:8])), nil
+}
+
+// skip skips n bytes of r.
+func skip(r io.Reader, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekCurrent)
+		return err
+	}
+	m, err := io.CopyN(ioutil.Discard, r, n)
+	if m == n {
+		return nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// pstringSize returns the size of the Pascal string s, including its
+// count byte and any pad byte.
+func pstringSize(s string) int {
+	n := 1 + len(s)
+	return n + n&1
+}
+
+// putPstring puts the Pascal string s in b.
+func putPstring(b []byte, s string) {
+	b[0] = byte(len(s))
+	copy(b[1:], s)
+}
+
+// getPstring returns the Pascal string at the start of b and its size, or
+// ok false if b is too short.
+func getPstring(b []byte) (s string, n int, ok bool) {
+	if len(b) == 0 {
+		return "", 0, false
+	}
+	n = 1 + int(b[0])
+	if n > len(b) {
+		return "", 0, false
+	}
+	s = string(b[1:n])
+	n += n & 1
+	if n > len(b) {
+		n = len(b)
+	}
+	return s, n, true
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package aiff
+
+import (
+	"encoding/binary"
+
+	"zikichombo.org/codec"
+)
+
+// Marker is a named position in the sample data, from a MARK chunk.
+type Marker struct {
+	// ID identifies the marker, for example in a Loop.
+	ID int
+	// Pos is the frame before which the marker lies.
+	Pos  int64
+	Name string
+}
+
+// LoopMode is the play mode of a Loop.
+type LoopMode int
+
+const (
+	NoLooping LoopMode = iota
+	ForwardLooping
+	ForwardBackwardLooping
+)
+
+// Loop is a loop of an Instrument, between two markers.
+type Loop struct {
+	Mode LoopMode
+	// Begin and End are the IDs of the markers delimiting the loop.
+	Begin, End int
+}
+
+// Instrument gives the parameters for playing the sound as a sampled
+// instrument, from an INST chunk.
+type Instrument struct {
+	// BaseNote is the MIDI note at which the sound plays at its recorded
+	// pitch, detuned by Detune cents.
+	BaseNote, Detune int
+	// The notes and velocities at which the instrument is suitable.
+	LowNote, HighNote         int
+	LowVelocity, HighVelocity int
+	// Gain is in decibels.
+	Gain int
+	// Sustain loops during the sustain of a note, Release after.
+	Sustain, Release Loop
+}
+
+// parseMark parses a MARK chunk with data d at offset off.
+func parseMark(d []byte, off int64) ([]Marker, error) {
+	if len(d) < 2 {
+		return nil, errs.Decode(codec.ErrCorrupt, off, "MARK", "chunk too small: %d", len(d))
+	}
+	n := int(binary.BigEndian.Uint16(d[:2]))
+	p := 2
+	// each marker takes at least 8 bytes.
+	if n > len(d)/8 {
+		return nil, errs.Decode(codec.ErrCorrupt, off, "MARK", "%d markers in %d bytes", n, len(d))
+	}
+	ms := make([]Marker, 0, n)
+	for i := 0; i < n; i++ {
+		if p+6 > len(d) {
+			return nil, errs.Decode(codec.ErrCorrupt, off+int64(p), "MARK", "marker %d of %d truncated", i, n)
+		}
+		m := Marker{
+			ID:  int(int16(binary.BigEndian.Uint16(d[p : p+2]))),
+			Pos: int64(binary.BigEndian.Uint32(d[p+2 : p+6]))}
+		name, k, ok := getPstring(d[p+6:])
+		if !ok {
+			return nil, errs.Decode(codec.ErrCorrupt, off+int64(p+6), "MARK", "marker %d of %d name truncated", i, n)
+		}
+		m.Name = name
+		ms = append(ms, m)
+		p += 6 + k
+	}
+	return ms, nil
+}
+
+// instSize is the size of an INST chunk.
+const instSize = 20
+
+// parseInst parses an INST chunk with data d at offset off.
+func parseInst(d []byte, off int64) (*Instrument, error) {
+	if len(d) < instSize {
+		return nil, errs.Decode(codec.ErrCorrupt, off, "INST", "chunk too small: %d", len(d))
+	}
+	loop := func(b []byte) Loop {
+		return Loop{
+			Mode:  LoopMode(int16(binary.BigEndian.Uint16(b[0:2]))),
+			Begin: int(int16(binary.BigEndian.Uint16(b[2:4]))),
+			End:   int(int16(binary.BigEndian.Uint16(b[4:6])))}
+	}
+	return &Instrument{
+		BaseNote:     int(int8(d[0])),
+		Detune:       int(int8(d[1])),
+		LowNote:      int(int8(d[2])),
+		HighNote:     int(int8(d[3])),
+		LowVelocity:  int(int8(d[4])),
+		HighVelocity: int(int8(d[5])),
+		Gain:         int(int16(binary.BigEndian.Uint16(d[6:8]))),
+		Sustain:      loop(d[8:14]),
+		Release:      loop(d[14:20])}, nil
+}
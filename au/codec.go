@@ -0,0 +1,126 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package au
+
+import (
+	"bufio"
+	"io"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Codec is the au codec.Codec, registered with package codec when
+// package au is imported.
+var Codec codec.Codec = auCodec{}
+
+func init() {
+	codec.RegisterCodec(Codec)
+}
+
+type auCodec struct {
+	codec.NullCodec
+}
+
+var (
+	_ codec.Prober            = auCodec{}
+	_ codec.ConfidenceSniffer = auCodec{}
+	_ codec.Describer         = auCodec{}
+)
+
+// Describe implements codec.Describer.  Encoding to a destination which
+// is not an io.Seeker leaves the size of the audio data unknown.  Mu-law
+// and A-law are encoded with NewEncoder.
+func (c auCodec) Describe() codec.Description {
+	return codec.Description{
+		Name:         "au",
+		MIMETypes:    []string{"audio/basic", "audio/x-au"},
+		Capabilities: codec.CanDecode | codec.CanSeek | codec.CanEncode,
+		SampleCodecs: sampleCodecs}
+}
+
+// sampleCodecs lists the sample codecs which can be encoded.
+var sampleCodecs = []sample.Codec{
+	sample.SByte, sample.SInt16B, sample.SInt24B, sample.SInt32B,
+	sample.SFloat32B, sample.SFloat64B}
+
+func (c auCodec) Extensions() []string {
+	return []string{".au", ".snd"}
+}
+
+func (c auCodec) Sniff(br *bufio.Reader) bool {
+	return c.SniffConfidence(br) > codec.SniffNone
+}
+
+// SniffConfidence implements codec.ConfidenceSniffer.
+func (c auCodec) SniffConfidence(br *bufio.Reader) int {
+	buf, err := br.Peek(4)
+	if err != nil {
+		return codec.SniffNone
+	}
+	if string(buf) != ".snd" {
+		return codec.SniffNone
+	}
+	return codec.SniffStrong
+}
+
+func (c auCodec) DefaultSampleCodec() sample.Codec {
+	return sample.SInt16B
+}
+
+func (c auCodec) Decoder(r io.ReadCloser) (sound.Source, sample.Codec, error) {
+	d, err := NewStreamDecoder(r)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	return d, d.Codec(), nil
+}
+
+func (c auCodec) SeekingDecoder(r codec.IoReadSeekCloser) (sound.SourceSeeker, sample.Codec, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	return d, d.Codec(), nil
+}
+
+func (c auCodec) Encoder(w io.WriteCloser, v sound.Form, sc sample.Codec) (sound.Sink, error) {
+	if sc == codec.AnySampleCodec {
+		sc = c.DefaultSampleCodec()
+	}
+	enc, err := EncodingFor(sc)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncoder(w, v, enc)
+}
+
+// Probe implements codec.Prober, reading the header of r.
+func (c auCodec) Probe(r io.ReadSeeker) (*codec.StreamInfo, error) {
+	base, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if h.size < 0 {
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		h.size = end - base - h.offset
+		if h.size < 0 {
+			h.size = 0
+		}
+	}
+	return &codec.StreamInfo{
+		Container:   "au",
+		Channels:    h.channels,
+		SampleRate:  h.rate,
+		SampleCodec: h.enc.Codec(),
+		Frames:      h.size / int64(h.bpf())}, nil
+}
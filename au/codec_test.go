@@ -0,0 +1,99 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package au
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+func TestConformance(t *testing.T) {
+	codectest.Run(t, Codec, nil)
+}
+
+func TestRegistered(t *testing.T) {
+	for _, ext := range []string{".au", ".snd"} {
+		if _, err := codec.CodecFor("x"+ext, nil); err != nil {
+			t.Errorf("%s: %v", ext, err)
+		}
+	}
+}
+
+// TestPipe checks that encoding to a pipe leaves the data size unknown,
+// which the decoders read to the end of their input.
+func TestPipe(t *testing.T) {
+	pr, pw := io.Pipe()
+	d := make([]float64, 2*1000)
+	for i := range d {
+		d[i] = float64(i%100)/100 - 0.5
+	}
+	errc := make(chan error, 1)
+	go func() {
+		e, err := NewEncoder(pw, sound.StereoCd(), Linear16)
+		if err == nil {
+			err = e.Send(d)
+		}
+		if err == nil {
+			err = e.Close()
+		}
+		errc <- err
+	}()
+	data, err := ioutil.ReadAll(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if string(data[8:12]) != "\xff\xff\xff\xff" {
+		t.Errorf("data size % x not unknown", data[8:12])
+	}
+
+	sd, err := NewStreamDecoder(ioutil.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd.Len() != -1 {
+		t.Errorf("stream decoder len %d before reading", sd.Len())
+	}
+	got := decodeAll(t, sd)
+	if len(got) != len(d) || sd.Len() != 1000 {
+		t.Errorf("stream decoder got %d samples, len %d", len(got), sd.Len())
+	}
+
+	dec, err := NewDecoder(codectest.NewFile(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.Len() != 1000 {
+		t.Errorf("decoder len %d", dec.Len())
+	}
+	info, err := codec.Probe(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Container != "au" || info.Frames != 1000 || info.SampleCodec != sample.SInt16B {
+		t.Errorf("probed %+v", info)
+	}
+}
+
+func TestInvalidForm(t *testing.T) {
+	for _, v := range []sound.Form{
+		sound.NewForm(44100*freq.Hertz, 0),
+		sound.NewForm(0, 1),
+		sound.NewForm(-44100*freq.Hertz, 1),
+	} {
+		if _, err := NewEncoder(codectest.NewFile(nil), v, Linear16); err == nil {
+			t.Errorf("%d channels at %s: no error", v.Channels(), v.SampleRate())
+		}
+	}
+}
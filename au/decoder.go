@@ -0,0 +1,208 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package au
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Decoder decodes an AU file.
+type Decoder struct {
+	*header
+	r    io.Reader
+	c    io.Closer
+	s    io.Seeker // nil if not seekable
+	base int64     // offset in r of the start of the file
+
+	buf  []byte    // encoded frames
+	tmp  []float64 // interleaved decoded frames
+	pos  int64     // frame position
+	nFrm int64     // number of frames, or -1 if unknown
+}
+
+// ReadSeekerCloser is the source of a seeking Decoder.
+type ReadSeekerCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// NewDecoder creates a decoder from an AU file, which starts at the
+// current offset of r.  If the header does not give the size of the audio
+// data, it extends to the end of r.
+func NewDecoder(r ReadSeekerCloser) (*Decoder, error) {
+	base, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if h.size < 0 {
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		h.size = end - base - h.offset
+		if h.size < 0 {
+			h.size = 0
+		}
+		if _, err := r.Seek(base+h.offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return newDecoder(h, r, r, r, base), nil
+}
+
+// NewStreamDecoder creates a decoder from an AU file read sequentially
+// from r.  The decoder cannot seek.
+func NewStreamDecoder(r io.ReadCloser) (*Decoder, error) {
+	h, err := readHeader(struct{ io.Reader }{r})
+	if err != nil {
+		return nil, err
+	}
+	return newDecoder(h, r, r, nil, 0), nil
+}
+
+func newDecoder(h *header, r io.Reader, c io.Closer, s io.Seeker, base int64) *Decoder {
+	bpf := h.bpf()
+	bufFrames := maxBlockAlign / bpf
+	if bufFrames > 1024 {
+		bufFrames = 1024
+	}
+	nFrm := int64(-1)
+	if h.size >= 0 {
+		nFrm = h.size / int64(bpf)
+	}
+	return &Decoder{
+		header: h,
+		r:      r,
+		c:      c,
+		s:      s,
+		base:   base,
+		buf:    make([]byte, bufFrames*bpf),
+		nFrm:   nFrm}
+}
+
+var _ sound.SourceSeeker = (*Decoder)(nil)
+
+// Codec returns the sample codec of the data, or codec.AnySampleCodec for
+// mu-law and A-law.
+func (d *Decoder) Codec() sample.Codec {
+	return d.enc.Codec()
+}
+
+// Encoding returns the encoding of the audio data.
+func (d *Decoder) Encoding() Encoding {
+	return d.enc
+}
+
+// Annotation returns the annotation following the header, up to its first
+// null byte.
+func (d *Decoder) Annotation() string {
+	return d.annotation
+}
+
+func (d *Decoder) SampleRate() freq.T {
+	return d.rate
+}
+
+func (d *Decoder) Channels() int {
+	return d.channels
+}
+
+func (d *Decoder) Receive(dst []float64) (int, error) {
+	nC := d.Channels()
+	if len(dst)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := int64(len(dst) / nC)
+	if rem := d.nFrm - d.pos; d.nFrm >= 0 && nF > rem {
+		nF = rem
+	}
+	if nF <= 0 {
+		return 0, io.EOF
+	}
+	if cap(d.tmp) < int(nF)*nC {
+		d.tmp = make([]float64, int(nF)*nC)
+	}
+	tmp := d.tmp[:int(nF)*nC]
+	bpf := d.bpf()
+	n := 0
+	for n < int(nF) {
+		m := int(nF) - n
+		if mx := len(d.buf) / bpf; m > mx {
+			m = mx
+		}
+		k, err := io.ReadFull(d.r, d.buf[:m*bpf])
+		k /= bpf
+		d.decode(tmp[n*nC:(n+k)*nC], d.buf[:k*bpf])
+		n += k
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// the audio data ends, early if the length is known.
+			d.nFrm = d.pos + int64(n)
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	for f := 0; f < n; f++ {
+		for c := 0; c < nC; c++ {
+			dst[c*n+f] = tmp[f*nC+c]
+		}
+	}
+	d.pos += int64(n)
+	return n, nil
+}
+
+// Len returns the number of frames, or -1 if it is unknown because a
+// decoder from NewStreamDecoder has not reached the end of audio data of
+// unknown size.
+func (d *Decoder) Len() int64 {
+	return d.nFrm
+}
+
+func (d *Decoder) Pos() int64 {
+	return d.pos
+}
+
+// Duration returns the duration of the sound, or -1 if Len is unknown.
+func (d *Decoder) Duration() time.Duration {
+	if d.nFrm < 0 {
+		return -1
+	}
+	return time.Duration(d.nFrm) * d.SampleRate().Period()
+}
+
+// Seek seeks to frame f, returning an error if f is negative or the
+// decoder cannot seek.  Seeking beyond the end is allowed, after which
+// Receive returns io.EOF.
+func (d *Decoder) Seek(f int64) error {
+	if d.s == nil {
+		return codec.ErrUnsupportedFunction
+	}
+	if f < 0 {
+		return fmt.Errorf("au: seek to negative frame %d", f)
+	}
+	if _, err := d.s.Seek(d.base+d.offset+f*int64(d.bpf()), io.SeekStart); err != nil {
+		return err
+	}
+	d.pos = f
+	return nil
+}
+
+func (d *Decoder) Close() error {
+	return d.c.Close()
+}
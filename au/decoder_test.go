@@ -0,0 +1,157 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package au
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+)
+
+// fileBytes returns an AU file with annotation a and audio data d.  A
+// negative size is written as unknown.
+func fileBytes(enc Encoding, rate, channels int, a string, size int, d []byte) []byte {
+	b := make([]byte, hdrSize, hdrSize+len(a)+len(d))
+	copy(b, ".snd")
+	binary.BigEndian.PutUint32(b[4:8], uint32(hdrSize+len(a)))
+	binary.BigEndian.PutUint32(b[8:12], uint32(size))
+	binary.BigEndian.PutUint32(b[12:16], uint32(enc))
+	binary.BigEndian.PutUint32(b[16:20], uint32(rate))
+	binary.BigEndian.PutUint32(b[20:24], uint32(channels))
+	b = append(b, a...)
+	return append(b, d...)
+}
+
+func decodeAll(t *testing.T, d *Decoder) []float64 {
+	t.Helper()
+	var res []float64
+	buf := make([]float64, 3*d.Channels())
+	for {
+		n, err := d.Receive(buf)
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, buf[:n*d.Channels()]...)
+	}
+}
+
+func TestEncodings(t *testing.T) {
+	for _, tc := range []struct {
+		enc  Encoding
+		data []byte
+		want []float64
+	}{
+		{Linear8, []byte{0x40, 0xc0}, []float64{.5, -.5}},
+		{Linear16, []byte{0x40, 0, 0xc0, 0}, []float64{.5, -.5}},
+		{Linear24, []byte{0x40, 0, 0, 0xc0, 0, 0}, []float64{.5, -.5}},
+		{Linear32, []byte{0x40, 0, 0, 0, 0xc0, 0, 0, 0}, []float64{.5, -.5}},
+		{Float32, []byte{0x3f, 0, 0, 0, 0xbf, 0, 0, 0}, []float64{.5, -.5}},
+		{Float64, []byte{0x3f, 0xe0, 0, 0, 0, 0, 0, 0, 0xbf, 0xe0, 0, 0, 0, 0, 0, 0}, []float64{.5, -.5}},
+		{ULaw, []byte{0xff, 0x80}, []float64{0, 32124. / 32768}},
+		{ALaw, []byte{0xd5, 0x2a}, []float64{8. / 32768, -32256. / 32768}},
+	} {
+		data := fileBytes(tc.enc, 8000, 1, "note\x00\x00\x00\x00", len(tc.data), tc.data)
+		d, err := NewDecoder(codectest.NewFile(data))
+		if err != nil {
+			t.Errorf("%s: %v", tc.enc, err)
+			continue
+		}
+		if d.Encoding() != tc.enc || d.Codec() != tc.enc.Codec() {
+			t.Errorf("%s: encoding %s codec %s", tc.enc, d.Encoding(), d.Codec())
+		}
+		if d.Annotation() != "note" {
+			t.Errorf("%s: annotation %q", tc.enc, d.Annotation())
+		}
+		if d.SampleRate() != 8000*freq.Hertz {
+			t.Errorf("%s: sample rate %s", tc.enc, d.SampleRate())
+		}
+		got := decodeAll(t, d)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %v want %v", tc.enc, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i]-tc.want[i]) > 1e-9 {
+				t.Errorf("%s: got %v want %v", tc.enc, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+// TestCompanding checks encoding and seeking of mu-law and A-law, which
+// the codec's Encoder does not offer.
+func TestCompanding(t *testing.T) {
+	for _, enc := range []Encoding{ULaw, ALaw} {
+		w := codectest.NewFile(nil)
+		e, err := NewEncoder(w, sound.MonoCd(), enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := []float64{0, 0.25, -0.25, 0.5, -0.99}
+		if err := e.Send(src); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewDecoder(codectest.NewFile(w.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Len() != int64(len(src)) {
+			t.Errorf("%s: len %d", enc, d.Len())
+		}
+		if err := d.Seek(1); err != nil {
+			t.Fatal(err)
+		}
+		got := decodeAll(t, d)
+		if len(got) != len(src)-1 {
+			t.Fatalf("%s: got %v", enc, got)
+		}
+		for i, v := range got {
+			// companding keeps about 4 significant bits.
+			if math.Abs(v-src[i+1]) > math.Abs(src[i+1])/16+1e-3 {
+				t.Errorf("%s: sample %d: %f != %f", enc, i+1, v, src[i+1])
+			}
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"not au", []byte("RIFF\x00\x00\x00\x04WAVE"), codec.ErrUnsupportedFormat},
+		{"short", []byte(".snd\x00\x00"), codec.ErrTruncated},
+		{"offset", []byte(".snd\x00\x00\x00\x10\x00\x00\x00\x00\x00\x00\x00\x03\x00\x00\x1f\x40\x00\x00\x00\x01"), codec.ErrCorrupt},
+		{"encoding", fileBytes(23, 8000, 1, "", 0, nil), codec.ErrUnsupportedFormat},
+		{"rate", fileBytes(Linear16, 0, 1, "", 0, nil), codec.ErrCorrupt},
+		{"channels", fileBytes(Linear16, 8000, 0, "", 0, nil), codec.ErrCorrupt},
+		{"frame size", fileBytes(Float64, 8000, 10000, "", 0, nil), codec.ErrCorrupt},
+		{"annotation", fileBytes(Linear16, 8000, 1, "annotation", 0, nil)[:30], codec.ErrTruncated},
+	} {
+		_, err := NewDecoder(codectest.NewFile(tc.data))
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: got %v not %v", tc.name, err, tc.err)
+			continue
+		}
+		var de *codec.DecodeError
+		if !errors.As(err, &de) || de.Codec != "au" {
+			t.Errorf("%s: %v is not an au *codec.DecodeError", tc.name, err)
+		}
+	}
+}
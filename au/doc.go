@@ -0,0 +1,14 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package au provides decoding and encoding of Sun/NeXT AU audio files.
+//
+// Package au supports big endian linear PCM of 8, 16, 24 and 32 bits,
+// 32 and 64 bit floating point, mu-law and A-law.  Files of unknown data
+// size, as written to pipes, are decoded to the end of their input, and
+// are written by an Encoder whose destination cannot seek.  Importing
+// package au registers it with zikichombo.org/codec for the extensions
+// .au and .snd.
+//
+// Package au is part of http://zikichombo.org
+package au /* import "zikichombo.org/codec/au" */
@@ -0,0 +1,110 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package au
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Encoder encodes an AU file.
+type Encoder struct {
+	*header
+	w      io.WriteCloser
+	s      io.Seeker // nil if the data size is left unknown
+	base   int64     // offset in w of the start of the file
+	frames int64
+	buf    []byte
+	tmp    []float64
+}
+
+// NewEncoder creates an encoder of sound of form v with encoding enc to w,
+// starting at its current offset.  The sample rate of v must be a whole
+// number of Hz.
+//
+// If w is an io.Seeker, Close writes the size of the audio data in the
+// header.  Otherwise the size is left unknown, so w may be a pipe.
+func NewEncoder(w io.WriteCloser, v sound.Form, enc Encoding) (*Encoder, error) {
+	if _, ok := encNames[enc]; !ok {
+		return nil, fmt.Errorf("au: unsupported encoding %s", enc)
+	}
+	if v.Channels() < 1 {
+		return nil, fmt.Errorf("au: unsupported channel count %d", v.Channels())
+	}
+	if v.SampleRate() <= 0 || v.SampleRate()%freq.Hertz != 0 || v.SampleRate()/freq.Hertz > 1<<32-1 {
+		return nil, fmt.Errorf("au: unsupported sample rate %s", v.SampleRate())
+	}
+	e := &Encoder{
+		header: &header{enc: enc, rate: v.SampleRate(), channels: v.Channels(), size: -1},
+		w:      w}
+	if s, ok := w.(io.Seeker); ok {
+		base, err := s.Seek(0, io.SeekCurrent)
+		if err == nil {
+			e.s, e.base = s, base
+		}
+	}
+	if _, err := w.Write(e.headerBytes()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Codec returns the sample codec of the encoding, or codec.AnySampleCodec
+// for mu-law and A-law.
+func (e *Encoder) Codec() sample.Codec {
+	return e.enc.Codec()
+}
+
+func (e *Encoder) Send(src []float64) error {
+	nC := e.channels
+	if len(src)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	n := len(src) / nC
+	if cap(e.tmp) < len(src) {
+		e.tmp = make([]float64, len(src))
+	}
+	tmp := e.tmp[:len(src)]
+	for f := 0; f < n; f++ {
+		for c := 0; c < nC; c++ {
+			tmp[f*nC+c] = src[c*n+f]
+		}
+	}
+	sz := len(src) * e.bytes()
+	if cap(e.buf) < sz {
+		e.buf = make([]byte, sz)
+	}
+	buf := e.buf[:sz]
+	e.encode(buf, tmp)
+	if _, err := e.w.Write(buf); err != nil {
+		return err
+	}
+	e.frames += int64(n)
+	return nil
+}
+
+// Close writes the size of the audio data, if w is an io.Seeker and the
+// size fits the header, and closes w.
+func (e *Encoder) Close() error {
+	size := e.frames * int64(e.bpf())
+	if e.s != nil && size < unknownSize {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(size))
+		if _, err := e.s.Seek(e.base+8, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := e.w.Write(b[:]); err != nil {
+			return err
+		}
+		if _, err := e.s.Seek(e.base+hdrSize+annotationSize+size, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return e.w.Close()
+}
@@ -0,0 +1,240 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package au
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/internal/g711"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Encoding is the encoding of the audio data of an AU file.
+type Encoding int
+
+// The supported encodings, with their values in the header.
+const (
+	ULaw     Encoding = 1
+	Linear8  Encoding = 2
+	Linear16 Encoding = 3
+	Linear24 Encoding = 4
+	Linear32 Encoding = 5
+	Float32  Encoding = 6
+	Float64  Encoding = 7
+	ALaw     Encoding = 27
+)
+
+var encNames = map[Encoding]string{
+	ULaw:     "mu-law",
+	Linear8:  "8-bit linear",
+	Linear16: "16-bit linear",
+	Linear24: "24-bit linear",
+	Linear32: "32-bit linear",
+	Float32:  "32-bit float",
+	Float64:  "64-bit float",
+	ALaw:     "A-law"}
+
+func (e Encoding) String() string {
+	if s, ok := encNames[e]; ok {
+		return s
+	}
+	return fmt.Sprintf("Encoding(%d)", int(e))
+}
+
+// Codec returns the sample codec of e, or codec.AnySampleCodec for mu-law
+// and A-law.
+func (e Encoding) Codec() sample.Codec {
+	switch e {
+	case Linear8:
+		return sample.SByte
+	case Linear16:
+		return sample.SInt16B
+	case Linear24:
+		return sample.SInt24B
+	case Linear32:
+		return sample.SInt32B
+	case Float32:
+		return sample.SFloat32B
+	case Float64:
+		return sample.SFloat64B
+	}
+	return codec.AnySampleCodec
+}
+
+// EncodingFor returns the encoding with sample codec sc, or
+// codec.ErrUnsupportedSampleCodec.
+func EncodingFor(sc sample.Codec) (Encoding, error) {
+	for _, e := range []Encoding{Linear8, Linear16, Linear24, Linear32, Float32, Float64} {
+		if e.Codec() == sc {
+			return e, nil
+		}
+	}
+	return 0, codec.ErrUnsupportedSampleCodec
+}
+
+const (
+	// hdrSize is the size of the fixed fields of the header.
+	hdrSize = 24
+	// annotationSize is the size of the annotation written by the
+	// encoder, the minimum the format prescribes.
+	annotationSize = 4
+
+	// unknownSize is the data size of a file of unknown length.
+	unknownSize = 0xffffffff
+
+	// maxAnnotation is the largest annotation read.  Longer annotations
+	// are truncated.
+	maxAnnotation = 1 << 16
+
+	// maxBlockAlign is the largest frame size accepted, which bounds the
+	// decoder's buffer.
+	maxBlockAlign = 1<<16 - 1
+)
+
+// header is the header of an AU file.
+type header struct {
+	offset     int64 // offset of the audio data
+	size       int64 // size of the audio data, or -1 if unknown
+	enc        Encoding
+	rate       freq.T
+	channels   int
+	annotation string
+}
+
+func (h *header) SampleRate() freq.T {
+	return h.rate
+}
+
+func (h *header) Channels() int {
+	return h.channels
+}
+
+// bytes returns the number of bytes per sample.
+func (h *header) bytes() int {
+	if sc := h.enc.Codec(); sc != codec.AnySampleCodec {
+		return sc.Bytes()
+	}
+	return 1
+}
+
+// bpf returns the number of bytes per frame.
+func (h *header) bpf() int {
+	return h.bytes() * h.channels
+}
+
+// decode decodes len(dst) samples from src.
+func (h *header) decode(dst []float64, src []byte) {
+	switch h.enc {
+	case ULaw:
+		g711.ULaw.Decode(dst, src)
+	case ALaw:
+		g711.ALaw.Decode(dst, src)
+	default:
+		h.enc.Codec().Decode(dst, src)
+	}
+}
+
+// encode encodes src into len(src) samples of dst.
+func (h *header) encode(dst []byte, src []float64) {
+	switch h.enc {
+	case ULaw:
+		g711.ULaw.Encode(dst, src)
+	case ALaw:
+		g711.ALaw.Encode(dst, src)
+	default:
+		h.enc.Codec().Encode(dst, src)
+	}
+}
+
+// readHeader reads the header and annotation from r, leaving it at the
+// start of the audio data.
+func readHeader(r io.Reader) (*header, error) {
+	var b [hdrSize]byte
+	if _, err := io.ReadFull(r, b[:4]); err != nil {
+		return nil, errs.Read(err, 0, "")
+	}
+	if string(b[:4]) != ".snd" {
+		return nil, errs.Decode(codec.ErrUnsupportedFormat, 0, "", "not an AU file")
+	}
+	if _, err := io.ReadFull(r, b[4:]); err != nil {
+		return nil, errs.Read(err, 4, "")
+	}
+	h := &header{
+		offset:   int64(binary.BigEndian.Uint32(b[4:8])),
+		size:     int64(binary.BigEndian.Uint32(b[8:12])),
+		enc:      Encoding(binary.BigEndian.Uint32(b[12:16])),
+		rate:     freq.T(binary.BigEndian.Uint32(b[16:20])) * freq.Hertz,
+		channels: int(binary.BigEndian.Uint32(b[20:24]))}
+	if h.offset < hdrSize {
+		return nil, errs.Decode(codec.ErrCorrupt, 4, "", "data offset %d within header", h.offset)
+	}
+	if h.size == unknownSize {
+		h.size = -1
+	}
+	if _, ok := encNames[h.enc]; !ok {
+		return nil, errs.Decode(codec.ErrUnsupportedFormat, 12, "", "unsupported encoding %d", int(h.enc))
+	}
+	if h.rate <= 0 {
+		return nil, errs.Decode(codec.ErrCorrupt, 16, "", "sample rate 0")
+	}
+	if h.channels <= 0 || h.channels > maxBlockAlign {
+		return nil, errs.Decode(codec.ErrCorrupt, 20, "", "%d channels", int32(h.channels))
+	}
+	if h.bpf() > maxBlockAlign {
+		return nil, errs.Decode(codec.ErrCorrupt, 20, "", "%d channels of %d bytes exceed the largest frame", h.channels, h.bytes())
+	}
+	n := h.offset - hdrSize
+	if n > maxAnnotation {
+		n = maxAnnotation
+	}
+	a := make([]byte, n)
+	if _, err := io.ReadFull(r, a); err != nil {
+		return nil, errs.Read(err, hdrSize, "")
+	}
+	if i := bytes.IndexByte(a, 0); i >= 0 {
+		a = a[:i]
+	}
+	h.annotation = string(a)
+	if err := skip(r, h.offset-hdrSize-n); err != nil {
+		return nil, errs.Read(err, hdrSize+n, "")
+	}
+	return h, nil
+}
+
+// headerBytes returns the header and annotation for h.
+func (h *header) headerBytes() []byte {
+	b := make([]byte, hdrSize+annotationSize)
+	copy(b[:4], ".snd")
+	binary.BigEndian.PutUint32(b[4:8], uint32(len(b)))
+	binary.BigEndian.PutUint32(b[8:12], unknownSize)
+	binary.BigEndian.PutUint32(b[12:16], uint32(h.enc))
+	binary.BigEndian.PutUint32(b[16:20], uint32(h.rate/freq.Hertz))
+	binary.BigEndian.PutUint32(b[20:24], uint32(h.channels))
+	return b
+}
+
+// skip skips n bytes of r.
+func skip(r io.Reader, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekCurrent)
+		return err
+	}
+	m, err := io.CopyN(ioutil.Discard, r, n)
+	if m == n {
+		return nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
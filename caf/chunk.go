@@ -0,0 +1,119 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package caf
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"zikichombo.org/codec"
+)
+
+const (
+	fileHdrSize  = 8
+	chunkHdrSize = 12
+
+	// maxChunkAlloc is the largest number of bytes allocated to read a
+	// chunk.  Larger chunks of metadata are skipped.
+	maxChunkAlloc = 1 << 16
+)
+
+// chunk is the header of a CAF chunk.
+type chunk struct {
+	id    string
+	start int64 // offset of the header
+	size  int64 // size of the data, or -1 for data extending to the end of the file
+}
+
+// data returns the offset of the chunk data.
+func (c *chunk) data() int64 {
+	return c.start + chunkHdrSize
+}
+
+// end returns the offset after the chunk.
+func (c *chunk) end() int64 {
+	return c.data() + c.size
+}
+
+// readChunk reads the header of a chunk at offset off.  It returns io.EOF
+// if there is no data, and a *codec.DecodeError if the header is truncated
+// or its size is invalid.
+func readChunk(r io.Reader, off int64) (*chunk, error) {
+	var buf [chunkHdrSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, errs.Read(err, off, "")
+	}
+	c := &chunk{
+		id:    string(buf[:4]),
+		start: off,
+		size:  int64(binary.BigEndian.Uint64(buf[4:]))}
+	switch {
+	case c.size == -1 && c.id == "data":
+	case c.size < 0 || c.size > math.MaxInt64-c.data():
+		return nil, errs.Decode(codec.ErrCorrupt, off+4, c.id, "chunk size %d", c.size)
+	}
+	return c, nil
+}
+
+// putChunkHdr puts the header of a chunk of type id and size n in b.
+func putChunkHdr(b []byte, id string, n int64) {
+	copy(b[:4], id)
+	binary.BigEndian.PutUint64(b[4:12], uint64(n))
+}
+
+// readFileHeader reads the CAF file header.
+func readFileHeader(r io.Reader) error {
+	var buf [fileHdrSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return errs.Read(err, 0, "")
+	}
+	if string(buf[:4]) != "caff" {
+		return errs.Decode(codec.ErrUnsupportedFormat, 0, "", "not a CAF file")
+	}
+	if v := binary.BigEndian.Uint16(buf[4:6]); v != 1 {
+		return errs.Decode(codec.ErrUnsupportedFormat, 4, "", "unsupported version %d", v)
+	}
+	return nil
+}
+
+// skip skips n bytes of r.
+func skip(r io.Reader, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekCurrent)
+		return err
+	}
+	m, err := io.CopyN(ioutil.Discard, r, n)
+	if m == n {
+		return nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readChunkData reads the data of c, of which at most max bytes are
+// returned and the rest skipped.
+func readChunkData(r io.Reader, c *chunk, max int64) ([]byte, error) {
+	n := c.size
+	if n > max {
+		n = max
+	}
+	d := make([]byte, n)
+	if _, err := io.ReadFull(r, d); err != nil {
+		return nil, errs.Read(err, c.data(), c.id)
+	}
+	if err := skip(r, c.size-n); err != nil {
+		return nil, errs.Read(err, c.data()+n, c.id)
+	}
+	return d, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package caf
+
+import (
+	"bufio"
+	"io"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Codec is the caf codec.Codec, registered with package codec when
+// package caf is imported.
+var Codec codec.Codec = cafCodec{}
+
+func init() {
+	codec.RegisterCodec(Codec)
+}
+
+type cafCodec struct {
+	codec.NullCodec
+}
+
+var (
+	_ codec.Prober            = cafCodec{}
+	_ codec.ConfidenceSniffer = cafCodec{}
+	_ codec.Describer         = cafCodec{}
+)
+
+// Describe implements codec.Describer.  Encoding to a destination which
+// is not an io.Seeker leaves the size of the audio data unspecified.
+func (c cafCodec) Describe() codec.Description {
+	return codec.Description{
+		Name:         "caf",
+		MIMETypes:    []string{"audio/x-caf"},
+		Capabilities: codec.CanDecode | codec.CanSeek | codec.CanEncode,
+		SampleCodecs: sampleCodecs}
+}
+
+// sampleCodecs lists the sample codecs which can be encoded.
+var sampleCodecs = []sample.Codec{
+	sample.SByte,
+	sample.SInt16B, sample.SInt24B, sample.SInt32B,
+	sample.SInt16L, sample.SInt24L, sample.SInt32L,
+	sample.SFloat32B, sample.SFloat64B,
+	sample.SFloat32L, sample.SFloat64L}
+
+func (c cafCodec) Extensions() []string {
+	return []string{".caf"}
+}
+
+func (c cafCodec) Sniff(br *bufio.Reader) bool {
+	return c.SniffConfidence(br) > codec.SniffNone
+}
+
+// SniffConfidence implements codec.ConfidenceSniffer.
+func (c cafCodec) SniffConfidence(br *bufio.Reader) int {
+	buf, err := br.Peek(fileHdrSize + 4)
+	if err != nil {
+		return codec.SniffNone
+	}
+	// the desc chunk comes first.
+	if string(buf[:6]) != "caff\x00\x01" || string(buf[8:12]) != "desc" {
+		return codec.SniffNone
+	}
+	return codec.SniffStrong
+}
+
+func (c cafCodec) DefaultSampleCodec() sample.Codec {
+	return sample.SInt16B
+}
+
+func (c cafCodec) Decoder(r io.ReadCloser) (sound.Source, sample.Codec, error) {
+	d, err := NewStreamDecoder(r)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	return d, d.Codec(), nil
+}
+
+func (c cafCodec) SeekingDecoder(r codec.IoReadSeekCloser) (sound.SourceSeeker, sample.Codec, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	return d, d.Codec(), nil
+}
+
+func (c cafCodec) Encoder(w io.WriteCloser, v sound.Form, sc sample.Codec) (sound.Sink, error) {
+	if sc == codec.AnySampleCodec {
+		sc = c.DefaultSampleCodec()
+	}
+	return NewEncoder(w, v, sc)
+}
+
+// Probe implements codec.Prober, reading all the chunks of r but not the
+// audio data.
+func (c cafCodec) Probe(r io.ReadSeeker) (*codec.StreamInfo, error) {
+	h, err := readHeader(r, true)
+	if err != nil {
+		return nil, err
+	}
+	frames := h.size / int64(h.f.bpf())
+	if h.pakt != nil && h.pakt.ValidFrames < frames {
+		frames = h.pakt.ValidFrames
+	}
+	return &codec.StreamInfo{
+		Container:   "caf",
+		Channels:    h.f.Channels(),
+		SampleRate:  h.f.SampleRate(),
+		SampleCodec: h.f.codec,
+		Frames:      frames}, nil
+}
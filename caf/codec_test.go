@@ -0,0 +1,97 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package caf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+func TestConformance(t *testing.T) {
+	codectest.Run(t, Codec, nil)
+}
+
+func TestRegistered(t *testing.T) {
+	if _, err := codec.CodecFor("x.caf", nil); err != nil {
+		t.Error(err)
+	}
+}
+
+type writeCloser struct {
+	*bytes.Buffer
+}
+
+func (w writeCloser) Close() error {
+	return nil
+}
+
+// TestUnspecifiedSize checks that encoding to a writer which cannot seek
+// leaves the data size unspecified, which both decoders handle.
+func TestUnspecifiedSize(t *testing.T) {
+	w := writeCloser{&bytes.Buffer{}}
+	e, err := NewEncoder(w, sound.MonoCd(), sample.SInt16L)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := make([]float64, 1000)
+	for i := range d {
+		d[i] = float64(i%100)/100 - 0.5
+	}
+	if err := e.Send(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := w.Bytes()
+
+	sd, err := NewStreamDecoder(ioutil.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd.Len() != -1 {
+		t.Errorf("stream decoder len %d before reading", sd.Len())
+	}
+	got := decodeAll(t, sd)
+	if len(got) != len(d) || sd.Len() != int64(len(d)) {
+		t.Errorf("stream decoder got %d frames, len %d", len(got), sd.Len())
+	}
+	if err := sd.Seek(0); err != codec.ErrUnsupportedFunction {
+		t.Errorf("stream decoder seek gave %v", err)
+	}
+
+	dec, err := NewDecoder(codectest.NewFile(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.Len() != int64(len(d)) {
+		t.Errorf("decoder len %d", dec.Len())
+	}
+	info, err := codec.Probe(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Container != "caf" || info.Frames != int64(len(d)) || info.SampleCodec != sample.SInt16L {
+		t.Errorf("probed %+v", info)
+	}
+}
+
+func TestInvalidForm(t *testing.T) {
+	for _, v := range []sound.Form{
+		sound.NewForm(44100*freq.Hertz, 0),
+		sound.NewForm(0, 1),
+		sound.NewForm(-44100*freq.Hertz, 1),
+	} {
+		if _, err := NewEncoder(codectest.NewFile(nil), v, sample.SInt16B); err == nil {
+			t.Errorf("%d channels at %s: no error", v.Channels(), v.SampleRate())
+		}
+	}
+}
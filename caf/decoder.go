@@ -0,0 +1,366 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package caf
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Decoder decodes a CAF file.
+type Decoder struct {
+	*header
+	r    io.Reader
+	c    io.Closer
+	s    io.Seeker // nil if not seekable
+	base int64     // offset in r of the start of the file
+
+	buf  []byte    // encoded frames
+	tmp  []float64 // interleaved decoded frames
+	pos  int64     // frame position
+	nFrm int64     // number of frames, or -1 if unknown
+}
+
+// ReadSeekerCloser is the source of a seeking Decoder.
+type ReadSeekerCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// header holds the information in the chunks of a file.
+type header struct {
+	f       *format
+	data    int64 // offset of the first valid frame
+	size    int64 // size of the audio data from data, or -1 if unknown
+	pakt    *PacketTable
+	info    map[string]string
+	markers []Marker
+	strg    map[int]string
+}
+
+// NewDecoder creates a decoder from a CAF file, which starts at the
+// current offset of r.
+//
+// NewDecoder reads all the chunks of the file, so the packet table,
+// information and markers are available wherever their chunks are.
+func NewDecoder(r ReadSeekerCloser) (*Decoder, error) {
+	base, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readHeader(r, true)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(base+h.data, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return newDecoder(h, r, r, r, base), nil
+}
+
+// NewStreamDecoder creates a decoder from a CAF file read sequentially
+// from r.  The decoder cannot seek, and only has the packet table,
+// information and markers of chunks preceding the audio data.
+func NewStreamDecoder(r io.ReadCloser) (*Decoder, error) {
+	h, err := readHeader(struct{ io.Reader }{r}, false)
+	if err != nil {
+		return nil, err
+	}
+	return newDecoder(h, r, r, nil, 0), nil
+}
+
+func newDecoder(h *header, r io.Reader, c io.Closer, s io.Seeker, base int64) *Decoder {
+	bpf := h.f.bpf()
+	bufFrames := maxChunkAlloc / bpf
+	if bufFrames > 1024 {
+		bufFrames = 1024
+	}
+	nFrm := int64(-1)
+	if h.size >= 0 {
+		nFrm = h.size / int64(bpf)
+	}
+	if h.pakt != nil && (nFrm < 0 || h.pakt.ValidFrames < nFrm) {
+		nFrm = h.pakt.ValidFrames
+	}
+	return &Decoder{
+		header: h,
+		r:      r,
+		c:      c,
+		s:      s,
+		base:   base,
+		buf:    make([]byte, bufFrames*bpf),
+		nFrm:   nFrm}
+}
+
+// readHeader reads the chunks of a file from r, which is positioned at
+// its start.  If seekable, all chunks are read, otherwise reading stops
+// at the start of the audio data.
+func readHeader(r io.Reader, seekable bool) (*header, error) {
+	if err := readFileHeader(r); err != nil {
+		return nil, err
+	}
+	h := &header{data: -1}
+	off := int64(fileHdrSize)
+	for {
+		c, err := readChunk(r, off)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.f == nil && c.id != "desc" {
+			return nil, errs.Decode(codec.ErrCorrupt, c.start, c.id, "first chunk is not desc")
+		}
+		if c.id == "data" {
+			if h.data >= 0 {
+				return nil, errs.Decode(codec.ErrCorrupt, c.start, c.id, "second audio data chunk")
+			}
+			if c.size >= 0 && c.size < 4 {
+				return nil, errs.Decode(codec.ErrCorrupt, c.start+4, c.id, "chunk too small: %d", c.size)
+			}
+			// skip the edit count.
+			if err := skip(r, 4); err != nil {
+				return nil, errs.Read(err, c.data(), c.id)
+			}
+			h.data = c.data() + 4
+			h.size = c.size - 4
+			if c.size < 0 {
+				h.size = -1
+			}
+			if !seekable {
+				if err := h.trim(); err != nil {
+					return nil, err
+				}
+				if h.data > c.data()+4 {
+					if err := skip(r, h.data-c.data()-4); err != nil {
+						return nil, errs.Read(err, c.data()+4, c.id)
+					}
+				}
+				return h, nil
+			}
+			if c.size < 0 {
+				// the audio data extends to the end of the file.
+				s := r.(io.Seeker)
+				cur, err := s.Seek(0, io.SeekCurrent)
+				if err != nil {
+					return nil, err
+				}
+				end, err := s.Seek(0, io.SeekEnd)
+				if err != nil {
+					return nil, err
+				}
+				h.size = end - cur
+				break
+			}
+			if err := skip(r, h.size); err != nil {
+				return nil, errs.Read(err, h.data, c.id)
+			}
+			off = c.end()
+			continue
+		}
+		off = c.end()
+		switch c.id {
+		case "desc":
+			if h.f != nil {
+				return nil, errs.Decode(codec.ErrCorrupt, c.start, c.id, "second desc chunk")
+			}
+			d, err := readChunkData(r, c, descSize)
+			if err != nil {
+				return nil, err
+			}
+			if h.f, err = parseDesc(d, c.data()); err != nil {
+				return nil, err
+			}
+			continue
+		case "pakt", "info", "mark", "strg":
+			if c.size > maxChunkAlloc {
+				break
+			}
+			d, err := readChunkData(r, c, maxChunkAlloc)
+			if err != nil {
+				return nil, err
+			}
+			switch c.id {
+			case "pakt":
+				h.pakt, err = parsePakt(d, c.data())
+			case "info":
+				h.info, err = parseInfo(d, c.data())
+			case "mark":
+				h.markers, err = parseMark(d, c.data())
+			case "strg":
+				h.strg, err = parseStrg(d, c.data())
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := skip(r, c.size); err != nil {
+			return nil, errs.Read(err, c.data(), c.id)
+		}
+	}
+	if h.f == nil {
+		return nil, errs.Decode(codec.ErrTruncated, off, "", "no desc chunk")
+	}
+	if h.data < 0 {
+		return nil, errs.Decode(codec.ErrTruncated, off, "", "no audio data chunk")
+	}
+	if err := h.trim(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// trim skips the priming frames given by the packet table, and names the
+// markers from the strg chunk.
+func (h *header) trim() error {
+	for i := range h.markers {
+		h.markers[i].Name = h.strg[h.markers[i].ID]
+	}
+	if h.pakt == nil || h.pakt.PrimingFrames == 0 {
+		return nil
+	}
+	n := int64(h.pakt.PrimingFrames) * int64(h.f.bpf())
+	if h.size >= 0 {
+		if n > h.size {
+			return errs.Decode(codec.ErrCorrupt, -1, "pakt", "%d priming frames exceed the audio data", h.pakt.PrimingFrames)
+		}
+		h.size -= n
+	}
+	h.data += n
+	return nil
+}
+
+var _ sound.SourceSeeker = (*Decoder)(nil)
+
+// Codec returns the sample codec of the data, or codec.AnySampleCodec for
+// mu-law and A-law.
+func (d *Decoder) Codec() sample.Codec {
+	return d.f.codec
+}
+
+// FormatID returns the format identifier of the desc chunk, "lpcm", "ulaw"
+// or "alaw".
+func (d *Decoder) FormatID() string {
+	return d.f.id
+}
+
+// PacketTable returns the header of the pakt chunk, or nil if there is
+// none.
+func (d *Decoder) PacketTable() *PacketTable {
+	return d.pakt
+}
+
+// Info returns the keys and values of the info chunk.
+func (d *Decoder) Info() map[string]string {
+	return d.info
+}
+
+// Markers returns the markers of the mark chunk.
+func (d *Decoder) Markers() []Marker {
+	return d.markers
+}
+
+func (d *Decoder) SampleRate() freq.T {
+	return d.f.SampleRate()
+}
+
+func (d *Decoder) Channels() int {
+	return d.f.Channels()
+}
+
+func (d *Decoder) Receive(dst []float64) (int, error) {
+	nC := d.Channels()
+	if len(dst)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := int64(len(dst) / nC)
+	if rem := d.nFrm - d.pos; d.nFrm >= 0 && nF > rem {
+		nF = rem
+	}
+	if nF <= 0 {
+		return 0, io.EOF
+	}
+	if cap(d.tmp) < int(nF)*nC {
+		d.tmp = make([]float64, int(nF)*nC)
+	}
+	tmp := d.tmp[:int(nF)*nC]
+	bpf := d.f.bpf()
+	n := 0
+	for n < int(nF) {
+		m := int(nF) - n
+		if mx := len(d.buf) / bpf; m > mx {
+			m = mx
+		}
+		k, err := io.ReadFull(d.r, d.buf[:m*bpf])
+		k /= bpf
+		d.f.decode(tmp[n*nC:(n+k)*nC], d.buf[:k*bpf])
+		n += k
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// the audio data ends, early if the length is known.
+			d.nFrm = d.pos + int64(n)
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	for f := 0; f < n; f++ {
+		for c := 0; c < nC; c++ {
+			dst[c*n+f] = tmp[f*nC+c]
+		}
+	}
+	d.pos += int64(n)
+	return n, nil
+}
+
+// Len returns the number of frames, or -1 if it is unknown because a
+// decoder from NewStreamDecoder has not reached the end of audio data of
+// unspecified size.
+func (d *Decoder) Len() int64 {
+	return d.nFrm
+}
+
+func (d *Decoder) Pos() int64 {
+	return d.pos
+}
+
+// Duration returns the duration of the sound, or -1 if Len is unknown.
+func (d *Decoder) Duration() time.Duration {
+	if d.nFrm < 0 {
+		return -1
+	}
+	return time.Duration(d.nFrm) * d.SampleRate().Period()
+}
+
+// Seek seeks to frame f, returning an error if f is negative or the
+// decoder cannot seek.  Seeking beyond the end is allowed, after which
+// Receive returns io.EOF.
+func (d *Decoder) Seek(f int64) error {
+	if d.s == nil {
+		return codec.ErrUnsupportedFunction
+	}
+	if f < 0 {
+		return fmt.Errorf("caf: seek to negative frame %d", f)
+	}
+	if _, err := d.s.Seek(d.base+d.data+f*int64(d.f.bpf()), io.SeekStart); err != nil {
+		return err
+	}
+	d.pos = f
+	return nil
+}
+
+func (d *Decoder) Close() error {
+	return d.c.Close()
+}
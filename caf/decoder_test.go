@@ -0,0 +1,228 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package caf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// chunkBytes returns a chunk with data d.
+func chunkBytes(id string, d []byte) []byte {
+	b := make([]byte, chunkHdrSize, chunkHdrSize+len(d))
+	putChunkHdr(b, id, int64(len(d)))
+	return append(b, d...)
+}
+
+// fileBytes returns a CAF file containing chunks.
+func fileBytes(chunks ...[]byte) []byte {
+	b := []byte("caff\x00\x01\x00\x00")
+	for _, c := range chunks {
+		b = append(b, c...)
+	}
+	return b
+}
+
+// descBytes returns a desc chunk.
+func descBytes(rate float64, id string, flags, bytesPerPacket, framesPerPacket, channels, bits int) []byte {
+	d := make([]byte, descSize)
+	binary.BigEndian.PutUint64(d[0:8], math.Float64bits(rate))
+	copy(d[8:12], id)
+	for i, v := range []int{flags, bytesPerPacket, framesPerPacket, channels, bits} {
+		binary.BigEndian.PutUint32(d[12+4*i:], uint32(v))
+	}
+	return chunkBytes("desc", d)
+}
+
+// dataBytes returns a data chunk with audio data d.
+func dataBytes(d []byte) []byte {
+	return chunkBytes("data", append(make([]byte, 4), d...))
+}
+
+func decodeAll(t *testing.T, d *Decoder) []float64 {
+	t.Helper()
+	var res []float64
+	buf := make([]float64, 3*d.Channels())
+	for {
+		n, err := d.Receive(buf)
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, buf[:n*d.Channels()]...)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	for _, tc := range []struct {
+		id    string
+		flags int
+		bits  int
+		data  []byte
+		want  []float64
+		codec sample.Codec
+	}{
+		{"lpcm", 0, 16, []byte{0x40, 0, 0xc0, 0}, []float64{.5, -.5}, sample.SInt16B},
+		{"lpcm", flagLittleEndian, 16, []byte{0, 0x40, 0, 0xc0}, []float64{.5, -.5}, sample.SInt16L},
+		{"lpcm", 0, 8, []byte{0x40, 0xc0}, []float64{.5, -.5}, sample.SByte},
+		{"lpcm", flagLittleEndian, 24, []byte{0, 0, 0x40, 0, 0, 0xc0}, []float64{.5, -.5}, sample.SInt24L},
+		{"lpcm", 0, 20, []byte{0x40, 0, 0, 0xc0, 0, 0}, []float64{.5, -.5}, sample.SInt24B},
+		{"lpcm", flagFloat, 32, []byte{0x3f, 0, 0, 0, 0xbf, 0, 0, 0}, []float64{.5, -.5}, sample.SFloat32B},
+		{"lpcm", flagFloat | flagLittleEndian, 64, []byte{0, 0, 0, 0, 0, 0, 0xe0, 0x3f, 0, 0, 0, 0, 0, 0, 0xe0, 0xbf}, []float64{.5, -.5}, sample.SFloat64L},
+		{"ulaw", 0, 8, []byte{0xff, 0x80}, []float64{0, 32124. / 32768}, codec.AnySampleCodec},
+		{"alaw", 0, 8, []byte{0xd5, 0x2a}, []float64{8. / 32768, -32256. / 32768}, codec.AnySampleCodec},
+	} {
+		bpp := len(tc.data) / 2
+		data := fileBytes(descBytes(8000, tc.id, tc.flags, bpp, 1, 1, tc.bits), dataBytes(tc.data))
+		d, err := NewDecoder(codectest.NewFile(data))
+		if err != nil {
+			t.Errorf("%s %d: %v", tc.id, tc.bits, err)
+			continue
+		}
+		if d.Codec() != tc.codec || d.FormatID() != tc.id {
+			t.Errorf("%s %d: codec %s format %q", tc.id, tc.bits, d.Codec(), d.FormatID())
+		}
+		if d.SampleRate() != 8000*freq.Hertz {
+			t.Errorf("%s %d: sample rate %s", tc.id, tc.bits, d.SampleRate())
+		}
+		got := decodeAll(t, d)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s %d: got %v want %v", tc.id, tc.bits, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i]-tc.want[i]) > 1e-9 {
+				t.Errorf("%s %d: got %v want %v", tc.id, tc.bits, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	pakt := make([]byte, paktSize)
+	binary.BigEndian.PutUint64(pakt[0:8], 8)
+	binary.BigEndian.PutUint64(pakt[8:16], 5)
+	binary.BigEndian.PutUint32(pakt[16:20], 2)
+	binary.BigEndian.PutUint32(pakt[20:24], 1)
+	info := []byte("\x00\x00\x00\x02title\x00Take 1\x00artist\x00\x00")
+	mark := make([]byte, 8+2*markerSize)
+	binary.BigEndian.PutUint32(mark[4:8], 2)
+	for i, m := range []Marker{{Type: "rbeg", Pos: 1, ID: 7}, {Type: "rend", Pos: 4.5, ID: 9, Channel: 1}} {
+		b := mark[8+i*markerSize:]
+		copy(b, m.Type)
+		binary.BigEndian.PutUint64(b[4:12], math.Float64bits(m.Pos))
+		binary.BigEndian.PutUint32(b[12:16], uint32(m.ID))
+		binary.BigEndian.PutUint32(b[24:28], uint32(m.Channel))
+	}
+	strg := []byte("\x00\x00\x00\x01\x00\x00\x00\x07\x00\x00\x00\x00\x00\x00\x00\x00verse\x00")
+	samples := []byte{0, 0, 0, 1, 0, 2, 0, 3, 0, 4, 0, 5, 0, 6, 0, 7}
+	data := fileBytes(
+		descBytes(44100, "lpcm", 0, 2, 1, 1, 16),
+		chunkBytes("pakt", pakt),
+		chunkBytes("info", info),
+		chunkBytes("strg", strg),
+		dataBytes(samples),
+		chunkBytes("mark", mark),
+		chunkBytes("free", make([]byte, 10)))
+
+	d, err := NewDecoder(codectest.NewFile(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPakt := &PacketTable{Packets: 8, ValidFrames: 5, PrimingFrames: 2, RemainderFrames: 1}
+	if !reflect.DeepEqual(d.PacketTable(), wantPakt) {
+		t.Errorf("packet table %+v != %+v", d.PacketTable(), wantPakt)
+	}
+	wantInfo := map[string]string{"title": "Take 1", "artist": ""}
+	if !reflect.DeepEqual(d.Info(), wantInfo) {
+		t.Errorf("info %v != %v", d.Info(), wantInfo)
+	}
+	wantMarkers := []Marker{{Type: "rbeg", Pos: 1, ID: 7, Name: "verse"}, {Type: "rend", Pos: 4.5, ID: 9, Channel: 1}}
+	if !reflect.DeepEqual(d.Markers(), wantMarkers) {
+		t.Errorf("markers %+v != %+v", d.Markers(), wantMarkers)
+	}
+	// the priming and remainder frames are trimmed.
+	if d.Len() != 5 {
+		t.Errorf("len %d != 5", d.Len())
+	}
+	got := decodeAll(t, d)
+	if len(got) != 5 || got[0] != 2./32768 || got[4] != 6./32768 {
+		t.Errorf("decoded %v", got)
+	}
+	if err := d.Seek(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeAll(t, d); len(got) != 4 || got[0] != 3./32768 {
+		t.Errorf("decoded %v after seek to frame 1", got)
+	}
+
+	// a stream decoder trims too, but has no markers after the data.
+	sd, err := NewStreamDecoder(ioutil.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd.Markers() != nil {
+		t.Errorf("stream decoder markers %v", sd.Markers())
+	}
+	if got := decodeAll(t, sd); len(got) != 5 || got[0] != 2./32768 {
+		t.Errorf("stream decoded %v", got)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	desc := descBytes(8000, "lpcm", 0, 2, 1, 1, 16)
+	data := dataBytes([]byte{0, 0})
+	for _, tc := range []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"not caf", []byte("RIFF\x00\x00\x00\x04WAVE"), codec.ErrUnsupportedFormat},
+		{"version", []byte("caff\x00\x02\x00\x00"), codec.ErrUnsupportedFormat},
+		{"short", []byte("caff"), codec.ErrTruncated},
+		{"no desc", fileBytes(), codec.ErrTruncated},
+		{"desc not first", fileBytes(chunkBytes("free", nil), desc, data), codec.ErrCorrupt},
+		{"no data", fileBytes(desc), codec.ErrTruncated},
+		{"desc size", fileBytes(chunkBytes("desc", make([]byte, 20)), data), codec.ErrCorrupt},
+		{"rate", fileBytes(descBytes(0, "lpcm", 0, 2, 1, 1, 16), data), codec.ErrCorrupt},
+		{"channels", fileBytes(descBytes(8000, "lpcm", 0, 0, 1, 0, 16), data), codec.ErrCorrupt},
+		{"bits", fileBytes(descBytes(8000, "lpcm", 0, 5, 1, 1, 40), data), codec.ErrUnsupportedFormat},
+		{"float bits", fileBytes(descBytes(8000, "lpcm", flagFloat, 2, 1, 1, 16), data), codec.ErrUnsupportedFormat},
+		{"bytes per packet", fileBytes(descBytes(8000, "lpcm", 0, 4, 1, 1, 16), data), codec.ErrUnsupportedFormat},
+		{"frames per packet", fileBytes(descBytes(8000, "lpcm", 0, 2, 0, 1, 16), data), codec.ErrUnsupportedFormat},
+		{"format", fileBytes(descBytes(8000, "aac ", 0, 0, 1024, 1, 0), data), codec.ErrUnsupportedFormat},
+		{"chunk size", fileBytes(desc, []byte("free\xff\xff\xff\xff\xff\xff\xff\xfe")), codec.ErrCorrupt},
+		{"data size", fileBytes(desc, chunkBytes("data", []byte{0, 0})), codec.ErrCorrupt},
+		{"two data", fileBytes(desc, data, data), codec.ErrCorrupt},
+		{"pakt size", fileBytes(desc, chunkBytes("pakt", make([]byte, 8)), data), codec.ErrCorrupt},
+		{"priming", fileBytes(desc, chunkBytes("pakt", []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 9, 0, 0, 0, 0}), data), codec.ErrCorrupt},
+		{"info", fileBytes(desc, chunkBytes("info", []byte("\x00\x00\x00\x01key")), data), codec.ErrCorrupt},
+		{"mark", fileBytes(desc, chunkBytes("mark", []byte("\x00\x00\x00\x00\x00\x00\x00\x01")), data), codec.ErrCorrupt},
+		{"strg", fileBytes(desc, chunkBytes("strg", []byte("\x00\x00\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x09")), data), codec.ErrCorrupt},
+	} {
+		_, err := NewDecoder(codectest.NewFile(tc.data))
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: got %v not %v", tc.name, err, tc.err)
+			continue
+		}
+		var de *codec.DecodeError
+		if !errors.As(err, &de) || de.Codec != "caf" {
+			t.Errorf("%s: %v is not a caf *codec.DecodeError", tc.name, err)
+		}
+	}
+}
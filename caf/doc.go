@@ -0,0 +1,15 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package caf provides decoding and encoding of Apple Core Audio Format
+// (CAF) files.
+//
+// Package caf decodes linear PCM, integer or floating point of either
+// endianness, as well as mu-law and A-law data.  It reads the packet table
+// of a pakt chunk, trimming priming and remainder frames, the metadata of
+// an info chunk and the markers of a mark chunk, named by the strg chunk.
+// It encodes linear PCM.  Importing package caf registers it with
+// zikichombo.org/codec for the extension .caf.
+//
+// Package caf is part of http://zikichombo.org
+package caf /* import "zikichombo.org/codec/caf" */
@@ -0,0 +1,115 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package caf
+
+import (
+	"encoding/binary"
+	"io"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Encoder encodes linear PCM to a CAF file.
+type Encoder struct {
+	w      io.WriteCloser
+	s      io.Seeker // nil if the size of the data chunk is left unspecified
+	f      *format
+	base   int64 // offset in w of the start of the file
+	frames int64
+	buf    []byte
+	tmp    []float64
+}
+
+// NewEncoder creates an encoder of sound of form v with sample codec sc to
+// w, starting at its current offset.  Any sample codec is supported.
+//
+// If w is an io.Seeker, Close writes the size of the audio data in its
+// chunk header.  Otherwise the size is left as -1, which CAF defines as
+// audio data extending to the end of the file.
+func NewEncoder(w io.WriteCloser, v sound.Form, sc sample.Codec) (*Encoder, error) {
+	f, err := newFormat(v.Channels(), v.SampleRate(), sc)
+	if err != nil {
+		return nil, err
+	}
+	e := &Encoder{w: w, f: f}
+	if s, ok := w.(io.Seeker); ok {
+		if e.base, err = s.Seek(0, io.SeekCurrent); err == nil {
+			e.s = s
+		}
+	}
+	hdr := []byte("caff\x00\x01\x00\x00")
+	hdr = append(hdr, f.descBytes()...)
+	var data [chunkHdrSize + 4]byte
+	// the edit count is 0.
+	putChunkHdr(data[:], "data", -1)
+	hdr = append(hdr, data[:]...)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Codec returns the sample codec of the encoding.
+func (e *Encoder) Codec() sample.Codec {
+	return e.f.codec
+}
+
+func (e *Encoder) Channels() int {
+	return e.f.channels
+}
+
+func (e *Encoder) SampleRate() freq.T {
+	return e.f.rate
+}
+
+func (e *Encoder) Send(src []float64) error {
+	nC := e.Channels()
+	if len(src)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	n := len(src) / nC
+	if cap(e.tmp) < len(src) {
+		e.tmp = make([]float64, len(src))
+	}
+	tmp := e.tmp[:len(src)]
+	for f := 0; f < n; f++ {
+		for c := 0; c < nC; c++ {
+			tmp[f*nC+c] = src[c*n+f]
+		}
+	}
+	sz := len(src) * e.f.bytes()
+	if cap(e.buf) < sz {
+		e.buf = make([]byte, sz)
+	}
+	buf := e.buf[:sz]
+	e.f.codec.Encode(buf, tmp)
+	if _, err := e.w.Write(buf); err != nil {
+		return err
+	}
+	e.frames += int64(n)
+	return nil
+}
+
+// Close writes the size of the audio data, if w is an io.Seeker, and
+// closes w.
+func (e *Encoder) Close() error {
+	if e.s != nil {
+		dataHdr := int64(fileHdrSize + chunkHdrSize + descSize)
+		size := 4 + e.frames*int64(e.f.bpf())
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(size))
+		if _, err := e.s.Seek(e.base+dataHdr+4, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := e.w.Write(b[:]); err != nil {
+			return err
+		}
+		if _, err := e.s.Seek(e.base+dataHdr+chunkHdrSize+size, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return e.w.Close()
+}
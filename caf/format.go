@@ -0,0 +1,200 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package caf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/internal/g711"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Format identifiers of the desc chunk.
+const (
+	fmtLPCM = "lpcm"
+	fmtULaw = "ulaw"
+	fmtALaw = "alaw"
+)
+
+// Format flags of linear PCM.
+const (
+	flagFloat        = 1
+	flagLittleEndian = 2
+)
+
+// descSize is the size of the desc chunk.
+const descSize = 32
+
+// maxBlockAlign is the largest frame size accepted, which bounds the
+// decoder's buffer.
+const maxBlockAlign = 1<<16 - 1
+
+// format describes the audio data of a CAF file, from its desc chunk.
+type format struct {
+	rate     freq.T
+	id       string
+	flags    uint32
+	channels int
+	bits     int
+	codec    sample.Codec // AnySampleCodec for ulaw and alaw
+	law      g711.Law
+}
+
+func (f *format) SampleRate() freq.T {
+	return f.rate
+}
+
+func (f *format) Channels() int {
+	return f.channels
+}
+
+// bytes returns the number of bytes per sample.
+func (f *format) bytes() int {
+	if f.codec == codec.AnySampleCodec {
+		return 1
+	}
+	return f.codec.Bytes()
+}
+
+// bpf returns the number of bytes per frame.
+func (f *format) bpf() int {
+	return f.bytes() * f.channels
+}
+
+// decode decodes len(dst) samples from src.
+func (f *format) decode(dst []float64, src []byte) {
+	if f.codec == codec.AnySampleCodec {
+		f.law.Decode(dst, src)
+		return
+	}
+	f.codec.Decode(dst, src)
+}
+
+// parseDesc parses a desc chunk with data d at offset off.
+func parseDesc(d []byte, off int64) (*format, error) {
+	descErr := func(err error, k int, format string, args ...interface{}) error {
+		return errs.Decode(err, off+int64(k), "desc", format, args...)
+	}
+	if len(d) < descSize {
+		return nil, descErr(codec.ErrCorrupt, -8, "chunk too small: %d", len(d))
+	}
+	rate := math.Float64frombits(binary.BigEndian.Uint64(d[0:8]))
+	f := &format{
+		id:       string(d[8:12]),
+		flags:    binary.BigEndian.Uint32(d[12:16]),
+		channels: int(binary.BigEndian.Uint32(d[24:28])),
+		bits:     int(binary.BigEndian.Uint32(d[28:32]))}
+	bytesPerPacket := binary.BigEndian.Uint32(d[16:20])
+	framesPerPacket := binary.BigEndian.Uint32(d[20:24])
+	if !(rate >= 1 && rate < 1<<32) {
+		return nil, descErr(codec.ErrCorrupt, 0, "sample rate %g", rate)
+	}
+	f.rate = freq.T(math.Round(rate * float64(freq.Hertz)))
+	if f.channels <= 0 || f.channels > maxBlockAlign {
+		return nil, descErr(codec.ErrCorrupt, 24, "%d channels", int32(f.channels))
+	}
+	switch f.id {
+	case fmtLPCM:
+		var sc sample.Codec
+		le := f.flags&flagLittleEndian != 0
+		if f.flags&flagFloat != 0 {
+			switch f.bits {
+			case 32:
+				sc = pick(le, sample.SFloat32L, sample.SFloat32B)
+			case 64:
+				sc = pick(le, sample.SFloat64L, sample.SFloat64B)
+			default:
+				return nil, descErr(codec.ErrUnsupportedFormat, 28, "unsupported float sample size: %d", f.bits)
+			}
+		} else {
+			switch {
+			case f.bits < 1 || f.bits > 32:
+				return nil, descErr(codec.ErrUnsupportedFormat, 28, "unsupported sample size: %d", f.bits)
+			case f.bits <= 8:
+				sc = sample.SByte
+			case f.bits <= 16:
+				sc = pick(le, sample.SInt16L, sample.SInt16B)
+			case f.bits <= 24:
+				sc = pick(le, sample.SInt24L, sample.SInt24B)
+			default:
+				sc = pick(le, sample.SInt32L, sample.SInt32B)
+			}
+		}
+		f.codec = sc
+	case fmtULaw:
+		f.codec, f.law = codec.AnySampleCodec, g711.ULaw
+	case fmtALaw:
+		f.codec, f.law = codec.AnySampleCodec, g711.ALaw
+	default:
+		return nil, descErr(codec.ErrUnsupportedFormat, 8, "unsupported format %q", f.id)
+	}
+	if f.bpf() > maxBlockAlign {
+		return nil, descErr(codec.ErrCorrupt, 24, "%d channels of %d bytes exceed the largest frame", f.channels, f.bytes())
+	}
+	if framesPerPacket != 1 {
+		return nil, descErr(codec.ErrUnsupportedFormat, 20, "%d frames per packet", framesPerPacket)
+	}
+	if bytesPerPacket != uint32(f.bpf()) {
+		return nil, descErr(codec.ErrUnsupportedFormat, 16, "%d bytes per packet for %d channels of %d bytes", bytesPerPacket, f.channels, f.bytes())
+	}
+	return f, nil
+}
+
+func pick(le bool, l, b sample.Codec) sample.Codec {
+	if le {
+		return l
+	}
+	return b
+}
+
+// newFormat returns the linear PCM format for encoding with sample codec
+// sc, or codec.ErrUnsupportedSampleCodec.
+func newFormat(channels int, rate freq.T, sc sample.Codec) (*format, error) {
+	if channels < 1 {
+		return nil, fmt.Errorf("caf: unsupported channel count %d", channels)
+	}
+	if rate <= 0 {
+		return nil, fmt.Errorf("caf: unsupported sample rate %s", rate)
+	}
+	f := &format{id: fmtLPCM, channels: channels, rate: rate, codec: sc}
+	switch sc {
+	case sample.SByte, sample.SInt16B, sample.SInt24B, sample.SInt32B:
+	case sample.SInt16L, sample.SInt24L, sample.SInt32L:
+		f.flags = flagLittleEndian
+	case sample.SFloat32B, sample.SFloat64B:
+		f.flags = flagFloat
+	case sample.SFloat32L, sample.SFloat64L:
+		f.flags = flagFloat | flagLittleEndian
+	default:
+		return nil, codec.ErrUnsupportedSampleCodec
+	}
+	f.bits = sc.Bits()
+	return f, nil
+}
+
+// descBytes returns the desc chunk, including its header, for f.
+func (f *format) descBytes() []byte {
+	b := make([]byte, chunkHdrSize+descSize)
+	putChunkHdr(b, "desc", descSize)
+	d := b[chunkHdrSize:]
+	binary.BigEndian.PutUint64(d[0:8], math.Float64bits(float64(f.rate)/float64(freq.Hertz)))
+	copy(d[8:12], f.id)
+	binary.BigEndian.PutUint32(d[12:16], f.flags)
+	binary.BigEndian.PutUint32(d[16:20], uint32(f.bpf()))
+	binary.BigEndian.PutUint32(d[20:24], 1)
+	binary.BigEndian.PutUint32(d[24:28], uint32(f.channels))
+	binary.BigEndian.PutUint32(d[28:32], uint32(f.bits))
+	return b
+}
+
+func (f *format) String() string {
+	if f.codec == codec.AnySampleCodec {
+		return fmt.Sprintf("%d channels at %s, %s", f.channels, f.rate, f.law)
+	}
+	return fmt.Sprintf("%d channels at %s, %d bits %s", f.channels, f.rate, f.bits, f.codec)
+}
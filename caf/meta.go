@@ -0,0 +1,146 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package caf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"zikichombo.org/codec"
+)
+
+// PacketTable is the header of a pakt chunk, giving the number of frames
+// of the audio data which are valid.
+//
+// The packet descriptions following the header are not kept, as the
+// formats of package caf have constant packet sizes.
+type PacketTable struct {
+	Packets int64
+	// ValidFrames is the number of frames after the priming frames
+	// which are part of the sound.
+	ValidFrames int64
+	// PrimingFrames precede the valid frames, RemainderFrames follow
+	// them.
+	PrimingFrames, RemainderFrames int
+}
+
+// paktSize is the size of the header of a pakt chunk.
+const paktSize = 24
+
+// parsePakt parses a pakt chunk with data d at offset off.
+func parsePakt(d []byte, off int64) (*PacketTable, error) {
+	if len(d) < paktSize {
+		return nil, errs.Decode(codec.ErrCorrupt, off, "pakt", "chunk too small: %d", len(d))
+	}
+	p := &PacketTable{
+		Packets:         int64(binary.BigEndian.Uint64(d[0:8])),
+		ValidFrames:     int64(binary.BigEndian.Uint64(d[8:16])),
+		PrimingFrames:   int(int32(binary.BigEndian.Uint32(d[16:20]))),
+		RemainderFrames: int(int32(binary.BigEndian.Uint32(d[20:24])))}
+	switch {
+	case p.Packets < 0:
+		return nil, errs.Decode(codec.ErrCorrupt, off, "pakt", "%d packets", p.Packets)
+	case p.ValidFrames < 0:
+		return nil, errs.Decode(codec.ErrCorrupt, off+8, "pakt", "%d valid frames", p.ValidFrames)
+	case p.PrimingFrames < 0 || p.RemainderFrames < 0:
+		return nil, errs.Decode(codec.ErrCorrupt, off+16, "pakt", "%d priming and %d remainder frames", p.PrimingFrames, p.RemainderFrames)
+	}
+	return p, nil
+}
+
+// parseInfo parses an info chunk with data d at offset off, which holds
+// pairs of null terminated keys and values.
+func parseInfo(d []byte, off int64) (map[string]string, error) {
+	if len(d) < 4 {
+		return nil, errs.Decode(codec.ErrCorrupt, off, "info", "chunk too small: %d", len(d))
+	}
+	n := int(binary.BigEndian.Uint32(d[:4]))
+	// each entry takes at least 2 bytes.
+	if n > len(d)/2 {
+		return nil, errs.Decode(codec.ErrCorrupt, off, "info", "%d entries in %d bytes", n, len(d))
+	}
+	info := make(map[string]string, n)
+	p := 4
+	for i := 0; i < n; i++ {
+		var kv [2]string
+		for j := range kv {
+			k := bytes.IndexByte(d[p:], 0)
+			if k < 0 {
+				return nil, errs.Decode(codec.ErrCorrupt, off+int64(p), "info", "entry %d of %d truncated", i, n)
+			}
+			kv[j] = string(d[p : p+k])
+			p += k + 1
+		}
+		info[kv[0]] = kv[1]
+	}
+	return info, nil
+}
+
+// Marker is a position in the audio data, from a mark chunk.
+type Marker struct {
+	// Type is the four character code of the marker type, for example
+	// "rbeg" for the beginning of a region.
+	Type string
+	// Pos is the frame position, which may be fractional.
+	Pos float64
+	// ID identifies the marker, and its name in the strg chunk.
+	ID int
+	// Channel is the channel to which the marker applies, counting from
+	// 1, or 0 for all.
+	Channel int
+	Name    string
+}
+
+// markerSize is the size of a marker in a mark chunk.
+const markerSize = 28
+
+// parseMark parses a mark chunk with data d at offset off.
+func parseMark(d []byte, off int64) ([]Marker, error) {
+	if len(d) < 8 {
+		return nil, errs.Decode(codec.ErrCorrupt, off, "mark", "chunk too small: %d", len(d))
+	}
+	n := int(binary.BigEndian.Uint32(d[4:8]))
+	if n > (len(d)-8)/markerSize {
+		return nil, errs.Decode(codec.ErrCorrupt, off+4, "mark", "%d markers in %d bytes", n, len(d))
+	}
+	ms := make([]Marker, n)
+	for i := range ms {
+		b := d[8+i*markerSize:]
+		ms[i] = Marker{
+			Type:    string(b[0:4]),
+			Pos:     math.Float64frombits(binary.BigEndian.Uint64(b[4:12])),
+			ID:      int(binary.BigEndian.Uint32(b[12:16])),
+			Channel: int(binary.BigEndian.Uint32(b[24:28]))}
+	}
+	return ms, nil
+}
+
+// parseStrg parses a strg chunk with data d at offset off, returning its
+// strings by ID.
+func parseStrg(d []byte, off int64) (map[int]string, error) {
+	if len(d) < 4 {
+		return nil, errs.Decode(codec.ErrCorrupt, off, "strg", "chunk too small: %d", len(d))
+	}
+	n := int(binary.BigEndian.Uint32(d[:4]))
+	if n > (len(d)-4)/12 {
+		return nil, errs.Decode(codec.ErrCorrupt, off, "strg", "%d strings in %d bytes", n, len(d))
+	}
+	strs := d[4+n*12:]
+	res := make(map[int]string, n)
+	for i := 0; i < n; i++ {
+		b := d[4+i*12:]
+		id := int(binary.BigEndian.Uint32(b[0:4]))
+		p := int64(binary.BigEndian.Uint64(b[4:12]))
+		if p < 0 || p >= int64(len(strs)) {
+			return nil, errs.Decode(codec.ErrCorrupt, off+int64(4+i*12), "strg", "string %d at %d beyond %d bytes", id, p, len(strs))
+		}
+		s := strs[p:]
+		if k := bytes.IndexByte(s, 0); k >= 0 {
+			s = s[:k]
+		}
+		res[id] = string(s)
+	}
+	return res, nil
+}
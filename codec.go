@@ -7,13 +7,16 @@ import (
 	"reflect"
 
 	"zikichombo.org/sound"
-	"zikichombo.org/sound/ops"
 	"zikichombo.org/sound/sample"
 )
 
 // ErrUnknownCodec is an error representing that a codec is unknown.
 var ErrUnknownCodec = errors.New("unknown codec")
 
+// ErrAmbiguousCodec is an error representing that more than one codec
+// recognizes some data with the same confidence.
+var ErrAmbiguousCodec = errors.New("ambiguous codec")
+
 // ErrUnsupportedFunction is an error which is returned
 // when a request is made of a Codec to perform some
 // function it doesn't implement (amongst decoding/encoding/seeking/random access).
@@ -108,6 +111,31 @@ type Codec interface {
 	RandomAccess(ws IoReadWriteSeekCloser, v sound.Form, c sample.Codec) (sound.RandomAccess, error)
 }
 
+// Sniff confidence levels.  Codecs which implement ConfidenceSniffer may
+// return any value, these are provided as reference points.
+const (
+	// SniffNone indicates the data is not recognized.
+	SniffNone = 0
+	// SniffWeak indicates the data is plausible, for example for formats
+	// without a magic number.
+	SniffWeak = 25
+	// SniffDefault is the confidence of a Codec whose Sniff returns true
+	// and which does not implement ConfidenceSniffer.
+	SniffDefault = 50
+	// SniffStrong indicates the data carries an unambiguous signature.
+	SniffStrong = 100
+)
+
+// ConfidenceSniffer is an optional interface which a Codec may implement to
+// report how confident it is in recognizing data.  When implemented,
+// SniffConfidence is used in place of Sniff when selecting a codec.
+type ConfidenceSniffer interface {
+	// SniffConfidence is like Codec.Sniff but returns a confidence level
+	// rather than a boolean.  A value less than or equal to SniffNone
+	// indicates the data is not recognized.
+	SniffConfidence(*bufio.Reader) int
+}
+
 type codec struct {
 	Codec
 
@@ -119,10 +147,11 @@ type codec struct {
 	pkgPath string
 }
 
-var codecs []codec
-
 func getPkgPath(v interface{}) string {
 	typ := reflect.ValueOf(v).Type()
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
 	return typ.PkgPath()
 }
 
@@ -131,14 +160,31 @@ func getPkgPath(v interface{}) string {
 //
 // A package "p" implementing a Codec can register a codec in its init()
 // function.
+//
+// RegisterCodec may be called concurrently with other functions in this
+// package.
 func RegisterCodec(c Codec) {
-	codecs = append(codecs, codec{
-		Codec:   c,
-		pkgPath: getPkgPath(c)})
+	defaultRegistry.Register(c)
+}
+
+// UnregisterCodec removes all registrations of c, returning whether c was
+// registered.  It is intended for tests and plugins which install codecs
+// temporarily.  The dynamic type of c must be comparable.
+//
+// UnregisterCodec may be called concurrently with other functions in this
+// package.
+func UnregisterCodec(c Codec) bool {
+	return defaultRegistry.Unregister(c)
 }
 
 // CodecFor tries to find a codec based on a filename extension.
 //
+// The extension ext may be given with or without the leading '.', or as part
+// of a file name or path, such as "take1.WAV".  Extensions are compared case
+// insensitively, and the codec with the longest extension matching the end of
+// ext is chosen, so that compound extensions such as ".opus.ogg" take
+// precedence over ".ogg".
+//
 // The returned codec, although a pointer to a struct with fields, should be
 // treated as read-only.  Not doing so may result in race conditions or worse.
 //
@@ -148,17 +194,7 @@ func RegisterCodec(c Codec) {
 // may exist, the first codec whose package path p is such that pkgSel(p) is true
 // will be returned.
 func CodecFor(ext string, pkgSel func(string) bool) (Codec, error) {
-	for i := range codecs {
-		c := &codecs[i]
-		for _, codExt := range c.Extensions() {
-			if ext == codExt {
-				if pkgSel == nil || pkgSel(c.pkgPath) {
-					return c.Codec, nil
-				}
-			}
-		}
-	}
-	return nil, ErrUnknownCodec
+	return defaultRegistry.CodecFor(ext, pkgSel)
 }
 
 // Decoder tries to turn an io.ReadCloser into a sound.Source.  If it fails, it
@@ -167,8 +203,9 @@ func CodecFor(ext string, pkgSel func(string) bool) (Codec, error) {
 // The function pkgSel may be used to filter or select packages implementing
 // codecs.  If the supplied value is nil, then by default the behavior is as if
 // the function body were "return true".  As multiple codec implementations may
-// exist, the first codec whose package path p is such that pkgSel(p) is true
-// will be returned.
+// recognize the data, the codec used is the first one returned by SniffAll,
+// that is the one with the highest confidence and, amongst those, the first
+// registered.  Callers which need to detect ambiguity may use Sniff or SniffAll.
 //
 // If it succeeds, it also returns a sample.Codec which may either be:
 //
@@ -177,11 +214,7 @@ func CodecFor(ext string, pkgSel func(string) bool) (Codec, error) {
 //
 // 2. A sample.Codec which defined the data received in sound.Source.
 func Decoder(r io.ReadCloser, pkgSel func(string) bool) (sound.Source, sample.Codec, error) {
-	theCodec, rr := sniff(r, pkgSel)
-	if theCodec == nil {
-		return nil, AnySampleCodec, ErrUnknownCodec
-	}
-	return theCodec.Decoder(rr)
+	return defaultRegistry.Decoder(r, pkgSel)
 }
 
 // SeekingDecoder is exactly like Decoder with respect to all arguments and
@@ -190,40 +223,60 @@ func Decoder(r io.ReadCloser, pkgSel func(string) bool) (sound.Source, sample.Co
 // 1. The io.ReadCloser must be seekable.
 //
 // 2. It returns a sound.SourceSeeker rather than a sound.Source.
+//
+// 3. The encoded data is taken to start at the current offset of r, which
+// need not be 0, for example when the data is embedded in a larger file.
+// Sniffing restores the offset of r before the codec's SeekingDecoder is
+// called, and any error seeking r is returned.
 func SeekingDecoder(r IoReadSeekCloser, pkgSel func(string) bool) (sound.SourceSeeker, sample.Codec, error) {
-	theCodec, _ := sniff(r, pkgSel)
-	if theCodec == nil {
-		return nil, AnySampleCodec, ErrUnknownCodec
-	}
-	r.Seek(0, io.SeekStart)
-	return theCodec.SeekingDecoder(r)
+	return defaultRegistry.SeekingDecoder(r, pkgSel)
 }
 
-type brCloser struct {
-	*bufio.Reader
-	io.Closer
+// Candidate is a codec which recognizes some data, together with the
+// confidence of the recognition.
+type Candidate struct {
+	Codec      Codec
+	PkgPath    string
+	Confidence int
+}
+
+// SniffAll returns all registered codecs which recognize the data in br and
+// whose package path p is such that pkgSel(p) is true.  As with Decoder, a nil
+// pkgSel selects all packages.
+//
+// The result is ordered by decreasing confidence.  Codecs with equal
+// confidence are ordered by registration.
+//
+// Like Codec.Sniff, SniffAll only calls br.Peek().
+func SniffAll(br *bufio.Reader, pkgSel func(string) bool) []Candidate {
+	return defaultRegistry.SniffAll(br, pkgSel)
 }
 
-func sniff(r io.ReadCloser, pkgSel func(string) bool) (Codec, *brCloser) {
-	br := bufio.NewReader(r)
-	var theCodec Codec
-	for i := range codecs {
-		c := &codecs[i]
-		if c.Sniff(br) && (pkgSel == nil || pkgSel(c.pkgPath)) {
-			theCodec = c.Codec
-		}
+// Sniff returns the codec which recognizes the data in br with the highest
+// confidence, selecting packages with pkgSel as in SniffAll.
+//
+// Sniff returns ErrUnknownCodec if no codec recognizes the data, and
+// ErrAmbiguousCodec if more than one codec recognizes the data with
+// the highest confidence.  Decoder and SeekingDecoder do not treat
+// ambiguity as an error, they use the first registered such codec.
+func Sniff(br *bufio.Reader, pkgSel func(string) bool) (Codec, error) {
+	return defaultRegistry.Sniff(br, pkgSel)
+}
+
+func sniffConfidence(c Codec, br *bufio.Reader) int {
+	if cs, ok := c.(ConfidenceSniffer); ok {
+		return cs.SniffConfidence(br)
+	}
+	if c.Sniff(br) {
+		return SniffDefault
 	}
-	return theCodec, &brCloser{Reader: br, Closer: r}
+	return SniffNone
 }
 
 // Encoder tries to turn an io.WriteCloser into a sound.Sink
 // given a filename extension and a form (channels + sample rate)
 func Encoder(dst io.WriteCloser, ext string, v sound.Form) (sound.Sink, error) {
-	co, err := CodecFor(ext, nil)
-	if err != nil {
-		return nil, err
-	}
-	return co.Encoder(dst, v, co.DefaultSampleCodec())
+	return defaultRegistry.Encoder(dst, ext, v)
 }
 
 // EncoderWith tries to turn an io.WriteCloser into a sound.Sink
@@ -233,18 +286,14 @@ func Encoder(dst io.WriteCloser, ext string, v sound.Form) (sound.Sink, error) {
 // The sample codec may be AnySampleCodec, which should be used when
 // the caller is not sure of the desired sample codec c.
 func EncoderWith(dst io.WriteCloser, ext string, v sound.Form, c sample.Codec) (sound.Sink, error) {
-	co, err := CodecFor(ext, nil)
-	if err != nil {
-		return nil, err
-	}
-	return co.Encoder(dst, v, c)
+	return defaultRegistry.EncoderWith(dst, ext, v, c)
 }
 
 // Encode encodes a sound.Source to an io.WriteCloser, selecting
 // the codec based on a filename extension ext. It returns any
 // error that may have been encountered in that process.
 func Encode(dst io.WriteCloser, src sound.Source, ext string) error {
-	return EncodeWith(dst, src, ext, AnySampleCodec)
+	return defaultRegistry.Encode(dst, src, ext)
 }
 
 // EncodeWith encodes a sound.Source to an io.WriteCloser, selecting
@@ -252,9 +301,5 @@ func Encode(dst io.WriteCloser, src sound.Source, ext string) error {
 //
 // EncodeWith returns any error that may have been encountered in that process.
 func EncodeWith(dst io.WriteCloser, src sound.Source, ext string, co sample.Codec) error {
-	snk, err := EncoderWith(dst, ext, src, co)
-	if err != nil {
-		return err
-	}
-	return ops.Copy(snk, src)
+	return defaultRegistry.EncodeWith(dst, src, ext, co)
 }
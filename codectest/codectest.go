@@ -0,0 +1,542 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codectest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Options controls Run.  The zero value, or nil, gives the defaults.
+type Options struct {
+	// Forms lists the forms to test.  The default is mono at 8kHz, stereo
+	// at 44.1kHz and 3 channels at 48kHz.
+	Forms []sound.Form
+
+	// SampleCodecs lists the sample codecs to test.  The default is those
+	// listed by the codec if it implements codec.Describer, and otherwise
+	// its default sample codec.
+	SampleCodecs []sample.Codec
+
+	// Frames is the number of frames encoded for each form and sample codec.
+	// The default is 2500.
+	Frames int
+
+	// Tolerance is the largest difference between a sample and its decoded
+	// value which is accepted.  The default is the quantization step of the
+	// sample codec, or for AnySampleCodec, not to compare samples.
+	Tolerance float64
+
+	// NoSniff indicates that the codec cannot recognize its encodings,
+	// for example because they have no header.
+	NoSniff bool
+}
+
+// Run tests the conformance of c to the contracts of codec.Codec.
+//
+// For each form and sample codec in opts, Run encodes a test signal, then
+// checks that c sniffs the encoding and that each of Decoder, SeekingDecoder
+// and RandomAccess which c supports reproduces the signal, reports its form,
+// Len and Duration, seeks accurately, and closes the underlying reader or
+// writer when closed.
+//
+// If c implements codec.Describer, the functions c supports are those in its
+// capabilities, and the others must return codec.ErrUnsupportedFunction.
+// Otherwise, functions which return codec.ErrUnsupportedFunction are skipped.
+// Decoding is only tested for codecs which can encode.
+func Run(t *testing.T, c codec.Codec, opts *Options) {
+	r := &runner{c: c, frames: 2500}
+	if opts != nil {
+		r.Options = *opts
+	}
+	if r.Frames > 0 {
+		r.frames = r.Frames
+	}
+	if len(r.Forms) == 0 {
+		r.Forms = []sound.Form{
+			sound.NewForm(8000*freq.Hertz, 1),
+			sound.NewForm(44100*freq.Hertz, 2),
+			sound.NewForm(48000*freq.Hertz, 3)}
+	}
+	if d, ok := c.(codec.Describer); ok {
+		desc := d.Describe()
+		r.desc = &desc
+		if len(r.SampleCodecs) == 0 {
+			r.SampleCodecs = desc.SampleCodecs
+		}
+	}
+	if len(r.SampleCodecs) == 0 {
+		r.SampleCodecs = []sample.Codec{c.DefaultSampleCodec()}
+	}
+	t.Run("Contract", r.testContract)
+	if !r.can(codec.CanEncode) {
+		return
+	}
+	for _, v := range r.Forms {
+		for _, sc := range r.SampleCodecs {
+			v, sc := v, sc
+			name := fmt.Sprintf("%s/%dch/%s", v.SampleRate(), v.Channels(), scName(sc))
+			t.Run(name, func(t *testing.T) {
+				r.testForm(t, v, sc)
+			})
+		}
+	}
+}
+
+type runner struct {
+	Options
+	c      codec.Codec
+	desc   *codec.Description
+	frames int
+}
+
+// can returns whether c is expected to have capability cap.  Without a
+// description, c is assumed capable, and tests skip functions returning
+// codec.ErrUnsupportedFunction.
+func (r *runner) can(cap codec.Capabilities) bool {
+	return r.desc == nil || r.desc.Capabilities.Has(cap)
+}
+
+// unsupported checks err from a function requiring capability cap.  It
+// returns true if the function is unsupported and the test should stop.
+func (r *runner) unsupported(t *testing.T, cap codec.Capabilities, err error) bool {
+	t.Helper()
+	if err == codec.ErrUnsupportedFunction {
+		if r.desc != nil {
+			t.Errorf("%s: capability %s is described but unsupported", r.name(), cap)
+		}
+		return true
+	}
+	return false
+}
+
+func (r *runner) name() string {
+	if r.desc != nil && r.desc.Name != "" {
+		return r.desc.Name
+	}
+	return fmt.Sprintf("%T", r.c)
+}
+
+// notAudio is data which no codec with a header should recognize.
+var notAudio = []byte(strings.Repeat("codectest: this is not audio. ", 8))
+
+func (r *runner) testContract(t *testing.T) {
+	c := r.c
+	for _, ext := range c.Extensions() {
+		if !strings.HasPrefix(ext, ".") {
+			t.Errorf("extension %q has no leading '.'", ext)
+		}
+	}
+	if r.desc != nil {
+		v := r.Forms[0]
+		if !r.can(codec.CanDecode) {
+			if _, _, err := c.Decoder(readCloser{NewFile(notAudio)}); err != codec.ErrUnsupportedFunction {
+				t.Errorf("Decoder without CanDecode: expected codec.ErrUnsupportedFunction got %v", err)
+			}
+		}
+		if !r.can(codec.CanSeek) {
+			if _, _, err := c.SeekingDecoder(NewFile(notAudio)); err != codec.ErrUnsupportedFunction {
+				t.Errorf("SeekingDecoder without CanSeek: expected codec.ErrUnsupportedFunction got %v", err)
+			}
+		}
+		if !r.can(codec.CanEncode) {
+			if _, err := c.Encoder(NewFile(nil), v, codec.AnySampleCodec); err != codec.ErrUnsupportedFunction {
+				t.Errorf("Encoder without CanEncode: expected codec.ErrUnsupportedFunction got %v", err)
+			}
+		}
+		if !r.can(codec.CanRandomAccess) {
+			if _, err := c.RandomAccess(NewFile(nil), v, codec.AnySampleCodec); err != codec.ErrUnsupportedFunction {
+				t.Errorf("RandomAccess without CanRandomAccess: expected codec.ErrUnsupportedFunction got %v", err)
+			}
+		}
+		if r.can(codec.CanEncode) && len(r.desc.SampleCodecs) != 0 {
+			if sc, ok := undescribed(r.desc.SampleCodecs); ok {
+				_, err := c.Encoder(NewFile(nil), v, sc)
+				if err != codec.ErrUnsupportedSampleCodec {
+					t.Errorf("Encoder with undescribed sample codec %s: expected codec.ErrUnsupportedSampleCodec got %v", sc, err)
+				}
+			}
+		}
+	}
+	if r.NoSniff {
+		return
+	}
+	if c.Sniff(bufio.NewReader(bytes.NewReader(notAudio))) {
+		t.Errorf("sniffed data which is not audio")
+	}
+	if r.can(codec.CanDecode) {
+		if src, _, err := c.Decoder(readCloser{NewFile(notAudio)}); err == nil {
+			src.Close()
+			t.Errorf("Decoder accepted data which is not audio")
+		}
+		if src, _, err := c.Decoder(readCloser{NewFile(nil)}); err == nil {
+			src.Close()
+			t.Errorf("Decoder accepted empty data")
+		}
+	}
+}
+
+// allSampleCodecs lists the sample codecs tried by undescribed.
+var allSampleCodecs = []sample.Codec{
+	sample.SByte,
+	sample.SInt16L, sample.SInt16B,
+	sample.SInt24L, sample.SInt24B,
+	sample.SInt32L, sample.SInt32B,
+	sample.SFloat32L, sample.SFloat32B,
+	sample.SFloat64L, sample.SFloat64B}
+
+// undescribed returns a sample codec not in cs, if there is one.
+func undescribed(cs []sample.Codec) (sample.Codec, bool) {
+	for _, sc := range allSampleCodecs {
+		found := false
+		for _, o := range cs {
+			if o == sc {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return sc, true
+		}
+	}
+	return codec.AnySampleCodec, false
+}
+
+func scName(sc sample.Codec) string {
+	if sc == codec.AnySampleCodec {
+		return "any"
+	}
+	return sc.String()
+}
+
+// signal returns n frames of v in the layout of sound.Source, where
+// channel c of frame f is at c*n+f.  Each channel has a distinct frequency,
+// so that channels and frames are distinguishable.
+func signal(v sound.Form, n int) []float64 {
+	nC := v.Channels()
+	d := make([]float64, nC*n)
+	for c := 0; c < nC; c++ {
+		hz := float64(220 * (c + 1))
+		for f := 0; f < n; f++ {
+			secs := float64(f) * float64(freq.Hertz) / float64(v.SampleRate())
+			d[c*n+f] = 0.8 * math.Sin(2*math.Pi*hz*secs)
+		}
+	}
+	return d
+}
+
+// frames returns frames [i, j) of d, which has n frames of nC channels.
+func frames(d []float64, nC, n, i, j int) []float64 {
+	res := make([]float64, 0, nC*(j-i))
+	for c := 0; c < nC; c++ {
+		res = append(res, d[c*n+i:c*n+j]...)
+	}
+	return res
+}
+
+// tolerance returns the largest accepted difference between a sample
+// encoded with sc and its decoded value, or -1 to not compare.
+func (r *runner) tolerance(sc sample.Codec) float64 {
+	switch {
+	case r.Tolerance > 0:
+		return r.Tolerance
+	case sc == codec.AnySampleCodec:
+		return -1
+	case sc.IsFloat():
+		return 1e-6
+	}
+	// allow for rounding and for scaling by 2^(bits-1)-1 or 2^(bits-1).
+	return 2 / float64(int64(1)<<uint(sc.Bits()-1))
+}
+
+// encode sends d, which has n frames, to snk in blocks of irregular size.
+func (r *runner) encode(t *testing.T, snk sound.Sink, d []float64, n int) {
+	t.Helper()
+	nC := snk.Channels()
+	for i, sz := 0, 1; i < n; i, sz = i+sz, sz*3+1 {
+		j := i + sz
+		if j > n {
+			j = n
+		}
+		if err := snk.Send(frames(d, nC, n, i, j)); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+}
+
+func (r *runner) testForm(t *testing.T, v sound.Form, sc sample.Codec) {
+	c, n, nC := r.c, r.frames, v.Channels()
+	d := signal(v, n)
+	w := NewFile(nil)
+	if snk, err := c.Encoder(writeCloser{w}, v, sc); err == nil {
+		snk.Close()
+	} else if err != codec.ErrUnsupportedFunction {
+		t.Errorf("Encoder without io.Seeker: expected success or codec.ErrUnsupportedFunction got %v", err)
+	}
+	w = NewFile(nil)
+	snk, err := c.Encoder(w, v, sc)
+	if r.unsupported(t, codec.CanEncode, err) {
+		return
+	}
+	if err != nil {
+		t.Fatalf("Encoder: %v", err)
+	}
+	if snk.Channels() != nC || snk.SampleRate() != v.SampleRate() {
+		t.Errorf("Encoder form %s %dch not %s %dch", snk.SampleRate(), snk.Channels(), v.SampleRate(), nC)
+	}
+	if nC > 1 {
+		if err := snk.Send(make([]float64, nC+1)); err != sound.ErrChannelAlignment {
+			t.Errorf("Send of misaligned buffer: expected sound.ErrChannelAlignment got %v", err)
+		}
+	}
+	r.encode(t, snk, d, n)
+	if err := snk.Close(); err != nil {
+		t.Fatalf("Close encoder: %v", err)
+	}
+	if !w.closed {
+		t.Errorf("closing encoder did not close writer")
+	}
+	data := w.Bytes()
+
+	if !r.NoSniff {
+		br := bufio.NewReader(bytes.NewReader(data))
+		if !c.Sniff(br) {
+			t.Errorf("encoding not sniffed")
+		}
+		if cs, ok := c.(codec.ConfidenceSniffer); ok && cs.SniffConfidence(br) <= codec.SniffNone {
+			t.Errorf("encoding sniffed with no confidence")
+		}
+		if rest, _ := ioutil.ReadAll(br); !bytes.Equal(rest, data) {
+			t.Errorf("sniffing consumed data")
+		}
+	}
+
+	if r.can(codec.CanDecode) {
+		rf := NewFile(data)
+		src, dsc, err := c.Decoder(readCloser{rf})
+		if !r.unsupported(t, codec.CanDecode, err) {
+			if err != nil {
+				t.Fatalf("Decoder: %v", err)
+			}
+			r.checkSource(t, "Decoder", src, dsc, v, sc, d, n)
+			r.checkClose(t, "Decoder", src, rf)
+		}
+	}
+	if r.can(codec.CanSeek) {
+		rf := NewFile(data)
+		src, dsc, err := c.SeekingDecoder(rf)
+		if !r.unsupported(t, codec.CanSeek, err) {
+			if err != nil {
+				t.Fatalf("SeekingDecoder: %v", err)
+			}
+			r.checkSource(t, "SeekingDecoder", src, dsc, v, sc, d, n)
+			r.checkSeeker(t, "SeekingDecoder", src, d, n, sc)
+			r.checkClose(t, "SeekingDecoder", src, rf)
+		}
+	}
+	if r.can(codec.CanRandomAccess) {
+		r.testRandomAccess(t, v, sc, d, n)
+	}
+}
+
+// checkSource checks that src has form v and sample codec sc and that it
+// reproduces d, which has n frames.
+func (r *runner) checkSource(t *testing.T, fn string, src sound.Source, dsc sample.Codec, v sound.Form, sc sample.Codec, d []float64, n int) {
+	t.Helper()
+	nC := v.Channels()
+	if src.Channels() != nC || src.SampleRate() != v.SampleRate() {
+		t.Errorf("%s form %s %dch not %s %dch", fn, src.SampleRate(), src.Channels(), v.SampleRate(), nC)
+		return
+	}
+	if sc != codec.AnySampleCodec && dsc != sc {
+		t.Errorf("%s sample codec %s not %s", fn, scName(dsc), scName(sc))
+	}
+	if nC > 1 {
+		if _, err := src.Receive(make([]float64, nC+1)); err != sound.ErrChannelAlignment {
+			t.Errorf("%s Receive of misaligned buffer: expected sound.ErrChannelAlignment got %v", fn, err)
+		}
+	}
+	got := receiveAll(t, fn, src, 777)
+	if len(got) != nC*n {
+		t.Errorf("%s decoded %d frames not %d", fn, len(got)/nC, n)
+		return
+	}
+	r.compare(t, fn, got, d, nC, 0, n, sc)
+}
+
+// receiveAll receives the rest of src, bufFrames at a time, returning the
+// frames in the layout of a single call to Receive.
+func receiveAll(t *testing.T, fn string, src sound.Source, bufFrames int) []float64 {
+	t.Helper()
+	nC := src.Channels()
+	buf := make([]float64, nC*bufFrames)
+	chans := make([][]float64, nC)
+	for {
+		n, err := src.Receive(buf)
+		for c := 0; c < nC; c++ {
+			chans[c] = append(chans[c], buf[c*n:(c+1)*n]...)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s Receive: %v", fn, err)
+		}
+		if n == 0 {
+			t.Fatalf("%s Receive returned 0 frames without error", fn)
+		}
+	}
+	var res []float64
+	for _, ch := range chans {
+		res = append(res, ch...)
+	}
+	return res
+}
+
+// compare compares got, frames [i,j) of d, with the corresponding frames of
+// d, which has n frames of nC channels.
+func (r *runner) compare(t *testing.T, fn string, got, d []float64, nC, i, j int, sc sample.Codec) {
+	t.Helper()
+	tol := r.tolerance(sc)
+	if tol < 0 {
+		return
+	}
+	m := j - i
+	n := len(d) / nC
+	for c := 0; c < nC; c++ {
+		for f := 0; f < m; f++ {
+			g, w := got[c*m+f], d[c*n+i+f]
+			if math.Abs(g-w) > tol {
+				t.Errorf("%s: channel %d frame %d: got %f not %f", fn, c, i+f, g, w)
+				return
+			}
+		}
+	}
+}
+
+// checkSeeker checks the Len, Duration and seeking of src, which encodes d
+// of n frames.
+func (r *runner) checkSeeker(t *testing.T, fn string, src sound.SourceSeeker, d []float64, n int, sc sample.Codec) {
+	t.Helper()
+	nC := src.Channels()
+	if src.Len() != int64(n) {
+		t.Errorf("%s Len %d not %d", fn, src.Len(), n)
+	}
+	if src.Pos() != int64(n) {
+		t.Errorf("%s Pos %d at end not %d", fn, src.Pos(), n)
+	}
+	if ds, ok := src.(interface {
+		Duration() time.Duration
+	}); ok {
+		if want := time.Duration(n) * src.SampleRate().Period(); ds.Duration() != want {
+			t.Errorf("%s Duration %s not %s", fn, ds.Duration(), want)
+		}
+	}
+	buf := make([]float64, nC*97)
+	for _, p := range []int{0, n / 3, 1, n - 1, n / 2, n / 2, n} {
+		if err := src.Seek(int64(p)); err != nil {
+			t.Errorf("%s Seek(%d): %v", fn, p, err)
+			continue
+		}
+		if src.Pos() != int64(p) {
+			t.Errorf("%s Pos %d after Seek(%d)", fn, src.Pos(), p)
+		}
+		m, err := src.Receive(buf)
+		if p == n {
+			if m != 0 || err != io.EOF {
+				t.Errorf("%s Receive at end: got %d, %v not 0, io.EOF", fn, m, err)
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			t.Errorf("%s Receive after Seek(%d): %v", fn, p, err)
+			continue
+		}
+		want := 97
+		if p+want > n {
+			want = n - p
+		}
+		if m != want {
+			t.Errorf("%s Receive after Seek(%d): got %d frames not %d", fn, p, m, want)
+			continue
+		}
+		r.compare(t, fmt.Sprintf("%s after Seek(%d)", fn, p), buf[:nC*m], d, nC, p, p+m, sc)
+		if src.Pos() != int64(p+m) {
+			t.Errorf("%s Pos %d after Seek(%d) and Receive of %d", fn, src.Pos(), p, m)
+		}
+	}
+}
+
+// checkClose checks that closing src closes f.
+func (r *runner) checkClose(t *testing.T, fn string, src sound.Source, f *File) {
+	t.Helper()
+	if err := src.Close(); err != nil {
+		t.Errorf("%s Close: %v", fn, err)
+	}
+	if !f.closed {
+		t.Errorf("closing %s source did not close reader", fn)
+	}
+}
+
+// testRandomAccess writes d with RandomAccess, overwrites part of it, and
+// checks the result reads back.
+func (r *runner) testRandomAccess(t *testing.T, v sound.Form, sc sample.Codec, d []float64, n int) {
+	nC := v.Channels()
+	f := NewFile(nil)
+	ra, err := r.c.RandomAccess(f, v, sc)
+	if r.unsupported(t, codec.CanRandomAccess, err) {
+		return
+	}
+	if err != nil {
+		t.Fatalf("RandomAccess: %v", err)
+	}
+	r.encode(t, ra, d, n)
+	if ra.Len() != int64(n) || ra.Pos() != int64(n) {
+		t.Errorf("RandomAccess Len %d Pos %d after writing %d frames", ra.Len(), ra.Pos(), n)
+	}
+	// overwrite frames [n/4, n/2) with the negated signal.
+	i, j := n/4, n/2
+	if err := ra.Seek(int64(i)); err != nil {
+		t.Fatalf("RandomAccess Seek(%d): %v", i, err)
+	}
+	neg := frames(d, nC, n, i, j)
+	for k := range neg {
+		neg[k] = -neg[k]
+	}
+	if err := ra.Send(neg); err != nil {
+		t.Fatalf("RandomAccess Send: %v", err)
+	}
+	if ra.Pos() != int64(j) || ra.Len() != int64(n) {
+		t.Errorf("RandomAccess Pos %d Len %d after overwriting [%d,%d)", ra.Pos(), ra.Len(), i, j)
+	}
+	want := append([]float64(nil), d...)
+	for c := 0; c < nC; c++ {
+		for k := i; k < j; k++ {
+			want[c*n+k] = -want[c*n+k]
+		}
+	}
+	if err := ra.Seek(0); err != nil {
+		t.Fatalf("RandomAccess Seek(0): %v", err)
+	}
+	got := receiveAll(t, "RandomAccess", ra, 333)
+	if len(got) != nC*n {
+		t.Errorf("RandomAccess read %d frames not %d", len(got)/nC, n)
+	} else {
+		r.compare(t, "RandomAccess", got, want, nC, 0, n, sc)
+	}
+	r.checkSeeker(t, "RandomAccess", ra, want, n, sc)
+	r.checkClose(t, "RandomAccess", ra, f)
+}
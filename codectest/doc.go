@@ -0,0 +1,14 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package codectest provides conformance tests for implementations of
+// codec.Codec.
+//
+// A codec package tests itself with
+//
+//	func TestConformance(t *testing.T) {
+//		codectest.Run(t, Codec, nil)
+//	}
+//
+// Package codectest is part of http://zikichombo.org
+package codectest /* import "zikichombo.org/codec/codectest" */
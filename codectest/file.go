@@ -0,0 +1,89 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codectest
+
+import (
+	"errors"
+	"io"
+)
+
+var errClosed = errors.New("codectest: use of closed file")
+
+// File is an in memory codec.IoReadWriteSeekCloser, for tests of
+// codecs, which records whether it has been closed.  Reading, writing
+// or seeking a closed File fails.
+type File struct {
+	data   []byte
+	pos    int64
+	closed bool
+}
+
+// NewFile creates a File holding d, at offset 0.
+func NewFile(d []byte) *File {
+	return &File{data: d}
+}
+
+// Bytes returns the contents of f, whether or not it is closed.
+func (f *File) Bytes() []byte {
+	return f.data
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, errClosed
+	}
+	if f.pos >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, errClosed
+	}
+	if end := f.pos + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	copy(f.data[f.pos:], p)
+	f.pos += int64(len(p))
+	return len(p), nil
+}
+
+func (f *File) Seek(off int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, errClosed
+	}
+	switch whence {
+	case io.SeekCurrent:
+		off += f.pos
+	case io.SeekEnd:
+		off += int64(len(f.data))
+	}
+	if off < 0 {
+		return 0, errors.New("codectest: negative offset")
+	}
+	f.pos = off
+	return off, nil
+}
+
+func (f *File) Close() error {
+	if f.closed {
+		return errClosed
+	}
+	f.closed = true
+	return nil
+}
+
+// readCloser hides all but the io.ReadCloser methods of a File.
+type readCloser struct {
+	io.ReadCloser
+}
+
+// writeCloser hides all but the io.WriteCloser methods of a File.
+type writeCloser struct {
+	io.WriteCloser
+}
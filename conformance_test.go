@@ -0,0 +1,22 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codec_test
+
+import (
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+)
+
+func TestConformance(t *testing.T) {
+	codectest.Run(t, codec.FakeCodec, nil)
+}
+
+func TestFakeCodecRegistered(t *testing.T) {
+	c, err := codec.CodecFor("take.fake", nil)
+	if err != nil || c != codec.FakeCodec {
+		t.Errorf("got %v, %v not %v", c, err, codec.FakeCodec)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codec
+
+import (
+	"context"
+	"io"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Progress reports the progress of CopyContext, EncodeContext and
+// TranscodeContext.
+type Progress struct {
+	// Frames is the number of frames sent to the sink or encoder.
+	Frames int64
+
+	// Total is the number of frames expected, or -1 if unknown.  Total is
+	// known when the source is a sound.SourceSeeker.
+	Total int64
+
+	// Bytes is the number of bytes written to the destination of an
+	// encoder, or 0 for CopyContext.
+	Bytes int64
+}
+
+// Percent returns the percentage of Total which has been processed, or -1
+// if Total is unknown.
+func (p Progress) Percent() float64 {
+	if p.Total < 0 {
+		return -1
+	}
+	if p.Total == 0 {
+		return 100
+	}
+	return 100 * float64(p.Frames) / float64(p.Total)
+}
+
+// CopyContext copies src to dst, checking for cancellation of ctx between
+// blocks of frames.  If progress is non-nil, it is called after each block.
+//
+// CopyContext returns ctx.Err() if ctx is done before src is exhausted.
+// It closes neither dst nor src.
+func CopyContext(ctx context.Context, dst sound.Sink, src sound.Source, progress func(Progress)) error {
+	_, err := copyContext(ctx, dst, src, remaining(src), progress, nil)
+	return err
+}
+
+// EncodeContext is like EncodeWithOptions, but checks for cancellation of
+// ctx and reports progress as CopyContext does, with Progress.Bytes counting
+// the bytes written to dst.
+//
+// Like EncodeWithOptions, EncodeContext closes the encoder once it has been
+// created, including when ctx is done.  After the encoder is closed without error, progress is called
+// a final time, so that Progress.Bytes includes data flushed on closing.
+func EncodeContext(ctx context.Context, dst io.WriteCloser, src sound.Source, ext string, c sample.Codec, opts *EncoderOptions, progress func(Progress)) error {
+	return defaultRegistry.EncodeContext(ctx, dst, src, ext, c, opts, progress)
+}
+
+// EncodeContext is like the package level EncodeContext but uses the codecs in r.
+func (r *Registry) EncodeContext(ctx context.Context, dst io.WriteCloser, src sound.Source, ext string, c sample.Codec, opts *EncoderOptions, progress func(Progress)) error {
+	co, err := r.CodecFor(ext, nil)
+	if err != nil {
+		return err
+	}
+	cw, n := countWrites(dst)
+	snk, err := encoderWithOptions(co, cw, src, c, opts)
+	if err != nil {
+		return err
+	}
+	p, err := copyContext(ctx, snk, src, remaining(src), progress, n)
+	return closeEncoder(snk, err, p, progress, n)
+}
+
+// closeEncoder closes snk after copying with result err, and makes a final
+// progress report including the bytes written on closing.
+func closeEncoder(snk sound.Sink, err error, p Progress, progress func(Progress), nBytes *int64) error {
+	cerr := snk.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err == nil && progress != nil {
+		p.Bytes = *nBytes
+		progress(p)
+	}
+	return err
+}
+
+// TranscodeContext is like Transcode, but checks for cancellation of ctx and
+// reports progress as EncodeContext does.  Progress.Frames and Progress.Total
+// count frames after conversion.
+func TranscodeContext(ctx context.Context, dst io.WriteCloser, src sound.Source, ext string, c sample.Codec, opts *TranscodeOptions, progress func(Progress)) (*Conversions, error) {
+	return defaultRegistry.TranscodeContext(ctx, dst, src, ext, c, opts, progress)
+}
+
+// remaining returns the number of frames left in src, or -1 if unknown.
+func remaining(src sound.Source) int64 {
+	if s, ok := src.(sound.SourceSeeker); ok {
+		return s.Len() - s.Pos()
+	}
+	return -1
+}
+
+func copyContext(ctx context.Context, dst sound.Sink, src sound.Source, total int64, progress func(Progress), nBytes *int64) (Progress, error) {
+	nC := src.Channels()
+	buf := make([]float64, 1024*nC)
+	p := Progress{Total: total}
+	for {
+		select {
+		case <-ctx.Done():
+			return p, ctx.Err()
+		default:
+		}
+		n, err := src.Receive(buf)
+		if n > 0 {
+			if e := dst.Send(buf[:n*nC]); e != nil {
+				return p, e
+			}
+			p.Frames += int64(n)
+			if nBytes != nil {
+				p.Bytes = *nBytes
+			}
+			if progress != nil {
+				progress(p)
+			}
+		}
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			return p, err
+		}
+	}
+}
+
+// countWrites wraps w so that the number of bytes written is counted
+// in the returned *int64.  If w is an io.Seeker, so is the result, and
+// the count is the furthest offset written relative to the offset of w
+// on wrapping, so that bytes rewritten after seeking back are not counted
+// twice.
+func countWrites(w io.WriteCloser) (io.WriteCloser, *int64) {
+	cw := &countWriter{WriteCloser: w}
+	if s, ok := w.(io.Seeker); ok {
+		base, err := s.Seek(0, io.SeekCurrent)
+		if err == nil {
+			return &countWriteSeeker{countWriter: cw, Seeker: s, base: base}, &cw.n
+		}
+	}
+	return cw, &cw.n
+}
+
+type countWriter struct {
+	io.WriteCloser
+	off int64 // current offset
+	n   int64 // high water offset
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.WriteCloser.Write(p)
+	c.off += int64(n)
+	if c.off > c.n {
+		c.n = c.off
+	}
+	return n, err
+}
+
+type countWriteSeeker struct {
+	*countWriter
+	io.Seeker
+	base int64
+}
+
+func (c *countWriteSeeker) Seek(off int64, whence int) (int64, error) {
+	o, err := c.Seeker.Seek(off, whence)
+	if err == nil {
+		c.off = o - c.base
+	}
+	return o, err
+}
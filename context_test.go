@@ -0,0 +1,236 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// seekSource is a sliceSource which knows its length.
+type seekSource struct {
+	*sliceSource
+	n, pos int64
+}
+
+func newSeekSource(nC, nF int) *seekSource {
+	src := &sliceSource{form: form{rate: 8000 * freq.Hertz, nC: nC}, frames: make([]float64, nC*nF)}
+	return &seekSource{sliceSource: src, n: int64(nF)}
+}
+
+func (s *seekSource) Receive(dst []float64) (int, error) {
+	n, err := s.sliceSource.Receive(dst)
+	s.pos += int64(n)
+	return n, err
+}
+
+func (s *seekSource) Len() int64 {
+	return s.n
+}
+
+func (s *seekSource) Pos() int64 {
+	return s.pos
+}
+
+func (s *seekSource) Seek(f int64) error {
+	return ErrUnsupportedFunction
+}
+
+// byteCodec encodes each sample as SInt16L, without a header.
+type byteCodec struct {
+	NullCodec
+}
+
+func (c byteCodec) Extensions() []string {
+	return []string{".byt"}
+}
+
+func (c byteCodec) Encoder(w io.WriteCloser, v sound.Form, sc sample.Codec) (sound.Sink, error) {
+	return &byteSink{form: form{rate: v.SampleRate(), nC: v.Channels()}, w: w}, nil
+}
+
+type byteSink struct {
+	form
+	w      io.WriteCloser
+	closed bool
+}
+
+func (s *byteSink) Send(src []float64) error {
+	buf := make([]byte, 2*len(src))
+	sample.SInt16L.Encode(buf, src)
+	_, err := s.w.Write(buf)
+	return err
+}
+
+func (s *byteSink) Close() error {
+	s.closed = true
+	return s.w.Close()
+}
+
+type bufCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+// seekBuf is a bufCloser which can seek, starting at offset base.
+type seekBuf struct {
+	bufCloser
+	base, off int64
+}
+
+func (b *seekBuf) Write(p []byte) (int, error) {
+	o := int(b.off - b.base)
+	for o+len(p) > b.Len() {
+		b.WriteByte(0)
+	}
+	copy(b.Bytes()[o:], p)
+	b.off += int64(len(p))
+	return len(p), nil
+}
+
+func (b *seekBuf) Seek(off int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		off += b.off
+	case io.SeekEnd:
+		off += b.base + int64(b.Len())
+	}
+	if off < b.base {
+		return b.off, errors.New("seek before base")
+	}
+	b.off = off
+	return off, nil
+}
+
+func TestCopyContext(t *testing.T) {
+	src := newSeekSource(2, 3000)
+	snk := &sliceSink{form: src.form}
+	var ps []Progress
+	err := CopyContext(context.Background(), snk, src, func(p Progress) {
+		ps = append(ps, p)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 3 {
+		t.Fatalf("got %d progress reports not 3", len(ps))
+	}
+	last := ps[len(ps)-1]
+	if last.Frames != 3000 || last.Total != 3000 || last.Percent() != 100 {
+		t.Errorf("last progress %+v", last)
+	}
+	if ps[0].Percent() <= 0 || ps[0].Percent() >= 100 {
+		t.Errorf("first progress %f%%", ps[0].Percent())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	src = newSeekSource(1, 3000)
+	err = CopyContext(ctx, &sliceSink{form: src.form}, src, func(p Progress) {
+		cancel()
+	})
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled got %v", err)
+	}
+	if src.pos != 1024 {
+		t.Errorf("copied %d frames after cancellation", src.pos)
+	}
+}
+
+func TestEncodeContext(t *testing.T) {
+	r := NewRegistry(byteCodec{})
+	src := &sliceSource{form: form{rate: 8000 * freq.Hertz, nC: 2}, frames: make([]float64, 2*2000)}
+	var last Progress
+	dst := &bufCloser{}
+	err := r.EncodeContext(context.Background(), dst, src, ".byt", AnySampleCodec, nil, func(p Progress) {
+		last = p
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Frames != 2000 || last.Bytes != 8000 || last.Total != -1 || last.Percent() != -1 {
+		t.Errorf("last progress %+v", last)
+	}
+	if dst.Len() != 8000 {
+		t.Errorf("wrote %d bytes", dst.Len())
+	}
+	if !dst.closed {
+		t.Errorf("encoder not closed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dst = &bufCloser{}
+	if err := r.EncodeContext(ctx, dst, newSeekSource(1, 10), ".byt", AnySampleCodec, nil, nil); err != context.Canceled {
+		t.Errorf("expected context.Canceled got %v", err)
+	}
+	if !dst.closed {
+		t.Errorf("encoder not closed after cancellation")
+	}
+}
+
+func TestEncodeWithOptionsCloses(t *testing.T) {
+	r := NewRegistry(byteCodec{})
+	dst := &bufCloser{}
+	src := &sliceSource{form: form{rate: 8000 * freq.Hertz, nC: 1}, frames: make([]float64, 100)}
+	if err := r.EncodeWithOptions(dst, src, ".byt", AnySampleCodec, nil); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Len() != 200 || !dst.closed {
+		t.Errorf("wrote %d bytes, closed %t", dst.Len(), dst.closed)
+	}
+}
+
+func TestCountWritesSeek(t *testing.T) {
+	dst := &seekBuf{base: 100, off: 100}
+	w, n := countWrites(dst)
+	ws, ok := w.(io.WriteSeeker)
+	if !ok {
+		t.Fatal("not a seeker")
+	}
+	ws.Write(make([]byte, 10))
+	// rewrite a header, as encoders do on closing.
+	if _, err := ws.Seek(102, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	ws.Write(make([]byte, 4))
+	if *n != 10 {
+		t.Errorf("counted %d bytes after rewriting, not 10", *n)
+	}
+	ws.Seek(0, io.SeekEnd)
+	ws.Write(make([]byte, 5))
+	if *n != 15 || dst.Len() != 15 {
+		t.Errorf("counted %d bytes, wrote %d, not 15", *n, dst.Len())
+	}
+}
+
+func TestTranscodeContext(t *testing.T) {
+	r := NewRegistry(byteCodec{})
+	src := newSeekSource(1, 8000)
+	opts := &TranscodeOptions{SampleRate: 16000 * freq.Hertz}
+	var last Progress
+	conv, err := r.TranscodeContext(context.Background(), &bufCloser{}, src, ".byt", AnySampleCodec, opts, func(p Progress) {
+		last = p
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conv.Resampled() {
+		t.Errorf("not resampled: %s", conv)
+	}
+	if last.Total != 16000 || last.Frames != 16000 || last.Bytes != 32000 {
+		t.Errorf("last progress %+v", last)
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codec
+
+import (
+	"io"
+	"math"
+	"math/rand"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+)
+
+// The conversions in this file are sound.Sources wrapping other
+// sound.Sources.  As elsewhere, buffers passed to Receive hold
+// the frames of each channel contiguously: when n frames are
+// received, channel c is at d[c*n:(c+1)*n].
+
+// remixer converts the number of channels of a source.
+//
+// Mixing to mono averages all channels, mixing from mono copies the channel
+// to all channels.  Otherwise, output channel i is the average of the input
+// channels j with j % nC == i when reducing channels, and is input channel
+// i % sC when increasing channels.
+type remixer struct {
+	sound.Source
+	nC  int
+	buf []float64
+}
+
+func newRemixer(src sound.Source, nC int) *remixer {
+	return &remixer{Source: src, nC: nC}
+}
+
+func (m *remixer) Channels() int {
+	return m.nC
+}
+
+func (m *remixer) Receive(dst []float64) (int, error) {
+	nC, sC := m.nC, m.Source.Channels()
+	if len(dst)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := len(dst) / nC
+	if cap(m.buf) < nF*sC {
+		m.buf = make([]float64, nF*sC)
+	}
+	buf := m.buf[:nF*sC]
+	n, err := m.Source.Receive(buf)
+	if n == 0 {
+		return 0, err
+	}
+	for c := 0; c < nC; c++ {
+		out := dst[c*n : (c+1)*n]
+		if nC > sC {
+			j := c % sC
+			copy(out, buf[j*n:(j+1)*n])
+			continue
+		}
+		for f := range out {
+			out[f] = 0
+		}
+		cnt := 0
+		for j := c; j < sC; j += nC {
+			in := buf[j*n : (j+1)*n]
+			for f, v := range in {
+				out[f] += v
+			}
+			cnt++
+		}
+		if cnt > 1 {
+			s := 1 / float64(cnt)
+			for f := range out {
+				out[f] *= s
+			}
+		}
+	}
+	return n, err
+}
+
+// resampler converts the sample rate of a source using windowed sinc
+// interpolation.
+type resampler struct {
+	sound.Source
+	rate freq.T
+	p, q int64   // input frames per output frame is p/q, in lowest terms
+	fc   float64 // cutoff relative to the input Nyquist frequency
+	w    int     // half width of the kernel in input frames
+
+	hist [][]float64 // per channel input, hist[c][0] is at input frame off
+	off  int64
+	k    int64 // index of the next output frame
+	inN  int64 // number of input frames read
+	eof  bool
+	err  error
+	buf  []float64
+	wts  []float64 // kernel weights for the current output frame
+}
+
+const resampleZeroCrossings = 16
+
+func newResampler(src sound.Source, rate freq.T) *resampler {
+	p, q := int64(src.SampleRate()), int64(rate)
+	g := gcd(p, q)
+	p, q = p/g, q/g
+	fc := 1.0
+	if p > q {
+		fc = float64(q) / float64(p)
+	}
+	w := int(math.Ceil(resampleZeroCrossings / fc))
+	r := &resampler{
+		Source: src,
+		rate:   rate,
+		p:      p,
+		q:      q,
+		fc:     fc,
+		w:      w,
+		hist:   make([][]float64, src.Channels()),
+		wts:    make([]float64, 2*w)}
+	// pad the start with zeros, so the first output frame is at input
+	// frame 0 with a full kernel.
+	for c := range r.hist {
+		r.hist[c] = make([]float64, w)
+	}
+	r.off = -int64(w)
+	return r
+}
+
+func (r *resampler) SampleRate() freq.T {
+	return r.rate
+}
+
+// fill reads input until frame last is available or the source is
+// exhausted.
+func (r *resampler) fill(last int64) {
+	nC := len(r.hist)
+	for !r.eof && r.off+int64(len(r.hist[0])) <= last {
+		if r.buf == nil {
+			r.buf = make([]float64, 1024*nC)
+		}
+		n, err := r.Source.Receive(r.buf)
+		for c := range r.hist {
+			r.hist[c] = append(r.hist[c], r.buf[c*n:(c+1)*n]...)
+		}
+		r.inN += int64(n)
+		if err != nil {
+			r.eof = true
+			if err != io.EOF {
+				r.err = err
+			}
+		}
+	}
+}
+
+// kernel evaluates the interpolation kernel at x input frames from
+// the output position.
+func (r *resampler) kernel(x float64) float64 {
+	fw := float64(r.w)
+	if x <= -fw || x >= fw {
+		return 0
+	}
+	v := r.fc
+	if x != 0 {
+		a := math.Pi * r.fc * x
+		v = r.fc * math.Sin(a) / a
+	}
+	// Blackman window
+	p := math.Pi * (x + fw) / fw
+	return v * (0.42 - 0.5*math.Cos(p) + 0.08*math.Cos(2*p))
+}
+
+func (r *resampler) Receive(dst []float64) (int, error) {
+	nC := len(r.hist)
+	if len(dst)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := len(dst) / nC
+	f := 0
+	for ; f < nF; f++ {
+		ti, frac := r.pos()
+		r.fill(ti + int64(r.w))
+		if r.eof && ti >= r.inN {
+			break
+		}
+		lo := ti - int64(r.w) + 1
+		for i := range r.wts {
+			r.wts[i] = r.kernel(float64(ti-lo-int64(i)) + frac)
+		}
+		for c := 0; c < nC; c++ {
+			h := r.hist[c]
+			acc := 0.0
+			for i, wt := range r.wts {
+				j := lo + int64(i) - r.off
+				if j < 0 || j >= int64(len(h)) {
+					continue
+				}
+				acc += h[j] * wt
+			}
+			dst[c*nF+f] = acc
+		}
+		r.k++
+	}
+	r.trim()
+	if f == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		return 0, io.EOF
+	}
+	if f < nF {
+		for c := 1; c < nC; c++ {
+			copy(dst[c*f:(c+1)*f], dst[c*nF:c*nF+f])
+		}
+	}
+	return f, nil
+}
+
+// pos returns the integer and fractional parts of the position of the
+// next output frame in input frames.
+func (r *resampler) pos() (int64, float64) {
+	n := r.k * r.p
+	return n / r.q, float64(n%r.q) / float64(r.q)
+}
+
+// trim drops input which is no longer needed.
+func (r *resampler) trim() {
+	ti, _ := r.pos()
+	keep := ti - int64(r.w) + 1
+	d := keep - r.off
+	if d <= 0 || d < int64(len(r.hist[0]))/2 {
+		return
+	}
+	for c, h := range r.hist {
+		n := copy(h, h[d:])
+		r.hist[c] = h[:n]
+	}
+	r.off = keep
+}
+
+// ditherer adds dither noise to a source which will be quantized to
+// bits bits, and clips the result to [-1, 1].
+type ditherer struct {
+	sound.Source
+	kind Dither
+	lsb  float64
+	rnd  *rand.Rand
+}
+
+func newDitherer(src sound.Source, kind Dither, bits int) *ditherer {
+	return &ditherer{
+		Source: src,
+		kind:   kind,
+		lsb:    math.Ldexp(1, 1-bits),
+		rnd:    rand.New(rand.NewSource(1))}
+}
+
+func (d *ditherer) Receive(dst []float64) (int, error) {
+	n, err := d.Source.Receive(dst)
+	for c := 0; c < d.Channels(); c++ {
+		seg := dst[c*n : (c+1)*n]
+		for i, v := range seg {
+			switch d.kind {
+			case DitherRectangular:
+				v += (d.rnd.Float64() - 0.5) * d.lsb
+			case DitherTriangular:
+				v += (d.rnd.Float64() - d.rnd.Float64()) * d.lsb
+			}
+			if v > 1 {
+				v = 1
+			} else if v < -1 {
+				v = -1
+			}
+			seg[i] = v
+		}
+	}
+	return n, err
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
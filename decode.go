@@ -0,0 +1,169 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codec
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// DecodeOptions controls the selection of a codec by DecoderWith and
+// SeekingDecoderWith.  The zero value selects codecs as Decoder does
+// with a nil pkgSel.
+type DecodeOptions struct {
+	// Codec, if non-nil, is used without sniffing.
+	Codec Codec
+
+	// Hint is a file name, path, or extension which is used to select a
+	// codec as in CodecFor when no codec recognizes the data.  This is
+	// useful for formats which are headerless or weakly identifiable.
+	Hint string
+
+	// PkgSel, if non-nil, restricts the codecs considered to those whose
+	// package path p is such that PkgSel(p) is true.
+	PkgSel func(string) bool
+
+	// Prefer, if non-nil, gives precedence to the codecs whose package path
+	// p is such that Prefer(p) is true.  Unlike PkgSel, other codecs are
+	// used if no preferred codec applies.
+	Prefer func(string) bool
+
+	// Strictness is the strictness with which the selected codec decodes.
+	// Codecs which do not implement StrictnessSetter decode Lenient data as
+	// they would Strict data, and fail with ErrUnsupportedFunction for
+	// Validate.
+	Strictness Strictness
+}
+
+// Strictness controls how a decoder treats data which violates the
+// specification of its format.
+type Strictness int
+
+const (
+	// Strict rejects violations which leave the data ambiguous and ignores
+	// others.  It is the default.
+	Strict Strictness = iota
+
+	// Lenient repairs violations where the intended data is clear, such as
+	// fields which are derived from others, so that more real world data
+	// can be decoded.
+	Lenient
+
+	// Validate rejects data with any violation which the decoder detects,
+	// reporting all of them in a *ValidationError.
+	Validate
+)
+
+var strictnessNames = [...]string{"strict", "lenient", "validate"}
+
+func (s Strictness) String() string {
+	if s < 0 || int(s) >= len(strictnessNames) {
+		return fmt.Sprintf("Strictness(%d)", int(s))
+	}
+	return strictnessNames[s]
+}
+
+// StrictnessSetter is an optional interface which a Codec may implement
+// when its decoders support strictness other than Strict.
+type StrictnessSetter interface {
+	// WithStrictness returns a codec whose decoders apply strictness s.
+	WithStrictness(s Strictness) Codec
+}
+
+// withStrictness returns c configured to decode with strictness s.
+func withStrictness(c Codec, s Strictness) (Codec, error) {
+	if s == Strict {
+		return c, nil
+	}
+	if ss, ok := c.(StrictnessSetter); ok {
+		return ss.WithStrictness(s), nil
+	}
+	if s == Validate {
+		return nil, ErrUnsupportedFunction
+	}
+	return c, nil
+}
+
+// DecoderWith is like Decoder but selects the codec according to opts
+// and also returns the codec used.
+//
+// Unless opts.Codec is given, sniffing is tried first, and opts.Hint is
+// used only if no codec recognizes the data.
+func DecoderWith(r io.ReadCloser, opts DecodeOptions) (sound.Source, sample.Codec, Codec, error) {
+	return defaultRegistry.DecoderWith(r, opts)
+}
+
+// SeekingDecoderWith is like SeekingDecoder but selects the codec according
+// to opts and also returns the codec used, as DecoderWith does.
+func SeekingDecoderWith(r IoReadSeekCloser, opts DecodeOptions) (sound.SourceSeeker, sample.Codec, Codec, error) {
+	return defaultRegistry.SeekingDecoderWith(r, opts)
+}
+
+// DecoderWith is like the package level DecoderWith but uses the codecs in r.
+func (r *Registry) DecoderWith(rc io.ReadCloser, opts DecodeOptions) (sound.Source, sample.Codec, Codec, error) {
+	br := bufio.NewReader(rc)
+	theCodec, err := r.selectCodec(br, &opts)
+	if err != nil {
+		return nil, AnySampleCodec, nil, err
+	}
+	theCodec, err = withStrictness(theCodec, opts.Strictness)
+	if err != nil {
+		return nil, AnySampleCodec, nil, err
+	}
+	src, sc, err := theCodec.Decoder(&brCloser{Reader: br, Closer: rc})
+	return src, sc, theCodec, err
+}
+
+// SeekingDecoderWith is like the package level SeekingDecoderWith but uses
+// the codecs in r.
+func (r *Registry) SeekingDecoderWith(rs IoReadSeekCloser, opts DecodeOptions) (sound.SourceSeeker, sample.Codec, Codec, error) {
+	var theCodec Codec
+	var err error
+	perr := peekSeeker(rs, func(br *bufio.Reader) {
+		theCodec, err = r.selectCodec(br, &opts)
+	})
+	if perr != nil {
+		return nil, AnySampleCodec, nil, perr
+	}
+	if err == nil {
+		theCodec, err = withStrictness(theCodec, opts.Strictness)
+	}
+	if err != nil {
+		return nil, AnySampleCodec, nil, err
+	}
+	src, sc, err := theCodec.SeekingDecoder(rs)
+	return src, sc, theCodec, err
+}
+
+func (r *Registry) selectCodec(br *bufio.Reader, opts *DecodeOptions) (Codec, error) {
+	if opts.Codec != nil {
+		return opts.Codec, nil
+	}
+	cands := r.SniffAll(br, opts.PkgSel)
+	if opts.Prefer != nil {
+		sort.SliceStable(cands, func(i, j int) bool {
+			return opts.Prefer(cands[i].PkgPath) && !opts.Prefer(cands[j].PkgPath)
+		})
+	}
+	if len(cands) > 0 {
+		return cands[0].Codec, nil
+	}
+	if opts.Hint == "" {
+		return nil, ErrUnknownCodec
+	}
+	if opts.Prefer != nil {
+		c, err := r.CodecFor(opts.Hint, func(p string) bool {
+			return opts.Prefer(p) && (opts.PkgSel == nil || opts.PkgSel(p))
+		})
+		if err == nil {
+			return c, nil
+		}
+	}
+	return r.CodecFor(opts.Hint, opts.PkgSel)
+}
@@ -0,0 +1,192 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codec
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/ops"
+	"zikichombo.org/sound/sample"
+)
+
+// Dither specifies dithering applied when reducing the bit depth of
+// samples while encoding.
+type Dither int
+
+const (
+	// DitherDefault leaves the choice of dithering to the codec.
+	DitherDefault Dither = iota
+	// DitherNone disables dithering.
+	DitherNone
+	// DitherRectangular applies rectangular (RPDF) dither.
+	DitherRectangular
+	// DitherTriangular applies triangular (TPDF) dither.
+	DitherTriangular
+)
+
+// Names of the options in EncoderOptions, as reported in OptionError.
+const (
+	OptQuality          = "Quality"
+	OptBitRate          = "BitRate"
+	OptCompressionLevel = "CompressionLevel"
+	OptDither           = "Dither"
+	OptMetadata         = "Metadata"
+)
+
+// EncoderOptions holds parameters for encoders beyond the form and sample
+// codec.  The zero value of each field selects the codec's default, so the
+// zero EncoderOptions is accepted by every codec.
+type EncoderOptions struct {
+	// Quality is the quality of lossy encodings, in (0, 1], where higher
+	// is better.
+	Quality float64
+
+	// BitRate is the target bit rate in bits per second.
+	BitRate int
+
+	// CompressionLevel is the effort spent compressing, from 1 (fastest)
+	// to 9 (smallest).
+	CompressionLevel int
+
+	// Dither specifies dithering applied when quantizing samples.
+	Dither Dither
+
+	// Metadata holds tags, such as "TITLE" or "ARTIST", to be stored
+	// with the encoded data.
+	Metadata map[string]string
+
+	// Extra holds codec specific options.  Codecs document the keys
+	// they accept.
+	Extra map[string]interface{}
+}
+
+// set returns the names of the options which are set in o, with
+// keys of o.Extra in sorted order.
+func (o *EncoderOptions) set() []string {
+	var res []string
+	if o.Quality != 0 {
+		res = append(res, OptQuality)
+	}
+	if o.BitRate != 0 {
+		res = append(res, OptBitRate)
+	}
+	if o.CompressionLevel != 0 {
+		res = append(res, OptCompressionLevel)
+	}
+	if o.Dither != DitherDefault {
+		res = append(res, OptDither)
+	}
+	if len(o.Metadata) != 0 {
+		res = append(res, OptMetadata)
+	}
+	extra := make([]string, 0, len(o.Extra))
+	for k := range o.Extra {
+		extra = append(extra, k)
+	}
+	sort.Strings(extra)
+	return append(res, extra...)
+}
+
+// Check returns an *OptionError for the first option set in o whose name
+// is not in known, or nil if there is no such option.  Names of fields
+// are given by the Opt constants and names of codec specific options are
+// the keys of o.Extra.
+//
+// Codecs may use Check to reject options they don't understand.  A nil
+// o is valid.
+func (o *EncoderOptions) Check(known ...string) error {
+	if o == nil {
+		return nil
+	}
+outer:
+	for _, name := range o.set() {
+		for _, k := range known {
+			if name == k {
+				continue outer
+			}
+		}
+		return &OptionError{Option: name}
+	}
+	return nil
+}
+
+// OptionError is returned by encoders given an option they do not
+// understand or cannot honor.
+type OptionError struct {
+	// Option is the name of the option, as in EncoderOptions.Check.
+	Option string
+	// Reason optionally explains why the option was rejected.
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("unsupported encoder option %s", e.Option)
+	}
+	return fmt.Sprintf("unsupported encoder option %s: %s", e.Option, e.Reason)
+}
+
+// OptionsEncoder is an optional interface which a Codec may implement to
+// accept EncoderOptions.  Codecs which do not implement OptionsEncoder are
+// treated as accepting no options.
+type OptionsEncoder interface {
+	// EncoderWithOptions is like Codec.Encoder, but takes options, which may be
+	// nil.  EncoderWithOptions returns an *OptionError if an option is not
+	// understood.
+	EncoderWithOptions(w io.WriteCloser, v sound.Form, c sample.Codec, opts *EncoderOptions) (sound.Sink, error)
+}
+
+// EncoderWithOptions is like EncoderWith, but also passes opts to the
+// codec.  If the codec does not understand an option, EncoderWithOptions
+// returns an *OptionError.
+func EncoderWithOptions(dst io.WriteCloser, ext string, v sound.Form, c sample.Codec, opts *EncoderOptions) (sound.Sink, error) {
+	return defaultRegistry.EncoderWithOptions(dst, ext, v, c, opts)
+}
+
+// EncodeWithOptions is like EncodeWith, but also passes opts to the codec
+// as EncoderWithOptions does.
+//
+// Unlike EncodeWith, EncodeWithOptions closes the encoder once it has been
+// created, including when copying src fails, so that the encoded data is
+// complete on success.
+func EncodeWithOptions(dst io.WriteCloser, src sound.Source, ext string, c sample.Codec, opts *EncoderOptions) error {
+	return defaultRegistry.EncodeWithOptions(dst, src, ext, c, opts)
+}
+
+// EncoderWithOptions is like the package level EncoderWithOptions but uses the
+// codecs in r.
+func (r *Registry) EncoderWithOptions(dst io.WriteCloser, ext string, v sound.Form, c sample.Codec, opts *EncoderOptions) (sound.Sink, error) {
+	co, err := r.CodecFor(ext, nil)
+	if err != nil {
+		return nil, err
+	}
+	return encoderWithOptions(co, dst, v, c, opts)
+}
+
+// EncodeWithOptions is like the package level EncodeWithOptions but uses the
+// codecs in r.
+func (r *Registry) EncodeWithOptions(dst io.WriteCloser, src sound.Source, ext string, c sample.Codec, opts *EncoderOptions) error {
+	snk, err := r.EncoderWithOptions(dst, ext, src, c, opts)
+	if err != nil {
+		return err
+	}
+	err = ops.Copy(snk, src)
+	if cerr := snk.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func encoderWithOptions(co Codec, dst io.WriteCloser, v sound.Form, c sample.Codec, opts *EncoderOptions) (sound.Sink, error) {
+	if oe, ok := co.(OptionsEncoder); ok {
+		return oe.EncoderWithOptions(dst, v, c, opts)
+	}
+	if err := opts.Check(); err != nil {
+		return nil, err
+	}
+	return co.Encoder(dst, v, c)
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors classifying failures to decode.  Codec implementations
+// should return them wrapped in a *DecodeError, so that callers can
+// test for them with errors.Is.
+var (
+	// ErrTruncated indicates that the data ended before a complete
+	// structure could be read.
+	ErrTruncated = errors.New("truncated data")
+
+	// ErrCorrupt indicates that the data is malformed or inconsistent.
+	ErrCorrupt = errors.New("corrupt data")
+
+	// ErrUnsupportedFormat indicates that the data is well formed but uses
+	// features which the codec does not support.
+	ErrUnsupportedFormat = errors.New("unsupported format")
+)
+
+// DecodeError describes a failure to decode, with its location in the data.
+type DecodeError struct {
+	// Codec names the codec which failed, such as "wav".
+	Codec string
+
+	// Offset is the byte offset in the encoded data at which the failure
+	// occurred, or -1 if unknown.
+	Offset int64
+
+	// Chunk identifies the chunk, box, or other container structure being
+	// decoded, such as "fmt ", or is empty.
+	Chunk string
+
+	// Err classifies the failure, and is normally one of ErrTruncated,
+	// ErrCorrupt, or ErrUnsupportedFormat.
+	Err error
+
+	// Detail optionally describes the failure further.
+	Detail string
+}
+
+func (e *DecodeError) Error() string {
+	s := e.Codec
+	if e.Chunk != "" {
+		s += fmt.Sprintf(" chunk %q", e.Chunk)
+	}
+	if e.Offset >= 0 {
+		s += fmt.Sprintf(" at offset %d", e.Offset)
+	}
+	s += ": " + e.Err.Error()
+	if e.Detail != "" {
+		s += ": " + e.Detail
+	}
+	return s
+}
+
+// Unwrap returns e.Err.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError reports the violations found by a decoder with Validate
+// strictness.
+type ValidationError struct {
+	// Errs lists the violations in the order found.
+	Errs []*DecodeError
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Errs) == 1 {
+		return e.Errs[0].Error()
+	}
+	msgs := make([]string, len(e.Errs))
+	for i, de := range e.Errs {
+		msgs[i] = de.Error()
+	}
+	return fmt.Sprintf("%d violations: %s", len(e.Errs), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the violations, so that errors.Is and errors.As match
+// any of them.
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, len(e.Errs))
+	for i, de := range e.Errs {
+		errs[i] = de
+	}
+	return errs
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codec
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Open opens the file at path and decodes it, selecting the codec by sniffing
+// its contents, or by the extension of path if no codec recognizes the
+// contents.
+//
+// If the selected codec supports seeking, the result implements
+// sound.SourceSeeker.  Closing the result closes the file.
+func Open(path string) (sound.Source, sample.Codec, error) {
+	return defaultRegistry.Open(path)
+}
+
+// Open is like the package level Open but uses the codecs in r.
+func (r *Registry) Open(path string) (sound.Source, sample.Codec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, AnySampleCodec, err
+	}
+	opts := DecodeOptions{Hint: path}
+	ss, sc, _, err := r.SeekingDecoderWith(f, opts)
+	if err == nil {
+		return &fileSourceSeeker{SourceSeeker: ss, f: f}, sc, nil
+	}
+	if err != ErrUnsupportedFunction {
+		f.Close()
+		return nil, AnySampleCodec, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, AnySampleCodec, err
+	}
+	src, sc, _, err := r.DecoderWith(f, opts)
+	if err != nil {
+		f.Close()
+		return nil, AnySampleCodec, err
+	}
+	return &fileSource{Source: src, f: f}, sc, nil
+}
+
+// Create creates the file at path and returns a sound.Sink encoding to it,
+// selecting the codec by the extension of path.  The sample codec c may be
+// AnySampleCodec, in which case the codec's default is used.
+//
+// Closing the result closes the file.  If no encoder can be created, the file
+// is removed.
+func Create(path string, v sound.Form, c sample.Codec) (sound.Sink, error) {
+	return defaultRegistry.Create(path, v, c)
+}
+
+// Create is like the package level Create but uses the codecs in r.
+func (r *Registry) Create(path string, v sound.Form, c sample.Codec) (sound.Sink, error) {
+	co, err := r.CodecFor(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c == AnySampleCodec {
+		c = co.DefaultSampleCodec()
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	snk, err := co.Encoder(f, v, c)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return &fileSink{Sink: snk, f: f}, nil
+}
+
+// closeFile closes f after the codec has closed whatever wraps it; codecs
+// may or may not close f themselves.
+func closeFile(f *os.File, err error) error {
+	ferr := f.Close()
+	if err == nil && ferr != nil && !errors.Is(ferr, os.ErrClosed) {
+		err = ferr
+	}
+	return err
+}
+
+type fileSource struct {
+	sound.Source
+	f *os.File
+}
+
+func (s *fileSource) Close() error {
+	return closeFile(s.f, s.Source.Close())
+}
+
+type fileSourceSeeker struct {
+	sound.SourceSeeker
+	f *os.File
+}
+
+func (s *fileSourceSeeker) Close() error {
+	return closeFile(s.f, s.SourceSeeker.Close())
+}
+
+type fileSink struct {
+	sound.Sink
+	f *os.File
+}
+
+func (s *fileSink) Close() error {
+	return closeFile(s.f, s.Sink.Close())
+}
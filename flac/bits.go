@@ -0,0 +1,201 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package flac
+
+import (
+	"io"
+	"math/bits"
+)
+
+// bitReader reads bits most significant first from a byte stream, keeping
+// the CRCs of the bytes read.
+type bitReader struct {
+	r   io.ByteReader
+	x   uint64 // the low n bits are unread
+	n   uint
+	off int64 // offset of the next byte of r
+
+	crc8  uint8
+	crc16 uint16
+}
+
+func newBitReader(r io.ByteReader, off int64) *bitReader {
+	return &bitReader{r: r, off: off}
+}
+
+// reset resets b to read from r at offset off.
+func (b *bitReader) reset(r io.ByteReader, off int64) {
+	*b = bitReader{r: r, off: off}
+}
+
+// resetCRC starts the CRCs from the next byte.  The reader must be byte
+// aligned.
+func (b *bitReader) resetCRC() {
+	b.crc8, b.crc16 = 0, 0
+}
+
+// pos returns the offset of the byte containing the next unread bit.
+func (b *bitReader) pos() int64 {
+	return b.off - int64((b.n+7)/8)
+}
+
+func (b *bitReader) fill() error {
+	c, err := b.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	b.x = b.x<<8 | uint64(c)
+	b.n += 8
+	b.off++
+	b.crc8 = crc8(b.crc8, c)
+	b.crc16 = crc16(b.crc16, c)
+	return nil
+}
+
+// readByte reads a byte, returning io.EOF if there is none.  The reader
+// must be byte aligned.
+func (b *bitReader) readByte() (byte, error) {
+	c, err := b.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	b.off++
+	b.crc8 = crc8(b.crc8, c)
+	b.crc16 = crc16(b.crc16, c)
+	return c, nil
+}
+
+// read reads n <= 56 bits.
+func (b *bitReader) read(n uint) (uint64, error) {
+	for b.n < n {
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+	b.n -= n
+	v := b.x >> b.n
+	b.x &= 1<<b.n - 1
+	return v, nil
+}
+
+// readSigned reads a two's complement integer of n <= 56 bits.
+func (b *bitReader) readSigned(n uint) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	v, err := b.read(n)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v<<(64-n)) >> (64 - n), nil
+}
+
+// readUnary reads zero bits up to a one bit, returning their number, or
+// errUnaryLimit if there are more than max.
+func (b *bitReader) readUnary(max uint64) (uint64, error) {
+	var q uint64
+	for {
+		if b.n == 0 {
+			if err := b.fill(); err != nil {
+				return 0, err
+			}
+		}
+		if b.x == 0 {
+			q += uint64(b.n)
+			b.n = 0
+			if q > max {
+				return 0, errUnaryLimit
+			}
+			continue
+		}
+		z := b.n - uint(bits.Len64(b.x))
+		q += uint64(z)
+		b.n -= z + 1
+		b.x &= 1<<b.n - 1
+		if q > max {
+			return 0, errUnaryLimit
+		}
+		return q, nil
+	}
+}
+
+// align skips to the next byte boundary.
+func (b *bitReader) align() {
+	b.n -= b.n % 8
+	b.x &= 1<<b.n - 1
+}
+
+// bitWriter writes bits most significant first.
+type bitWriter struct {
+	buf []byte
+	x   uint64 // the low n bits are unwritten
+	n   uint
+}
+
+// flush writes the whole bytes of x.
+func (w *bitWriter) flush() {
+	for w.n >= 8 {
+		w.n -= 8
+		w.buf = append(w.buf, byte(w.x>>w.n))
+	}
+}
+
+// write writes the low n <= 56 bits of v.
+func (w *bitWriter) write(v uint64, n uint) {
+	if w.n+n > 64 {
+		w.flush()
+	}
+	w.x = w.x<<n | v&(1<<n-1)
+	w.n += n
+}
+
+// writeSigned writes v as a two's complement integer of n <= 56 bits.
+func (w *bitWriter) writeSigned(v int64, n uint) {
+	w.write(uint64(v), n)
+}
+
+// writeUnary writes q zero bits and a one bit.
+func (w *bitWriter) writeUnary(q uint64) {
+	for ; q > 56; q -= 56 {
+		w.write(0, 56)
+	}
+	w.write(1, uint(q)+1)
+}
+
+// writeUTF8 writes the frame or sample number v of a frame header, coded
+// like UTF-8 extended to 36 bits.
+func (w *bitWriter) writeUTF8(v uint64) {
+	if v < 0x80 {
+		w.write(v, 8)
+		return
+	}
+	n := uint(1)
+	for v>>(6*n) >= 1<<(6-n) {
+		n++
+	}
+	w.write(0xff00>>(n+1)|v>>(6*n), 8)
+	for i := n; i > 0; i-- {
+		w.write(0x80|v>>(6*(i-1))&0x3f, 8)
+	}
+}
+
+// align writes zero bits to the next byte boundary.
+func (w *bitWriter) align() {
+	if k := w.n % 8; k != 0 {
+		w.write(0, 8-k)
+	}
+}
+
+// bytes returns the bytes written.  The writer must be byte aligned.
+func (w *bitWriter) bytes() []byte {
+	w.flush()
+	return w.buf
+}
+
+func (w *bitWriter) reset() {
+	w.buf, w.x, w.n = w.buf[:0], 0, 0
+}
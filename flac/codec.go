@@ -0,0 +1,193 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package flac
+
+import (
+	"bufio"
+	"io"
+	"sort"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Codec is the flac codec.Codec, registered with package codec when
+// package flac is imported.
+var Codec codec.Codec = flacCodec{}
+
+func init() {
+	codec.RegisterCodec(Codec)
+}
+
+type flacCodec struct {
+	codec.NullCodec
+}
+
+var (
+	_ codec.Prober            = flacCodec{}
+	_ codec.ConfidenceSniffer = flacCodec{}
+	_ codec.Describer         = flacCodec{}
+	_ codec.OptionsEncoder    = flacCodec{}
+	_ codec.FormNegotiator    = flacCodec{}
+)
+
+// Describe implements codec.Describer.  The byte order of a sample codec
+// does not matter for encoding; decoders report little endian ones.
+func (c flacCodec) Describe() codec.Description {
+	return codec.Description{
+		Name:         "flac",
+		MIMETypes:    []string{"audio/flac", "audio/x-flac"},
+		Capabilities: codec.CanDecode | codec.CanSeek | codec.CanEncode,
+		SampleCodecs: sampleCodecs}
+}
+
+// sampleCodecs lists the sample codecs which can be encoded.
+var sampleCodecs = []sample.Codec{
+	sample.SByte,
+	sample.SInt16L, sample.SInt16B,
+	sample.SInt24L, sample.SInt24B,
+	sample.SInt32L, sample.SInt32B}
+
+// bitsPerSample returns the bits per sample of encoding sc, or 0 if it
+// cannot be encoded.
+func bitsPerSample(sc sample.Codec) int {
+	switch sc {
+	case sample.SByte:
+		return 8
+	case sample.SInt16L, sample.SInt16B:
+		return 16
+	case sample.SInt24L, sample.SInt24B:
+		return 24
+	case sample.SInt32L, sample.SInt32B:
+		return 32
+	}
+	return 0
+}
+
+func (c flacCodec) Extensions() []string {
+	return []string{".flac"}
+}
+
+func (c flacCodec) Sniff(br *bufio.Reader) bool {
+	return c.SniffConfidence(br) > codec.SniffNone
+}
+
+// SniffConfidence implements codec.ConfidenceSniffer.  A stream preceded
+// by an ID3v2 tag is not recognized.
+func (c flacCodec) SniffConfidence(br *bufio.Reader) int {
+	buf, err := br.Peek(4)
+	if err != nil {
+		return codec.SniffNone
+	}
+	if string(buf) != "fLaC" {
+		return codec.SniffNone
+	}
+	return codec.SniffStrong
+}
+
+func (c flacCodec) DefaultSampleCodec() sample.Codec {
+	return sample.SInt16L
+}
+
+func (c flacCodec) Decoder(r io.ReadCloser) (sound.Source, sample.Codec, error) {
+	d, err := NewStreamDecoder(r)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	return d, d.Codec(), nil
+}
+
+func (c flacCodec) SeekingDecoder(r codec.IoReadSeekCloser) (sound.SourceSeeker, sample.Codec, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	return d, d.Codec(), nil
+}
+
+// Encoder returns an encoder with the default options, which completes
+// the metadata only if w is an io.Seeker.
+func (c flacCodec) Encoder(w io.WriteCloser, v sound.Form, sc sample.Codec) (sound.Sink, error) {
+	if sc == codec.AnySampleCodec {
+		sc = c.DefaultSampleCodec()
+	}
+	bps := bitsPerSample(sc)
+	if bps == 0 {
+		return nil, codec.ErrUnsupportedSampleCodec
+	}
+	return NewEncoder(w, v, bps, nil)
+}
+
+// EncoderWithOptions implements codec.OptionsEncoder.  CompressionLevel,
+// from 1 to 9, selects the Level of EncoderOptions from 0 to 8, and the
+// Metadata are written as the comments of the VORBIS_COMMENT block, in
+// the order of their names.  Other options are not understood.
+func (c flacCodec) EncoderWithOptions(w io.WriteCloser, v sound.Form, sc sample.Codec, opts *codec.EncoderOptions) (sound.Sink, error) {
+	if err := opts.Check(codec.OptCompressionLevel, codec.OptMetadata); err != nil {
+		return nil, err
+	}
+	if sc == codec.AnySampleCodec {
+		sc = c.DefaultSampleCodec()
+	}
+	bps := bitsPerSample(sc)
+	if bps == 0 {
+		return nil, codec.ErrUnsupportedSampleCodec
+	}
+	o := &EncoderOptions{Level: DefaultLevel, SeekPoints: DefaultSeekPoints}
+	if opts == nil {
+		return NewEncoder(w, v, bps, o)
+	}
+	if l := opts.CompressionLevel; l != 0 {
+		if l < 1 || l > len(levels) {
+			return nil, &codec.OptionError{Option: codec.OptCompressionLevel, Reason: "not in [1, 9]"}
+		}
+		o.Level = l - 1
+	}
+	names := make([]string, 0, len(opts.Metadata))
+	for k := range opts.Metadata {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		o.Comments = append(o.Comments, k+"="+opts.Metadata[k])
+	}
+	return NewEncoder(w, v, bps, o)
+}
+
+// NegotiateForm implements codec.FormNegotiator, limiting the number of
+// channels to 8 and choosing the integer sample codec with the fewest
+// bits not less than those of sc, or 32 bits if there is none.
+func (c flacCodec) NegotiateForm(v sound.Form, sc sample.Codec) (sound.Form, sample.Codec) {
+	if v.Channels() > 8 {
+		v = sound.NewForm(v.SampleRate(), 8)
+	}
+	if sc == codec.AnySampleCodec || bitsPerSample(sc) != 0 {
+		return v, sc
+	}
+	for _, o := range sampleCodecs {
+		if o.Bits() >= sc.Bits() {
+			return v, o
+		}
+	}
+	return v, sample.SInt32L
+}
+
+// Probe implements codec.Prober, reading the metadata blocks of r.
+func (c flacCodec) Probe(r io.ReadSeeker) (*codec.StreamInfo, error) {
+	m, err := readMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	frames := m.info.Frames
+	if frames == 0 {
+		frames = -1
+	}
+	return &codec.StreamInfo{
+		Container:   "flac",
+		Channels:    m.info.Channels,
+		SampleRate:  m.info.SampleRate,
+		SampleCodec: sampleCodec(m.info.BitsPerSample),
+		Frames:      frames}, nil
+}
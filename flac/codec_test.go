@@ -0,0 +1,129 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+func sampleRate(hz int) freq.T {
+	return freq.T(hz) * freq.Hertz
+}
+
+func TestRegistered(t *testing.T) {
+	c, err := codec.CodecFor("x.flac", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != Codec {
+		t.Errorf("got codec %v", c)
+	}
+}
+
+func TestSniffProbe(t *testing.T) {
+	s := testStream{rate: 48000, bps: 24, block: 4096, sub: subFixed2, assign: 1}
+	s.samples = signal(2, 5000, 24)
+	data := s.bytes()
+	cs := Codec.(codec.ConfidenceSniffer)
+	if got := cs.SniffConfidence(bufio.NewReader(bytes.NewReader(data))); got != codec.SniffStrong {
+		t.Errorf("sniff confidence %d", got)
+	}
+	if Codec.Sniff(bufio.NewReader(bytes.NewReader([]byte("RIFF....WAVE")))) {
+		t.Errorf("sniffed RIFF as flac")
+	}
+	info, err := Codec.(codec.Prober).Probe(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := codec.StreamInfo{
+		Container:   "flac",
+		Channels:    2,
+		SampleRate:  sampleRate(48000),
+		SampleCodec: sampleCodec(24),
+		Frames:      5000}
+	if *info != want {
+		t.Errorf("probe got %+v want %+v", *info, want)
+	}
+	src, sc, err := Codec.SeekingDecoder(codectest.NewFile(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc != sampleCodec(24) || src.Channels() != 2 {
+		t.Errorf("seeking decoder codec %s channels %d", sc, src.Channels())
+	}
+}
+
+func TestEncoderOptions(t *testing.T) {
+	v := sound.NewForm(sampleRate(44100), 2)
+	for _, tc := range []struct {
+		opts  *codec.EncoderOptions
+		block int
+		want  []string
+	}{
+		{nil, 4096, nil},
+		{&codec.EncoderOptions{CompressionLevel: 1}, 1152, nil},
+		{&codec.EncoderOptions{CompressionLevel: 9, Metadata: map[string]string{"TITLE": "t", "ARTIST": "a"}}, 4096, []string{"ARTIST=a", "TITLE=t"}},
+	} {
+		w := codectest.NewFile(nil)
+		snk, err := codec.EncoderWithOptions(w, ".flac", v, sample.SInt16L, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := snk.Send(make([]float64, 2*5000)); err != nil {
+			t.Fatal(err)
+		}
+		if err := snk.Close(); err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewDecoder(codectest.NewFile(w.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.StreamInfo().MaxBlockSize; got != tc.block {
+			t.Errorf("%+v: block size %d not %d", tc.opts, got, tc.block)
+		}
+		if got := d.Comments(); len(got)+len(tc.want) != 0 && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%+v: comments %q not %q", tc.opts, got, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		opts   *codec.EncoderOptions
+		option string
+	}{
+		{&codec.EncoderOptions{CompressionLevel: 10}, codec.OptCompressionLevel},
+		{&codec.EncoderOptions{BitRate: 500000}, codec.OptBitRate},
+		{&codec.EncoderOptions{Extra: map[string]interface{}{"x": 1}}, "x"},
+	} {
+		_, err := codec.EncoderWithOptions(codectest.NewFile(nil), ".flac", v, sample.SInt16L, tc.opts)
+		if oe, ok := err.(*codec.OptionError); !ok || oe.Option != tc.option {
+			t.Errorf("%+v: got %v", tc.opts, err)
+		}
+	}
+}
+
+func TestNegotiateForm(t *testing.T) {
+	fn := Codec.(codec.FormNegotiator)
+	for _, tc := range []struct {
+		nC, wantC int
+		sc, want  sample.Codec
+	}{
+		{2, 2, sample.SInt24B, sample.SInt24B},
+		{10, 8, codec.AnySampleCodec, codec.AnySampleCodec},
+		{1, 1, sample.SFloat32L, sample.SInt32L},
+	} {
+		v, sc := fn.NegotiateForm(sound.NewForm(sampleRate(48000), tc.nC), tc.sc)
+		if v.Channels() != tc.wantC || v.SampleRate() != sampleRate(48000) || sc != tc.want {
+			t.Errorf("%d channels %s: got %d channels %s", tc.nC, tc.sc, v.Channels(), sc)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package flac
+
+// crc8Table and crc16Table are the tables of the CRCs of frame headers, with
+// polynomial x^8 + x^2 + x + 1, and of frames, with polynomial
+// x^16 + x^15 + x^2 + 1.  Both are computed most significant bit first from
+// zero.
+var (
+	crc8Table  [256]uint8
+	crc16Table [256]uint16
+)
+
+func init() {
+	for i := range crc8Table {
+		c := uint8(i)
+		for k := 0; k < 8; k++ {
+			if c&0x80 != 0 {
+				c = c<<1 ^ 0x07
+			} else {
+				c <<= 1
+			}
+		}
+		crc8Table[i] = c
+	}
+	for i := range crc16Table {
+		c := uint16(i) << 8
+		for k := 0; k < 8; k++ {
+			if c&0x8000 != 0 {
+				c = c<<1 ^ 0x8005
+			} else {
+				c <<= 1
+			}
+		}
+		crc16Table[i] = c
+	}
+}
+
+func crc8(c uint8, b byte) uint8 {
+	return crc8Table[c^b]
+}
+
+func crc16(c uint16, b byte) uint16 {
+	return c<<8 ^ crc16Table[byte(c>>8)^b]
+}
@@ -0,0 +1,414 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"io"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Decoder decodes a FLAC stream.
+type Decoder struct {
+	*metadata
+	r    io.Reader
+	c    io.Closer
+	s    io.Seeker // nil if not seekable
+	base int64     // offset in r of the start of the stream
+	end  int64     // offset of the end of the stream, if seekable
+
+	br   *bufio.Reader
+	bits bitReader
+	fr   frame
+	fOff int // position in the current FLAC frame
+	fLen int // number of frames of the current FLAC frame
+
+	pos   int64
+	nFrm  int64 // number of frames, or -1 if unknown
+	err   error // error ending the frames decoded
+	scale float64
+
+	md5  hash.Hash // nil unless verifying the signature
+	mbuf []byte
+}
+
+// ReadSeekerCloser is the source of a seeking Decoder.
+type ReadSeekerCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// NewDecoder creates a decoder from a FLAC stream, which starts at the
+// current offset of r.
+func NewDecoder(r ReadSeekerCloser) (*Decoder, error) {
+	base, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	m, err := readMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	d := newDecoder(m, r, r, r, base)
+	d.end = end - base
+	if err := d.reset(m.first); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// NewStreamDecoder creates a decoder from a FLAC stream read sequentially
+// from r.  The decoder cannot seek.
+func NewStreamDecoder(r io.ReadCloser) (*Decoder, error) {
+	br := bufio.NewReader(r)
+	m, err := readMetadata(br)
+	if err != nil {
+		return nil, err
+	}
+	d := newDecoder(m, r, r, nil, 0)
+	d.br = br
+	d.bits.reset(br, m.first)
+	return d, nil
+}
+
+func newDecoder(m *metadata, r io.Reader, c io.Closer, s io.Seeker, base int64) *Decoder {
+	d := &Decoder{
+		metadata: m,
+		r:        r,
+		c:        c,
+		s:        s,
+		base:     base,
+		nFrm:     -1,
+		scale:    1 / float64(int64(1)<<uint(m.info.BitsPerSample-1))}
+	if m.info.Frames > 0 {
+		d.nFrm = m.info.Frames
+	}
+	if m.info.MD5 != [16]byte{} {
+		d.md5 = md5.New()
+	}
+	return d
+}
+
+var _ sound.SourceSeeker = (*Decoder)(nil)
+
+// StreamInfo returns the STREAMINFO metadata block.
+func (d *Decoder) StreamInfo() StreamInfo {
+	return *d.info
+}
+
+// SeekTable returns the seek points of the SEEKTABLE metadata block,
+// excluding placeholders, or nil if there is none.
+func (d *Decoder) SeekTable() []SeekPoint {
+	return d.seekTable
+}
+
+// Vendor returns the vendor string of the VORBIS_COMMENT metadata block,
+// or "" if there is none.
+func (d *Decoder) Vendor() string {
+	return d.vendor
+}
+
+// Comments returns the comments of the VORBIS_COMMENT metadata block, of
+// the form "NAME=value", or nil if there is none.
+func (d *Decoder) Comments() []string {
+	return d.comments
+}
+
+// Codec returns the smallest integer sample codec holding the samples.
+func (d *Decoder) Codec() sample.Codec {
+	return sampleCodec(d.info.BitsPerSample)
+}
+
+func sampleCodec(bps int) sample.Codec {
+	switch {
+	case bps <= 8:
+		return sample.SByte
+	case bps <= 16:
+		return sample.SInt16L
+	case bps <= 24:
+		return sample.SInt24L
+	default:
+		return sample.SInt32L
+	}
+}
+
+func (d *Decoder) SampleRate() freq.T {
+	return d.info.SampleRate
+}
+
+func (d *Decoder) Channels() int {
+	return d.info.Channels
+}
+
+func (d *Decoder) Receive(dst []float64) (int, error) {
+	nC := d.Channels()
+	if len(dst)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := len(dst) / nC
+	n := 0
+	for n < nF {
+		if d.fOff == d.fLen {
+			if d.err == nil {
+				d.err = d.next()
+			}
+			if d.err != nil {
+				break
+			}
+		}
+		k := d.fLen - d.fOff
+		if k > nF-n {
+			k = nF - n
+		}
+		for c, s := range d.fr.samples {
+			out := dst[c*nF+n : c*nF+n+k]
+			for i, v := range s[d.fOff : d.fOff+k] {
+				out[i] = float64(v) * d.scale
+			}
+		}
+		n += k
+		d.fOff += k
+		d.pos += int64(k)
+	}
+	if n == 0 {
+		if d.err == nil {
+			return 0, io.EOF
+		}
+		return 0, d.err
+	}
+	for c := 1; c < nC; c++ {
+		copy(dst[c*n:(c+1)*n], dst[c*nF:c*nF+n])
+	}
+	return n, nil
+}
+
+// next decodes the next FLAC frame.
+func (d *Decoder) next() error {
+	if d.nFrm >= 0 && d.pos >= d.nFrm {
+		return d.finish()
+	}
+	err := d.fr.decode(&d.bits, d.info)
+	if err == io.EOF {
+		if d.nFrm >= 0 {
+			return errs.Decode(codec.ErrTruncated, d.bits.pos(), "frame", "%d of %d frames", d.pos, d.nFrm)
+		}
+		d.nFrm = d.pos
+		return d.finish()
+	}
+	if err != nil {
+		return err
+	}
+	d.fOff, d.fLen = 0, d.fr.blockSize
+	if d.nFrm >= 0 && d.pos+int64(d.fLen) > d.nFrm {
+		d.fLen = int(d.nFrm - d.pos)
+	}
+	if d.md5 != nil {
+		d.sum()
+	}
+	return nil
+}
+
+// sum adds the frames of the current FLAC frame to the MD5 signature,
+// as interleaved little endian samples of whole bytes.
+func (d *Decoder) sum() {
+	bps := (d.info.BitsPerSample + 7) / 8
+	sz := d.fLen * len(d.fr.samples) * bps
+	if cap(d.mbuf) < sz {
+		d.mbuf = make([]byte, sz)
+	}
+	buf := d.mbuf[:sz]
+	i := 0
+	for f := 0; f < d.fLen; f++ {
+		for _, s := range d.fr.samples {
+			v := s[f]
+			for k := 0; k < bps; k++ {
+				buf[i] = byte(v >> uint(8*k))
+				i++
+			}
+		}
+	}
+	d.md5.Write(buf)
+}
+
+// finish verifies the MD5 signature at the end of the stream, returning
+// io.EOF if it matches or is not verified.
+func (d *Decoder) finish() error {
+	if d.md5 == nil {
+		return io.EOF
+	}
+	sum := d.md5.Sum(nil)
+	d.md5 = nil
+	if !bytes.Equal(sum, d.info.MD5[:]) {
+		return errs.Decode(codec.ErrCorrupt, -1, "", "MD5 signature mismatch")
+	}
+	return io.EOF
+}
+
+// Len returns the number of frames, or -1 if it is unknown because
+// STREAMINFO does not give it and the decoder has not reached the end of
+// the stream.
+func (d *Decoder) Len() int64 {
+	return d.nFrm
+}
+
+func (d *Decoder) Pos() int64 {
+	return d.pos
+}
+
+// Seek seeks to frame f, returning an error if f is negative or the
+// decoder cannot seek.  Seeking beyond the end is allowed, after which
+// Receive returns io.EOF.  After seeking, the MD5 signature is not
+// verified.
+func (d *Decoder) Seek(f int64) error {
+	if d.s == nil {
+		return codec.ErrUnsupportedFunction
+	}
+	if f < 0 {
+		return fmt.Errorf("flac: seek to negative frame %d", f)
+	}
+	d.md5, d.err = nil, nil
+	d.fOff, d.fLen = 0, 0
+	if d.nFrm >= 0 && f >= d.nFrm {
+		d.pos, d.err = f, io.EOF
+		return nil
+	}
+	off, err := d.search(f)
+	if err != nil {
+		return err
+	}
+	if err := d.reset(off); err != nil {
+		return err
+	}
+	for {
+		err := d.fr.decode(&d.bits, d.info)
+		if err == io.EOF {
+			d.pos, d.err = f, io.EOF
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		first := d.fr.first(d.info)
+		if f < first {
+			return errs.Decode(codec.ErrCorrupt, d.fr.off, "frame", "frame at sample %d precedes sample %d", first, f)
+		}
+		if f < first+int64(d.fr.blockSize) {
+			d.pos = f
+			d.fOff, d.fLen = int(f-first), d.fr.blockSize
+			if d.nFrm >= 0 && first+int64(d.fLen) > d.nFrm {
+				d.fLen = int(d.nFrm - first)
+			}
+			return nil
+		}
+	}
+}
+
+// search returns the offset of a FLAC frame at or before the one
+// containing frame f, from the seek table if there is one and otherwise
+// by bisection.
+func (d *Decoder) search(f int64) (int64, error) {
+	if len(d.seekTable) > 0 {
+		off := d.first
+		for _, pt := range d.seekTable {
+			if pt.Frame > f {
+				break
+			}
+			off = d.first + pt.Offset
+		}
+		return off, nil
+	}
+	span := int64(d.info.MaxFrameSize)
+	if span == 0 {
+		span = 1 << 16
+	}
+	lo, hi := d.first, d.end
+	for hi-lo > span {
+		mid := lo + (hi-lo)/2
+		off, ok, err := d.sync(mid, hi)
+		if err != nil {
+			return 0, err
+		}
+		first := d.fr.first(d.info)
+		if !ok || first > f {
+			hi = mid
+			continue
+		}
+		lo = off
+		if f < first+int64(d.fr.blockSize) {
+			break
+		}
+	}
+	return lo, nil
+}
+
+// sync decodes the first FLAC frame starting in [from, to), returning its
+// offset, or false if there is none.
+func (d *Decoder) sync(from, to int64) (int64, bool, error) {
+	for p := from; p < to; {
+		if err := d.reset(p); err != nil {
+			return 0, false, err
+		}
+		var prev byte
+		for {
+			c, err := d.br.ReadByte()
+			if err == io.EOF {
+				return 0, false, nil
+			}
+			if err != nil {
+				return 0, false, err
+			}
+			p++
+			if prev == 0xff && c&0xfe == 0xf8 {
+				break
+			}
+			prev = c
+		}
+		p -= 2
+		if p >= to {
+			return 0, false, nil
+		}
+		if err := d.reset(p); err != nil {
+			return 0, false, err
+		}
+		err := d.fr.decode(&d.bits, d.info)
+		if err == nil {
+			return p, true, nil
+		}
+		if _, ok := err.(*codec.DecodeError); !ok && err != io.EOF {
+			return 0, false, err
+		}
+		p++
+	}
+	return 0, false, nil
+}
+
+// reset positions the decoder at offset off of the stream.
+func (d *Decoder) reset(off int64) error {
+	if _, err := d.s.Seek(d.base+off, io.SeekStart); err != nil {
+		return err
+	}
+	if d.br == nil {
+		d.br = bufio.NewReader(d.r)
+	} else {
+		d.br.Reset(d.r)
+	}
+	d.bits.reset(d.br, off)
+	return nil
+}
+
+func (d *Decoder) Close() error {
+	return d.c.Close()
+}
@@ -0,0 +1,687 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+)
+
+// Subframe kinds written by testStream.
+const (
+	subConstant = iota
+	subVerbatim
+	subFixed0
+	subFixed1
+	subFixed2
+	subFixed3
+	subFixed4
+	subLPC
+	subEscape
+	subRice2 // fixed order 2 with 5 bit Rice parameters
+	subWasted
+)
+
+// testStream describes a FLAC stream to build.
+type testStream struct {
+	rate      int
+	bps       int
+	samples   [][]int64 // of each channel
+	block     int
+	variable  bool
+	assign    int  // channel assignment of stereo frames
+	sub       int  // subframe kind
+	seekTable bool // write a SEEKTABLE with a point per frame
+	noFrames  bool // leave the number of frames unknown
+	noMD5     bool
+}
+
+// bytes returns the encoded stream.
+func (s *testStream) bytes() []byte {
+	nC := len(s.samples)
+	n := len(s.samples[0])
+	var frames []byte
+	var offsets, firsts []int
+	for i, f := 0, 0; f < n; i, f = i+1, f+s.block {
+		m := s.block
+		if f+m > n {
+			m = n - f
+		}
+		offsets = append(offsets, len(frames))
+		firsts = append(firsts, f)
+		num := uint64(i)
+		if s.variable {
+			num = uint64(f)
+		}
+		chans := make([][]int64, nC)
+		for c := range chans {
+			chans[c] = s.samples[c][f : f+m]
+		}
+		frames = append(frames, s.frame(num, chans)...)
+	}
+	var w bitWriter
+	w.write(0x664c6143, 32) // "fLaC"
+	last := uint64(1)
+	if s.seekTable {
+		last = 0
+	}
+	w.write(last, 1)
+	w.write(blockStreamInfo, 7)
+	w.write(streamInfoSize, 24)
+	w.write(uint64(s.block), 16)
+	w.write(uint64(s.block), 16)
+	w.write(0, 24)
+	w.write(0, 24)
+	w.write(uint64(s.rate), 20)
+	w.write(uint64(nC-1), 3)
+	w.write(uint64(s.bps-1), 5)
+	if s.noFrames {
+		w.write(0, 36)
+	} else {
+		w.write(uint64(n), 36)
+	}
+	if s.noMD5 {
+		w.write(0, 64)
+		w.write(0, 64)
+	} else {
+		for _, b := range s.md5() {
+			w.write(uint64(b), 8)
+		}
+	}
+	if s.seekTable {
+		// a placeholder point follows the real ones.
+		w.write(1, 1)
+		w.write(blockSeekTable, 7)
+		w.write(uint64(seekPointSize*(len(offsets)+1)), 24)
+		for i := range offsets {
+			w.write(uint64(firsts[i]), 64)
+			w.write(uint64(offsets[i]), 64)
+			w.write(uint64(s.block), 16)
+		}
+		w.write(placeholder, 64)
+		w.write(0, 64)
+		w.write(0, 16)
+	}
+	return append(w.bytes(), frames...)
+}
+
+func (s *testStream) md5() []byte {
+	h := md5.New()
+	nb := (s.bps + 7) / 8
+	for f := range s.samples[0] {
+		for _, c := range s.samples {
+			for k := 0; k < nb; k++ {
+				h.Write([]byte{byte(c[f] >> uint(8*k))})
+			}
+		}
+	}
+	return h.Sum(nil)
+}
+
+// frame returns a frame with number num of samples chans.
+func (s *testStream) frame(num uint64, chans [][]int64) []byte {
+	m := len(chans[0])
+	var w bitWriter
+	w.write(0xfff8>>1, 15)
+	if s.variable {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+	bsCode, bsBits := uint64(7), uint(16)
+	switch {
+	case m == 192:
+		bsCode, bsBits = 1, 0
+	case m == 4096:
+		bsCode, bsBits = 12, 0
+	case m <= 256:
+		bsCode, bsBits = 6, 8
+	}
+	w.write(bsCode, 4)
+	rateCode, rateBits, rate := uint64(0), uint(0), uint64(0)
+	switch {
+	case s.rate == 44100:
+		rateCode = 9
+	case s.rate%1000 == 0 && s.rate/1000 < 256:
+		rateCode, rateBits, rate = 12, 8, uint64(s.rate/1000)
+	case s.rate < 1<<16:
+		rateCode, rateBits, rate = 13, 16, uint64(s.rate)
+	}
+	w.write(rateCode, 4)
+	assign := uint64(len(chans) - 1)
+	if len(chans) == 2 {
+		assign = uint64(s.assign)
+	}
+	w.write(assign, 4)
+	ssCode := uint64(0)
+	for i, b := range sampleSizes {
+		if b == s.bps {
+			ssCode = uint64(i)
+		}
+	}
+	w.write(ssCode, 3)
+	w.write(0, 1)
+	w.writeUTF8(num)
+	w.write(uint64(m-1), bsBits)
+	w.write(rate, rateBits)
+	var c8 uint8
+	for _, b := range w.bytes() {
+		c8 = crc8(c8, b)
+	}
+	w.write(uint64(c8), 8)
+
+	var l, r []int64
+	if len(chans) == 2 {
+		l, r = chans[0], chans[1]
+	}
+	side := make([]int64, m)
+	for i := range side {
+		if l != nil {
+			side[i] = l[i] - r[i]
+		}
+	}
+	for c, ch := range chans {
+		bps := uint(s.bps)
+		switch {
+		case assign == leftSide && c == 1:
+			ch, bps = side, bps+1
+		case assign == sideRight && c == 0:
+			ch, bps = side, bps+1
+		case assign == midSide && c == 0:
+			ch = make([]int64, m)
+			for i := range ch {
+				ch[i] = (l[i] + r[i]) >> 1
+			}
+		case assign == midSide && c == 1:
+			ch, bps = side, bps+1
+		}
+		s.subframe(&w, ch, bps)
+	}
+	w.align()
+	var c16 uint16
+	for _, b := range w.bytes() {
+		c16 = crc16(c16, b)
+	}
+	w.write(uint64(c16), 16)
+	return w.bytes()
+}
+
+func (s *testStream) subframe(w *bitWriter, x []int64, bps uint) {
+	kind := s.sub
+	if kind == subConstant {
+		for _, v := range x {
+			if v != x[0] {
+				kind = subVerbatim
+			}
+		}
+	}
+	wasted := uint(0)
+	if kind == subWasted {
+		var or int64
+		for _, v := range x {
+			or |= v
+		}
+		if or != 0 {
+			wasted = uint(bits.TrailingZeros64(uint64(or)))
+		}
+		if wasted >= bps {
+			wasted = 0
+		}
+		y := make([]int64, len(x))
+		for i := range x {
+			y[i] = x[i] >> wasted
+		}
+		x, bps, kind = y, bps-wasted, subFixed2
+	}
+	if kind >= subFixed1 && kind <= subLPC && len(x) < kind-subFixed0 {
+		kind = subVerbatim
+	}
+	typ := map[int]uint64{
+		subConstant: 0, subVerbatim: 1,
+		subFixed0: 8, subFixed1: 9, subFixed2: 10, subFixed3: 11, subFixed4: 12,
+		subEscape: 10, subRice2: 10, subLPC: 32 + 2}[kind]
+	w.write(0, 1)
+	w.write(typ, 6)
+	if wasted > 0 {
+		w.write(1, 1)
+		w.writeUnary(uint64(wasted - 1))
+	} else {
+		w.write(0, 1)
+	}
+	switch kind {
+	case subConstant:
+		w.writeSigned(x[0], bps)
+		return
+	case subVerbatim:
+		for _, v := range x {
+			w.writeSigned(v, bps)
+		}
+		return
+	}
+	order := int(typ) - 8
+	if kind == subLPC {
+		order = 3
+	}
+	for _, v := range x[:order] {
+		w.writeSigned(v, bps)
+	}
+	res := make([]int64, len(x))
+	if kind == subLPC {
+		// 2x[i-1] - x[i-2] with coefficients scaled by the shift.
+		coefs := []int64{4, -2, 0}
+		w.write(4, 4) // precision 5 bits
+		w.writeSigned(1, 5)
+		for _, c := range coefs {
+			w.writeSigned(c, 5)
+		}
+		for i := order; i < len(x); i++ {
+			var sum int64
+			for j, c := range coefs {
+				sum += c * x[i-1-j]
+			}
+			res[i] = x[i] - sum>>1
+		}
+	} else {
+		copy(res, x)
+		for k := 0; k < order; k++ {
+			for i := len(res) - 1; i > k; i-- {
+				res[i] -= res[i-1]
+			}
+		}
+	}
+	s.residual(w, res[order:], len(x), order, kind)
+}
+
+// residual writes residual res of a subframe of n samples, in 2
+// partitions if n is even.
+func (s *testStream) residual(w *bitWriter, res []int64, n, order, kind int) {
+	method, paramBits, escape := uint64(0), uint(4), uint64(15)
+	if kind == subRice2 {
+		method, paramBits, escape = 1, 5, 31
+	}
+	w.write(method, 2)
+	parts := 1
+	if n%2 == 0 && n/2 >= order {
+		parts = 2
+	}
+	w.write(uint64(bits.Len(uint(parts-1))), 4)
+	for p := 0; p < parts; p++ {
+		lo, hi := p*n/parts-order, (p+1)*n/parts-order
+		if lo < 0 {
+			lo = 0
+		}
+		part := res[lo:hi]
+		var sum uint64
+		zz := make([]uint64, len(part))
+		for i, v := range part {
+			zz[i] = uint64(v<<1) ^ uint64(v>>63)
+			sum += zz[i]
+		}
+		if kind == subEscape {
+			var width uint
+			for _, v := range part {
+				for width < 56 && (v < -1<<(width-1) || v >= 1<<(width-1) || width == 0) {
+					width++
+				}
+			}
+			w.write(escape, paramBits)
+			w.write(uint64(width), 5)
+			for _, v := range part {
+				w.writeSigned(v, width)
+			}
+			continue
+		}
+		k := uint64(0)
+		if len(part) > 0 {
+			k = uint64(bits.Len64(sum / uint64(len(part))))
+		}
+		if k >= escape {
+			k = escape - 1
+		}
+		w.write(k, paramBits)
+		for _, z := range zz {
+			w.writeUnary(z >> k)
+			w.write(z&(1<<k-1), uint(k))
+		}
+	}
+}
+
+// signal returns nC channels of n samples of bps bits.
+func signal(nC, n, bps int) [][]int64 {
+	res := make([][]int64, nC)
+	amp := math.Ldexp(1, bps-1) - 1
+	seed := uint32(1)
+	for c := range res {
+		res[c] = make([]int64, n)
+		for i := range res[c] {
+			seed = seed*1664525 + 1013904223
+			noise := float64(seed>>16)/65536 - 0.5
+			v := 0.9*math.Sin(float64(i*(c+1))/17) + 0.1*noise
+			x := int64(math.Floor(v * amp))
+			if bps > 4 && c%2 == 1 {
+				x &^= 3
+			}
+			res[c][i] = x
+		}
+	}
+	return res
+}
+
+func decodeAll(t *testing.T, d *Decoder) ([][]float64, error) {
+	t.Helper()
+	nC := d.Channels()
+	res := make([][]float64, nC)
+	buf := make([]float64, 1000*nC)
+	for {
+		n, err := d.Receive(buf)
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return res, err
+		}
+		for c := range res {
+			res[c] = append(res[c], buf[c*n:(c+1)*n]...)
+		}
+	}
+}
+
+func checkSamples(t *testing.T, name string, got [][]float64, want [][]int64, bps int) {
+	t.Helper()
+	for c := range want {
+		if len(got[c]) != len(want[c]) {
+			t.Errorf("%s: channel %d: got %d samples want %d", name, c, len(got[c]), len(want[c]))
+			return
+		}
+		for i, v := range want[c] {
+			if w := math.Ldexp(float64(v), 1-bps); got[c][i] != w {
+				t.Errorf("%s: channel %d sample %d: got %v want %v", name, c, i, got[c][i], w)
+				return
+			}
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		s    testStream
+	}{
+		{"mono 16 verbatim", testStream{rate: 44100, bps: 16, block: 4096, sub: subVerbatim}},
+		{"mono 8 constant", testStream{rate: 8000, bps: 8, block: 192, sub: subConstant}},
+		{"mono 4 fixed0", testStream{rate: 8000, bps: 4, block: 200, sub: subFixed0}},
+		{"mono 12 fixed1", testStream{rate: 11025, bps: 12, block: 1000, sub: subFixed1}},
+		{"stereo 16 fixed2 independent", testStream{rate: 44100, bps: 16, block: 1152, sub: subFixed2, assign: 1}},
+		{"stereo 20 fixed3 left side", testStream{rate: 48000, bps: 20, block: 1152, sub: subFixed3, assign: leftSide}},
+		{"stereo 24 fixed4 side right", testStream{rate: 96000, bps: 24, block: 4096, sub: subFixed4, assign: sideRight}},
+		{"stereo 24 lpc mid side", testStream{rate: 96000, bps: 24, block: 4096, sub: subLPC, assign: midSide}},
+		{"stereo 32 lpc mid side", testStream{rate: 192000, bps: 32, block: 1024, sub: subLPC, assign: midSide}},
+		{"stereo 32 verbatim left side", testStream{rate: 192000, bps: 32, block: 1024, sub: subVerbatim, assign: leftSide}},
+		{"stereo 16 escape", testStream{rate: 22050, bps: 16, block: 500, sub: subEscape, assign: midSide}},
+		{"stereo 24 rice2", testStream{rate: 44100, bps: 24, block: 4096, sub: subRice2, assign: sideRight}},
+		{"stereo 16 wasted", testStream{rate: 44100, bps: 16, block: 4096, sub: subWasted, assign: 1}},
+		{"6 channels 16 fixed2 variable", testStream{rate: 48000, bps: 16, block: 3000, sub: subFixed2, variable: true}},
+		{"mono 16 unknown length", testStream{rate: 44100, bps: 16, block: 4096, sub: subFixed2, noFrames: true}},
+	} {
+		nC := 1
+		switch {
+		case tc.s.assign != 0:
+			nC = 2
+		case tc.s.variable:
+			nC = 6
+		}
+		tc.s.samples = signal(nC, 10000, tc.s.bps)
+		if tc.s.sub == subConstant {
+			for c := range tc.s.samples {
+				for i := range tc.s.samples[c] {
+					tc.s.samples[c][i] = int64(i/tc.s.block) - 3
+				}
+			}
+		}
+		data := tc.s.bytes()
+		d, err := NewStreamDecoder(ioutil.NopCloser(bytes.NewReader(data)))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if d.Channels() != nC || d.SampleRate() != sampleRate(tc.s.rate) || d.StreamInfo().BitsPerSample != tc.s.bps {
+			t.Errorf("%s: %d channels at %s, %d bits", tc.name, d.Channels(), d.SampleRate(), d.StreamInfo().BitsPerSample)
+		}
+		if want := sampleCodec(tc.s.bps); d.Codec() != want {
+			t.Errorf("%s: codec %s want %s", tc.name, d.Codec(), want)
+		}
+		got, err := decodeAll(t, d)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		checkSamples(t, tc.name, got, tc.s.samples, tc.s.bps)
+		if d.Len() != 10000 || d.Pos() != 10000 {
+			t.Errorf("%s: len %d pos %d", tc.name, d.Len(), d.Pos())
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	for _, seekTable := range []bool{false, true} {
+		for _, variable := range []bool{false, true} {
+			s := testStream{rate: 44100, bps: 16, block: 1152, sub: subFixed2, assign: midSide, seekTable: seekTable, variable: variable}
+			s.samples = signal(2, 300000, 16)
+			data := s.bytes()
+			d, err := NewDecoder(codectest.NewFile(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(d.SeekTable()); seekTable && got != (300000+1151)/1152 {
+				t.Errorf("seek table has %d points", got)
+			}
+			for _, f := range []int64{250000, 0, 1151, 1152, 123456, 299999, 7} {
+				if err := d.Seek(f); err != nil {
+					t.Fatalf("seek table %t variable %t: seek %d: %v", seekTable, variable, f, err)
+				}
+				if d.Pos() != f {
+					t.Errorf("seek %d: pos %d", f, d.Pos())
+				}
+				buf := make([]float64, 2*100)
+				n, err := d.Receive(buf)
+				if err != nil {
+					t.Fatalf("seek %d: %v", f, err)
+				}
+				want := [][]int64{s.samples[0][f:], s.samples[1][f:]}
+				for c := range want {
+					if len(want[c]) > n {
+						want[c] = want[c][:n]
+					}
+				}
+				checkSamples(t, "seek", [][]float64{buf[:n], buf[n : 2*n]}, want, 16)
+			}
+			if err := d.Seek(400000); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := d.Receive(make([]float64, 2)); err != io.EOF {
+				t.Errorf("receive beyond end: %v", err)
+			}
+		}
+	}
+}
+
+func TestSeekUnknownLength(t *testing.T) {
+	s := testStream{rate: 44100, bps: 16, block: 4096, sub: subFixed1, noFrames: true}
+	s.samples = signal(1, 100000, 16)
+	d, err := NewDecoder(codectest.NewFile(s.bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Len() != -1 {
+		t.Errorf("len %d", d.Len())
+	}
+	if err := d.Seek(99990); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeAll(t, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSamples(t, "unknown length", got, [][]int64{s.samples[0][99990:]}, 16)
+	if d.Len() != 100000 {
+		t.Errorf("len %d", d.Len())
+	}
+}
+
+func TestMD5(t *testing.T) {
+	s := testStream{rate: 44100, bps: 24, block: 4096, sub: subFixed2}
+	s.samples = signal(1, 10000, 24)
+	data := s.bytes()
+	// the MD5 signature is at offset 26.
+	data[30] ^= 1
+	d, err := NewDecoder(codectest.NewFile(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = decodeAll(t, d)
+	if !errors.Is(err, codec.ErrCorrupt) {
+		t.Errorf("got %v want MD5 mismatch", err)
+	}
+	// after seeking, the signature is not verified.
+	if err := d.Seek(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeAll(t, d); err != nil {
+		t.Errorf("after seek: %v", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	s := testStream{rate: 44100, bps: 16, block: 4096, sub: subFixed2}
+	s.samples = signal(1, 10000, 16)
+	good := s.bytes()
+	const first = 4 + 4 + streamInfoSize
+	riff := []byte("RIFF\x00\x00\x00\x00WAVE")
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want error
+		meta bool // whether NewStreamDecoder fails
+	}{
+		{"not flac", riff, codec.ErrUnsupportedFormat, true},
+		{"short", good[:20], codec.ErrTruncated, true},
+		{"no streaminfo", append([]byte("fLaC\x81\x00\x00\x00"), good[8:]...), codec.ErrCorrupt, true},
+		{"header crc", flip(good, first+4), codec.ErrCorrupt, false},
+		{"frame crc", flip(good, first+100), codec.ErrCorrupt, false},
+		{"no sync", flip(good, first), codec.ErrCorrupt, false},
+		{"truncated frames", good[:len(good)-100], codec.ErrTruncated, false},
+	} {
+		d, err := NewStreamDecoder(ioutil.NopCloser(bytes.NewReader(tc.data)))
+		if err == nil {
+			_, err = decodeAll(t, d)
+			if tc.meta {
+				t.Errorf("%s: no error decoding metadata", tc.name)
+			}
+		}
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v want %v", tc.name, err, tc.want)
+			continue
+		}
+		var de *codec.DecodeError
+		if !errors.As(err, &de) || de.Codec != "flac" {
+			t.Errorf("%s: %v is not a flac DecodeError", tc.name, err)
+		}
+	}
+}
+
+// TestID3 checks that an ID3v2 tag before the stream is skipped.
+func TestID3(t *testing.T) {
+	s := testStream{rate: 44100, bps: 16, block: 4096, sub: subFixed2}
+	s.samples = signal(1, 5000, 16)
+	tag := make([]byte, 10+300)
+	// the size is 300 in 7 bit bytes.
+	copy(tag, "ID3\x04\x00\x00\x00\x00\x02\x2c")
+	d, err := NewDecoder(codectest.NewFile(append(tag, s.bytes()...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Seek(4500); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeAll(t, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSamples(t, "id3", got, [][]int64{s.samples[0][4500:]}, 16)
+}
+
+func flip(d []byte, i int) []byte {
+	res := append([]byte{}, d...)
+	res[i] ^= 0x10
+	return res
+}
+
+// TestReference decodes streams written by the reference encoder, which are
+// described in testdata/README.md, and checks the samples against the MD5
+// signature in their STREAMINFO.
+func TestReference(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		nC     int
+		bps    int
+		frames int64
+	}{
+		{"243749.flac", 1, 24, 402},
+		{"59996.flac", 2, 24, 8192},
+		{"189983.flac", 2, 16, 20724},
+		{"love.flac", 2, 16, 40900},
+	} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", tc.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewDecoder(codectest.NewFile(data))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !strings.HasPrefix(d.Vendor(), "reference libFLAC") {
+			t.Errorf("%s: vendor %q", tc.name, d.Vendor())
+		}
+		si := d.StreamInfo()
+		if si.Channels != tc.nC || si.BitsPerSample != tc.bps || si.Frames != tc.frames {
+			t.Errorf("%s: %d channels, %d bits, %d frames", tc.name, si.Channels, si.BitsPerSample, si.Frames)
+		}
+		got, err := decodeAll(t, d)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if int64(len(got[0])) != tc.frames {
+			t.Errorf("%s: decoded %d frames", tc.name, len(got[0]))
+			continue
+		}
+		// the signature is of the samples in little endian order,
+		// interleaved.
+		h := md5.New()
+		nb := (tc.bps + 7) / 8
+		for i := range got[0] {
+			for c := range got {
+				v := int64(math.Ldexp(got[c][i], tc.bps-1))
+				for k := 0; k < nb; k++ {
+					h.Write([]byte{byte(v >> uint(8*k))})
+				}
+			}
+		}
+		if sum := h.Sum(nil); !bytes.Equal(sum, si.MD5[:]) {
+			t.Errorf("%s: MD5 %x want %x", tc.name, sum, si.MD5)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package flac provides a pure Go decoder and encoder of FLAC, the Free
+// Lossless Audio Codec.
+//
+// Package flac decodes native FLAC files of any bit depth from 4 to 32
+// bits, with any channel decorrelation mode and constant, verbatim, fixed
+// and LPC subframes.  A seeking decoder uses the SEEKTABLE metadata block
+// where there is one, and otherwise searches for frame headers.  A decoder
+// which reads the whole stream from the start verifies the MD5 signature
+// of STREAMINFO.
+//
+// The encoder offers compression levels 0 to 8 like those of the
+// reference encoder, with fixed and LPC prediction, partitioned Rice
+// coding and stereo decorrelation.  It writes STREAMINFO, a SEEKTABLE and
+// a VORBIS_COMMENT block, completing the first two on Close when the
+// destination is an io.Seeker.  Through zikichombo.org/codec, the
+// CompressionLevel and Metadata of codec.EncoderOptions select the level
+// and the comments.  Importing package flac registers it with
+// zikichombo.org/codec for the extension .flac.
+//
+// Package flac is part of http://zikichombo.org
+package flac /* import "zikichombo.org/codec/flac" */
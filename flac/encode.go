@@ -0,0 +1,437 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package flac
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Subframe kinds.
+const (
+	kindConstant = iota
+	kindVerbatim
+	kindFixed
+	kindLPC
+)
+
+// Stereo decorrelation modes of a compression level.
+const (
+	stereoNone       = iota // independent channels
+	stereoEstimate          // choose by the residual of a fixed predictor
+	stereoExhaustive        // choose by the size of the encoded subframes
+)
+
+// level is a compression level.
+type level struct {
+	blockSize  int
+	maxLPC     int  // highest LPC order, 0 for fixed prediction only
+	stereo     int  // stereo decorrelation mode
+	maxPart    int  // highest Rice partition order
+	allOrders  bool // try every LPC order instead of the estimated best
+	precSearch bool // try neighbouring coefficient precisions
+}
+
+// levels are the compression levels, after those of the reference
+// encoder.
+var levels = [...]level{
+	{blockSize: 1152, stereo: stereoNone, maxPart: 3},
+	{blockSize: 1152, stereo: stereoEstimate, maxPart: 3},
+	{blockSize: 1152, stereo: stereoExhaustive, maxPart: 3},
+	{blockSize: 4096, maxLPC: 6, stereo: stereoNone, maxPart: 4},
+	{blockSize: 4096, maxLPC: 8, stereo: stereoEstimate, maxPart: 4},
+	{blockSize: 4096, maxLPC: 8, stereo: stereoExhaustive, maxPart: 5},
+	{blockSize: 4096, maxLPC: 8, stereo: stereoExhaustive, maxPart: 6},
+	{blockSize: 4096, maxLPC: 12, stereo: stereoExhaustive, maxPart: 6, allOrders: true},
+	{blockSize: 4096, maxLPC: 12, stereo: stereoExhaustive, maxPart: 6, allOrders: true, precSearch: true}}
+
+const (
+	maxFixedOrder = 4
+	maxPartOrder  = 8
+	// Rice parameters of 4 bits below riceEscape4 and of 5 bits below
+	// riceEscape5; the highest values are escapes.
+	riceEscape4 = 15
+	riceEscape5 = 31
+)
+
+// subframe is an encoding of a channel of a block.
+type subframe struct {
+	kind   int
+	order  int
+	wasted uint
+	bps    uint    // bits per sample of x
+	x      []int64 // samples shifted right by wasted
+	res    []int64 // residual of a fixed or LPC subframe
+	prec   uint
+	shift  int
+	coefs  [maxLPCOrder]int64
+	rice   rice
+	bits   int // size in bits
+}
+
+// rice is the partitioned Rice coding of a residual.
+type rice struct {
+	order  uint
+	params []uint
+	sums   []uint64
+}
+
+// analyzer chooses the subframes of a block.
+type analyzer struct {
+	level
+	win  []float64
+	fx   []float64
+	ac   [maxLPCOrder + 1]float64
+	lp   [maxLPCOrder][maxLPCOrder]float64
+	errs [maxLPCOrder]float64
+	tmp  subframe
+}
+
+// analyze sets sf to the smallest subframe it finds of samples x of bps
+// bits, using xs for the samples without wasted bits.
+func (a *analyzer) analyze(sf *subframe, x []int64, bps uint, xs *[]int64) {
+	n := len(x)
+	if cap(*xs) < n {
+		*xs = make([]int64, n)
+	}
+	sf.x = (*xs)[:n]
+	copy(sf.x, x)
+	sf.kind, sf.order, sf.wasted, sf.bps = kindConstant, 0, 0, bps
+	var or int64
+	constant := true
+	for _, v := range x {
+		or |= v
+		constant = constant && v == x[0]
+	}
+	if constant {
+		sf.bits = 8 + int(bps)
+		return
+	}
+	if k := uint(bits.TrailingZeros64(uint64(or))); k > 0 {
+		for i := range sf.x {
+			sf.x[i] >>= k
+		}
+		sf.wasted, sf.bps = k, bps-k
+	}
+	hdr := 8 + int(sf.wasted)
+	sf.kind = kindVerbatim
+	sf.bits = hdr + n*int(sf.bps)
+
+	t := &a.tmp
+	t.x, t.wasted, t.bps = sf.x, sf.wasted, sf.bps
+	if n > maxFixedOrder {
+		order := bestFixedOrder(sf.x)
+		t.kind, t.order = kindFixed, order
+		if fixedResidual(t, order) {
+			t.bits = hdr + order*int(t.bps) + t.rice.choose(t.res, n, order, a.maxPart)
+			if t.bits < sf.bits {
+				*sf, *t = *t, *sf
+			}
+		}
+	}
+	if a.maxLPC > 0 && n > a.maxLPC {
+		a.lpc(sf, hdr)
+	}
+}
+
+// lpc replaces sf by the smallest LPC subframe tried if it is smaller.
+func (a *analyzer) lpc(sf *subframe, hdr int) {
+	x, n := sf.x, len(sf.x)
+	if len(a.win) != n {
+		a.win = make([]float64, n)
+		tukey(a.win, 0.5)
+	}
+	if cap(a.fx) < n {
+		a.fx = make([]float64, n)
+	}
+	fx := a.fx[:n]
+	for i, v := range x {
+		fx[i] = float64(v) * a.win[i]
+	}
+	ac := a.ac[:a.maxLPC+1]
+	autocorrelate(fx, ac)
+	if ac[0] == 0 {
+		return
+	}
+	max := levinson(ac, &a.lp, a.errs[:])
+	lo, hi := 1, max
+	if !a.allOrders {
+		best, bestBits := max, math.Inf(1)
+		for k := 1; k <= max; k++ {
+			p := precision(n, sf.bps, k)
+			b := expectedBits(a.errs[k-1], n-k)*float64(n-k) + float64(k)*float64(p+sf.bps)
+			if b < bestBits {
+				best, bestBits = k, b
+			}
+		}
+		lo, hi = best, best
+	}
+	t := &a.tmp
+	for order := lo; order <= hi; order++ {
+		p := precision(n, sf.bps, order)
+		pLo, pHi := p, p
+		if a.precSearch {
+			pLo, pHi = p-1, p+1
+			if pLo < minPrecision {
+				pLo = minPrecision
+			}
+			if pHi > maxPrecision {
+				pHi = maxPrecision
+			}
+		}
+		for prec := pLo; prec <= pHi; prec++ {
+			t.x, t.wasted, t.bps = sf.x, sf.wasted, sf.bps
+			t.kind, t.order, t.prec = kindLPC, order, prec
+			shift, ok := quantize(a.lp[order-1][:order], t.coefs[:order], prec)
+			if !ok || !lpcResidual(t, shift) {
+				continue
+			}
+			t.shift = shift
+			t.bits = hdr + order*int(t.bps) + 4 + 5 + order*int(prec) + t.rice.choose(t.res, n, order, a.maxPart)
+			if t.bits < sf.bits {
+				*sf, *t = *t, *sf
+			}
+		}
+	}
+}
+
+// bestFixedOrder returns the order of the fixed predictor with the least
+// total absolute residual of x.
+func bestFixedOrder(x []int64) int {
+	var sums [maxFixedOrder + 1]uint64
+	for i := maxFixedOrder; i < len(x); i++ {
+		e0 := x[i]
+		e1 := e0 - x[i-1]
+		e2 := e1 - (x[i-1] - x[i-2])
+		e3 := e2 - (x[i-1] - 2*x[i-2] + x[i-3])
+		e4 := e3 - (x[i-1] - 3*x[i-2] + 3*x[i-3] - x[i-4])
+		sums[0] += abs(e0)
+		sums[1] += abs(e1)
+		sums[2] += abs(e2)
+		sums[3] += abs(e3)
+		sums[4] += abs(e4)
+	}
+	best := 0
+	for k := range sums {
+		if sums[k] < sums[best] {
+			best = k
+		}
+	}
+	return best
+}
+
+func abs(v int64) uint64 {
+	if v < 0 {
+		return uint64(-v)
+	}
+	return uint64(v)
+}
+
+// fitsResidual reports whether v is in the range of a residual, which
+// decoders may hold in 32 bits.
+func fitsResidual(v int64) bool {
+	return v >= math.MinInt32 && v <= math.MaxInt32
+}
+
+// fixedResidual sets the residual of sf for the fixed predictor of order
+// order, returning false if it is out of range.
+func fixedResidual(sf *subframe, order int) bool {
+	x := sf.x
+	res := grow(&sf.res, len(x)-order)
+	for i := order; i < len(x); i++ {
+		var p int64
+		switch order {
+		case 1:
+			p = x[i-1]
+		case 2:
+			p = 2*x[i-1] - x[i-2]
+		case 3:
+			p = 3*x[i-1] - 3*x[i-2] + x[i-3]
+		case 4:
+			p = 4*x[i-1] - 6*x[i-2] + 4*x[i-3] - x[i-4]
+		}
+		r := x[i] - p
+		if !fitsResidual(r) {
+			return false
+		}
+		res[i-order] = r
+	}
+	return true
+}
+
+// lpcResidual sets the residual of sf for its quantized LPC coefficients
+// and shift, returning false if it is out of range.
+func lpcResidual(sf *subframe, shift int) bool {
+	x, coefs := sf.x, sf.coefs[:sf.order]
+	res := grow(&sf.res, len(x)-sf.order)
+	for i := sf.order; i < len(x); i++ {
+		var sum int64
+		for j, c := range coefs {
+			sum += c * x[i-1-j]
+		}
+		r := x[i] - sum>>uint(shift)
+		if !fitsResidual(r) {
+			return false
+		}
+		res[i-sf.order] = r
+	}
+	return true
+}
+
+func grow(s *[]int64, n int) []int64 {
+	if cap(*s) < n {
+		*s = make([]int64, n)
+	}
+	*s = (*s)[:n]
+	return *s
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+// choose chooses the partition order and Rice parameters of residual res
+// of a block of n samples with predictor order order, returning the size
+// in bits of the coded residual.
+func (rc *rice) choose(res []int64, n, order, maxPart int) int {
+	p := maxPart
+	for p > 0 && (n%(1<<uint(p)) != 0 || n>>uint(p) <= order) {
+		p--
+	}
+	parts := 1 << uint(p)
+	if cap(rc.sums) < parts {
+		rc.sums = make([]uint64, parts)
+	}
+	sums := rc.sums[:parts]
+	psz := n >> uint(p)
+	i := 0
+	for k := range sums {
+		var s uint64
+		for end := (k+1)*psz - order; i < end; i++ {
+			s += zigzag(res[i])
+		}
+		sums[k] = s
+	}
+	if cap(rc.params) < parts {
+		rc.params = make([]uint, parts)
+	}
+	var params [1 << maxPartOrder]uint
+	best := -1
+	for ; ; p-- {
+		parts := 1 << uint(p)
+		psz := n >> uint(p)
+		total, big := 0, false
+		for k := 0; k < parts; k++ {
+			cnt := psz
+			if k == 0 {
+				cnt -= order
+			}
+			param, b := riceParam(sums[k], cnt)
+			params[k] = param
+			total += b
+			big = big || param >= riceEscape4
+		}
+		if big {
+			total += 5 * parts
+		} else {
+			total += 4 * parts
+		}
+		if best < 0 || total < best {
+			best = total
+			rc.order = uint(p)
+			rc.params = rc.params[:parts]
+			copy(rc.params, params[:parts])
+		}
+		if p == 0 {
+			break
+		}
+		for k := 0; k < parts/2; k++ {
+			sums[k] = sums[2*k] + sums[2*k+1]
+		}
+	}
+	return best + 2 + 4
+}
+
+// riceParam returns the Rice parameter for cnt values summing to sum and
+// an upper bound on their coded size in bits.
+func riceParam(sum uint64, cnt int) (uint, int) {
+	best, bestBits := uint(0), uint64(math.MaxUint64)
+	for k := uint(0); k < riceEscape5; k++ {
+		b := uint64(cnt)*uint64(k+1) + sum>>k
+		if b < bestBits {
+			best, bestBits = k, b
+		}
+		if sum>>k == 0 {
+			break
+		}
+	}
+	// sum>>k is less than the sum of the quotients by at most cnt.
+	return best, int(bestBits) + cnt
+}
+
+// writeSubframe writes sf to w.
+func writeSubframe(w *bitWriter, sf *subframe) {
+	var typ uint64
+	switch sf.kind {
+	case kindConstant:
+		typ = 0
+	case kindVerbatim:
+		typ = 1
+	case kindFixed:
+		typ = 8 + uint64(sf.order)
+	case kindLPC:
+		typ = 32 + uint64(sf.order) - 1
+	}
+	if sf.wasted > 0 {
+		w.write(typ<<1|1, 8)
+		w.writeUnary(uint64(sf.wasted - 1))
+	} else {
+		w.write(typ<<1, 8)
+	}
+	switch sf.kind {
+	case kindConstant:
+		w.writeSigned(sf.x[0], sf.bps)
+		return
+	case kindVerbatim:
+		for _, v := range sf.x {
+			w.writeSigned(v, sf.bps)
+		}
+		return
+	}
+	for _, v := range sf.x[:sf.order] {
+		w.writeSigned(v, sf.bps)
+	}
+	if sf.kind == kindLPC {
+		w.write(uint64(sf.prec-1), 4)
+		w.writeSigned(int64(sf.shift), 5)
+		for _, c := range sf.coefs[:sf.order] {
+			w.writeSigned(c, sf.prec)
+		}
+	}
+	writeResidual(w, sf)
+}
+
+// writeResidual writes the residual of sf to w.
+func writeResidual(w *bitWriter, sf *subframe) {
+	rc := &sf.rice
+	paramBits := uint(4)
+	for _, k := range rc.params {
+		if k >= riceEscape4 {
+			paramBits = 5
+		}
+	}
+	w.write(uint64(paramBits-4), 2)
+	w.write(uint64(rc.order), 4)
+	n := len(sf.x)
+	psz := n >> rc.order
+	i := 0
+	for p, k := range rc.params {
+		w.write(uint64(k), paramBits)
+		for end := (p+1)*psz - sf.order; i < end; i++ {
+			z := zigzag(sf.res[i])
+			w.writeUnary(z >> k)
+			w.write(z, k)
+		}
+	}
+}
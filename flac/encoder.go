@@ -0,0 +1,528 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package flac
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+)
+
+const (
+	// DefaultLevel is the compression level of nil EncoderOptions.
+	DefaultLevel = 5
+	// DefaultSeekPoints is the number of seek points of nil
+	// EncoderOptions.
+	DefaultSeekPoints = 100
+)
+
+// Vendor is the vendor string of the VORBIS_COMMENT blocks written.
+const Vendor = "zikichombo.org/codec/flac"
+
+// EncoderOptions are the options of an Encoder.
+type EncoderOptions struct {
+	// Level is the compression level, from 0, the fastest, to 8, the
+	// smallest.
+	Level int
+	// SeekPoints is the number of points of the SEEKTABLE, spread evenly
+	// over the stream when the encoder closes.  No SEEKTABLE is written
+	// if it is 0 or the destination is not an io.Seeker.
+	SeekPoints int
+	// Comments are the fields of the VORBIS_COMMENT block, of the form
+	// "NAME=value".
+	Comments []string
+}
+
+// Encoder encodes a FLAC stream.
+type Encoder struct {
+	w    io.Writer
+	c    io.Closer
+	s    io.Seeker // nil if not seekable
+	base int64     // offset in w of the start of the stream
+
+	info      StreamInfo
+	nPoints   int
+	seekTable int64 // offset of the SEEKTABLE data
+	first     int64 // offset of the first frame
+	offsets   []int64
+
+	a      analyzer
+	block  [][]int64 // samples of the current block, by channel
+	n      int       // number of frames in the current block
+	num    uint64    // number of the current block
+	off    int64     // offset of the current block
+	subs   []subframe
+	xs     [][]int64
+	side   []int64
+	mid    []int64
+	bw     bitWriter
+	md5    hash.Hash
+	mbuf   []byte
+	scale  float64
+	closed bool
+}
+
+var _ sound.Sink = (*Encoder)(nil)
+
+// NewEncoder creates an encoder of samples of form v with bps bits per
+// sample, from 4 to 32, to w, with options opts or the defaults if opts
+// is nil.  If w is an io.Seeker, Close completes STREAMINFO with the
+// number of frames, frame sizes and MD5 signature and fills the
+// SEEKTABLE; otherwise these are left unknown.
+func NewEncoder(w io.WriteCloser, v sound.Form, bps int, opts *EncoderOptions) (*Encoder, error) {
+	if opts == nil {
+		opts = &EncoderOptions{Level: DefaultLevel, SeekPoints: DefaultSeekPoints}
+	}
+	rate := v.SampleRate()
+	switch {
+	case opts.Level < 0 || opts.Level >= len(levels):
+		return nil, fmt.Errorf("flac: invalid compression level %d", opts.Level)
+	case bps < 4 || bps > 32:
+		return nil, fmt.Errorf("flac: unsupported bits per sample %d", bps)
+	case v.Channels() < 1 || v.Channels() > 8:
+		return nil, fmt.Errorf("flac: unsupported number of channels %d", v.Channels())
+	case rate <= 0 || rate%freq.Hertz != 0 || rate/freq.Hertz >= 1<<20:
+		return nil, fmt.Errorf("flac: unsupported sample rate %s", rate)
+	case opts.SeekPoints < 0 || opts.SeekPoints*seekPointSize >= 1<<24:
+		return nil, fmt.Errorf("flac: invalid number of seek points %d", opts.SeekPoints)
+	}
+	lv := levels[opts.Level]
+	e := &Encoder{
+		w: w,
+		c: w,
+		a: analyzer{level: lv},
+		info: StreamInfo{
+			MinBlockSize:  lv.blockSize,
+			MaxBlockSize:  lv.blockSize,
+			SampleRate:    rate,
+			Channels:      v.Channels(),
+			BitsPerSample: bps},
+		block: make([][]int64, v.Channels()),
+		subs:  make([]subframe, 4+v.Channels()),
+		xs:    make([][]int64, 4+v.Channels()),
+		md5:   md5.New(),
+		scale: float64(int64(1) << uint(bps-1))}
+	for c := range e.block {
+		e.block[c] = make([]int64, lv.blockSize)
+	}
+	if s, ok := w.(io.Seeker); ok {
+		base, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		e.s, e.base, e.nPoints = s, base, opts.SeekPoints
+	}
+	if err := e.writeMetadata(opts.Comments); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// writeMetadata writes the stream marker and metadata blocks.
+func (e *Encoder) writeMetadata(comments []string) error {
+	var vc []byte
+	vc = appendString(vc, Vendor)
+	vc = appendUint32LE(vc, uint32(len(comments)))
+	for _, c := range comments {
+		vc = appendString(vc, c)
+	}
+	if len(vc) >= 1<<24 {
+		return errors.New("flac: comments too large")
+	}
+	buf := []byte("fLaC")
+	buf = appendBlockHeader(buf, blockStreamInfo, streamInfoSize, false)
+	buf = e.info.append(buf)
+	if e.nPoints > 0 {
+		buf = appendBlockHeader(buf, blockSeekTable, e.nPoints*seekPointSize, false)
+		e.seekTable = int64(len(buf))
+		buf = appendSeekTable(buf, nil, e.nPoints)
+	}
+	buf = appendBlockHeader(buf, blockVorbisComment, len(vc), true)
+	buf = append(buf, vc...)
+	e.first = int64(len(buf))
+	e.off = e.first
+	_, err := e.w.Write(buf)
+	return err
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint32LE(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func appendUint32LE(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// appendUint appends the n low bytes of v to b, big endian.
+func appendUint(b []byte, n int, v uint64) []byte {
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, byte(v>>uint(8*i)))
+	}
+	return b
+}
+
+func appendBlockHeader(b []byte, typ, n int, last bool) []byte {
+	if last {
+		typ |= 0x80
+	}
+	return append(b, byte(typ), byte(n>>16), byte(n>>8), byte(n))
+}
+
+// append appends the STREAMINFO block data of si to b.
+func (si *StreamInfo) append(b []byte) []byte {
+	b = appendUint(b, 2, uint64(si.MinBlockSize))
+	b = appendUint(b, 2, uint64(si.MaxBlockSize))
+	b = append(b, byte(si.MinFrameSize>>16), byte(si.MinFrameSize>>8), byte(si.MinFrameSize))
+	b = append(b, byte(si.MaxFrameSize>>16), byte(si.MaxFrameSize>>8), byte(si.MaxFrameSize))
+	x := uint64(si.SampleRate/freq.Hertz)<<44 |
+		uint64(si.Channels-1)<<41 |
+		uint64(si.BitsPerSample-1)<<36 |
+		uint64(si.Frames)&(1<<36-1)
+	b = appendUint(b, 8, x)
+	return append(b, si.MD5[:]...)
+}
+
+// appendSeekTable appends the SEEKTABLE block data of pts and
+// placeholders up to n points to b.
+func appendSeekTable(b []byte, pts []SeekPoint, n int) []byte {
+	for _, pt := range pts {
+		b = appendUint(b, 8, uint64(pt.Frame))
+		b = appendUint(b, 8, uint64(pt.Offset))
+		b = appendUint(b, 2, uint64(pt.Frames))
+	}
+	for i := len(pts); i < n; i++ {
+		b = appendUint(b, 8, placeholder)
+		b = append(b, make([]byte, 10)...)
+	}
+	return b
+}
+
+func (e *Encoder) SampleRate() freq.T {
+	return e.info.SampleRate
+}
+
+func (e *Encoder) Channels() int {
+	return e.info.Channels
+}
+
+// Send sends the frames of src, encoding each block as it fills.  Samples
+// are scaled by 2^(bps-1) and clipped.
+func (e *Encoder) Send(src []float64) error {
+	nC := e.info.Channels
+	if len(src)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	if e.closed {
+		return errors.New("flac: send to closed encoder")
+	}
+	nF := len(src) / nC
+	max := e.scale - 1
+	for f := 0; f < nF; {
+		k := len(e.block[0]) - e.n
+		if k > nF-f {
+			k = nF - f
+		}
+		for c, b := range e.block {
+			for i, x := range src[c*nF+f : c*nF+f+k] {
+				v := math.Floor(x*e.scale + 0.5)
+				switch {
+				case v > max:
+					v = max
+				case v < -e.scale:
+					v = -e.scale
+				case v != v:
+					v = 0
+				}
+				b[e.n+i] = int64(v)
+			}
+		}
+		e.n += k
+		f += k
+		if e.n == len(e.block[0]) {
+			if err := e.writeFrame(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFrame encodes and writes the current block.
+func (e *Encoder) writeFrame() error {
+	n := e.n
+	chans := make([][]int64, len(e.block))
+	for c, b := range e.block {
+		chans[c] = b[:n]
+	}
+	e.sum(chans)
+	bps := uint(e.info.BitsPerSample)
+	assign := len(chans) - 1
+	subs := e.subs[:len(chans)]
+	if len(chans) == 2 && e.a.stereo != stereoNone {
+		assign = e.stereo(chans[0], chans[1], bps)
+		subs = e.subs[4:6]
+	} else {
+		for c, x := range chans {
+			e.a.analyze(&subs[c], x, bps, &e.xs[c])
+		}
+	}
+
+	w := &e.bw
+	w.reset()
+	w.write(0xfff8, 16)
+	bsCode, bsBits := blockSizeCode(n)
+	rCode, rBits, rVal := rateCode(e.info.SampleRate)
+	w.write(bsCode, 4)
+	w.write(rCode, 4)
+	w.write(uint64(assign), 4)
+	w.write(sampleSizeCode(e.info.BitsPerSample)<<1, 4)
+	w.writeUTF8(e.num)
+	if bsBits > 0 {
+		w.write(uint64(n-1), bsBits)
+	}
+	if rBits > 0 {
+		w.write(rVal, rBits)
+	}
+	var c8 uint8
+	for _, b := range w.bytes() {
+		c8 = crc8(c8, b)
+	}
+	w.write(uint64(c8), 8)
+	for c := range subs {
+		writeSubframe(w, &subs[c])
+	}
+	w.align()
+	var c16 uint16
+	for _, b := range w.bytes() {
+		c16 = crc16(c16, b)
+	}
+	w.write(uint64(c16), 16)
+	buf := w.bytes()
+	if _, err := e.w.Write(buf); err != nil {
+		return err
+	}
+	e.offsets = append(e.offsets, e.off-e.first)
+	e.off += int64(len(buf))
+	if sz := len(buf); e.info.MinFrameSize == 0 || sz < e.info.MinFrameSize {
+		e.info.MinFrameSize = sz
+	}
+	if sz := len(buf); sz > e.info.MaxFrameSize {
+		e.info.MaxFrameSize = sz
+	}
+	e.info.Frames += int64(n)
+	e.num++
+	e.n = 0
+	return nil
+}
+
+// stereo chooses the channel assignment of the block of left channel l
+// and right channel r, leaving its subframes in e.subs[4:6].
+func (e *Encoder) stereo(l, r []int64, bps uint) int {
+	n := len(l)
+	side, mid := grow(&e.side, n), grow(&e.mid, n)
+	for i := range side {
+		side[i] = l[i] - r[i]
+		mid[i] = (l[i] + r[i]) >> 1
+	}
+	chans := [4][]int64{l, r, mid, side}
+	var cost [4]int
+	if e.a.stereo == stereoEstimate {
+		for c, x := range chans {
+			cost[c] = fixedCost(x)
+		}
+	} else {
+		for c, x := range chans {
+			b := bps
+			if c == 3 {
+				b++
+			}
+			e.a.analyze(&e.subs[c], x, b, &e.xs[c])
+			cost[c] = e.subs[c].bits
+		}
+	}
+	// the channels of each assignment, in order.
+	pairs := [...]struct{ assign, a, b int }{
+		{1, 0, 1},
+		{leftSide, 0, 3},
+		{sideRight, 3, 1},
+		{midSide, 2, 3}}
+	best := pairs[0]
+	for _, p := range pairs[1:] {
+		if cost[p.a]+cost[p.b] < cost[best.a]+cost[best.b] {
+			best = p
+		}
+	}
+	for i, c := range []int{best.a, best.b} {
+		sf := &e.subs[4+i]
+		if e.a.stereo == stereoEstimate {
+			b := bps
+			if c == 3 {
+				b++
+			}
+			e.a.analyze(sf, chans[c], b, &e.xs[4+i])
+			continue
+		}
+		// move the subframe, with its buffers, out of the candidates.
+		*sf, e.subs[c] = e.subs[c], *sf
+		e.xs[4+i], e.xs[c] = e.xs[c], e.xs[4+i]
+	}
+	return best.assign
+}
+
+// fixedCost estimates the cost of x by the absolute residual of its best
+// fixed predictor.
+func fixedCost(x []int64) int {
+	if len(x) <= maxFixedOrder {
+		return 0
+	}
+	var sum uint64
+	for i := 2; i < len(x); i++ {
+		sum += abs(x[i] - 2*x[i-1] + x[i-2])
+	}
+	if sum > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(sum)
+}
+
+// sum adds the block to the MD5 signature.
+func (e *Encoder) sum(chans [][]int64) {
+	nb := (e.info.BitsPerSample + 7) / 8
+	sz := len(chans[0]) * len(chans) * nb
+	if cap(e.mbuf) < sz {
+		e.mbuf = make([]byte, sz)
+	}
+	buf := e.mbuf[:sz]
+	i := 0
+	for f := range chans[0] {
+		for _, s := range chans {
+			v := s[f]
+			for k := 0; k < nb; k++ {
+				buf[i] = byte(v >> uint(8*k))
+				i++
+			}
+		}
+	}
+	e.md5.Write(buf)
+}
+
+// blockSizeCode returns the block size code of a frame header for n
+// samples and the number of bits of n-1 following the header.
+func blockSizeCode(n int) (uint64, uint) {
+	switch {
+	case n == 192:
+		return 1, 0
+	case n >= 576 && n <= 4608 && n%576 == 0 && (n/576)&(n/576-1) == 0:
+		return uint64(2 + ilog2(n/576)), 0
+	case n >= 256 && n <= 32768 && n&(n-1) == 0:
+		return uint64(8 + ilog2(n/256)), 0
+	case n <= 256:
+		return 6, 8
+	}
+	return 7, 16
+}
+
+// rateCode returns the sample rate code of a frame header and any value
+// of rBits bits following the header.
+func rateCode(rate freq.T) (code uint64, rBits uint, v uint64) {
+	hz := uint64(rate / freq.Hertz)
+	for i, r := range rates {
+		if i > 0 && uint64(r) == hz {
+			return uint64(i), 0, 0
+		}
+	}
+	switch {
+	case hz%1000 == 0 && hz/1000 < 1<<8:
+		return 12, 8, hz / 1000
+	case hz < 1<<16:
+		return 13, 16, hz
+	case hz%10 == 0 && hz/10 < 1<<16:
+		return 14, 16, hz / 10
+	}
+	return 0, 0, 0
+}
+
+// sampleSizeCode returns the sample size code of a frame header for bps
+// bits per sample.
+func sampleSizeCode(bps int) uint64 {
+	for i, b := range sampleSizes {
+		if b == bps {
+			return uint64(i)
+		}
+	}
+	return 0
+}
+
+// Close writes any partial block and, if the destination is seekable,
+// completes the metadata, then closes the destination.
+func (e *Encoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	if e.n > 0 {
+		if err := e.writeFrame(); err != nil {
+			e.c.Close()
+			return err
+		}
+	}
+	if e.s != nil {
+		if err := e.finish(); err != nil {
+			e.c.Close()
+			return err
+		}
+	}
+	return e.c.Close()
+}
+
+// finish rewrites STREAMINFO and the SEEKTABLE.
+func (e *Encoder) finish() error {
+	copy(e.info.MD5[:], e.md5.Sum(nil))
+	if _, err := e.s.Seek(e.base+8, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(e.info.append(nil)); err != nil {
+		return err
+	}
+	if e.nPoints > 0 {
+		if _, err := e.s.Seek(e.base+e.seekTable, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := e.w.Write(appendSeekTable(nil, e.seekPoints(), e.nPoints)); err != nil {
+			return err
+		}
+	}
+	_, err := e.s.Seek(e.base+e.off, io.SeekStart)
+	return err
+}
+
+// seekPoints returns up to e.nPoints seek points at the frames closest
+// before evenly spaced samples.
+func (e *Encoder) seekPoints() []SeekPoint {
+	var pts []SeekPoint
+	bs := int64(e.info.MaxBlockSize)
+	for k := 0; k < e.nPoints; k++ {
+		target := e.info.Frames * int64(k) / int64(e.nPoints)
+		i := int(target / bs)
+		if i >= len(e.offsets) {
+			break
+		}
+		if len(pts) > 0 && pts[len(pts)-1].Frame == int64(i)*bs {
+			continue
+		}
+		frames := bs
+		if rem := e.info.Frames - int64(i)*bs; rem < frames {
+			frames = rem
+		}
+		pts = append(pts, SeekPoint{Frame: int64(i) * bs, Offset: e.offsets[i], Frames: int(frames)})
+	}
+	return pts
+}
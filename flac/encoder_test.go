@@ -0,0 +1,314 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package flac
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"zikichombo.org/codec/codectest"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// TestConformance runs the conformance suite with the sample codecs the
+// decoder reports.
+func TestConformance(t *testing.T) {
+	codectest.Run(t, Codec, &codectest.Options{
+		SampleCodecs: []sample.Codec{sample.SByte, sample.SInt16L, sample.SInt24L, sample.SInt32L}})
+}
+
+type form struct {
+	nC int
+}
+
+func (v form) SampleRate() freq.T { return sampleRate(44100) }
+func (v form) Channels() int      { return v.nC }
+
+// floats returns samples of bps bits as sent to an encoder.
+func floats(samples [][]int64, bps int) []float64 {
+	var res []float64
+	for _, c := range samples {
+		for _, v := range c {
+			res = append(res, math.Ldexp(float64(v), 1-bps))
+		}
+	}
+	return res
+}
+
+// encode encodes samples of bps bits to w, sending them in blocks of
+// irregular size.
+func encode(t *testing.T, w io.WriteCloser, samples [][]int64, bps int, opts *EncoderOptions) {
+	t.Helper()
+	e, err := NewEncoder(w, form{len(samples)}, bps, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len(samples[0])
+	for i, sz := 0, 1; i < n; i, sz = i+sz, sz*2+3 {
+		j := i + sz
+		if j > n {
+			j = n
+		}
+		part := make([][]int64, len(samples))
+		for c := range part {
+			part[c] = samples[c][i:j]
+		}
+		if err := e.Send(floats(part, bps)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tone returns a correlated stereo tone of n samples of 16 bits with a
+// little noise.
+func tone(n int) [][]int64 {
+	res := [][]int64{make([]int64, n), make([]int64, n)}
+	seed := uint32(7)
+	for i := 0; i < n; i++ {
+		seed = seed*1664525 + 1013904223
+		x := 12000*math.Sin(float64(i)/20) + 4000*math.Sin(float64(i)/3.1)
+		res[0][i] = int64(x) + int64(seed>>28)
+		res[1][i] = int64(0.8*x) - int64(seed>>29&3)
+	}
+	return res
+}
+
+func TestEncodeLevels(t *testing.T) {
+	samples := tone(20000)
+	var sizes []int
+	for lv := range levels {
+		w := codectest.NewFile(nil)
+		encode(t, w, samples, 16, &EncoderOptions{Level: lv})
+		d, err := NewDecoder(codectest.NewFile(w.Bytes()))
+		if err != nil {
+			t.Fatalf("level %d: %v", lv, err)
+		}
+		si := d.StreamInfo()
+		if si.MD5 == [16]byte{} || si.Frames != 20000 || si.MinFrameSize == 0 || si.MaxFrameSize < si.MinFrameSize {
+			t.Errorf("level %d: stream info %+v", lv, si)
+		}
+		got, err := decodeAll(t, d)
+		if err != nil {
+			t.Errorf("level %d: %v", lv, err)
+		}
+		checkSamples(t, "level", got, samples, 16)
+		sizes = append(sizes, len(w.Bytes()))
+	}
+	if raw := 2 * 2 * 20000; sizes[0] >= raw/2 {
+		t.Errorf("level 0 size %d of %d", sizes[0], raw)
+	}
+	if sizes[8] > sizes[5] || sizes[5] > sizes[0] {
+		t.Errorf("sizes of levels 0, 5 and 8 %d, %d and %d", sizes[0], sizes[5], sizes[8])
+	}
+}
+
+func TestEncodeBitDepths(t *testing.T) {
+	for _, bps := range []int{4, 8, 12, 16, 20, 24, 32} {
+		for _, nC := range []int{1, 2, 3} {
+			samples := signal(nC, 5000, bps)
+			// full scale, constant and silent stretches
+			lo, hi := -int64(1)<<uint(bps-1), int64(1)<<uint(bps-1)-1
+			for i := 1000; i < 1200; i++ {
+				samples[0][i] = lo
+				if i%2 == 0 {
+					samples[0][i] = hi
+				}
+				samples[nC-1][i+1000] = 0
+			}
+			for _, lv := range []int{0, 5, 8} {
+				w := codectest.NewFile(nil)
+				encode(t, w, samples, bps, &EncoderOptions{Level: lv})
+				d, err := NewDecoder(codectest.NewFile(w.Bytes()))
+				if err != nil {
+					t.Fatalf("%d bits %d channels level %d: %v", bps, nC, lv, err)
+				}
+				got, err := decodeAll(t, d)
+				if err != nil {
+					t.Errorf("%d bits %d channels level %d: %v", bps, nC, lv, err)
+				}
+				checkSamples(t, "bit depths", got, samples, bps)
+			}
+		}
+	}
+}
+
+// TestEncodeStereo checks channels suiting each stereo decorrelation.
+func TestEncodeStereo(t *testing.T) {
+	base := signal(2, 8192, 24)
+	for _, tc := range []struct {
+		name string
+		r    func(l, r int64) int64
+	}{
+		{"identical", func(l, r int64) int64 { return l }},
+		{"inverted", func(l, r int64) int64 { return -l }},
+		{"near", func(l, r int64) int64 { return l + r>>12 }},
+		{"silent", func(l, r int64) int64 { return 0 }},
+	} {
+		samples := [][]int64{base[0], make([]int64, len(base[0]))}
+		for i := range samples[1] {
+			samples[1][i] = tc.r(base[0][i], base[1][i])
+		}
+		for _, lv := range []int{1, 4, 6} {
+			w := codectest.NewFile(nil)
+			encode(t, w, samples, 24, &EncoderOptions{Level: lv})
+			d, err := NewDecoder(codectest.NewFile(w.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := decodeAll(t, d)
+			if err != nil {
+				t.Errorf("%s level %d: %v", tc.name, lv, err)
+			}
+			checkSamples(t, tc.name, got, samples, 24)
+		}
+	}
+}
+
+func TestEncodeMetadata(t *testing.T) {
+	samples := signal(2, 100000, 16)
+	comments := []string{"TITLE=Take Five", "ARTIST=", "COMMENT=a=b"}
+	w := codectest.NewFile(nil)
+	encode(t, w, samples, 16, &EncoderOptions{Level: 2, SeekPoints: 10, Comments: comments})
+	d, err := NewDecoder(codectest.NewFile(w.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Vendor() != Vendor {
+		t.Errorf("vendor %q", d.Vendor())
+	}
+	if got := d.Comments(); len(got) != len(comments) {
+		t.Errorf("comments %q", got)
+	} else {
+		for i := range got {
+			if got[i] != comments[i] {
+				t.Errorf("comments %q", got)
+			}
+		}
+	}
+	pts := d.SeekTable()
+	if len(pts) != 10 {
+		t.Errorf("%d seek points", len(pts))
+	}
+	for i, pt := range pts {
+		if pt.Frame%1152 != 0 || pt.Frame > int64(i)*10000 || pt.Frame+1152 <= int64(i)*10000 {
+			t.Errorf("seek point %d: %+v", i, pt)
+		}
+	}
+	for _, f := range []int64{99999, 54321, 0, 1152} {
+		if err := d.Seek(f); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]float64, 2)
+		if _, err := d.Receive(buf); err != nil {
+			t.Fatal(err)
+		}
+		checkSamples(t, "seek", [][]float64{buf[:1], buf[1:]}, [][]int64{samples[0][f : f+1], samples[1][f : f+1]}, 16)
+	}
+}
+
+type writeCloser struct {
+	io.Writer
+}
+
+func (w writeCloser) Close() error {
+	return nil
+}
+
+// TestEncodeStream checks that encoding without seeking leaves the
+// number of frames and the signature unknown.
+func TestEncodeStream(t *testing.T) {
+	samples := signal(1, 10000, 24)
+	var b bytes.Buffer
+	encode(t, writeCloser{&b}, samples, 24, nil)
+	d, err := NewStreamDecoder(ioutil.NopCloser(bytes.NewReader(b.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if si := d.StreamInfo(); si.Frames != 0 || si.MD5 != [16]byte{} || d.SeekTable() != nil {
+		t.Errorf("stream info %+v, %d seek points", si, len(d.SeekTable()))
+	}
+	got, err := decodeAll(t, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSamples(t, "stream", got, samples, 24)
+	if d.Len() != 10000 {
+		t.Errorf("len %d", d.Len())
+	}
+}
+
+func TestEncoderErrors(t *testing.T) {
+	for _, tc := range []struct {
+		v    sound.Form
+		bps  int
+		opts *EncoderOptions
+	}{
+		{form{2}, 3, nil},
+		{form{2}, 33, nil},
+		{form{9}, 16, nil},
+		{form{2}, 16, &EncoderOptions{Level: 9}},
+		{form{2}, 16, &EncoderOptions{SeekPoints: -1}},
+	} {
+		if _, err := NewEncoder(codectest.NewFile(nil), tc.v, tc.bps, tc.opts); err == nil {
+			t.Errorf("%d channels %d bits %+v: no error", tc.v.Channels(), tc.bps, tc.opts)
+		}
+	}
+}
+
+// TestEncodeReference re-encodes the streams of TestReference, checking
+// that the MD5 signature matches that of the reference encoder and that
+// the streams are not much larger.
+func TestEncodeReference(t *testing.T) {
+	for _, name := range []string{"243749.flac", "59996.flac", "189983.flac", "love.flac"} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewDecoder(codectest.NewFile(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref := d.StreamInfo()
+		got, err := decodeAll(t, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples := make([][]int64, len(got))
+		for c := range got {
+			samples[c] = make([]int64, len(got[c]))
+			for i, v := range got[c] {
+				samples[c][i] = int64(math.Ldexp(v, ref.BitsPerSample-1))
+			}
+		}
+		for _, lv := range []int{0, 5, 8} {
+			w := codectest.NewFile(nil)
+			encode(t, w, samples, ref.BitsPerSample, &EncoderOptions{Level: lv})
+			d, err := NewDecoder(codectest.NewFile(w.Bytes()))
+			if err != nil {
+				t.Fatalf("%s level %d: %v", name, lv, err)
+			}
+			if si := d.StreamInfo(); si.MD5 != ref.MD5 {
+				t.Errorf("%s level %d: MD5 %x want %x", name, lv, si.MD5, ref.MD5)
+			}
+			res, err := decodeAll(t, d)
+			if err != nil {
+				t.Errorf("%s level %d: %v", name, lv, err)
+			}
+			checkSamples(t, name, res, samples, ref.BitsPerSample)
+			// the reference streams include their metadata.
+			if n := len(w.Bytes()); lv >= 5 && n > len(data)*11/10 {
+				t.Errorf("%s level %d: %d bytes, reference %d", name, lv, n, len(data))
+			}
+		}
+	}
+}
@@ -0,0 +1,9 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package flac
+
+import "zikichombo.org/codec/internal/decerr"
+
+// errs makes the *codec.DecodeErrors of package flac.
+const errs = decerr.Codec("flac")
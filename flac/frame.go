@@ -0,0 +1,482 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package flac
+
+import (
+	"errors"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound/freq"
+)
+
+// errUnaryLimit is returned by bitReader.readUnary for a value too large.
+var errUnaryLimit = errors.New("unary value too large")
+
+// Channel assignments of a frame beyond the independent ones, which are
+// the number of channels minus one.
+const (
+	leftSide  = 8
+	sideRight = 9
+	midSide   = 10
+)
+
+// maxRice bounds the quotient of a Rice coded residual, far beyond that of
+// any residual of 32 bit samples.
+const maxRice = 1 << 34
+
+// frameHeader is the header of a FLAC frame.
+type frameHeader struct {
+	blockSize int
+	rate      freq.T // 0 if given by STREAMINFO
+	assign    int    // channel assignment
+	channels  int
+	bps       int
+	variable  bool   // variable block size
+	num       uint64 // sample number if variable, else frame number
+}
+
+// frame is a decoded FLAC frame.
+type frame struct {
+	frameHeader
+	off     int64     // offset of the frame
+	samples [][]int64 // samples of each channel, blockSize long
+	coefs   [32]int64
+}
+
+// first returns the number of the first sample of fr, in a stream with
+// info si.
+func (fr *frame) first(si *StreamInfo) int64 {
+	if fr.variable {
+		return int64(fr.num)
+	}
+	bs := int64(fr.blockSize)
+	if si.MinBlockSize == si.MaxBlockSize {
+		bs = int64(si.MaxBlockSize)
+	}
+	return int64(fr.num) * bs
+}
+
+// decode decodes a frame from b, which is byte aligned, in a stream with
+// info si.  It returns io.EOF if b has no more data.
+func (fr *frame) decode(b *bitReader, si *StreamInfo) error {
+	if err := fr.readHeader(b, si); err != nil {
+		return err
+	}
+	if cap(fr.samples) < fr.channels {
+		fr.samples = make([][]int64, fr.channels)
+	}
+	fr.samples = fr.samples[:fr.channels]
+	for c := range fr.samples {
+		s := fr.samples[c]
+		if cap(s) < fr.blockSize {
+			s = make([]int64, fr.blockSize)
+		}
+		fr.samples[c] = s[:fr.blockSize]
+		bps := uint(fr.bps)
+		switch {
+		case fr.assign == leftSide && c == 1, fr.assign == sideRight && c == 0, fr.assign == midSide && c == 1:
+			bps++
+		}
+		if err := fr.readSubframe(b, fr.samples[c], bps); err != nil {
+			if err == errUnaryLimit {
+				return errs.Decode(codec.ErrCorrupt, b.pos(), "frame", "residual out of range in channel %d", c)
+			}
+			if _, ok := err.(*codec.DecodeError); ok {
+				return err
+			}
+			return errs.Read(err, fr.off, "frame")
+		}
+	}
+	b.align()
+	crc := b.crc16
+	x, err := b.read(16)
+	if err != nil {
+		return errs.Read(err, fr.off, "frame")
+	}
+	if uint16(x) != crc {
+		return errs.Decode(codec.ErrCorrupt, fr.off, "frame", "CRC-16 %#04x, computed %#04x", x, crc)
+	}
+	fr.decorrelate()
+	return nil
+}
+
+// readHeader reads the frame header from b.
+func (fr *frame) readHeader(b *bitReader, si *StreamInfo) error {
+	b.resetCRC()
+	fr.off = b.pos()
+	c, err := b.readByte()
+	if err != nil {
+		return err
+	}
+	var hdr [4]byte
+	hdr[0] = c
+	for i := 1; i < 4; i++ {
+		if hdr[i], err = b.readByte(); err != nil {
+			return errs.Read(err, fr.off, "frame")
+		}
+	}
+	if hdr[0] != 0xff || hdr[1]&0xfe != 0xf8 {
+		return errs.Decode(codec.ErrCorrupt, fr.off, "frame", "no frame sync code")
+	}
+	h := &fr.frameHeader
+	h.variable = hdr[1]&1 != 0
+	bsCode, rateCode := hdr[2]>>4, hdr[2]&0xf
+	h.assign = int(hdr[3] >> 4)
+	ssCode := hdr[3] >> 1 & 0x7
+	switch {
+	case bsCode == 0:
+		return errs.Decode(codec.ErrCorrupt, fr.off+2, "frame", "reserved block size")
+	case rateCode == 15:
+		return errs.Decode(codec.ErrCorrupt, fr.off+2, "frame", "invalid sample rate")
+	case h.assign > midSide:
+		return errs.Decode(codec.ErrCorrupt, fr.off+3, "frame", "reserved channel assignment %d", h.assign)
+	case ssCode == 3:
+		return errs.Decode(codec.ErrCorrupt, fr.off+3, "frame", "reserved sample size")
+	case hdr[3]&1 != 0:
+		return errs.Decode(codec.ErrCorrupt, fr.off+3, "frame", "reserved bit set")
+	}
+	if h.num, err = readUTF8(b, h.variable); err != nil {
+		if err, ok := err.(*codec.DecodeError); ok {
+			err.Offset = fr.off + 4
+			return err
+		}
+		return errs.Read(err, fr.off, "frame")
+	}
+	switch {
+	case bsCode == 1:
+		h.blockSize = 192
+	case bsCode <= 5:
+		h.blockSize = 576 << (bsCode - 2)
+	case bsCode == 6:
+		x, err := b.read(8)
+		if err != nil {
+			return errs.Read(err, fr.off, "frame")
+		}
+		h.blockSize = int(x) + 1
+	case bsCode == 7:
+		x, err := b.read(16)
+		if err != nil {
+			return errs.Read(err, fr.off, "frame")
+		}
+		h.blockSize = int(x) + 1
+	default:
+		h.blockSize = 256 << (bsCode - 8)
+	}
+	switch rateCode {
+	case 0:
+		h.rate = 0
+	case 12, 13, 14:
+		n, unit := uint(16), freq.T(1)
+		switch rateCode {
+		case 12:
+			n, unit = 8, 1000
+		case 14:
+			unit = 10
+		}
+		x, err := b.read(n)
+		if err != nil {
+			return errs.Read(err, fr.off, "frame")
+		}
+		h.rate = freq.T(x) * unit * freq.Hertz
+	default:
+		h.rate = rates[rateCode] * freq.Hertz
+	}
+	h.bps = sampleSizes[ssCode]
+	if h.bps == 0 {
+		h.bps = si.BitsPerSample
+	}
+	h.channels = h.assign + 1
+	if h.assign >= leftSide {
+		h.channels = 2
+	}
+	crc := b.crc8
+	x, err := b.read(8)
+	if err != nil {
+		return errs.Read(err, fr.off, "frame")
+	}
+	if uint8(x) != crc {
+		return errs.Decode(codec.ErrCorrupt, fr.off, "frame", "header CRC-8 %#02x, computed %#02x", x, crc)
+	}
+	switch {
+	case h.channels != si.Channels:
+		return errs.Decode(codec.ErrCorrupt, fr.off, "frame", "%d channels in a stream of %d", h.channels, si.Channels)
+	case h.bps != si.BitsPerSample:
+		return errs.Decode(codec.ErrCorrupt, fr.off, "frame", "%d bits per sample in a stream of %d", h.bps, si.BitsPerSample)
+	case h.rate != 0 && h.rate != si.SampleRate:
+		return errs.Decode(codec.ErrCorrupt, fr.off, "frame", "sample rate %v in a stream of %v", h.rate, si.SampleRate)
+	}
+	return nil
+}
+
+// rates are the sample rates in Hz of the sample rate codes of a frame
+// header which give one.
+var rates = [...]freq.T{0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000}
+
+// sampleSizes are the bits per sample of the sample size codes of a frame
+// header, or 0 if given by STREAMINFO or reserved.
+var sampleSizes = [...]int{0, 8, 12, 0, 16, 20, 24, 32}
+
+// readUTF8 reads the frame or sample number of a frame header, coded like
+// UTF-8 extended to 36 bits.
+func readUTF8(b *bitReader, variable bool) (uint64, error) {
+	c, err := b.read(8)
+	if err != nil {
+		return 0, err
+	}
+	var x uint64
+	var n int
+	switch {
+	case c&0x80 == 0:
+		x, n = c, 0
+	case c&0xe0 == 0xc0:
+		x, n = c&0x1f, 1
+	case c&0xf0 == 0xe0:
+		x, n = c&0x0f, 2
+	case c&0xf8 == 0xf0:
+		x, n = c&0x07, 3
+	case c&0xfc == 0xf8:
+		x, n = c&0x03, 4
+	case c&0xfe == 0xfc:
+		x, n = c&0x01, 5
+	case c == 0xfe:
+		x, n = 0, 6
+	default:
+		return 0, errs.Decode(codec.ErrCorrupt, -1, "frame", "invalid coded number")
+	}
+	if n == 6 && !variable {
+		return 0, errs.Decode(codec.ErrCorrupt, -1, "frame", "frame number too large")
+	}
+	for i := 0; i < n; i++ {
+		c, err := b.read(8)
+		if err != nil {
+			return 0, err
+		}
+		if c&0xc0 != 0x80 {
+			return 0, errs.Decode(codec.ErrCorrupt, -1, "frame", "invalid coded number")
+		}
+		x = x<<6 | c&0x3f
+	}
+	return x, nil
+}
+
+// readSubframe reads a subframe of samples s of bps bits from b.
+func (fr *frame) readSubframe(b *bitReader, s []int64, bps uint) error {
+	off := b.pos()
+	h, err := b.read(8)
+	if err != nil {
+		return err
+	}
+	if h&0x80 != 0 {
+		return errs.Decode(codec.ErrCorrupt, off, "frame", "subframe padding bit set")
+	}
+	typ := int(h >> 1 & 0x3f)
+	var wasted uint
+	if h&1 != 0 {
+		k, err := b.readUnary(uint64(bps))
+		if err != nil && err != errUnaryLimit {
+			return err
+		}
+		if err != nil || k+1 >= uint64(bps) {
+			return errs.Decode(codec.ErrCorrupt, off, "frame", "too many wasted bits")
+		}
+		wasted = uint(k) + 1
+		bps -= wasted
+	}
+	switch {
+	case typ == 0:
+		v, err := b.readSigned(bps)
+		if err != nil {
+			return err
+		}
+		for i := range s {
+			s[i] = v
+		}
+	case typ == 1:
+		for i := range s {
+			if s[i], err = b.readSigned(bps); err != nil {
+				return err
+			}
+		}
+	case typ >= 8 && typ <= 12:
+		order := typ - 8
+		if err := readWarmUp(b, s, bps, order, off); err != nil {
+			return err
+		}
+		if err := readResidual(b, s, order, off); err != nil {
+			return err
+		}
+		predictFixed(s, order)
+	case typ >= 32:
+		order := typ - 31
+		if err := readWarmUp(b, s, bps, order, off); err != nil {
+			return err
+		}
+		x, err := b.read(4)
+		if err != nil {
+			return err
+		}
+		if x == 15 {
+			return errs.Decode(codec.ErrCorrupt, off, "frame", "invalid LPC coefficient precision")
+		}
+		prec := uint(x) + 1
+		shift, err := b.readSigned(5)
+		if err != nil {
+			return err
+		}
+		if shift < 0 {
+			return errs.Decode(codec.ErrCorrupt, off, "frame", "negative LPC shift %d", shift)
+		}
+		coefs := fr.coefs[:order]
+		for i := range coefs {
+			if coefs[i], err = b.readSigned(prec); err != nil {
+				return err
+			}
+		}
+		if err := readResidual(b, s, order, off); err != nil {
+			return err
+		}
+		predictLPC(s, coefs, uint(shift))
+	default:
+		return errs.Decode(codec.ErrCorrupt, off, "frame", "reserved subframe type %d", typ)
+	}
+	if wasted != 0 {
+		for i := range s {
+			s[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+// readWarmUp reads the first order samples of s, of bps bits, for a
+// subframe at offset off.
+func readWarmUp(b *bitReader, s []int64, bps uint, order int, off int64) error {
+	if order > len(s) {
+		return errs.Decode(codec.ErrCorrupt, off, "frame", "predictor order %d exceeds block size %d", order, len(s))
+	}
+	var err error
+	for i := 0; i < order; i++ {
+		if s[i], err = b.readSigned(bps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readResidual reads the residual of a subframe at offset off with
+// predictor order order into s[order:].
+func readResidual(b *bitReader, s []int64, order int, off int64) error {
+	method, err := b.read(2)
+	if err != nil {
+		return err
+	}
+	if method > 1 {
+		return errs.Decode(codec.ErrCorrupt, off, "frame", "reserved residual coding method %d", method)
+	}
+	paramBits, escape := uint(4), uint64(15)
+	if method == 1 {
+		paramBits, escape = 5, 31
+	}
+	x, err := b.read(4)
+	if err != nil {
+		return err
+	}
+	parts := 1 << x
+	n := len(s) >> x
+	if n<<x != len(s) || n < order {
+		return errs.Decode(codec.ErrCorrupt, off, "frame", "partition order %d invalid for block size %d and predictor order %d", x, len(s), order)
+	}
+	i := order
+	for p := 0; p < parts; p++ {
+		end := (p + 1) * n
+		k, err := b.read(paramBits)
+		if err != nil {
+			return err
+		}
+		if k == escape {
+			w, err := b.read(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				if s[i], err = b.readSigned(uint(w)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			q, err := b.readUnary(maxRice)
+			if err != nil {
+				return err
+			}
+			r, err := b.read(uint(k))
+			if err != nil {
+				return err
+			}
+			v := q<<k | r
+			s[i] = int64(v>>1) ^ -int64(v&1)
+		}
+	}
+	return nil
+}
+
+// predictFixed adds the prediction of the fixed predictor of order order
+// to the residual s[order:].
+func predictFixed(s []int64, order int) {
+	switch order {
+	case 1:
+		for i := 1; i < len(s); i++ {
+			s[i] += s[i-1]
+		}
+	case 2:
+		for i := 2; i < len(s); i++ {
+			s[i] += 2*s[i-1] - s[i-2]
+		}
+	case 3:
+		for i := 3; i < len(s); i++ {
+			s[i] += 3*s[i-1] - 3*s[i-2] + s[i-3]
+		}
+	case 4:
+		for i := 4; i < len(s); i++ {
+			s[i] += 4*s[i-1] - 6*s[i-2] + 4*s[i-3] - s[i-4]
+		}
+	}
+}
+
+// predictLPC adds the prediction of the linear predictor with
+// coefficients coefs and shift to the residual s[len(coefs):].
+func predictLPC(s []int64, coefs []int64, shift uint) {
+	order := len(coefs)
+	for i := order; i < len(s); i++ {
+		var sum int64
+		for j, c := range coefs {
+			sum += c * s[i-1-j]
+		}
+		s[i] += sum >> shift
+	}
+}
+
+// decorrelate restores the left and right channels of fr from a side
+// channel.
+func (fr *frame) decorrelate() {
+	switch fr.assign {
+	case leftSide:
+		l, d := fr.samples[0], fr.samples[1]
+		for i := range d {
+			d[i] = l[i] - d[i]
+		}
+	case sideRight:
+		d, r := fr.samples[0], fr.samples[1]
+		for i := range d {
+			d[i] += r[i]
+		}
+	case midSide:
+		m, d := fr.samples[0], fr.samples[1]
+		for i := range m {
+			x := m[i]<<1 | d[i]&1
+			m[i] = (x + d[i]) >> 1
+			d[i] = (x - d[i]) >> 1
+		}
+	}
+}
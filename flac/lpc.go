@@ -0,0 +1,169 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package flac
+
+import "math"
+
+const (
+	maxLPCOrder = 32
+	// minPrecision and maxPrecision bound the precision in bits of
+	// quantized LPC coefficients.
+	minPrecision = 5
+	maxPrecision = 15
+	// maxShift is the largest shift of quantized LPC coefficients.
+	maxShift = 15
+)
+
+// tukey sets w to a Tukey window with tapered fraction p.
+func tukey(w []float64, p float64) {
+	n := len(w)
+	np := int(p / 2 * float64(n))
+	for i := range w {
+		w[i] = 1
+	}
+	if np <= 1 {
+		return
+	}
+	for i := 0; i < np; i++ {
+		x := 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(np))
+		w[i] = x
+		w[n-1-i] = x
+	}
+}
+
+// autocorrelate sets ac[l] to the autocorrelation at lag l of x.
+func autocorrelate(x, ac []float64) {
+	for l := range ac {
+		var sum float64
+		for i := l; i < len(x); i++ {
+			sum += x[i] * x[i-l]
+		}
+		ac[l] = sum
+	}
+}
+
+// levinson computes the linear predictors of orders 1 to len(ac)-1 from
+// the autocorrelation ac by the Levinson-Durbin recursion.  The predictor
+// of order k predicts x[i] as the sum of lp[k-1][j]*x[i-1-j], with error
+// errs[k-1].  It returns the highest order computed, which is lower if
+// the error vanishes.
+func levinson(ac []float64, lp *[maxLPCOrder][maxLPCOrder]float64, errs []float64) int {
+	var a [maxLPCOrder]float64
+	e := ac[0]
+	max := len(ac) - 1
+	for i := 0; i < max; i++ {
+		r := -ac[i+1]
+		for j := 0; j < i; j++ {
+			r -= a[j] * ac[i-j]
+		}
+		r /= e
+		a[i] = r
+		for j := 0; j < i/2; j++ {
+			t := a[j]
+			a[j] += r * a[i-1-j]
+			a[i-1-j] += r * t
+		}
+		if i%2 == 1 {
+			a[i/2] += a[i/2] * r
+		}
+		e *= 1 - r*r
+		for j := 0; j <= i; j++ {
+			lp[i][j] = -a[j]
+		}
+		errs[i] = e
+		if e <= 0 {
+			return i + 1
+		}
+	}
+	return max
+}
+
+// quantize quantizes the predictor lp to coefficients q of prec bits with
+// a shift, returning false if the predictor cannot be represented.
+func quantize(lp []float64, q []int64, prec uint) (int, bool) {
+	var cmax float64
+	for _, c := range lp {
+		cmax = math.Max(cmax, math.Abs(c))
+	}
+	if cmax <= 0 || math.IsInf(cmax, 0) || math.IsNaN(cmax) {
+		return 0, false
+	}
+	_, exp := math.Frexp(cmax)
+	shift := int(prec) - exp - 1
+	if shift > maxShift {
+		shift = maxShift
+	}
+	if shift < 0 {
+		return 0, false
+	}
+	qmax := int64(1)<<(prec-1) - 1
+	qmin := -qmax - 1
+	var e float64
+	for i, c := range lp {
+		e += c * float64(int64(1)<<uint(shift))
+		v := int64(math.Floor(e + 0.5))
+		if v > qmax {
+			v = qmax
+		} else if v < qmin {
+			v = qmin
+		}
+		e -= float64(v)
+		q[i] = v
+	}
+	return shift, true
+}
+
+// expectedBits estimates the bits of each of n residual samples of a
+// predictor with error e.
+func expectedBits(e float64, n int) float64 {
+	if e <= 0 {
+		return 0
+	}
+	b := 0.5 * math.Log2(0.5*e/float64(n))
+	if b < 0 {
+		return 0
+	}
+	return b
+}
+
+// precision returns the default precision of LPC coefficients of order
+// order for a block of n samples of bps bits.  Samples of at most 17 bits
+// are limited to predictions a 32 bit decoder computes exactly.
+func precision(n int, bps uint, order int) uint {
+	var p uint
+	switch {
+	case n <= 192:
+		p = 7
+	case n <= 384:
+		p = 8
+	case n <= 576:
+		p = 9
+	case n <= 1152:
+		p = 10
+	case n <= 2304:
+		p = 11
+	case n <= 4608:
+		p = 12
+	default:
+		p = 13
+	}
+	if bps <= 17 {
+		lim := 32 - int(bps) - ilog2(order)
+		if lim < int(p) {
+			p = uint(lim)
+		}
+		if p < minPrecision {
+			p = minPrecision
+		}
+	}
+	return p
+}
+
+func ilog2(v int) int {
+	n := -1
+	for ; v > 0; v >>= 1 {
+		n++
+	}
+	return n
+}
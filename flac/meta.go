@@ -0,0 +1,283 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package flac
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound/freq"
+)
+
+// Metadata block types.
+const (
+	blockStreamInfo    = 0
+	blockSeekTable     = 3
+	blockVorbisComment = 4
+)
+
+const (
+	streamInfoSize = 34
+	seekPointSize  = 18
+
+	// maxSeekTable is the largest SEEKTABLE read, holding more seek points
+	// than a file plausibly needs.  Larger tables are skipped.
+	maxSeekTable = 1 << 20
+	// maxComments is the largest VORBIS_COMMENT block read.  Larger blocks
+	// are skipped.
+	maxComments = 1 << 20
+
+	// placeholder is the sample number of a placeholder seek point.
+	placeholder = 1<<64 - 1
+)
+
+// StreamInfo is the STREAMINFO metadata block, describing the stream.
+type StreamInfo struct {
+	// MinBlockSize and MaxBlockSize bound the number of frames in a FLAC
+	// frame, excluding the last.
+	MinBlockSize, MaxBlockSize int
+	// MinFrameSize and MaxFrameSize bound the size of a FLAC frame in
+	// bytes, or are 0 if unknown.
+	MinFrameSize, MaxFrameSize int
+	SampleRate                 freq.T
+	Channels                   int
+	BitsPerSample              int
+	// Frames is the number of frames, in the sense of package sound, or 0
+	// if unknown.
+	Frames int64
+	// MD5 is the signature of the decoded samples, or zero if unknown.
+	MD5 [16]byte
+}
+
+// parseStreamInfo parses a STREAMINFO block with data d at offset off.
+func parseStreamInfo(d []byte, off int64) (*StreamInfo, error) {
+	if len(d) < streamInfoSize {
+		return nil, errs.Decode(codec.ErrCorrupt, off, "STREAMINFO", "block too small: %d", len(d))
+	}
+	x := binary.BigEndian.Uint64(d[10:18])
+	si := &StreamInfo{
+		MinBlockSize:  int(binary.BigEndian.Uint16(d[0:2])),
+		MaxBlockSize:  int(binary.BigEndian.Uint16(d[2:4])),
+		MinFrameSize:  int(d[4])<<16 | int(d[5])<<8 | int(d[6]),
+		MaxFrameSize:  int(d[7])<<16 | int(d[8])<<8 | int(d[9]),
+		SampleRate:    freq.T(x>>44) * freq.Hertz,
+		Channels:      int(x>>41&0x7) + 1,
+		BitsPerSample: int(x>>36&0x1f) + 1,
+		Frames:        int64(x & (1<<36 - 1))}
+	copy(si.MD5[:], d[18:34])
+	switch {
+	case si.MinBlockSize < 16:
+		return nil, errs.Decode(codec.ErrCorrupt, off, "STREAMINFO", "minimum block size %d", si.MinBlockSize)
+	case si.MaxBlockSize < si.MinBlockSize:
+		return nil, errs.Decode(codec.ErrCorrupt, off+2, "STREAMINFO", "maximum block size %d below minimum %d", si.MaxBlockSize, si.MinBlockSize)
+	case si.SampleRate == 0:
+		return nil, errs.Decode(codec.ErrCorrupt, off+10, "STREAMINFO", "sample rate 0")
+	case si.BitsPerSample < 4:
+		return nil, errs.Decode(codec.ErrUnsupportedFormat, off+12, "STREAMINFO", "%d bits per sample", si.BitsPerSample)
+	}
+	return si, nil
+}
+
+// SeekPoint is a point of the SEEKTABLE metadata block.
+type SeekPoint struct {
+	// Frame is the first frame, in the sense of package sound, of the
+	// target FLAC frame.
+	Frame int64
+	// Offset is the offset of the target FLAC frame from the first.
+	Offset int64
+	// Frames is the number of frames of the target FLAC frame.
+	Frames int
+}
+
+// parseSeekTable parses a SEEKTABLE block with data d at offset off,
+// omitting placeholder points.
+func parseSeekTable(d []byte, off int64) ([]SeekPoint, error) {
+	if len(d)%seekPointSize != 0 {
+		return nil, errs.Decode(codec.ErrCorrupt, off, "SEEKTABLE", "block size %d is not a multiple of %d", len(d), seekPointSize)
+	}
+	pts := make([]SeekPoint, 0, len(d)/seekPointSize)
+	for p := 0; p < len(d); p += seekPointSize {
+		f := binary.BigEndian.Uint64(d[p : p+8])
+		if f == placeholder {
+			continue
+		}
+		pt := SeekPoint{
+			Frame:  int64(f),
+			Offset: int64(binary.BigEndian.Uint64(d[p+8 : p+16])),
+			Frames: int(binary.BigEndian.Uint16(d[p+16 : p+18]))}
+		if pt.Frame < 0 || pt.Offset < 0 {
+			return nil, errs.Decode(codec.ErrCorrupt, off+int64(p), "SEEKTABLE", "seek point %d at offset %d", f, pt.Offset)
+		}
+		if n := len(pts); n > 0 && (pt.Frame <= pts[n-1].Frame || pt.Offset < pts[n-1].Offset) {
+			return nil, errs.Decode(codec.ErrCorrupt, off+int64(p), "SEEKTABLE", "seek points out of order")
+		}
+		pts = append(pts, pt)
+	}
+	return pts, nil
+}
+
+// parseComments parses a VORBIS_COMMENT block with data d at offset off,
+// returning its vendor string and comments.
+func parseComments(d []byte, off int64) (string, []string, error) {
+	p := 0
+	next := func() (string, bool) {
+		if len(d)-p < 4 {
+			return "", false
+		}
+		n := int(binary.LittleEndian.Uint32(d[p:]))
+		p += 4
+		if n < 0 || n > len(d)-p {
+			return "", false
+		}
+		p += n
+		return string(d[p-n : p]), true
+	}
+	vendor, ok := next()
+	if !ok || len(d)-p < 4 {
+		return "", nil, errs.Decode(codec.ErrCorrupt, off, "VORBIS_COMMENT", "invalid vendor string")
+	}
+	n := binary.LittleEndian.Uint32(d[p:])
+	p += 4
+	if int64(n)*4 > int64(len(d)-p) {
+		return "", nil, errs.Decode(codec.ErrCorrupt, off, "VORBIS_COMMENT", "%d comments in %d bytes", n, len(d)-p)
+	}
+	comments := make([]string, n)
+	for i := range comments {
+		if comments[i], ok = next(); !ok {
+			return "", nil, errs.Decode(codec.ErrCorrupt, off+int64(p), "VORBIS_COMMENT", "invalid comment %d", i)
+		}
+	}
+	return vendor, comments, nil
+}
+
+// metadata holds the metadata blocks of a stream.
+type metadata struct {
+	info      *StreamInfo
+	seekTable []SeekPoint
+	vendor    string
+	comments  []string
+	first     int64 // offset of the first frame
+}
+
+// readMetadata reads the metadata blocks from r, which is positioned at the
+// start of the stream, possibly preceded by an ID3v2 tag.  It leaves r at
+// the first frame.
+func readMetadata(r io.Reader) (*metadata, error) {
+	var hdr [10]byte
+	if _, err := io.ReadFull(r, hdr[:4]); err != nil {
+		return nil, errs.Read(err, 0, "")
+	}
+	off := int64(4)
+	if string(hdr[:3]) == "ID3" {
+		if _, err := io.ReadFull(r, hdr[4:10]); err != nil {
+			return nil, errs.Read(err, 4, "ID3")
+		}
+		// the tag size is 4 bytes of 7 bits.
+		n := int64(hdr[6]&0x7f)<<21 | int64(hdr[7]&0x7f)<<14 | int64(hdr[8]&0x7f)<<7 | int64(hdr[9]&0x7f)
+		if hdr[5]&0x10 != 0 {
+			// footer
+			n += 10
+		}
+		if err := skip(r, n); err != nil {
+			return nil, errs.Read(err, 10, "ID3")
+		}
+		off = 10 + n
+		if _, err := io.ReadFull(r, hdr[:4]); err != nil {
+			return nil, errs.Read(err, off, "")
+		}
+		off += 4
+	}
+	if string(hdr[:4]) != "fLaC" {
+		return nil, errs.Decode(codec.ErrUnsupportedFormat, off-4, "", "not a FLAC stream")
+	}
+	m := &metadata{}
+	for last := false; !last; {
+		if _, err := io.ReadFull(r, hdr[:4]); err != nil {
+			return nil, errs.Read(err, off, "")
+		}
+		last = hdr[0]&0x80 != 0
+		typ := hdr[0] & 0x7f
+		n := int64(hdr[1])<<16 | int64(hdr[2])<<8 | int64(hdr[3])
+		off += 4
+		if m.info == nil && typ != blockStreamInfo {
+			return nil, errs.Decode(codec.ErrCorrupt, off-4, "", "first metadata block has type %d not STREAMINFO", typ)
+		}
+		switch {
+		case typ == blockStreamInfo:
+			if m.info != nil {
+				return nil, errs.Decode(codec.ErrCorrupt, off-4, "STREAMINFO", "second STREAMINFO block")
+			}
+			d, err := readBlock(r, off, n, streamInfoSize, "STREAMINFO")
+			if err != nil {
+				return nil, err
+			}
+			if m.info, err = parseStreamInfo(d, off); err != nil {
+				return nil, err
+			}
+		case typ == blockSeekTable && n <= maxSeekTable && m.seekTable == nil:
+			d, err := readBlock(r, off, n, n, "SEEKTABLE")
+			if err != nil {
+				return nil, err
+			}
+			if m.seekTable, err = parseSeekTable(d, off); err != nil {
+				return nil, err
+			}
+		case typ == blockVorbisComment && n <= maxComments && m.comments == nil:
+			d, err := readBlock(r, off, n, n, "VORBIS_COMMENT")
+			if err != nil {
+				return nil, err
+			}
+			if m.vendor, m.comments, err = parseComments(d, off); err != nil {
+				return nil, err
+			}
+		case typ == 127:
+			return nil, errs.Decode(codec.ErrCorrupt, off-4, "", "invalid metadata block type 127")
+		default:
+			if err := skip(r, n); err != nil {
+				return nil, errs.Read(err, off, "")
+			}
+		}
+		off += n
+	}
+	m.first = off
+	return m, nil
+}
+
+// readBlock reads at most max bytes of a metadata block of size n at
+// offset off, skipping the rest.
+func readBlock(r io.Reader, off, n, max int64, id string) ([]byte, error) {
+	k := n
+	if k > max {
+		k = max
+	}
+	d := make([]byte, k)
+	if _, err := io.ReadFull(r, d); err != nil {
+		return nil, errs.Read(err, off, id)
+	}
+	if err := skip(r, n-k); err != nil {
+		return nil, errs.Read(err, off+k, id)
+	}
+	return d, nil
+}
+
+// skip skips n bytes of r.
+func skip(r io.Reader, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekCurrent)
+		return err
+	}
+	m, err := io.CopyN(ioutil.Discard, r, n)
+	if m == n {
+		return nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+//go:build go1.18
+// +build go1.18
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// fakeFile returns an encoding by FakeCodec of n frames of v with sc.
+func fakeFile(v sound.Form, sc sample.Codec, n int) []byte {
+	w := &bufCloser{}
+	snk, err := FakeCodec.Encoder(w, v, sc)
+	if err != nil {
+		panic(err)
+	}
+	snk.Send(make([]float64, n*v.Channels()))
+	return w.Bytes()
+}
+
+func FuzzDecoder(f *testing.F) {
+	for _, d := range [][]byte{
+		fakeFile(form{rate: 8000 * freq.Hertz, nC: 1}, sample.SInt16L, 10),
+		fakeFile(form{rate: 48000 * freq.Hertz, nC: 3}, sample.SFloat32L, 7),
+		[]byte("FAKE\x40\x1f\x00\x00\xff\xff\x00\x00"),
+		[]byte("FAKE\x40\x1f"),
+		[]byte("hint"),
+		nil} {
+		f.Add(d)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		SniffAll(bufio.NewReader(bytes.NewReader(data)), nil)
+		src, _, err := Decoder(ioutil.NopCloser(bytes.NewReader(data)), nil)
+		if err != nil {
+			return
+		}
+		defer src.Close()
+		buf := make([]float64, 64*src.Channels())
+		for {
+			n, err := src.Receive(buf)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n == 0 {
+				t.Fatal("Receive returned 0 frames without error")
+			}
+		}
+	})
+}
+
+func FuzzCodecFor(f *testing.F) {
+	for _, s := range []string{"take.fake", ".FAKE", "fake", "dir/a.tar.gz", "", ".", "/"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, ext string) {
+		CodecFor(ext, nil)
+	})
+}
+
+func FuzzCodecForMIME(f *testing.F) {
+	for _, s := range []string{"audio/x-fake", "audio/L16;rate=8000;channels=1", "audio/x-fake; q=\"", ";;", ""} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, mt string) {
+		c, err := CodecForMIME(mt, nil)
+		if err == nil && c == nil {
+			t.Errorf("%q: nil codec without error", mt)
+		}
+	})
+}
@@ -1,3 +1,5 @@
 module zikichombo.org/codec
 
+go 1.20
+
 require zikichombo.org/sound v0.1.3-alpha.2
@@ -0,0 +1,106 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codec
+
+import (
+	"path"
+	"strings"
+
+	"zikichombo.org/sound/sample"
+)
+
+// Capabilities is a set of flags indicating which functions of a
+// Codec are supported.
+type Capabilities uint
+
+const (
+	// CanDecode indicates Codec.Decoder is supported.
+	CanDecode Capabilities = 1 << iota
+	// CanSeek indicates Codec.SeekingDecoder is supported.
+	CanSeek
+	// CanEncode indicates Codec.Encoder is supported.
+	CanEncode
+	// CanRandomAccess indicates Codec.RandomAccess is supported.
+	CanRandomAccess
+)
+
+var capNames = [...]string{"decode", "seek", "encode", "random-access"}
+
+// Has returns whether all the capabilities in o are in c.
+func (c Capabilities) Has(o Capabilities) bool {
+	return c&o == o
+}
+
+func (c Capabilities) String() string {
+	var names []string
+	for i, name := range capNames {
+		if c&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Description describes a Codec beyond what is available from the
+// Codec interface.
+type Description struct {
+	// Name is a short name for the codec, such as "wav".
+	Name string
+
+	// MIMETypes lists the MIME types of data the codec handles,
+	// the preferred type first.
+	MIMETypes []string
+
+	// Capabilities indicates which Codec functions are supported.
+	Capabilities Capabilities
+
+	// SampleCodecs lists the sample codecs supported for encoding.
+	SampleCodecs []sample.Codec
+}
+
+// Describer is an optional interface which a Codec may implement
+// to describe itself.
+//
+// The returned Description should be read-only.
+type Describer interface {
+	Describe() Description
+}
+
+// Info describes a registered Codec.
+type Info struct {
+	Description
+
+	// Codec is the registered codec.
+	Codec Codec
+
+	// PkgPath is the package path of the codec, as used by pkgSel
+	// arguments in this package.
+	PkgPath string
+
+	// Extensions is the result of Codec.Extensions().
+	Extensions []string
+}
+
+// Codecs lists the registered codecs in order of registration.
+//
+// Codecs which do not implement Describer are listed with a Name derived from
+// their package path and with no MIME types, capabilities, or sample codecs,
+// since these cannot be determined without calling the Codec functions.
+func Codecs() []Info {
+	return defaultRegistry.Codecs()
+}
+
+func (c *codec) info() Info {
+	inf := Info{
+		Codec:      c.Codec,
+		PkgPath:    c.pkgPath,
+		Extensions: c.Extensions()}
+	if d, ok := c.Codec.(Describer); ok {
+		inf.Description = d.Describe()
+	}
+	if inf.Name == "" {
+		inf.Name = path.Base(c.pkgPath)
+	}
+	return inf
+}
@@ -0,0 +1,37 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package decerr makes the *codec.DecodeErrors of the codec packages.
+package decerr /* import "zikichombo.org/codec/internal/decerr" */
+
+import (
+	"fmt"
+	"io"
+
+	"zikichombo.org/codec"
+)
+
+// Codec is the name of a codec, for the errors it makes.
+type Codec string
+
+// Decode returns a *codec.DecodeError classified by err at offset off
+// (-1 if unknown) in the chunk, block or other structure named id, which
+// may be empty.
+func (c Codec) Decode(err error, off int64, id string, format string, args ...interface{}) error {
+	return &codec.DecodeError{
+		Codec:  string(c),
+		Offset: off,
+		Chunk:  id,
+		Err:    err,
+		Detail: fmt.Sprintf(format, args...)}
+}
+
+// Read classifies an error err from reading a structure at offset off in
+// the structure named id.  End of file is reported as codec.ErrTruncated,
+// other errors are returned as is.
+func (c Codec) Read(err error, off int64, id string) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return c.Decode(codec.ErrTruncated, off, id, "")
+	}
+	return err
+}
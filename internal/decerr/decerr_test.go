@@ -0,0 +1,38 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package decerr
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"zikichombo.org/codec"
+)
+
+func TestDecode(t *testing.T) {
+	err := Codec("wav").Decode(codec.ErrCorrupt, 12, "fmt ", "%d channels", 0)
+	de, ok := err.(*codec.DecodeError)
+	if !ok {
+		t.Fatalf("got %T", err)
+	}
+	if de.Codec != "wav" || de.Offset != 12 || de.Chunk != "fmt " || de.Detail != "0 channels" {
+		t.Errorf("got %+v", de)
+	}
+	if !errors.Is(err, codec.ErrCorrupt) {
+		t.Errorf("%v is not ErrCorrupt", err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	for _, e := range []error{io.EOF, io.ErrUnexpectedEOF} {
+		if err := Codec("au").Read(e, 4, ""); !errors.Is(err, codec.ErrTruncated) {
+			t.Errorf("%v: got %v", e, err)
+		}
+	}
+	other := errors.New("other")
+	if err := Codec("au").Read(other, 4, ""); err != other {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package g711 implements the mu-law and A-law companding of ITU-T G.711,
+// which several containers support alongside linear PCM.
+package g711 /* import "zikichombo.org/codec/internal/g711" */
+
+// Law is a G.711 companding law, encoding each sample in one byte.
+type Law int
+
+const (
+	// ULaw is mu-law, used in North America and Japan.
+	ULaw Law = iota
+	// ALaw is A-law, used elsewhere.
+	ALaw
+)
+
+func (l Law) String() string {
+	if l == ALaw {
+		return "A-law"
+	}
+	return "mu-law"
+}
+
+// Decode decodes len(dst) samples from src into dst, with values in [-1, 1).
+func (l Law) Decode(dst []float64, src []byte) {
+	dec := DecodeULaw
+	if l == ALaw {
+		dec = DecodeALaw
+	}
+	for i := range dst {
+		dst[i] = float64(dec(src[i])) / 32768
+	}
+}
+
+// Encode encodes the samples in src, with values in [-1, 1], into dst.
+func (l Law) Encode(dst []byte, src []float64) {
+	enc := EncodeULaw
+	if l == ALaw {
+		enc = EncodeALaw
+	}
+	for i, v := range src {
+		v *= 32768
+		switch {
+		case v > 32767:
+			v = 32767
+		case v < -32768:
+			v = -32768
+		}
+		dst[i] = enc(int16(v))
+	}
+}
+
+const (
+	signBit   = 0x80
+	quantMask = 0x0f
+	segShift  = 4
+	segMask   = 0x70
+	uBias     = 0x84
+	uClip     = 8159
+)
+
+var (
+	segAEnd = [8]int{0x1f, 0x3f, 0x7f, 0xff, 0x1ff, 0x3ff, 0x7ff, 0xfff}
+	segUEnd = [8]int{0x3f, 0x7f, 0xff, 0x1ff, 0x3ff, 0x7ff, 0xfff, 0x1fff}
+)
+
+func segment(v int, ends *[8]int) int {
+	for i, e := range ends {
+		if v <= e {
+			return i
+		}
+	}
+	return len(ends)
+}
+
+// EncodeULaw returns the mu-law encoding of the 16 bit linear sample s.
+func EncodeULaw(s int16) byte {
+	v := int(s) >> 2
+	mask := 0xff
+	if v < 0 {
+		v = -v
+		mask = 0x7f
+	}
+	if v > uClip {
+		v = uClip
+	}
+	v += uBias >> 2
+	seg := segment(v, &segUEnd)
+	if seg >= 8 {
+		return byte(0x7f ^ mask)
+	}
+	u := seg<<segShift | (v>>uint(seg+1))&quantMask
+	return byte(u ^ mask)
+}
+
+// DecodeULaw returns the 16 bit linear sample encoded by the mu-law byte u.
+func DecodeULaw(u byte) int16 {
+	u = ^u
+	t := (int(u&quantMask) << 3) + uBias
+	t <<= uint(u&segMask) >> segShift
+	if u&signBit != 0 {
+		return int16(uBias - t)
+	}
+	return int16(t - uBias)
+}
+
+// EncodeALaw returns the A-law encoding of the 16 bit linear sample s.
+func EncodeALaw(s int16) byte {
+	v := int(s) >> 3
+	mask := 0xd5
+	if v < 0 {
+		mask = 0x55
+		v = -v - 1
+	}
+	seg := segment(v, &segAEnd)
+	if seg >= 8 {
+		return byte(0x7f ^ mask)
+	}
+	a := seg << segShift
+	if seg < 2 {
+		a |= (v >> 1) & quantMask
+	} else {
+		a |= (v >> uint(seg)) & quantMask
+	}
+	return byte(a ^ mask)
+}
+
+// DecodeALaw returns the 16 bit linear sample encoded by the A-law byte a.
+func DecodeALaw(a byte) int16 {
+	a ^= 0x55
+	t := int(a&quantMask) << 4
+	switch seg := uint(a&segMask) >> segShift; seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if a&signBit != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
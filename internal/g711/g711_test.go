@@ -0,0 +1,57 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package g711
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		u := byte(i)
+		// 0x7f is negative zero, which encodes as positive zero.
+		if got := EncodeULaw(DecodeULaw(u)); got != u && u != 0x7f {
+			t.Errorf("mu-law %#x: decoded %d encoded %#x", u, DecodeULaw(u), got)
+		}
+		if got := EncodeALaw(DecodeALaw(u)); got != u {
+			t.Errorf("A-law %#x: decoded %d encoded %#x", u, DecodeALaw(u), got)
+		}
+	}
+}
+
+func TestKnownValues(t *testing.T) {
+	for _, tc := range []struct {
+		s    int16
+		u, a byte
+	}{
+		{0, 0xff, 0xd5},
+		{32767, 0x80, 0xaa},
+		{-32768, 0x00, 0x2a},
+		{-1, 0x7e, 0x55},
+	} {
+		if u := EncodeULaw(tc.s); u != tc.u {
+			t.Errorf("mu-law of %d: %#x not %#x", tc.s, u, tc.u)
+		}
+		if a := EncodeALaw(tc.s); a != tc.a {
+			t.Errorf("A-law of %d: %#x not %#x", tc.s, a, tc.a)
+		}
+	}
+}
+
+func TestLaw(t *testing.T) {
+	src := []float64{0, 0.5, -0.5, 0.99, -1}
+	for _, l := range []Law{ULaw, ALaw} {
+		enc := make([]byte, len(src))
+		l.Encode(enc, src)
+		dec := make([]float64, len(src))
+		l.Decode(dec, enc)
+		for i, v := range src {
+			// the quantization step is 1/32 of the magnitude at most.
+			if math.Abs(dec[i]-v) > math.Abs(v)/32+1e-3 {
+				t.Errorf("%s: %f decoded as %f", l, v, dec[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codec
+
+import (
+	"mime"
+	"strings"
+)
+
+// MIMEParameterizer is an optional interface which a Codec may implement
+// when parameters of a MIME type, such as the rate and channels of
+// "audio/L16;rate=8000;channels=1", configure the codec.
+type MIMEParameterizer interface {
+	// WithMIMEParams returns a codec configured by the MIME type
+	// parameters params, whose keys are lower case.
+	WithMIMEParams(params map[string]string) (Codec, error)
+}
+
+// CodecForMIME tries to find a codec based on a MIME type, such as the value
+// of a Content-Type header.  The media type is compared case insensitively
+// with the MIME types declared by codecs implementing Describer.
+//
+// If the codec found implements MIMEParameterizer, then the codec returned is
+// the result of its WithMIMEParams, otherwise any parameters are ignored.
+//
+// The function pkgSel selects codecs as in CodecFor.
+func CodecForMIME(mimeType string, pkgSel func(string) bool) (Codec, error) {
+	return defaultRegistry.CodecForMIME(mimeType, pkgSel)
+}
+
+// CodecForMIME is like the package level CodecForMIME but uses the codecs in r.
+func (r *Registry) CodecForMIME(mimeType string, pkgSel func(string) bool) (Codec, error) {
+	mt, params, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return nil, err
+	}
+	cs := r.registered()
+	for i := range cs {
+		c := &cs[i]
+		if pkgSel != nil && !pkgSel(c.pkgPath) {
+			continue
+		}
+		d, ok := c.Codec.(Describer)
+		if !ok {
+			continue
+		}
+		for _, cmt := range d.Describe().MIMETypes {
+			if !strings.EqualFold(mt, cmt) {
+				continue
+			}
+			if mp, ok := c.Codec.(MIMEParameterizer); ok {
+				return mp.WithMIMEParams(params)
+			}
+			return c.Codec, nil
+		}
+	}
+	return nil, ErrUnknownCodec
+}
+
+// MIMEType returns the preferred MIME type of c, for example to
+// set the Content-Type of encoded data.  If c does not implement Describer
+// or declares no MIME types, MIMEType returns "application/octet-stream".
+func MIMEType(c Codec) string {
+	if d, ok := c.(Describer); ok {
+		if mts := d.Describe().MIMETypes; len(mts) > 0 {
+			return mts[0]
+		}
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package ogg
+
+import (
+	"bufio"
+	"io"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Codec is the ogg codec.Codec, registered with package codec when
+// package ogg is imported.  It decodes the first logical stream of a
+// codec whose Mapping is registered.
+var Codec codec.Codec = oggCodec{}
+
+func init() {
+	codec.RegisterCodec(Codec)
+}
+
+type oggCodec struct {
+	codec.NullCodec
+}
+
+var (
+	_ codec.ConfidenceSniffer = oggCodec{}
+	_ codec.Describer         = oggCodec{}
+)
+
+// Describe implements codec.Describer.  Encoding is provided by the
+// packages of the codecs, using a Writer.
+func (c oggCodec) Describe() codec.Description {
+	return codec.Description{
+		Name:         "ogg",
+		MIMETypes:    []string{"audio/ogg", "application/ogg"},
+		Capabilities: codec.CanDecode | codec.CanSeek}
+}
+
+func (c oggCodec) Extensions() []string {
+	return []string{".ogg", ".oga"}
+}
+
+func (c oggCodec) Sniff(br *bufio.Reader) bool {
+	return c.SniffConfidence(br) > codec.SniffNone
+}
+
+// SniffConfidence implements codec.ConfidenceSniffer.  A stream is
+// recognized if the first packet of its first page is identified by a
+// registered Mapping.
+func (c oggCodec) SniffConfidence(br *bufio.Reader) int {
+	first := FirstPacket(br)
+	if first == nil || mappingFor(first) == nil {
+		return codec.SniffNone
+	}
+	return codec.SniffStrong
+}
+
+// FirstPacket peeks at the first page of the physical stream br, and
+// returns the first packet of its first logical stream, or nil if br does
+// not start with such a page.  The CRC of the page is not checked.
+func FirstPacket(br *bufio.Reader) []byte {
+	hdr, err := br.Peek(headerSize)
+	if err != nil || string(hdr[:4]) != capture || hdr[5]&BOS == 0 {
+		return nil
+	}
+	nSeg := int(hdr[26])
+	hdr, err = br.Peek(headerSize + nSeg)
+	if err != nil {
+		return nil
+	}
+	n := 0
+	for _, v := range hdr[headerSize:] {
+		n += int(v)
+		if v < 255 {
+			break
+		}
+	}
+	buf, err := br.Peek(headerSize + nSeg + n)
+	if err != nil {
+		return nil
+	}
+	return buf[headerSize+nSeg:]
+}
+
+func (c oggCodec) Decoder(r io.ReadCloser) (sound.Source, sample.Codec, error) {
+	return decoder(readCloser{r})
+}
+
+func (c oggCodec) SeekingDecoder(r codec.IoReadSeekCloser) (sound.SourceSeeker, sample.Codec, error) {
+	src, sc, err := decoder(r)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	ss, ok := src.(sound.SourceSeeker)
+	if !ok {
+		src.Close()
+		return nil, codec.AnySampleCodec, codec.ErrUnsupportedFunction
+	}
+	return ss, sc, nil
+}
+
+// readCloser hides the methods of an io.ReadCloser other than Read and
+// Close, so that a Stream does not seek it.
+type readCloser struct {
+	io.ReadCloser
+}
+
+// decoder opens the first logical stream of r of a registered mapping
+// and returns its decoder.
+func decoder(r io.ReadCloser) (sound.Source, sample.Codec, error) {
+	var m Mapping
+	s, err := OpenStream(r, func(first []byte) bool {
+		m = mappingFor(first)
+		return m != nil
+	})
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	return m.Decoder(s)
+}
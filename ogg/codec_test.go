@@ -0,0 +1,201 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package ogg
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// pcmMapping is a Mapping of 8 bit mono PCM, whose packets hold the
+// samples after an identification header "\x7fPCM" and whose granule
+// positions are numbers of samples.
+type pcmMapping struct{}
+
+func init() {
+	RegisterMapping(pcmMapping{})
+}
+
+func (m pcmMapping) Name() string {
+	return "pcm"
+}
+
+func (m pcmMapping) Identify(first []byte) bool {
+	return string(first) == "\x7fPCM"
+}
+
+func (m pcmMapping) Decoder(s *Stream) (sound.Source, sample.Codec, error) {
+	if _, err := s.ReadPacket(); err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	d := &pcmDecoder{s: s, n: -1}
+	if s.CanSeek() {
+		n, err := s.LastGranule()
+		if err != nil {
+			return nil, codec.AnySampleCodec, err
+		}
+		d.n = n
+	}
+	return d, sample.SByte, nil
+}
+
+type pcmDecoder struct {
+	s    *Stream
+	buf  []byte
+	pos  int64
+	n    int64
+	skip int64
+}
+
+func (d *pcmDecoder) SampleRate() freq.T { return 8000 * freq.Hertz }
+func (d *pcmDecoder) Channels() int      { return 1 }
+func (d *pcmDecoder) Len() int64         { return d.n }
+func (d *pcmDecoder) Pos() int64         { return d.pos }
+func (d *pcmDecoder) Close() error       { return d.s.Close() }
+
+func (d *pcmDecoder) Receive(dst []float64) (int, error) {
+	for len(d.buf) == 0 || d.skip > 0 {
+		if int64(len(d.buf)) > d.skip {
+			d.buf, d.skip = d.buf[d.skip:], 0
+			break
+		}
+		d.skip -= int64(len(d.buf))
+		p, err := d.s.ReadPacket()
+		if err != nil {
+			return 0, err
+		}
+		d.buf = p.Data
+	}
+	n := copy(make([]byte, len(dst)), d.buf)
+	for i, v := range d.buf[:n] {
+		dst[i] = float64(int8(v)) / 128
+	}
+	d.buf = d.buf[n:]
+	d.pos += int64(n)
+	return n, nil
+}
+
+func (d *pcmDecoder) Seek(f int64) error {
+	g, err := d.s.SeekGranule(f)
+	if err != nil {
+		return err
+	}
+	d.buf, d.pos, d.skip = nil, f, f-g
+	return nil
+}
+
+// pcmStream returns an Ogg stream of n samples, sample i being i%251.
+func pcmStream(t *testing.T, n int) []byte {
+	var b bytes.Buffer
+	w := NewWriter(&b, 42)
+	if err := w.WritePacket([]byte("\x7fPCM"), 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; {
+		sz := 1 + i%300
+		if i+sz > n {
+			sz = n - i
+		}
+		pkt := make([]byte, sz)
+		for j := range pkt {
+			pkt[j] = byte((i + j) % 251)
+		}
+		i += sz
+		if err := w.WritePacket(pkt, int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestRegistered(t *testing.T) {
+	for _, name := range []string{"x.ogg", "x.oga"} {
+		c, err := codec.CodecFor(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != Codec {
+			t.Errorf("%s: got codec %v", name, c)
+		}
+	}
+}
+
+func TestCodec(t *testing.T) {
+	const n = 100000
+	data := pcmStream(t, n)
+	cs := Codec.(codec.ConfidenceSniffer)
+	if got := cs.SniffConfidence(bufio.NewReader(bytes.NewReader(data))); got != codec.SniffStrong {
+		t.Errorf("sniff confidence %d", got)
+	}
+	if Codec.Sniff(bufio.NewReader(bytes.NewReader(mux(t, 10)))) {
+		t.Errorf("sniffed a stream of no registered mapping")
+	}
+	if Codec.Sniff(bufio.NewReader(bytes.NewReader([]byte("fLaC")))) {
+		t.Errorf("sniffed flac as ogg")
+	}
+	src, sc, err := Codec.Decoder(ioutil.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc != sample.SByte {
+		t.Errorf("sample codec %s", sc)
+	}
+	if src.(*pcmDecoder).s.CanSeek() {
+		t.Errorf("decoder can seek")
+	}
+	buf := make([]float64, 1000)
+	for i := 0; i < n; {
+		k, err := src.Receive(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, v := range buf[:k] {
+			if v != float64(int8((i+j)%251))/128 {
+				t.Fatalf("sample %d: %g", i+j, v)
+			}
+		}
+		i += k
+	}
+	if _, err := src.Receive(buf); err != io.EOF {
+		t.Errorf("at end: %v", err)
+	}
+	if _, _, err := Codec.Decoder(ioutil.NopCloser(bytes.NewReader(mux(t, 10)))); err == nil {
+		t.Errorf("decoded a stream of no registered mapping")
+	}
+}
+
+func TestCodecSeek(t *testing.T) {
+	const n = 200000
+	ss, _, err := Codec.SeekingDecoder(codectest.NewFile(pcmStream(t, n)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss.Len() != n {
+		t.Errorf("len %d", ss.Len())
+	}
+	buf := make([]float64, 10)
+	for _, f := range []int64{123456, 0, 7, n - 1, 65536, 4097} {
+		if err := ss.Seek(f); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ss.Receive(buf[:1]); err != nil {
+			t.Fatal(err)
+		}
+		if buf[0] != float64(int8(f%251))/128 || ss.Pos() != f+1 {
+			t.Errorf("seek to %d: got %g at %d", f, buf[0], ss.Pos())
+		}
+	}
+}
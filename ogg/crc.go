@@ -0,0 +1,29 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package ogg
+
+// crcTable is the table of the CRC of pages, with polynomial 0x04c11db7
+// computed most significant bit first from zero.
+var crcTable [256]uint32
+
+func init() {
+	for i := range crcTable {
+		c := uint32(i) << 24
+		for k := 0; k < 8; k++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		crcTable[i] = c
+	}
+}
+
+func crc32(c uint32, buf []byte) uint32 {
+	for _, b := range buf {
+		c = c<<8 ^ crcTable[byte(c>>24)^b]
+	}
+	return c
+}
@@ -0,0 +1,19 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package ogg provides the Ogg container, for codecs encapsulated in it
+// such as Vorbis, Opus, FLAC and Speex.
+//
+// A Reader demultiplexes the logical streams of a physical Ogg stream,
+// validating the CRC of each page and reassembling packets across pages,
+// and seeks a logical stream by bisection on the granule positions of its
+// pages.  A Stream reads the packets of a single logical stream, and a
+// Writer writes the packets of a logical stream as pages.
+//
+// Packages implementing a codec in Ogg register a Mapping, through which
+// the ogg Codec, registered with zikichombo.org/codec for the extensions
+// .ogg and .oga, sniffs and decodes logical streams by the identification
+// header of the codec, their first packet.
+//
+// Package ogg is part of http://zikichombo.org
+package ogg /* import "zikichombo.org/codec/ogg" */
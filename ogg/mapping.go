@@ -0,0 +1,57 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package ogg
+
+import (
+	"sync"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Mapping is the encapsulation of a codec in Ogg, through which the ogg
+// Codec decodes logical streams of the codec.
+type Mapping interface {
+	// Name returns the name of the codec, such as "opus".
+	Name() string
+	// Identify returns whether first, the first packet of a logical
+	// stream, is an identification header of the codec.
+	Identify(first []byte) bool
+	// Decoder returns a decoder of s, whose first packet is accepted by
+	// Identify.  The decoder closes s when it is closed, and if s can
+	// seek, it should be a sound.SourceSeeker.
+	Decoder(s *Stream) (sound.Source, sample.Codec, error)
+}
+
+var mappings struct {
+	sync.Mutex
+	ms []Mapping
+}
+
+// RegisterMapping registers m with the ogg Codec.  Packages implementing
+// a codec in Ogg call it from an init function.  Mappings registered
+// earlier take precedence when several identify a stream.
+func RegisterMapping(m Mapping) {
+	mappings.Lock()
+	defer mappings.Unlock()
+	mappings.ms = append(mappings.ms, m)
+}
+
+// Mappings returns the registered mappings.
+func Mappings() []Mapping {
+	mappings.Lock()
+	defer mappings.Unlock()
+	return append([]Mapping(nil), mappings.ms...)
+}
+
+// mappingFor returns the registered mapping identifying first, or nil if
+// there is none.
+func mappingFor(first []byte) Mapping {
+	for _, m := range Mappings() {
+		if m.Identify(first) {
+			return m
+		}
+	}
+	return nil
+}
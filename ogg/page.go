@@ -0,0 +1,142 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package ogg
+
+import (
+	"encoding/binary"
+	"io"
+
+	"zikichombo.org/codec"
+)
+
+// Flags of the header type of a page.
+const (
+	// Continued marks a page whose first packet continues the last packet
+	// of the previous page of its logical stream.
+	Continued byte = 1 << iota
+	// BOS marks the first page of a logical stream.
+	BOS
+	// EOS marks the last page of a logical stream.
+	EOS
+)
+
+const (
+	capture    = "OggS"
+	headerSize = 27
+	// MaxPageSize is the size of the largest page.
+	MaxPageSize = headerSize + 255 + 255*255
+)
+
+// Page is an Ogg page.
+type Page struct {
+	Flags byte
+	// Granule is the granule position after the last packet completed on
+	// the page, or -1 if no packet is completed.
+	Granule int64
+	Serial  uint32
+	Seq     uint32
+	// Lacing holds the lacing values of the segments of Data.
+	Lacing []byte
+	Data   []byte
+}
+
+// Packets returns the packets and packet fragments of p, in order.  If
+// the last lacing value is 255, the last packet is continued on the next
+// page and partial is true.
+func (p *Page) Packets() (packets [][]byte, partial bool) {
+	start, end := 0, 0
+	for i, v := range p.Lacing {
+		end += int(v)
+		if v < 255 {
+			packets = append(packets, p.Data[start:end])
+			start = end
+		} else if i == len(p.Lacing)-1 {
+			packets = append(packets, p.Data[start:end])
+			partial = true
+		}
+	}
+	return packets, partial
+}
+
+// Append appends the encoding of p, with its CRC, to buf.
+func (p *Page) Append(buf []byte) []byte {
+	start := len(buf)
+	var hdr [headerSize]byte
+	copy(hdr[:], capture)
+	hdr[5] = p.Flags
+	binary.LittleEndian.PutUint64(hdr[6:], uint64(p.Granule))
+	binary.LittleEndian.PutUint32(hdr[14:], p.Serial)
+	binary.LittleEndian.PutUint32(hdr[18:], p.Seq)
+	hdr[26] = byte(len(p.Lacing))
+	buf = append(buf, hdr[:]...)
+	buf = append(buf, p.Lacing...)
+	buf = append(buf, p.Data...)
+	binary.LittleEndian.PutUint32(buf[start+22:], crc32(0, buf[start:]))
+	return buf
+}
+
+// ReadPage reads a page from r, checking its CRC.  ReadPage returns
+// io.EOF if r is at its end, and a *codec.DecodeError if the page is
+// malformed or truncated.
+func ReadPage(r io.Reader) (*Page, error) {
+	p := &Page{}
+	if err := readPage(r, p, 0); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// readPage reads a page at offset off from r into p.
+func readPage(r io.Reader, p *Page, off int64) error {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return err
+		}
+		return errs.Read(err, off, "page")
+	}
+	if string(hdr[:4]) != capture {
+		return errs.Decode(codec.ErrCorrupt, off, "page", "no capture pattern")
+	}
+	if hdr[4] != 0 {
+		return errs.Decode(codec.ErrUnsupportedFormat, off, "page", "version %d", hdr[4])
+	}
+	p.Flags = hdr[5]
+	p.Granule = int64(binary.LittleEndian.Uint64(hdr[6:]))
+	p.Serial = binary.LittleEndian.Uint32(hdr[14:])
+	p.Seq = binary.LittleEndian.Uint32(hdr[18:])
+	sum := binary.LittleEndian.Uint32(hdr[22:])
+	p.Lacing = grow(p.Lacing, int(hdr[26]))
+	if _, err := io.ReadFull(r, p.Lacing); err != nil {
+		return errs.Read(err, off, "page")
+	}
+	n := 0
+	for _, v := range p.Lacing {
+		n += int(v)
+	}
+	p.Data = grow(p.Data, n)
+	if _, err := io.ReadFull(r, p.Data); err != nil {
+		return errs.Read(err, off, "page")
+	}
+	hdr[22], hdr[23], hdr[24], hdr[25] = 0, 0, 0, 0
+	c := crc32(crc32(crc32(0, hdr[:]), p.Lacing), p.Data)
+	if c != sum {
+		return errs.Decode(codec.ErrCorrupt, off, "page", "CRC %08x, expected %08x", c, sum)
+	}
+	return nil
+}
+
+// size returns the encoded size of p.
+func (p *Page) size() int {
+	return headerSize + len(p.Lacing) + len(p.Data)
+}
+
+// grow returns a slice of length n, reusing the storage of buf if it is
+// large enough.
+func grow(buf []byte, n int) []byte {
+	if cap(buf) < n {
+		return make([]byte, n)
+	}
+	return buf[:n]
+}
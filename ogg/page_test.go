@@ -0,0 +1,72 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package ogg
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"zikichombo.org/codec"
+)
+
+func TestPage(t *testing.T) {
+	p := &Page{
+		Flags:   BOS | Continued,
+		Granule: 1 << 40,
+		Serial:  0xdeadbeef,
+		Seq:     7,
+		Lacing:  []byte{3, 255, 255, 0, 255},
+		Data:    make([]byte, 3+3*255)}
+	for i := range p.Data {
+		p.Data[i] = byte(i)
+	}
+	buf := p.Append([]byte("xyz"))[3:]
+	if len(buf) != p.size() {
+		t.Errorf("size %d of %d", len(buf), p.size())
+	}
+	got, err := ReadPage(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Flags != p.Flags || got.Granule != p.Granule || got.Serial != p.Serial || got.Seq != p.Seq ||
+		!bytes.Equal(got.Lacing, p.Lacing) || !bytes.Equal(got.Data, p.Data) {
+		t.Errorf("got %+v", got)
+	}
+	pkts, partial := got.Packets()
+	if len(pkts) != 3 || !partial || len(pkts[0]) != 3 || len(pkts[1]) != 510 || len(pkts[2]) != 255 {
+		t.Errorf("%d packets, partial %t", len(pkts), partial)
+	}
+	if _, err := ReadPage(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("empty: %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		off  int
+		err  error
+	}{
+		{"capture", 1, codec.ErrCorrupt},
+		{"version", 4, codec.ErrUnsupportedFormat},
+		{"crc", 40, codec.ErrCorrupt},
+	} {
+		bad := append([]byte(nil), buf...)
+		bad[tc.off]++
+		_, err := ReadPage(bytes.NewReader(bad))
+		if de, ok := err.(*codec.DecodeError); !ok || de.Err != tc.err {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+	}
+	_, err = ReadPage(bytes.NewReader(buf[:len(buf)-1]))
+	if de, ok := err.(*codec.DecodeError); !ok || de.Err != codec.ErrTruncated {
+		t.Errorf("truncated: got %v", err)
+	}
+}
+
+// TestCRC checks the CRC computation against the check value of
+// CRC-32/MPEG-2, which differs only by its initial value.
+func TestCRC(t *testing.T) {
+	if c := crc32(0xffffffff, []byte("123456789")); c != 0x0376e6e7 {
+		t.Errorf("got %08x", c)
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package ogg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"zikichombo.org/codec"
+)
+
+// maxPacket bounds the size of a packet, against corrupt streams which
+// continue a packet indefinitely.
+const maxPacket = 1 << 24
+
+// seekSpan is the size of the range of offsets below which seeking scans
+// pages rather than bisecting.
+const seekSpan = 1 << 16
+
+// Packet is a packet of a logical stream.
+type Packet struct {
+	Serial uint32
+	Data   []byte
+	// Granule is the granule position of the page on which the packet is
+	// completed if it is the last packet completed there, and -1
+	// otherwise.
+	Granule int64
+	// BOS is true for the first packet of a logical stream and EOS for
+	// the last.
+	BOS, EOS bool
+}
+
+// Reader reads the packets of the logical streams of a physical Ogg
+// stream, in the order in which they are completed.
+type Reader struct {
+	r    io.Reader
+	s    io.Seeker // nil if not seekable
+	base int64     // offset in r of the start of the stream
+	end  int64     // offset of the end of the stream, or -1 if unknown
+	br   *bufio.Reader
+	off  int64 // offset of the next page
+
+	page    Page
+	streams map[uint32]*state
+	queue   []Packet
+	qi      int
+}
+
+// state is the state of a logical stream read by a Reader.
+type state struct {
+	first   int64 // offset of the first page read
+	seq     uint32
+	synced  bool // whether seq is that of the last page read
+	partial []byte
+	cont    bool // whether partial is the start of a packet
+}
+
+// NewReader creates a Reader of the physical stream starting at the
+// current offset of r.  The Reader can seek if r is an io.Seeker.
+func NewReader(r io.Reader) *Reader {
+	rd := &Reader{
+		r:       r,
+		end:     -1,
+		br:      bufio.NewReader(r),
+		streams: make(map[uint32]*state)}
+	if s, ok := r.(io.Seeker); ok {
+		if base, err := s.Seek(0, io.SeekCurrent); err == nil {
+			rd.s, rd.base = s, base
+		}
+	}
+	return rd
+}
+
+// CanSeek returns whether r can seek.
+func (r *Reader) CanSeek() bool {
+	return r.s != nil
+}
+
+// ReadPacket returns the next packet completed in the physical stream.
+// Fragments of packets whose start was lost, as after seeking, are
+// skipped.  ReadPacket returns io.EOF at the end of the stream.
+func (r *Reader) ReadPacket() (*Packet, error) {
+	for r.qi == len(r.queue) {
+		r.queue, r.qi = r.queue[:0], 0
+		err := r.next()
+		if err == io.EOF {
+			for serial, st := range r.streams {
+				if st.cont {
+					return nil, errs.Decode(codec.ErrTruncated, r.off, "page", "stream %08x ends within a packet", serial)
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	p := r.queue[r.qi]
+	r.qi++
+	return &p, nil
+}
+
+// next reads the next page, queueing the packets completed on it.
+func (r *Reader) next() error {
+	off := r.off
+	p := &r.page
+	if err := readPage(r.br, p, off); err != nil {
+		return err
+	}
+	r.off += int64(p.size())
+	st := r.streams[p.Serial]
+	if st == nil {
+		st = &state{first: off}
+		r.streams[p.Serial] = st
+	}
+	if st.synced && p.Seq != st.seq+1 {
+		st.cont = false
+	}
+	st.seq, st.synced = p.Seq, true
+	pkts, partial := p.Packets()
+	cont := st.cont && p.Flags&Continued != 0
+	start := st.partial
+	st.partial, st.cont = nil, false
+	j := len(r.queue)
+	for i, d := range pkts {
+		if i == 0 && p.Flags&Continued != 0 {
+			if !cont {
+				continue
+			}
+			d = append(start, d...)
+		} else {
+			d = append([]byte(nil), d...)
+		}
+		if i == len(pkts)-1 && partial {
+			if len(d) > maxPacket {
+				return errs.Decode(codec.ErrCorrupt, off, "page", "packet exceeds %d bytes", maxPacket)
+			}
+			st.partial, st.cont = d, true
+			break
+		}
+		r.queue = append(r.queue, Packet{
+			Serial:  p.Serial,
+			Data:    d,
+			Granule: -1,
+			BOS:     i == 0 && p.Flags&BOS != 0})
+	}
+	if k := len(r.queue) - 1; k >= j {
+		r.queue[k].Granule = p.Granule
+		r.queue[k].EOS = p.Flags&EOS != 0
+	}
+	return nil
+}
+
+// SeekGranule positions r in the logical stream serial such that the
+// packets read next are those completed after its last page whose
+// granule position is at most g, and returns that granule position.
+// Packets of the other logical streams may be skipped.  The granule
+// position of the pages of the headers of the stream should be at most
+// g.
+func (r *Reader) SeekGranule(serial uint32, g int64) (int64, error) {
+	if r.s == nil {
+		return 0, codec.ErrUnsupportedFunction
+	}
+	st := r.streams[serial]
+	if st == nil {
+		return 0, fmt.Errorf("ogg: unknown logical stream %08x", serial)
+	}
+	if g < 0 {
+		return 0, fmt.Errorf("ogg: seek to negative granule position %d", g)
+	}
+	end, err := r.size()
+	if err != nil {
+		return 0, err
+	}
+	lo, hi := st.first, end
+	for hi-lo > seekSpan {
+		mid := lo + (hi-lo)/2
+		off, ok, err := r.sync(serial, mid, hi)
+		if err != nil {
+			return 0, err
+		}
+		if !ok || r.page.Granule > g {
+			hi = mid
+			continue
+		}
+		lo = off
+	}
+	if err := r.reset(lo); err != nil {
+		return 0, err
+	}
+	best, bestG := st.first, int64(0)
+	for {
+		off := r.off
+		err := readPage(r.br, &r.page, off)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.off += int64(r.page.size())
+		if r.page.Serial != serial {
+			continue
+		}
+		if r.page.Granule != -1 {
+			if r.page.Granule > g {
+				break
+			}
+			best, bestG = off, r.page.Granule
+		}
+		if r.page.Flags&EOS != 0 {
+			break
+		}
+	}
+	if err := r.reset(best); err != nil {
+		return 0, err
+	}
+	r.queue, r.qi = r.queue[:0], 0
+	for _, s := range r.streams {
+		s.synced, s.cont = false, false
+	}
+	if err := r.next(); err != nil {
+		return 0, err
+	}
+	r.queue = r.queue[:0]
+	return bestG, nil
+}
+
+// LastGranule returns the granule position of the last page of the
+// logical stream serial which completes a packet, or -1 if there is
+// none.  The position of r is unchanged.
+func (r *Reader) LastGranule(serial uint32) (int64, error) {
+	if r.s == nil {
+		return 0, codec.ErrUnsupportedFunction
+	}
+	st := r.streams[serial]
+	if st == nil {
+		return 0, fmt.Errorf("ogg: unknown logical stream %08x", serial)
+	}
+	end, err := r.size()
+	if err != nil {
+		return 0, err
+	}
+	save := r.off
+	g := int64(-1)
+	for hi := end; hi > st.first && g == -1; hi -= seekSpan {
+		lo := hi - seekSpan
+		if lo < st.first {
+			lo = st.first
+		}
+		for p := lo; ; {
+			off, ok, err := r.sync(serial, p, hi)
+			if err != nil {
+				return 0, err
+			}
+			if !ok {
+				break
+			}
+			g = r.page.Granule
+			p = off + int64(r.page.size())
+		}
+	}
+	if err := r.reset(save); err != nil {
+		return 0, err
+	}
+	return g, nil
+}
+
+// sync reads into r.page the first valid page of the logical stream
+// serial starting in [from, to) which completes a packet, returning its
+// offset, or false if there is none.
+func (r *Reader) sync(serial uint32, from, to int64) (int64, bool, error) {
+	for p := from; p < to; {
+		if err := r.reset(p); err != nil {
+			return 0, false, err
+		}
+		n := 0
+		for n < len(capture) {
+			c, err := r.br.ReadByte()
+			if err == io.EOF {
+				return 0, false, nil
+			}
+			if err != nil {
+				return 0, false, err
+			}
+			p++
+			switch {
+			case c == capture[n]:
+				n++
+			case c == capture[0]:
+				n = 1
+			default:
+				n = 0
+			}
+		}
+		p -= int64(len(capture))
+		if p >= to {
+			return 0, false, nil
+		}
+		if err := r.reset(p); err != nil {
+			return 0, false, err
+		}
+		err := readPage(r.br, &r.page, p)
+		if err == nil {
+			if r.page.Serial == serial && r.page.Granule != -1 {
+				return p, true, nil
+			}
+			p += int64(r.page.size())
+			continue
+		}
+		if _, ok := err.(*codec.DecodeError); !ok && err != io.EOF {
+			return 0, false, err
+		}
+		p++
+	}
+	return 0, false, nil
+}
+
+// size returns the size of the physical stream.
+func (r *Reader) size() (int64, error) {
+	if r.end < 0 {
+		end, err := r.s.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, err
+		}
+		r.end = end - r.base
+		if err := r.reset(r.off); err != nil {
+			return 0, err
+		}
+	}
+	return r.end, nil
+}
+
+// reset positions r at offset off of the physical stream.
+func (r *Reader) reset(off int64) error {
+	if _, err := r.s.Seek(r.base+off, io.SeekStart); err != nil {
+		return err
+	}
+	r.br.Reset(r.r)
+	r.off = off
+	return nil
+}
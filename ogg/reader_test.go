@@ -0,0 +1,216 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"zikichombo.org/codec"
+)
+
+// packet returns packet k of a test stream with serial number serial,
+// which starts with k and serial.
+func packet(serial uint32, k int) []byte {
+	n := 8 + k*37%700
+	if k%97 == 50 {
+		n = 70000
+	}
+	if k%89 == 3 {
+		n = 255 * 4
+	}
+	d := make([]byte, n)
+	binary.LittleEndian.PutUint32(d, uint32(k))
+	binary.LittleEndian.PutUint32(d[4:], serial)
+	for i := 8; i < n; i++ {
+		d[i] = byte(i + k)
+	}
+	return d
+}
+
+// granule is the granule position of the end of packet k of a test
+// stream.
+func granule(k int) int64 {
+	return int64(k+1) * 100
+}
+
+// mux returns a physical stream multiplexing logical streams 1 and 2 of
+// n and n/3 packets after a header.
+func mux(t *testing.T, n int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	ws := []*Writer{NewWriter(&b, 1), NewWriter(&b, 2)}
+	for i, w := range ws {
+		if err := w.WritePacket([]byte{'h', byte(i + 1)}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for k := 0; k < n; k++ {
+		if err := ws[0].WritePacket(packet(1, k), granule(k)); err != nil {
+			t.Fatal(err)
+		}
+		if k%3 == 0 {
+			if err := ws[1].WritePacket(packet(2, k/3), granule(k/3)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, w := range ws {
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ws[0].WritePacket([]byte{1}, 0); err == nil {
+		t.Errorf("wrote to closed Writer")
+	}
+	return b.Bytes()
+}
+
+// check checks that p is packet k of logical stream serial.
+func check(t *testing.T, p *Packet, serial uint32, k int) {
+	t.Helper()
+	if p.Serial != serial || !bytes.Equal(p.Data, packet(serial, k)) {
+		t.Fatalf("packet %d of stream %d: got %d bytes of stream %d", k, serial, len(p.Data), p.Serial)
+	}
+}
+
+func TestReader(t *testing.T) {
+	data := mux(t, 1000)
+	r := NewReader(bytes.NewReader(data))
+	next := map[uint32]int{1: -1, 2: -1}
+	for {
+		p, err := r.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		k := next[p.Serial]
+		next[p.Serial]++
+		if p.BOS != (k == -1) {
+			t.Errorf("stream %d packet %d: BOS %t", p.Serial, k, p.BOS)
+		}
+		if k == -1 {
+			if !bytes.Equal(p.Data, []byte{'h', byte(p.Serial)}) {
+				t.Errorf("stream %d header %q", p.Serial, p.Data)
+			}
+			continue
+		}
+		check(t, p, p.Serial, k)
+		if p.Granule != -1 && p.Granule != granule(k) {
+			t.Errorf("stream %d packet %d: granule %d", p.Serial, k, p.Granule)
+		}
+		if last := p.Serial == 1 && k == 999 || p.Serial == 2 && k == 333; p.EOS != last {
+			t.Errorf("stream %d packet %d: EOS %t", p.Serial, k, p.EOS)
+		}
+	}
+	if next[1] != 1000 || next[2] != 334 {
+		t.Errorf("read %v packets", next)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	data := mux(t, 200)
+	bad := append([]byte(nil), data...)
+	bad[len(bad)/2]++
+	if err := readAll(bad); err == nil || err.(*codec.DecodeError).Err != codec.ErrCorrupt {
+		t.Errorf("corrupt: %v", err)
+	}
+	// the stream ends within the packet of 70000 bytes.
+	if err := readAll(data[:30000]); err == nil || err.(*codec.DecodeError).Err != codec.ErrTruncated {
+		t.Errorf("truncated: %v", err)
+	}
+}
+
+func readAll(data []byte) error {
+	r := NewReader(bytes.NewReader(data))
+	for {
+		_, err := r.ReadPacket()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	data := mux(t, 300)
+	s, err := OpenStream(ioutil.NopCloser(bytes.NewReader(data)), func(first []byte) bool {
+		return first[1] == 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Serial() != 2 || s.CanSeek() {
+		t.Errorf("serial %d, can seek %t", s.Serial(), s.CanSeek())
+	}
+	if _, err := s.SeekGranule(0); err != codec.ErrUnsupportedFunction {
+		t.Errorf("seek: %v", err)
+	}
+	p, err := s.ReadPacket()
+	if err != nil || !p.BOS {
+		t.Fatalf("header %+v, %v", p, err)
+	}
+	for k := 0; k < 100; k++ {
+		p, err := s.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, p, 2, k)
+	}
+	if _, err := s.ReadPacket(); err != io.EOF {
+		t.Errorf("after last packet: %v", err)
+	}
+	_, err = OpenStream(bytes.NewReader(data), func([]byte) bool { return false })
+	if de, ok := err.(*codec.DecodeError); !ok || de.Err != codec.ErrUnsupportedFormat {
+		t.Errorf("unidentified: %v", err)
+	}
+}
+
+func TestSeekGranule(t *testing.T) {
+	const n = 3000
+	data := mux(t, n)
+	s, err := OpenStream(bytes.NewReader(data), func(first []byte) bool {
+		return first[1] == 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, err := s.LastGranule(); err != nil || g != granule(n-1) {
+		t.Errorf("last granule %d, %v", g, err)
+	}
+	p, err := s.ReadPacket()
+	if err != nil || !p.BOS {
+		t.Fatalf("header %+v, %v", p, err)
+	}
+	for _, g := range []int64{150000, 0, 99, 100, granule(n - 1), 5 * n * 100, 123456, granule(49), granule(50), 1} {
+		got, err := s.SeekGranule(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got > g || got < g-10000 && g < granule(n-1) {
+			t.Errorf("seek to %d: at granule %d", g, got)
+		}
+		p, err := s.ReadPacket()
+		if got >= granule(n-1) {
+			if err != io.EOF {
+				t.Errorf("seek to %d: got %v", g, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, p, 1, int(got/100))
+	}
+	if _, err := s.SeekGranule(-1); err == nil {
+		t.Errorf("seek to negative granule position")
+	}
+}
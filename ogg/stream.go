@@ -0,0 +1,108 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package ogg
+
+import (
+	"io"
+
+	"zikichombo.org/codec"
+)
+
+// Stream reads the packets of one logical stream of a physical Ogg
+// stream, skipping those of the others.
+type Stream struct {
+	r       *Reader
+	c       io.Closer
+	serial  uint32
+	pending *Packet // packet read by OpenStream
+	eos     bool
+}
+
+// OpenStream opens the first logical stream of r whose identification
+// header, the first packet of the stream, is accepted by identify.  The
+// first packet read from the Stream is the identification header.  If
+// r is an io.Seeker, the Stream can seek, and if r is an io.Closer, it is
+// closed by the Stream.
+//
+// Only the logical streams starting at the beginning of r are
+// considered.  If identify accepts none of them, OpenStream returns a
+// *codec.DecodeError classified as codec.ErrUnsupportedFormat.
+func OpenStream(r io.Reader, identify func(first []byte) bool) (*Stream, error) {
+	rd := NewReader(r)
+	for {
+		p, err := rd.ReadPacket()
+		if err == io.EOF {
+			return nil, errs.Decode(codec.ErrTruncated, rd.off, "page", "no logical stream")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !p.BOS {
+			return nil, errs.Decode(codec.ErrUnsupportedFormat, 0, "page", "no logical stream of a known codec")
+		}
+		if identify(p.Data) {
+			s := &Stream{r: rd, serial: p.Serial, pending: p}
+			s.c, _ = r.(io.Closer)
+			return s, nil
+		}
+	}
+}
+
+// Serial returns the serial number of s.
+func (s *Stream) Serial() uint32 {
+	return s.serial
+}
+
+// CanSeek returns whether s can seek.
+func (s *Stream) CanSeek() bool {
+	return s.r.CanSeek()
+}
+
+// ReadPacket returns the next packet of s, or io.EOF after the last
+// packet.
+func (s *Stream) ReadPacket() (*Packet, error) {
+	if p := s.pending; p != nil {
+		s.pending = nil
+		s.eos = p.EOS
+		return p, nil
+	}
+	for !s.eos {
+		p, err := s.r.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		if p.Serial == s.serial {
+			s.eos = p.EOS
+			return p, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+// SeekGranule positions s after the packets of its last page whose
+// granule position is at most g, returning that granule position, as
+// Reader.SeekGranule.  It returns codec.ErrUnsupportedFunction if s
+// cannot seek.
+func (s *Stream) SeekGranule(g int64) (int64, error) {
+	res, err := s.r.SeekGranule(s.serial, g)
+	if err != nil {
+		return 0, err
+	}
+	s.pending, s.eos = nil, false
+	return res, nil
+}
+
+// LastGranule returns the granule position of the last page of s which
+// completes a packet, as Reader.LastGranule.
+func (s *Stream) LastGranule() (int64, error) {
+	return s.r.LastGranule(s.serial)
+}
+
+// Close closes the source of s if it is an io.Closer.
+func (s *Stream) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package ogg
+
+import (
+	"errors"
+	"io"
+)
+
+// pageFill is the amount of packet data after which a Writer ends a page.
+const pageFill = 4096
+
+var errClosed = errors.New("ogg: write to closed Writer")
+
+// Writer writes the packets of a logical stream as pages.  Each page is
+// written by a single call to Write of the destination, so Writers of
+// different logical streams may share a destination to multiplex them.
+type Writer struct {
+	w       io.Writer
+	page    Page
+	done    bool // whether a packet is completed on page
+	started bool // whether the first page was written
+	cont    bool // whether page continues a packet
+	last    int64
+	buf     []byte
+	err     error
+}
+
+// NewWriter creates a Writer of the logical stream with serial number
+// serial to w.
+func NewWriter(w io.Writer, serial uint32) *Writer {
+	return &Writer{w: w, page: Page{Serial: serial}}
+}
+
+// WritePacket writes the packet data, whose end has granule position
+// granule.  The first packet is written on a page of its own.  Pages are
+// otherwise written once they hold about 4kB and another packet is
+// written, so that the last packet is on the page Close marks as the end
+// of the stream, or by Flush.
+func (w *Writer) WritePacket(data []byte, granule int64) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.page.Data) >= pageFill {
+		if err := w.emit(0, false); err != nil {
+			return err
+		}
+	}
+	for i := 0; ; i += 255 {
+		n := len(data) - i
+		if n > 255 {
+			n = 255
+		}
+		w.page.Lacing = append(w.page.Lacing, byte(n))
+		w.page.Data = append(w.page.Data, data[i:i+n]...)
+		if n < 255 {
+			w.page.Granule, w.done = granule, true
+		}
+		if len(w.page.Lacing) == 255 {
+			if err := w.emit(0, n == 255); err != nil {
+				return err
+			}
+		}
+		if n < 255 {
+			break
+		}
+	}
+	w.last = granule
+	if !w.started {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush writes the packets not yet written on a page.  Codecs require it
+// after their header packets, whose pages must not contain audio.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.page.Lacing) == 0 {
+		return nil
+	}
+	return w.emit(0, false)
+}
+
+// Close writes the last page, marked as the end of the stream, and
+// prevents further writes.  It does not close the destination.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		if w.err == errClosed {
+			return nil
+		}
+		return w.err
+	}
+	if !w.done && !w.cont {
+		w.page.Granule = w.last
+		w.done = true
+	}
+	if err := w.emit(EOS, false); err != nil {
+		return err
+	}
+	w.err = errClosed
+	return nil
+}
+
+// emit writes the page being filled, with flags added to those implied by
+// the stream, and starts the next page, which continues a packet if cont
+// is true.
+func (w *Writer) emit(flags byte, cont bool) error {
+	p := &w.page
+	p.Flags = flags
+	if !w.started {
+		p.Flags |= BOS
+	}
+	if w.cont {
+		p.Flags |= Continued
+	}
+	if !w.done {
+		p.Granule = -1
+	}
+	w.buf = p.Append(w.buf[:0])
+	if _, err := w.w.Write(w.buf); err != nil {
+		w.err = err
+		return err
+	}
+	w.started, w.cont, w.done = true, cont, false
+	p.Seq++
+	p.Lacing, p.Data = p.Lacing[:0], p.Data[:0]
+	return nil
+}
@@ -0,0 +1,952 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import "math"
+
+// bandCtx is the state of the coding of the shapes of the bands of a
+// frame, as in RFC 6716 section 4.3.4.
+type bandCtx struct {
+	ec         *celtCoder
+	i          int // band
+	intensity  int
+	spread     int
+	tfChange   int
+	remaining  int // 1/8 bits left
+	seed       uint32
+	avoidNoise bool
+	disableInv bool      // no phase inversion, when decoding to mono
+	bandE      []float64 // amplitudes of the bands, when encoding
+	scratch    []int
+}
+
+// splitCtx are the parameters of the split of a band in two.
+type splitCtx struct {
+	inv    bool
+	imid   int
+	iside  int
+	delta  int
+	itheta int
+	qalloc int
+}
+
+// quantAllBands decodes the normalized shapes of the bands [start, end)
+// of the frame of LM lm into x, and y if stereo, setting their collapse
+// masks, with the allocation a and total total 1/8 bits.  When ec
+// encodes, it encodes the shapes of x and y, of band amplitudes bandE,
+// and replaces them by those decoded.
+func quantAllBands(ec *celtCoder, start, end int, x, y, bandE []float64, masks []uint, a *allocation, shortBlocks bool, spread int, tfRes []int, total int, lm int, seed *uint32, disableInv bool) {
+	m := 1 << uint(lm)
+	nC := 1
+	if y != nil {
+		nC = 2
+	}
+	b0 := 1
+	if shortBlocks {
+		b0 = m
+	}
+	normOffset := m * eBands[start]
+	normLen := m*eBands[nbEBands-1] - normOffset
+	norm := make([]float64, nC*normLen)
+	norm2 := norm[normLen:]
+	scratch := x[m*eBands[nbEBands-1]:]
+	ctx := bandCtx{
+		ec:         ec,
+		intensity:  a.intensity,
+		spread:     spread,
+		seed:       *seed,
+		avoidNoise: b0 > 1,
+		disableInv: disableInv,
+		bandE:      bandE}
+	balance := a.balance
+	dualStereo := a.dualStereo
+	updateLowband := true
+	lowbandOffset := 0
+	for i := start; i < end; i++ {
+		ctx.i = i
+		last := i == end-1
+		xb := x[m*eBands[i]:]
+		var yb []float64
+		if y != nil {
+			yb = y[m*eBands[i]:]
+		}
+		n := m*eBands[i+1] - m*eBands[i]
+		tell := ec.tellFrac()
+		if i != start {
+			balance -= tell
+		}
+		remaining := total - tell - 1
+		ctx.remaining = remaining
+		b := 0
+		if i <= a.codedBands-1 {
+			currBalance := balance / minInt(3, a.codedBands-i)
+			b = maxInt(0, minInt(16383, minInt(remaining+1, a.pulses[i]+currBalance)))
+		}
+		if (m*eBands[i]-n >= m*eBands[start] || i == start+1) && (updateLowband || lowbandOffset == 0) {
+			lowbandOffset = i
+		}
+		if i == start+1 {
+			// The first band of hybrid frames is duplicated, to fold
+			// the wider second band.
+			n1 := m * (eBands[start+1] - eBands[start])
+			n2 := m * (eBands[start+2] - eBands[start+1])
+			copy(norm[n1:n2], norm[2*n1-n2:])
+			if dualStereo {
+				copy(norm2[n1:n2], norm2[2*n1-n2:])
+			}
+		}
+		ctx.tfChange = tfRes[i]
+		lbScratch := scratch
+		if last {
+			lbScratch = nil
+		}
+		effLowband := -1
+		xcm, ycm := uint(0), uint(0)
+		if lowbandOffset != 0 && (spread != spreadAggressive || b0 > 1 || ctx.tfChange < 0) {
+			effLowband = maxInt(0, m*eBands[lowbandOffset]-normOffset-n)
+			foldStart := lowbandOffset
+			for {
+				foldStart--
+				if m*eBands[foldStart] <= effLowband+normOffset {
+					break
+				}
+			}
+			foldEnd := lowbandOffset - 1
+			for {
+				foldEnd++
+				if foldEnd >= i || m*eBands[foldEnd] >= effLowband+normOffset+n {
+					break
+				}
+			}
+			for f := foldStart; ; {
+				xcm |= masks[f*nC]
+				ycm |= masks[f*nC+nC-1]
+				if f++; f >= foldEnd {
+					break
+				}
+			}
+		} else {
+			xcm = 1<<uint(b0) - 1
+			ycm = xcm
+		}
+		if dualStereo && i == a.intensity {
+			dualStereo = false
+			for j := 0; j < m*eBands[i]-normOffset; j++ {
+				norm[j] = 0.5 * (norm[j] + norm2[j])
+			}
+		}
+		lowband := func(nrm []float64) []float64 {
+			if effLowband == -1 {
+				return nil
+			}
+			return nrm[effLowband:]
+		}
+		out := func(nrm []float64) []float64 {
+			if last {
+				return nil
+			}
+			return nrm[m*eBands[i]-normOffset:]
+		}
+		if dualStereo {
+			xcm = ctx.quantBand(xb[:n], b/2, b0, lowband(norm), lm, out(norm), 1, lbScratch, xcm)
+			ycm = ctx.quantBand(yb[:n], b/2, b0, lowband(norm2), lm, out(norm2), 1, lbScratch, ycm)
+		} else {
+			if yb != nil {
+				xcm = ctx.quantBandStereo(xb[:n], yb[:n], b, b0, lowband(norm), lm, out(norm), lbScratch, xcm|ycm)
+			} else {
+				xcm = ctx.quantBand(xb[:n], b, b0, lowband(norm), lm, out(norm), 1, lbScratch, xcm|ycm)
+			}
+			ycm = xcm
+		}
+		masks[i*nC] = xcm
+		masks[i*nC+nC-1] = ycm
+		balance += a.pulses[i] + tell
+		updateLowband = b > n<<bitRes
+		ctx.avoidNoise = false
+	}
+	*seed = ctx.seed
+}
+
+// quantBand codes the shape of a mono band, or of a channel or the mid
+// or side of a stereo band, of b 1/8 bits and nb short blocks, folding
+// lowband where no pulses are coded, and writes into lowbandOut the
+// shape for folding higher bands.
+func (ctx *bandCtx) quantBand(x []float64, b, nb int, lowband []float64, lm int, lowbandOut []float64, gain float64, scratch []float64, fill uint) uint {
+	n := len(x)
+	n0 := n
+	nB := n / nb
+	b0 := nb
+	longBlocks := b0 == 1
+	if n == 1 {
+		return ctx.quantBandN1(x, nil, b, lowbandOut)
+	}
+	recombine := 0
+	if ctx.tfChange > 0 {
+		recombine = ctx.tfChange
+	}
+	if scratch != nil && lowband != nil && (recombine != 0 || (nB&1 == 0 && ctx.tfChange < 0) || b0 > 1) {
+		copy(scratch[:n], lowband[:n])
+		lowband = scratch[:n]
+	}
+	encode := ctx.ec.enc != nil
+	for k := 0; k < recombine; k++ {
+		if encode {
+			haar1(x, n>>uint(k), 1<<uint(k))
+		}
+		if lowband != nil {
+			haar1(lowband, n>>uint(k), 1<<uint(k))
+		}
+		fill = uint(bitInterleave[fill&0xf]) | uint(bitInterleave[fill>>4])<<2
+	}
+	nb >>= uint(recombine)
+	nB <<= uint(recombine)
+	timeDivide := 0
+	tfChange := ctx.tfChange
+	for nB&1 == 0 && tfChange < 0 {
+		if encode {
+			haar1(x, nB, nb)
+		}
+		if lowband != nil {
+			haar1(lowband, nB, nb)
+		}
+		fill |= fill << uint(nb)
+		nb <<= 1
+		nB >>= 1
+		timeDivide++
+		tfChange++
+	}
+	b0 = nb
+	nB0 := nB
+	if b0 > 1 && encode {
+		deinterleaveHadamard(x, nB>>uint(recombine), b0<<uint(recombine), longBlocks)
+	}
+	if b0 > 1 && lowband != nil {
+		deinterleaveHadamard(lowband, nB>>uint(recombine), b0<<uint(recombine), longBlocks)
+	}
+	cm := ctx.quantPartition(x, b, nb, lowband, lm, gain, fill)
+	if b0 > 1 {
+		interleaveHadamard(x, nB>>uint(recombine), b0<<uint(recombine), longBlocks)
+	}
+	nB = nB0
+	nb = b0
+	for k := 0; k < timeDivide; k++ {
+		nb >>= 1
+		nB <<= 1
+		cm |= cm >> uint(nb)
+		haar1(x, nB, nb)
+	}
+	for k := 0; k < recombine; k++ {
+		cm = uint(bitDeinterleave[cm])
+		haar1(x, n0>>uint(k), 1<<uint(k))
+	}
+	nb <<= uint(recombine)
+	if lowbandOut != nil {
+		s := math.Sqrt(float64(n0))
+		for j := 0; j < n0; j++ {
+			lowbandOut[j] = s * x[j]
+		}
+	}
+	return cm & (1<<uint(nb) - 1)
+}
+
+var bitInterleave = [16]uint8{0, 1, 1, 1, 2, 3, 3, 3, 2, 3, 3, 3, 2, 3, 3, 3}
+
+var bitDeinterleave = [16]uint8{0x00, 0x03, 0x0C, 0x0F, 0x30, 0x33, 0x3C, 0x3F, 0xC0, 0xC3, 0xCC, 0xCF, 0xF0, 0xF3, 0xFC, 0xFF}
+
+// quantBandN1 codes the signs of bands of a single bin.
+func (ctx *bandCtx) quantBandN1(x, y []float64, b int, lowbandOut []float64) uint {
+	for _, v := range [][]float64{x, y} {
+		if v == nil {
+			continue
+		}
+		sign := uint32(0)
+		if ctx.remaining >= 1<<bitRes {
+			if v[0] < 0 {
+				sign = 1
+			}
+			sign = ctx.ec.bits(sign, 1)
+			ctx.remaining -= 1 << bitRes
+		}
+		v[0] = 1
+		if sign != 0 {
+			v[0] = -1
+		}
+	}
+	if lowbandOut != nil {
+		lowbandOut[0] = x[0]
+	}
+	return 1
+}
+
+// quantPartition codes a band of b 1/8 bits, recursively splitting it
+// in two halves while it has more bits than its largest codebook.
+func (ctx *bandCtx) quantPartition(x []float64, b, nb int, lowband []float64, lm int, gain float64, fill uint) uint {
+	n := len(x)
+	b0 := nb
+	i := ctx.i
+	cache := pulseCache.bits[pulseCache.index[(lm+1)*nbEBands+i]:]
+	if lm != -1 && b > cache[cache[0]]+12 && n > 2 {
+		n >>= 1
+		y := x[n:]
+		x = x[:n]
+		lm--
+		if nb == 1 {
+			fill = fill&1 | fill<<1
+		}
+		nb = (nb + 1) >> 1
+		var s splitCtx
+		ctx.computeTheta(&s, x, y, &b, nb, b0, lm, false, &fill)
+		mid := float64(s.imid) / 32768
+		side := float64(s.iside) / 32768
+		delta := s.delta
+		if b0 > 1 && s.itheta&0x3fff != 0 {
+			if s.itheta > 8192 {
+				delta -= delta >> uint(4-lm)
+			} else {
+				delta = minInt(0, delta+(n<<bitRes>>uint(5-lm)))
+			}
+		}
+		mbits := maxInt(0, minInt(b, (b-delta)/2))
+		sbits := b - mbits
+		ctx.remaining -= s.qalloc
+		var lowband2 []float64
+		if lowband != nil {
+			lowband2 = lowband[n:]
+		}
+		rebalance := ctx.remaining
+		var cm uint
+		if mbits >= sbits {
+			cm = ctx.quantPartition(x, mbits, nb, lowband, lm, gain*mid, fill)
+			rebalance = mbits - (rebalance - ctx.remaining)
+			if rebalance > 3<<bitRes && s.itheta != 0 {
+				sbits += rebalance - 3<<bitRes
+			}
+			cm |= ctx.quantPartition(y, sbits, nb, lowband2, lm, gain*side, fill>>uint(nb)) << uint(b0>>1)
+		} else {
+			cm = ctx.quantPartition(y, sbits, nb, lowband2, lm, gain*side, fill>>uint(nb)) << uint(b0>>1)
+			rebalance = sbits - (rebalance - ctx.remaining)
+			if rebalance > 3<<bitRes && s.itheta != 16384 {
+				mbits += rebalance - 3<<bitRes
+			}
+			cm |= ctx.quantPartition(x, mbits, nb, lowband, lm, gain*mid, fill)
+		}
+		return cm
+	}
+	q := bits2Pulses(i, lm, b)
+	curr := pulses2Bits(i, lm, q)
+	ctx.remaining -= curr
+	for ctx.remaining < 0 && q > 0 {
+		ctx.remaining += curr
+		q--
+		curr = pulses2Bits(i, lm, q)
+		ctx.remaining -= curr
+	}
+	if q != 0 && ctx.ec.enc != nil {
+		return ctx.algQuant(x, getPulses(q), nb, gain)
+	}
+	if q != 0 {
+		return ctx.algUnquant(x, getPulses(q), nb, gain)
+	}
+	mask := uint(1)<<uint(nb) - 1
+	fill &= mask
+	if fill == 0 {
+		for j := range x {
+			x[j] = 0
+		}
+		return 0
+	}
+	var cm uint
+	if lowband == nil {
+		for j := range x {
+			ctx.seed = lcgRand(ctx.seed)
+			x[j] = float64(int32(ctx.seed) >> 20)
+		}
+		cm = mask
+	} else {
+		for j := range x {
+			ctx.seed = lcgRand(ctx.seed)
+			t := 1.0 / 256
+			if ctx.seed&0x8000 == 0 {
+				t = -t
+			}
+			x[j] = lowband[j] + t
+		}
+		cm = fill
+	}
+	renormalize(x, gain)
+	return cm
+}
+
+// quantBandStereo codes the shapes of both channels of a stereo band,
+// as their mid and side or by intensity stereo.
+func (ctx *bandCtx) quantBandStereo(x, y []float64, b, nb int, lowband []float64, lm int, lowbandOut, scratch []float64, fill uint) uint {
+	n := len(x)
+	if n == 1 {
+		return ctx.quantBandN1(x, y, b, lowbandOut)
+	}
+	origFill := fill
+	var s splitCtx
+	ctx.computeTheta(&s, x, y, &b, nb, nb, lm, true, &fill)
+	mid := float64(s.imid) / 32768
+	side := float64(s.iside) / 32768
+	var cm uint
+	if n == 2 {
+		mbits := b
+		sbits := 0
+		if s.itheta != 0 && s.itheta != 16384 {
+			sbits = 1 << bitRes
+		}
+		mbits -= sbits
+		c := s.itheta > 8192
+		ctx.remaining -= s.qalloc + sbits
+		x2, y2 := x, y
+		if c {
+			x2, y2 = y, x
+		}
+		sign := 0
+		if sbits != 0 {
+			if x2[0]*y2[1]-x2[1]*y2[0] < 0 {
+				sign = 1
+			}
+			sign = int(ctx.ec.bits(uint32(sign), 1))
+		}
+		sign = 1 - 2*sign
+		cm = ctx.quantBand(x2, mbits, nb, lowband, lm, lowbandOut, 1, scratch, origFill)
+		y2[0] = float64(-sign) * x2[1]
+		y2[1] = float64(sign) * x2[0]
+		x[0], x[1] = mid*x[0], mid*x[1]
+		y[0], y[1] = side*y[0], side*y[1]
+		x[0], y[0] = x[0]-y[0], x[0]+y[0]
+		x[1], y[1] = x[1]-y[1], x[1]+y[1]
+	} else {
+		mbits := maxInt(0, minInt(b, (b-s.delta)/2))
+		sbits := b - mbits
+		ctx.remaining -= s.qalloc
+		rebalance := ctx.remaining
+		if mbits >= sbits {
+			cm = ctx.quantBand(x, mbits, nb, lowband, lm, lowbandOut, 1, scratch, fill)
+			rebalance = mbits - (rebalance - ctx.remaining)
+			if rebalance > 3<<bitRes && s.itheta != 0 {
+				sbits += rebalance - 3<<bitRes
+			}
+			cm |= ctx.quantBand(y, sbits, nb, nil, lm, nil, side, nil, fill>>uint(nb))
+		} else {
+			cm = ctx.quantBand(y, sbits, nb, nil, lm, nil, side, nil, fill>>uint(nb))
+			rebalance = sbits - (rebalance - ctx.remaining)
+			if rebalance > 3<<bitRes && s.itheta != 16384 {
+				mbits += rebalance - 3<<bitRes
+			}
+			cm |= ctx.quantBand(x, mbits, nb, lowband, lm, lowbandOut, 1, scratch, fill)
+		}
+		stereoMerge(x, y, mid)
+	}
+	if s.inv {
+		for j := range y {
+			y[j] = -y[j]
+		}
+	}
+	return cm
+}
+
+// computeTheta codes the angle of the split of a band into x and y,
+// or of a stereo band into its mid and side, and the bits it takes.
+func (ctx *bandCtx) computeTheta(s *splitCtx, x, y []float64, b *int, nb, b0, lm int, stereo bool, fill *uint) {
+	n := len(x)
+	i := ctx.i
+	ec := ctx.ec
+	pulseCap := logN[i] + lm<<bitRes
+	offset := pulseCap>>1 - qthetaOffset
+	if stereo && n == 2 {
+		offset = pulseCap>>1 - qthetaOffset2
+	}
+	qn := computeQN(n, *b, offset, pulseCap, stereo)
+	if stereo && i >= ctx.intensity {
+		qn = 1
+	}
+	itheta := 0
+	inv := false
+	enc := ec.enc
+	if enc != nil {
+		itheta = stereoITheta(x, y, stereo)
+	}
+	tell := ec.tellFrac()
+	d := ec.dec
+	if qn != 1 {
+		if enc != nil {
+			itheta = (itheta*qn + 8192) >> 14
+			if !stereo && ctx.avoidNoise && itheta > 0 && itheta < qn {
+				u := itheta * 16384 / qn
+				delta := fracMul16((n-1)<<7, bitexactLog2Tan(bitexactCos(16384-u), bitexactCos(u)))
+				if delta > *b {
+					itheta = qn
+				} else if delta < -*b {
+					itheta = 0
+				}
+			}
+		}
+		switch {
+		case stereo && n > 2:
+			p0 := 3
+			x0 := qn / 2
+			ft := uint32(p0*(x0+1) + x0)
+			xv := itheta
+			if enc == nil {
+				fs := int(d.decode(ft))
+				if fs < (x0+1)*p0 {
+					xv = fs / p0
+				} else {
+					xv = x0 + 1 + (fs - (x0+1)*p0)
+				}
+			}
+			var fl, fh int
+			if xv <= x0 {
+				fl, fh = p0*xv, p0*(xv+1)
+			} else {
+				fl, fh = (xv-1-x0)+(x0+1)*p0, (xv-x0)+(x0+1)*p0
+			}
+			if enc != nil {
+				enc.encode(uint32(fl), uint32(fh), ft)
+			} else {
+				d.update(uint32(fl), uint32(fh), ft)
+			}
+			itheta = xv
+		case b0 > 1 || stereo:
+			itheta = int(ec.uint(uint32(itheta), uint32(qn+1)))
+		default:
+			h := qn >> 1
+			ft := (h + 1) * (h + 1)
+			var fl, fs int
+			if enc != nil {
+				if itheta <= h {
+					fs = itheta + 1
+					fl = itheta * (itheta + 1) >> 1
+				} else {
+					fs = qn + 1 - itheta
+					fl = ft - (qn+1-itheta)*(qn+2-itheta)>>1
+				}
+				enc.encode(uint32(fl), uint32(fl+fs), uint32(ft))
+				break
+			}
+			fm := int(d.decode(uint32(ft)))
+			if fm < h*(h+1)>>1 {
+				itheta = (isqrt32(uint32(8*fm+1)) - 1) >> 1
+				fs = itheta + 1
+				fl = itheta * (itheta + 1) >> 1
+			} else {
+				itheta = (2*(qn+1) - isqrt32(uint32(8*(ft-fm-1)+1))) >> 1
+				fs = qn + 1 - itheta
+				fl = ft - (qn+1-itheta)*(qn+2-itheta)>>1
+			}
+			d.update(uint32(fl), uint32(fl+fs), uint32(ft))
+		}
+		itheta = itheta * 16384 / qn
+		if enc != nil && stereo {
+			if itheta == 0 {
+				ctx.intensityStereo(x, y)
+			} else {
+				stereoSplit(x, y)
+			}
+		}
+	} else if stereo {
+		if enc != nil {
+			inv = itheta > 8192 && !ctx.disableInv
+			if inv {
+				for j := range y {
+					y[j] = -y[j]
+				}
+			}
+			ctx.intensityStereo(x, y)
+		}
+		v := 0
+		if inv {
+			v = 1
+		}
+		inv = false
+		if *b > 2<<bitRes && ctx.remaining > 2<<bitRes {
+			inv = ec.bit(v, 2) == 1
+		}
+		inv = inv && !ctx.disableInv
+		itheta = 0
+	}
+	qalloc := ec.tellFrac() - tell
+	*b -= qalloc
+	var imid, iside, delta int
+	switch itheta {
+	case 0:
+		imid, iside = 32767, 0
+		*fill &= 1<<uint(nb) - 1
+		delta = -16384
+	case 16384:
+		imid, iside = 0, 32767
+		*fill &= (1<<uint(nb) - 1) << uint(nb)
+		delta = 16384
+	default:
+		imid = bitexactCos(itheta)
+		iside = bitexactCos(16384 - itheta)
+		delta = fracMul16((n-1)<<7, bitexactLog2Tan(iside, imid))
+	}
+	*s = splitCtx{inv: inv, imid: imid, iside: iside, delta: delta, itheta: itheta, qalloc: qalloc}
+}
+
+var exp2Table8 = [8]int{16384, 17866, 19483, 21247, 23170, 25267, 27554, 30048}
+
+// computeQN returns the number of steps of the quantized split angle.
+func computeQN(n, b, offset, pulseCap int, stereo bool) int {
+	n2 := 2*n - 1
+	if stereo && n == 2 {
+		n2--
+	}
+	qb := (b + n2*offset) / n2
+	qb = minInt(b-pulseCap-4<<bitRes, qb)
+	qb = minInt(8<<bitRes, qb)
+	if qb < 1<<bitRes>>1 {
+		return 1
+	}
+	qn := exp2Table8[qb&7] >> uint(14-qb>>bitRes)
+	return (qn + 1) >> 1 << 1
+}
+
+func fracMul16(a, b int) int {
+	return (16384 + int(int16(a))*int(int16(b))) >> 15
+}
+
+func bitexactCos(x int) int {
+	t := (4096 + x*x) >> 13
+	x2 := t
+	x2 = (32767 - x2) + fracMul16(x2, -7651+fracMul16(x2, 8277+fracMul16(-626, x2)))
+	return 1 + x2
+}
+
+func bitexactLog2Tan(isin, icos int) int {
+	lc := int(ilog(uint32(icos)))
+	ls := int(ilog(uint32(isin)))
+	icos <<= uint(15 - lc)
+	isin <<= uint(15 - ls)
+	return (ls-lc)*(1<<11) + fracMul16(isin, fracMul16(isin, -2597)+7932) - fracMul16(icos, fracMul16(icos, -2597)+7932)
+}
+
+// isqrt32 returns the integer square root of v.
+func isqrt32(v uint32) int {
+	r := int(math.Sqrt(float64(v)))
+	for r*r > int(v) {
+		r--
+	}
+	for (r+1)*(r+1) <= int(v) {
+		r++
+	}
+	return r
+}
+
+func lcgRand(seed uint32) uint32 {
+	return 1664525*seed + 1013904223
+}
+
+// algUnquant decodes the pulses of a vector of k pulses, normalized to
+// gain and spread, returning its collapse mask.
+func (ctx *bandCtx) algUnquant(x []float64, k, nb int, gain float64) uint {
+	n := len(x)
+	if cap(ctx.scratch) < n {
+		ctx.scratch = make([]int, n)
+	}
+	iy := ctx.scratch[:n]
+	ctx.ec.dec.decodePulses(iy, k)
+	return ctx.resynth(x, iy, k, nb, gain)
+}
+
+// algQuant encodes x as the vector of k pulses nearest it after
+// spreading, and replaces it by its decoding, returning its collapse
+// mask.
+func (ctx *bandCtx) algQuant(x []float64, k, nb int, gain float64) uint {
+	n := len(x)
+	if cap(ctx.scratch) < n {
+		ctx.scratch = make([]int, n)
+	}
+	iy := ctx.scratch[:n]
+	expRotation(x, 1, nb, k, ctx.spread)
+	pvqSearch(x, iy, k)
+	ctx.ec.enc.encodePulses(iy, k)
+	return ctx.resynth(x, iy, k, nb, gain)
+}
+
+// resynth sets x to the pulses iy of k pulses, normalized to gain and
+// spread, returning their collapse mask.
+func (ctx *bandCtx) resynth(x []float64, iy []int, k, nb int, gain float64) uint {
+	ryy := 0.0
+	for _, v := range iy {
+		ryy += float64(v * v)
+	}
+	g := gain / math.Sqrt(ryy)
+	for j, v := range iy {
+		x[j] = g * float64(v)
+	}
+	expRotation(x, -1, nb, k, ctx.spread)
+	return collapseMask(iy, nb)
+}
+
+// pvqSearch sets iy to the vector of k pulses of direction nearest that
+// of x, projecting x on the pyramid and adding the pulses left one at a
+// time, as the reference encoder does.  x is overwritten.
+func pvqSearch(x []float64, iy []int, k int) {
+	n := len(x)
+	neg := make([]bool, n)
+	y := make([]float64, n)
+	for j, v := range x {
+		neg[j] = v < 0
+		x[j] = math.Abs(v)
+		iy[j] = 0
+	}
+	xy, yy := 0.0, 0.0
+	left := k
+	if k > n>>1 {
+		sum := 0.0
+		for _, v := range x {
+			sum += v
+		}
+		if !(sum > 1e-15 && sum < 64) {
+			x[0] = 1
+			for j := 1; j < n; j++ {
+				x[j] = 0
+			}
+			sum = 1
+		}
+		r := (float64(k) + 0.8) / sum
+		for j, v := range x {
+			iy[j] = int(math.Floor(r * v))
+			y[j] = float64(iy[j])
+			yy += y[j] * y[j]
+			xy += v * y[j]
+			y[j] *= 2
+			left -= iy[j]
+		}
+	}
+	if left > n+3 {
+		t := float64(left)
+		yy += t*t + t*y[0]
+		iy[0] += left
+		left = 0
+	}
+	for ; left > 0; left-- {
+		yy++
+		best := 0
+		bestNum := (xy + x[0]) * (xy + x[0])
+		bestDen := yy + y[0]
+		for j := 1; j < n; j++ {
+			num := (xy + x[j]) * (xy + x[j])
+			den := yy + y[j]
+			if bestDen*num > den*bestNum {
+				best, bestNum, bestDen = j, num, den
+			}
+		}
+		xy += x[best]
+		yy += y[best]
+		y[best] += 2
+		iy[best]++
+	}
+	for j := range iy {
+		if neg[j] {
+			iy[j] = -iy[j]
+		}
+	}
+}
+
+// stereoITheta returns the angle, in units of pi/32768, of the energies
+// of the mid and side of the stereo band x, y or, if not stereo, of the
+// halves x and y of a band.
+func stereoITheta(x, y []float64, stereo bool) int {
+	emid, eside := 1e-15, 1e-15
+	for j := range x {
+		if stereo {
+			m := 0.5*x[j] + 0.5*y[j]
+			s := 0.5*x[j] - 0.5*y[j]
+			emid += m * m
+			eside += s * s
+		} else {
+			emid += x[j] * x[j]
+			eside += y[j] * y[j]
+		}
+	}
+	return int(math.Floor(0.5 + 16384*2/math.Pi*math.Atan2(math.Sqrt(eside), math.Sqrt(emid))))
+}
+
+// intensityStereo replaces x by the sum of the channels x and y of the
+// band, weighted by their amplitudes.
+func (ctx *bandCtx) intensityStereo(x, y []float64) {
+	l := ctx.bandE[ctx.i]
+	r := ctx.bandE[ctx.i+nbEBands]
+	norm := 1e-15 + math.Sqrt(1e-15+l*l+r*r)
+	a1, a2 := l/norm, r/norm
+	for j := range x {
+		x[j] = a1*x[j] + a2*y[j]
+	}
+}
+
+// stereoSplit replaces the channels x and y of a band by their mid and
+// side.
+func stereoSplit(x, y []float64) {
+	for j := range x {
+		l := math.Sqrt2 / 2 * x[j]
+		r := math.Sqrt2 / 2 * y[j]
+		x[j], y[j] = l+r, r-l
+	}
+}
+
+// collapseMask returns the mask of the blocks of iy with pulses.
+func collapseMask(iy []int, nb int) uint {
+	if nb <= 1 {
+		return 1
+	}
+	n0 := len(iy) / nb
+	var mask uint
+	for i := 0; i < nb; i++ {
+		for _, v := range iy[i*n0 : (i+1)*n0] {
+			if v != 0 {
+				mask |= 1 << uint(i)
+				break
+			}
+		}
+	}
+	return mask
+}
+
+var spreadFactor = [3]int{15, 10, 5}
+
+// expRotation applies the spreading rotation of x of k pulses in nb
+// blocks, or its inverse if dir is negative.
+func expRotation(x []float64, dir, nb, k, spread int) {
+	n := len(x)
+	if 2*k >= n || spread == spreadNone {
+		return
+	}
+	factor := spreadFactor[spread-1]
+	gain := float64(n) / float64(n+factor*k)
+	theta := 0.5 * gain * gain
+	c := math.Cos(0.5 * math.Pi * theta)
+	s := math.Cos(0.5 * math.Pi * (1 - theta))
+	stride2 := 0
+	if n >= 8*nb {
+		stride2 = 1
+		for (stride2*stride2+stride2)*nb+nb>>2 < n {
+			stride2++
+		}
+	}
+	l := n / nb
+	for i := 0; i < nb; i++ {
+		v := x[i*l : (i+1)*l]
+		if dir < 0 {
+			if stride2 != 0 {
+				expRotation1(v, stride2, s, c)
+			}
+			expRotation1(v, 1, c, s)
+		} else {
+			expRotation1(v, 1, c, -s)
+			if stride2 != 0 {
+				expRotation1(v, stride2, s, -c)
+			}
+		}
+	}
+}
+
+func expRotation1(x []float64, stride int, c, s float64) {
+	n := len(x)
+	for i := 0; i < n-stride; i++ {
+		x1, x2 := x[i], x[i+stride]
+		x[i+stride] = c*x2 + s*x1
+		x[i] = c*x1 - s*x2
+	}
+	for i := n - 2*stride - 1; i >= 0; i-- {
+		x1, x2 := x[i], x[i+stride]
+		x[i+stride] = c*x2 + s*x1
+		x[i] = c*x1 - s*x2
+	}
+}
+
+// renormalize scales x to norm gain.
+func renormalize(x []float64, gain float64) {
+	e := 1e-15
+	for _, v := range x {
+		e += v * v
+	}
+	g := gain / math.Sqrt(e)
+	for i := range x {
+		x[i] *= g
+	}
+}
+
+// stereoMerge converts the mid x, of gain mid, and side y of a band into
+// its left and right channels.
+func stereoMerge(x, y []float64, mid float64) {
+	xp, side := 0.0, 0.0
+	for j := range x {
+		xp += y[j] * x[j]
+		side += y[j] * y[j]
+	}
+	xp *= mid
+	el := mid*mid + side - 2*xp
+	er := mid*mid + side + 2*xp
+	if er < 6e-4 || el < 6e-4 {
+		copy(y, x)
+		return
+	}
+	lg := 1 / math.Sqrt(el)
+	rg := 1 / math.Sqrt(er)
+	for j := range x {
+		l := mid * x[j]
+		r := y[j]
+		x[j] = lg * (l - r)
+		y[j] = rg * (l + r)
+	}
+}
+
+// haar1 applies the Haar transform to pairs of the n0 bins, in stride
+// interleaved blocks, of x.
+func haar1(x []float64, n0, stride int) {
+	n0 >>= 1
+	for i := 0; i < stride; i++ {
+		for j := 0; j < n0; j++ {
+			a := math.Sqrt2 / 2 * x[stride*2*j+i]
+			b := math.Sqrt2 / 2 * x[stride*(2*j+1)+i]
+			x[stride*2*j+i] = a + b
+			x[stride*(2*j+1)+i] = a - b
+		}
+	}
+}
+
+var orderyTable = [...]int{
+	1, 0,
+	3, 0, 2, 1,
+	7, 0, 4, 3, 6, 1, 5, 2,
+	15, 0, 8, 7, 12, 3, 11, 4, 14, 1, 9, 6, 13, 2, 10, 5}
+
+// deinterleaveHadamard reorders the stride interleaved blocks of n0 bins
+// of x to be consecutive, in Hadamard order if hadamard.
+func deinterleaveHadamard(x []float64, n0, stride int, hadamard bool) {
+	n := n0 * stride
+	t := make([]float64, n)
+	for i := 0; i < stride; i++ {
+		o := i
+		if hadamard {
+			o = orderyTable[stride-2+i]
+		}
+		for j := 0; j < n0; j++ {
+			t[o*n0+j] = x[j*stride+i]
+		}
+	}
+	copy(x, t)
+}
+
+// interleaveHadamard undoes deinterleaveHadamard.
+func interleaveHadamard(x []float64, n0, stride int, hadamard bool) {
+	n := n0 * stride
+	t := make([]float64, n)
+	for i := 0; i < stride; i++ {
+		o := i
+		if hadamard {
+			o = orderyTable[stride-2+i]
+		}
+		for j := 0; j < n0; j++ {
+			t[j*stride+i] = x[o*n0+j]
+		}
+	}
+	copy(x, t)
+}
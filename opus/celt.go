@@ -0,0 +1,322 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import "math"
+
+// The CELT mode of Opus, of 48kHz, as in RFC 6716 section 4.3 and the
+// reference implementation.
+const (
+	nbEBands      = 21
+	shortMdctSize = 120
+	maxLM         = 3
+	overlap       = 120
+	maxFineBits   = 8
+	fineOffset    = 21
+	qthetaOffset  = 4
+	qthetaOffset2 = 16 // qthetaOffset of stereo bands of 2 bins
+	allocSteps    = 6
+	maxPseudo     = 40
+	logMaxPseudo  = 6
+	combMinPeriod = 15
+	preemph       = 0.8500061035
+	sigScale      = 32768
+)
+
+// Spreading decisions.
+const (
+	spreadNone = iota
+	spreadLight
+	spreadNormal
+	spreadAggressive
+)
+
+// eBands are the edges of the bands in units of 8 bins of the 5ms MDCT.
+var eBands = [nbEBands + 1]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 10, 12, 14, 16, 20, 24, 28, 34, 40, 48, 60, 78, 100}
+
+// eMeans are the mean log2 energies of the bands.
+var eMeans = [nbEBands]float64{
+	6.4375, 6.25, 5.75, 5.3125, 5.0625, 4.8125, 4.5, 4.375, 4.875, 4.6875,
+	4.5625, 4.4375, 4.875, 4.625, 4.3125, 4.5, 4.375, 4.625, 4.75, 4.4375,
+	3.75}
+
+// logN are the log2 widths of the bands in 1/8 bits.
+var logN = [nbEBands]int{0, 0, 0, 0, 0, 0, 0, 0, 8, 8, 8, 8, 16, 16, 16, 21, 21, 24, 29, 34, 36}
+
+// allocVectors are the static bit allocations of RFC 6716 table 57, in
+// 1/32 bits per MDCT bin.
+var allocVectors = [11][nbEBands]int{
+	{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	{90, 80, 75, 69, 63, 56, 49, 40, 34, 29, 20, 18, 10, 0, 0, 0, 0, 0, 0, 0, 0},
+	{110, 100, 90, 84, 78, 71, 65, 58, 51, 45, 39, 32, 26, 20, 12, 0, 0, 0, 0, 0, 0},
+	{118, 110, 103, 93, 86, 80, 75, 70, 65, 59, 53, 47, 40, 31, 23, 15, 4, 0, 0, 0, 0},
+	{126, 119, 112, 104, 95, 89, 83, 78, 72, 66, 60, 54, 47, 39, 32, 25, 17, 12, 1, 0, 0},
+	{134, 127, 120, 114, 103, 97, 91, 85, 78, 72, 66, 60, 54, 47, 41, 35, 29, 23, 16, 10, 1},
+	{144, 137, 130, 124, 113, 107, 101, 95, 88, 82, 76, 70, 64, 57, 51, 45, 39, 33, 26, 15, 1},
+	{152, 145, 138, 132, 123, 117, 111, 105, 98, 92, 86, 80, 74, 67, 61, 55, 49, 43, 36, 20, 1},
+	{162, 155, 148, 142, 133, 127, 121, 115, 108, 102, 96, 90, 84, 77, 71, 65, 59, 53, 46, 30, 1},
+	{172, 165, 158, 152, 143, 137, 131, 125, 118, 112, 106, 100, 94, 87, 81, 75, 69, 63, 56, 45, 20},
+	{200, 200, 200, 200, 200, 200, 200, 200, 198, 193, 188, 183, 178, 173, 168, 163, 158, 153, 148, 129, 104}}
+
+// log2FracTable are log2 of 1 to 24 in 1/8 bits, rounded up.
+var log2FracTable = [24]int{0, 8, 13, 16, 19, 21, 23, 24, 26, 27, 28, 29, 30, 31, 32, 32, 33, 34, 34, 35, 36, 36, 37, 37}
+
+var (
+	spreadICDF = []uint8{25, 23, 2, 0}
+	trimICDF   = []uint8{126, 124, 119, 109, 87, 41, 19, 9, 4, 2, 0}
+	tapsetICDF = []uint8{2, 1, 0}
+)
+
+// tfSelectTable gives the time-frequency resolution changes of bands,
+// by LM, transient flag, tf_select and tf_res.
+var tfSelectTable = [4][8]int{
+	{0, -1, 0, -1, 0, -1, 0, -1},
+	{0, -1, 0, -2, 1, 0, 1, -1},
+	{0, -2, 0, -3, 2, 0, 1, -1},
+	{0, -2, 0, -3, 3, 0, 1, -1}}
+
+// endBands are the numbers of coded bands by Bandwidth.
+var endBands = [...]int{Narrowband: 13, Mediumband: 17, Wideband: 17, SuperWideband: 19, Fullband: 21}
+
+// window is the power complementary window of the MDCT overlap.
+var window [overlap]float64
+
+// pulseCache holds, for each band of each LM from -1 to maxLM, the
+// number of 1/8 bits less 1 of coding 1 to maxK pseudo pulses, preceded
+// by maxK, as in the reference implementation.
+var pulseCache struct {
+	index [(maxLM + 2) * nbEBands]int
+	bits  []int
+	caps  [(maxLM + 1) * 2 * nbEBands]int
+}
+
+func init() {
+	for i := range window {
+		s := math.Sin(0.5 * math.Pi * (float64(i) + 0.5) / overlap)
+		window[i] = math.Sin(0.5 * math.Pi * s * s)
+	}
+	initPulseCache()
+	initCaps()
+}
+
+// getPulses returns the number of pulses of pseudo pulse count i.
+func getPulses(i int) int {
+	if i < 8 {
+		return i
+	}
+	return (8 + i&7) << uint(i>>3-1)
+}
+
+func initPulseCache() {
+	type entry struct{ n, k, i int }
+	var entries []entry
+	curr := 0
+	c := &pulseCache
+	for i := 0; i <= maxLM+1; i++ {
+		for j := 0; j < nbEBands; j++ {
+			n := (eBands[j+1] - eBands[j]) << uint(i) >> 1
+			c.index[i*nbEBands+j] = -1
+		same:
+			for k := 0; k <= i; k++ {
+				for m := 0; m < nbEBands && (k != i || m < j); m++ {
+					if n == (eBands[m+1]-eBands[m])<<uint(k)>>1 {
+						c.index[i*nbEBands+j] = c.index[k*nbEBands+m]
+						break same
+					}
+				}
+			}
+			if c.index[i*nbEBands+j] == -1 && n != 0 {
+				k := 0
+				for k < maxPseudo && pvqFits32(n, getPulses(k+1)) {
+					k++
+				}
+				c.index[i*nbEBands+j] = curr
+				entries = append(entries, entry{n, k, curr})
+				curr += k + 1
+			}
+		}
+	}
+	c.bits = make([]int, curr)
+	for _, e := range entries {
+		b := requiredBits(e.n, getPulses(e.k))
+		p := c.bits[e.i:]
+		p[0] = e.k
+		for j := 1; j <= e.k; j++ {
+			p[j] = b[getPulses(j)] - 1
+		}
+	}
+}
+
+// requiredBits returns the number of 1/8 bits of coding 0 to maxK pulses
+// in n dimensions.
+func requiredBits(n, maxK int) []int {
+	b := make([]int, maxK+1)
+	if n == 1 {
+		for k := 1; k <= maxK; k++ {
+			b[k] = 1 << bitRes
+		}
+		return b
+	}
+	for k := 1; k <= maxK; k++ {
+		b[k] = log2Frac(pvqV(n, k), bitRes)
+	}
+	return b
+}
+
+// log2Frac returns log2 of v with frac fractional bits, rounded up.
+func log2Frac(v uint32, frac uint) int {
+	l := ilog(v)
+	if v&(v-1) == 0 {
+		return int(l-1) << frac
+	}
+	if l > 16 {
+		v = (v-1)>>(l-16) + 1
+	} else {
+		v <<= 16 - l
+	}
+	r := int(l-1) << frac
+	for {
+		b := v >> 16
+		r += int(b) << frac
+		v = (v + b) >> b
+		v = (v*v + 0x7fff) >> 15
+		if frac == 0 {
+			break
+		}
+		frac--
+	}
+	if v > 0x8000 {
+		r++
+	}
+	return r
+}
+
+// initCaps computes the maximum allocations of the bands, in 1/32 bits
+// per bin less 64, by LM and number of channels.
+func initCaps() {
+	for lm := 0; lm <= maxLM; lm++ {
+		for nC := 1; nC <= 2; nC++ {
+			for j := 0; j < nbEBands; j++ {
+				pulseCache.caps[nbEBands*(2*lm+nC-1)+j] = bandCap(lm, nC, j)
+			}
+		}
+	}
+}
+
+func bandCap(lm, nC, j int) int {
+	n0 := eBands[j+1] - eBands[j]
+	var maxBits int
+	if n0<<uint(lm) == 1 {
+		maxBits = nC * (1 + maxFineBits) << bitRes
+	} else {
+		lm0 := 0
+		if n0 > 2 {
+			n0 >>= 1
+			lm0--
+		} else if n0 <= 1 {
+			lm0 = lm
+			if lm0 > 1 {
+				lm0 = 1
+			}
+			n0 <<= uint(lm0)
+		}
+		p := pulseCache.bits[pulseCache.index[(lm0+1)*nbEBands+j]:]
+		maxBits = p[p[0]] + 1
+		n := n0
+		for k := 0; k < lm-lm0; k++ {
+			maxBits <<= 1
+			offset := (logN[j]+(lm0+k)<<bitRes)>>1 - qthetaOffset
+			num := 459 * ((2*n-1)*offset + maxBits)
+			den := (2*n-1)<<9 - 459
+			maxBits += minInt((num+den>>1)/den, 57)
+			n <<= 1
+		}
+		if nC == 2 {
+			maxBits <<= 1
+			off, ndof, p0, qmax := qthetaOffset, 2*n-1, 487, 61
+			if n == 2 {
+				off, ndof, p0, qmax = qthetaOffset2, 2*n-2, 512, 64
+			}
+			offset := (logN[j]+lm<<bitRes)>>1 - off
+			num := p0 * (maxBits + ndof*offset)
+			den := ndof<<9 - p0
+			maxBits += minInt((num+den>>1)/den, qmax)
+		}
+		ndof := nC * n
+		if nC == 2 && n > 2 {
+			ndof++
+		}
+		offset := (logN[j]+lm<<bitRes)>>1 - fineOffset
+		if n == 2 {
+			offset += 1 << bitRes >> 2
+		}
+		num := maxBits + ndof*offset
+		den := (ndof - 1) << bitRes
+		maxBits += nC * minInt((num+den>>1)/den, maxFineBits) << bitRes
+	}
+	return 4*maxBits/(nC*(eBands[j+1]-eBands[j])<<uint(lm)) - 64
+}
+
+// initBandCaps returns the maximum allocations of the bands in 1/8 bits.
+func initBandCaps(lm, nC int) [nbEBands]int {
+	var caps [nbEBands]int
+	for i := range caps {
+		n := (eBands[i+1] - eBands[i]) << uint(lm)
+		caps[i] = (pulseCache.caps[nbEBands*(2*lm+nC-1)+i] + 64) * nC * n >> 2
+	}
+	return caps
+}
+
+// bits2Pulses returns the pseudo pulse count of band of LM lm whose cost
+// is closest to b 1/8 bits.
+func bits2Pulses(band, lm, b int) int {
+	cache := pulseCache.bits[pulseCache.index[(lm+1)*nbEBands+band]:]
+	lo, hi := 0, cache[0]
+	b--
+	for i := 0; i < logMaxPseudo; i++ {
+		mid := (lo + hi + 1) >> 1
+		if cache[mid] >= b {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	l := -1
+	if lo != 0 {
+		l = cache[lo]
+	}
+	if b-l <= cache[hi]-b {
+		return lo
+	}
+	return hi
+}
+
+// pulses2Bits returns the cost in 1/8 bits of q pseudo pulses.
+func pulses2Bits(band, lm, q int) int {
+	if q == 0 {
+		return 0
+	}
+	return pulseCache.bits[pulseCache.index[(lm+1)*nbEBands+band]+q] + 1
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
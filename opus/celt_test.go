@@ -0,0 +1,509 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"zikichombo.org/codec/codectest"
+)
+
+// TestRangeCoder checks that the range decoder decodes the symbols and
+// raw bits of the range encoder, telling the same positions.
+func TestRangeCoder(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	type sym struct {
+		kind int
+		v    int
+		p    uint32
+	}
+	for frame := 0; frame < 200; frame++ {
+		buf := make([]byte, 20+rnd.Intn(200))
+		var syms []sym
+		var tells []int
+		var e rangeEncoder
+		e.init(buf)
+		for e.tell()+64 < len(buf)*8 {
+			s := sym{kind: rnd.Intn(5)}
+			switch s.kind {
+			case 0:
+				s.p = uint32(1 + rnd.Intn(15))
+				s.v = rnd.Intn(2)
+				e.bitLogp(s.v, uint(s.p))
+			case 1:
+				s.v = rnd.Intn(3)
+				e.icdf(s.v, trimICDF, 7)
+			case 2:
+				s.p = uint32(2 + rnd.Intn(1<<uint(1+rnd.Intn(31))))
+				s.v = rnd.Intn(int(s.p))
+				e.uint(uint32(s.v), s.p)
+			case 3:
+				s.p = uint32(1 + rnd.Intn(25))
+				s.v = rnd.Intn(1 << s.p)
+				e.bits(uint32(s.v), uint(s.p))
+			case 4:
+				s.v = e.laplace(rnd.Intn(41)-20, 72<<7, 127<<6)
+			}
+			syms = append(syms, s)
+			tells = append(tells, e.tellFrac())
+		}
+		if !e.done() {
+			t.Fatalf("frame %d: overflow", frame)
+		}
+		var d rangeDecoder
+		d.init(buf)
+		for i, s := range syms {
+			var v int
+			switch s.kind {
+			case 0:
+				v = d.bitLogp(uint(s.p))
+			case 1:
+				v = d.icdf(trimICDF, 7)
+			case 2:
+				v = int(d.uint(s.p))
+			case 3:
+				v = int(d.bits(uint(s.p)))
+			case 4:
+				v = d.laplace(72<<7, 127<<6)
+			}
+			if v != s.v || d.tellFrac() != tells[i] {
+				t.Fatalf("frame %d symbol %d kind %d: got %d at %d, want %d at %d", frame, i, s.kind, v, d.tellFrac(), s.v, tells[i])
+			}
+		}
+		if d.rng != e.rng {
+			t.Errorf("frame %d: final range %x, want %x", frame, d.rng, e.rng)
+		}
+	}
+}
+
+func TestPVQCounts(t *testing.T) {
+	// largest k and n for which V(n, k) fits in 32 bits, from the
+	// reference implementation.
+	maxN := map[int]int{3: 1476, 4: 283, 5: 109, 6: 60, 7: 40, 8: 29, 9: 24, 10: 20, 11: 18, 12: 16, 13: 14, 14: 13}
+	maxK := map[int]int{4: 1172, 5: 238, 6: 95, 7: 53, 8: 36, 9: 27, 10: 22, 11: 18, 12: 16, 13: 15}
+	for k, n := range maxN {
+		if n >= maxPVQN {
+			continue
+		}
+		if !pvqFits32(n, k) || pvqFits32(n+1, k) {
+			t.Errorf("V(%d, %d) limit", n, k)
+		}
+	}
+	for n, k := range maxK {
+		if k >= maxPVQK {
+			continue
+		}
+		if !pvqFits32(n, k) || pvqFits32(n, k+1) {
+			t.Errorf("V(%d, %d) limit", n, k)
+		}
+	}
+}
+
+func TestPVQVector(t *testing.T) {
+	for n := 1; n <= 6; n++ {
+		for k := 0; k <= 6; k++ {
+			seen := map[[6]int]bool{}
+			y := make([]int, n)
+			for i := uint64(0); i < uint64(pvqV(n, k)); i++ {
+				pvqVector(y, k, i)
+				var key [6]int
+				s := 0
+				for j, v := range y {
+					key[j] = v
+					if v < 0 {
+						v = -v
+					}
+					s += v
+				}
+				if s != k || seen[key] {
+					t.Fatalf("n %d k %d index %d: %v", n, k, i, y)
+				}
+				seen[key] = true
+			}
+		}
+	}
+}
+
+func TestPVQIndex(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		n := 1 + rnd.Intn(maxPVQN)
+		k := 1 + rnd.Intn(maxPVQK)
+		for !pvqFits32(n, k) {
+			k /= 2
+		}
+		y := make([]int, n)
+		for j := 0; j < k; j++ {
+			y[rnd.Intn(n)]++
+		}
+		for j := range y {
+			if rnd.Intn(2) == 1 {
+				y[j] = -y[j]
+			}
+		}
+		idx := pvqIndex(y, k)
+		if idx >= uint64(pvqV(n, k)) {
+			t.Fatalf("n %d k %d: index %d of %d", n, k, idx, pvqV(n, k))
+		}
+		got := make([]int, n)
+		pvqVector(got, k, idx)
+		for j := range y {
+			if got[j] != y[j] {
+				t.Fatalf("n %d k %d: %v gave %v", n, k, y, got)
+			}
+		}
+	}
+}
+
+func TestCaps(t *testing.T) {
+	// the first rows of the caps of the reference implementation, of LM
+	// 0 and 1, mono and stereo.
+	want := [][nbEBands]int{
+		{224, 224, 224, 224, 224, 224, 224, 224, 160, 160, 160, 160, 185, 185, 185, 178, 178, 168, 134, 61, 37},
+		{224, 224, 224, 224, 224, 224, 224, 224, 240, 240, 240, 240, 207, 207, 207, 198, 198, 183, 144, 66, 40},
+		{160, 160, 160, 160, 160, 160, 160, 160, 185, 185, 185, 185, 193, 193, 193, 183, 183, 172, 138, 64, 38}}
+	for r, w := range want {
+		for i, c := range w {
+			if got := pulseCache.caps[r*nbEBands+i]; got != c {
+				t.Errorf("cap %d of row %d: got %d want %d", i, r, got, c)
+			}
+		}
+	}
+	for i, c := range pulseCache.caps {
+		if c < 0 || c > 255 {
+			t.Errorf("cap %d: %d", i, c)
+		}
+	}
+	for i, b := range pulseCache.bits {
+		if b < 0 || b > 255 {
+			t.Errorf("cache %d: %d", i, b)
+		}
+	}
+}
+
+func TestFFT(t *testing.T) {
+	for _, m := range mdcts {
+		n := len(m.buf)
+		z := make([]complex128, n)
+		want := make([]complex128, n)
+		for i := range z {
+			z[i] = complex(rand.Float64(), rand.Float64())
+		}
+		for k := range want {
+			for j, v := range z {
+				want[k] += v * cmplx.Rect(1, -2*math.Pi*float64(j*k)/float64(n))
+			}
+		}
+		m.fft(z)
+		for k := range z {
+			if cmplx.Abs(z[k]-want[k]) > 1e-9 {
+				t.Fatalf("fft %d: bin %d got %v want %v", n, k, z[k], want[k])
+			}
+		}
+	}
+}
+
+// TestMDCT checks that backward reconstructs the input of forward,
+// delayed by the overlap.
+func TestMDCT(t *testing.T) {
+	for _, m := range mdcts {
+		n := m.n
+		x := make([]float64, overlap+8*n)
+		for i := overlap; i < len(x); i++ {
+			x[i] = rand.Float64() - 0.5
+		}
+		coefs := make([]float64, n)
+		out := make([]float64, n+overlap)
+		for f := 0; f < 7; f++ {
+			m.forward(x[f*n:(f+1)*n+overlap], coefs, 1)
+			copy(out, out[n:n+overlap/2])
+			m.backward(coefs, 1, out)
+			for i, v := range out[:n] {
+				if f > 0 && math.Abs(v-x[f*n+i]) > 1e-9 {
+					t.Fatalf("mdct %d frame %d sample %d: got %v want %v", n, f, i, v, x[f*n+i])
+				}
+			}
+		}
+	}
+}
+
+// tone returns sample i at 48kHz of channel c of the test signal.
+func tone(c, i int) float64 {
+	x := float64(i) / SampleRate
+	return 0.3*math.Sin(2*math.Pi*440*float64(c+1)*x) + 0.1*math.Sin(2*math.Pi*1234*x+float64(c))
+}
+
+// TestCELTEncode checks the signal to noise ratio of CELT frames encoded
+// and decoded, of all frame sizes, input rates and numbers of channels.
+func TestCELTEncode(t *testing.T) {
+	for _, rate := range []int{8000, 16000, 24000, 48000} {
+		for nC := 1; nC <= 2; nC++ {
+			for _, fs := range []int{120, 240, 480, 960, 1920, 2880} {
+				fe, err := newCELTFrameEncoder(rate, nC, &EncoderOptions{FrameSize: fs, Bitrate: 64000 * nC})
+				if err != nil {
+					t.Fatal(err)
+				}
+				fd, _ := newOpusFrameDecoder(nC)
+				u := SampleRate / rate
+				dst := make([]float64, nC*MaxPacketDuration)
+				var sig, noise float64
+				for pos := 0; pos < SampleRate/2/u; pos += fs / u {
+					pcm := make([]float64, nC*fs/u)
+					for c := 0; c < nC; c++ {
+						for i := 0; i < fs/u; i++ {
+							pcm[c*fs/u+i] = tone(c, (pos+i)*u)
+						}
+					}
+					pkt, err := fe.Encode(pcm, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					n, err := fd.Decode(pkt, dst)
+					if err != nil || n != fs {
+						t.Fatalf("rate %d channels %d frame size %d: decoded %d: %v", rate, nC, fs, n, err)
+					}
+					for c := 0; c < nC; c++ {
+						for i, v := range dst[c*n : (c+1)*n] {
+							j := pos*u + i - fe.Lookahead()
+							if j < SampleRate/10 {
+								continue
+							}
+							w := tone(c, j)
+							sig += w * w
+							noise += (v - w) * (v - w)
+						}
+					}
+				}
+				// about the ratios of the reference encoder at its lowest
+				// complexity, which fall with the frame size.
+				min := map[int]float64{120: 20, 240: 25, 480: 27}[fs]
+				if fs >= 960 {
+					min = 28
+				}
+				if snr := 10 * math.Log10(sig/noise); snr < min {
+					t.Errorf("rate %d channels %d frame size %d: snr %.1fdB", rate, nC, fs, snr)
+				}
+			}
+		}
+	}
+}
+
+// TestCELTSilence checks that silence is coded in short frames.
+func TestCELTSilence(t *testing.T) {
+	fe, _ := newCELTFrameEncoder(48000, 2, &EncoderOptions{FrameSize: 960})
+	fd, _ := newOpusFrameDecoder(2)
+	dst := make([]float64, 2*MaxPacketDuration)
+	for i := 0; i < 3; i++ {
+		pkt, err := fe.Encode(make([]float64, 2*960), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pkt) != 3 {
+			t.Errorf("silent packet of %d bytes", len(pkt))
+		}
+		if _, err := fd.Decode(pkt, dst); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range dst[:2*960] {
+			if math.Abs(v) > 1e-6 {
+				t.Fatalf("decoded silence as %g", v)
+			}
+		}
+	}
+}
+
+// TestCELTReference checks the streams of testdata, coded by the frame
+// encoder and decoded by the reference decoder as described in
+// testdata/README.md: that the frame encoder still codes them, that the
+// frame decoder decodes the final ranges and samples of the reference
+// decoder, and that the samples of the reference decoder are close to
+// the coded signal.
+func TestCELTReference(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		nC, fs int
+		min    float64
+	}{
+		{"celt-stereo-20ms", 2, 960, 28},
+		{"celt-mono-5ms", 1, 240, 25},
+	} {
+		bit, err := ioutil.ReadFile(filepath.Join("testdata", tc.name+".bit"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pcm, err := ioutil.ReadFile(filepath.Join("testdata", tc.name+".pcm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nC, fs := tc.nC, tc.fs
+		fe, _ := newCELTFrameEncoder(SampleRate, nC, &EncoderOptions{FrameSize: fs, Bitrate: 64000 * nC, Complexity: 10})
+		fd, _ := newOpusFrameDecoder(nC)
+		in := make([]float64, nC*fs)
+		dst := make([]float64, nC*MaxPacketDuration)
+		var sig, noise float64
+		pos := 0
+		for ; len(bit) >= 8; pos += fs {
+			n, rng := binary.BigEndian.Uint32(bit), binary.BigEndian.Uint32(bit[4:])
+			want := bit[8 : 8+n]
+			bit = bit[8+n:]
+			for c := 0; c < nC; c++ {
+				for i := 0; i < fs; i++ {
+					in[c*fs+i] = tone(c, pos+i)
+				}
+			}
+			pkt, err := fe.Encode(in, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(pkt, want) {
+				t.Fatalf("%s: packet %d differs", tc.name, pos/fs)
+			}
+			if _, err := fd.Decode(want, dst); err != nil {
+				t.Fatal(err)
+			}
+			if got := fd.(*frameDecoder).rng; got != rng {
+				t.Fatalf("%s: packet %d final range %x want %x", tc.name, pos/fs, got, rng)
+			}
+			for c := 0; c < nC; c++ {
+				for i, v := range dst[c*fs : (c+1)*fs] {
+					ref := float64(int16(binary.LittleEndian.Uint16(pcm[2*((pos+i)*nC+c):])))
+					got := math.Max(-32768, math.Min(32767, math.Floor(0.5+v*32768)))
+					if math.Abs(got-ref) > 1 {
+						t.Fatalf("%s: sample %d channel %d got %g want %g", tc.name, pos+i, c, got, ref)
+					}
+					j := pos + i - fe.Lookahead()
+					if j < SampleRate/10 {
+						continue
+					}
+					w := tone(c, j)
+					sig += w * w
+					noise += (ref/32768 - w) * (ref/32768 - w)
+				}
+			}
+		}
+		if len(pcm) != 2*nC*pos {
+			t.Errorf("%s: %d samples for %d frames", tc.name, len(pcm)/2/nC, pos/fs)
+		}
+		if snr := 10 * math.Log10(sig/noise); snr < tc.min {
+			t.Errorf("%s: snr %.1fdB", tc.name, snr)
+		}
+	}
+}
+
+// TestCELTComplexity checks that the complexity changes the coding of
+// frames.
+func TestCELTComplexity(t *testing.T) {
+	pcm := make([]float64, 2*960)
+	for c := 0; c < 2; c++ {
+		for i := 0; i < 960; i++ {
+			pcm[c*960+i] = tone(c, i)
+		}
+	}
+	var pkts [2][]byte
+	for k, cx := range []int{0, 10} {
+		fe, _ := newCELTFrameEncoder(48000, 2, &EncoderOptions{FrameSize: 960, Bitrate: 128000, Complexity: cx})
+		for i := 0; i < 4; i++ {
+			pkt, err := fe.Encode(pcm, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pkts[k] = append(pkts[k], pkt...)
+		}
+	}
+	if bytes.Equal(pkts[0], pkts[1]) {
+		t.Errorf("complexity 0 and 10 coded the same packets")
+	}
+}
+
+// TestCELTOgg checks Ogg Opus streams encoded and decoded end to end with
+// the frame encoder and decoder which package opus registers.
+func TestCELTOgg(t *testing.T) {
+	for _, v := range []form{{48000, 2}, {16000, 1}} {
+		n := v.rate + 123
+		x := make([]float64, v.nC*n)
+		u := SampleRate / v.rate
+		for c := 0; c < v.nC; c++ {
+			for i := 0; i < n; i++ {
+				x[c*n+i] = tone(c, i*u)
+			}
+		}
+		w := codectest.NewFile(nil)
+		encode(t, w, v, x, nil)
+		d, err := NewDecoder(codectest.NewFile(w.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Head().PreSkip != overlap || d.Len() != int64(n*u) {
+			t.Errorf("%+v: pre-skip %d len %d", v, d.Head().PreSkip, d.Len())
+		}
+		buf := make([]float64, v.nC*1000)
+		var sig, noise float64
+		f := 0
+		for {
+			k, err := d.Receive(buf)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for c := 0; c < v.nC; c++ {
+				for i, s := range buf[c*k : (c+1)*k] {
+					if f+i < SampleRate/10 {
+						continue
+					}
+					w := tone(c, f+i)
+					sig += w * w
+					noise += (s - w) * (s - w)
+				}
+			}
+			f += k
+		}
+		if f != n*u {
+			t.Errorf("%+v: decoded %d of %d frames", v, f, n*u)
+		}
+		if snr := 10 * math.Log10(sig/noise); snr < 15 {
+			t.Errorf("%+v: snr %.1fdB", v, snr)
+		}
+	}
+}
+
+// TestCELTDecodeRandom checks that the CELT decoder decodes arbitrary
+// frames of all sizes, bandwidths and channels to finite samples.
+func TestCELTDecodeRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for nC := 1; nC <= 2; nC++ {
+		fd, _ := newOpusFrameDecoder(nC)
+		dst := make([]float64, nC*MaxPacketDuration)
+		for i := 0; i < 400; i++ {
+			toc := byte(16+rnd.Intn(16)) << 3
+			if rnd.Intn(2) == 1 {
+				toc |= 4
+			}
+			p := make([]byte, 1+rnd.Intn(300))
+			rnd.Read(p[1:])
+			p[0] = toc
+			n, err := fd.Decode(p, dst)
+			if err != nil {
+				t.Fatalf("packet %d: %v", i, err)
+			}
+			if n != TOC(toc).FrameSize() {
+				t.Fatalf("packet %d: %d samples", i, n)
+			}
+			for _, v := range dst[:nC*n] {
+				if math.IsNaN(v) || math.IsInf(v, 0) {
+					t.Fatalf("packet %d: sample %v", i, v)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,452 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"math"
+
+	"zikichombo.org/codec"
+)
+
+// decodeBufferSize is the number of past samples kept per channel, for
+// the postfilter.
+const decodeBufferSize = 2048
+
+// celtDecoder decodes CELT frames, as in RFC 6716 section 4.3.
+type celtDecoder struct {
+	nC int // output channels
+
+	mem        [2][]float64 // past samples and overlap, by channel
+	oldE       [2 * nbEBands]float64
+	oldLogE    [2 * nbEBands]float64
+	oldLogE2   [2 * nbEBands]float64
+	background [2 * nbEBands]float64
+	preemphMem [2]float64
+	rng        uint32
+
+	// The concealment state: the duration of the losses, in 2.5ms, and
+	// the pitch period and LPC filter of the last samples, by channel.
+	lossDuration int
+	skipPLC      bool
+	lastPitch    int
+	lpc          [2][celtLPCOrder]float64
+
+	pfPeriod, pfPeriodOld int
+	pfGain, pfGainOld     float64
+	pfTapset, pfTapsetOld int
+
+	dec   rangeDecoder
+	ec    celtCoder
+	alloc allocation
+	x     []float64
+	freq  []float64
+	masks [2 * nbEBands]uint
+}
+
+func newCELTDecoder(nC int) *celtDecoder {
+	d := &celtDecoder{
+		nC:   nC,
+		x:    make([]float64, 2*shortMdctSize<<maxLM),
+		freq: make([]float64, 2*shortMdctSize<<maxLM)}
+	for c := 0; c < nC; c++ {
+		d.mem[c] = make([]float64, decodeBufferSize+overlap)
+	}
+	d.reset()
+	return d
+}
+
+func (d *celtDecoder) reset() {
+	for c := 0; c < d.nC; c++ {
+		for i := range d.mem[c] {
+			d.mem[c][i] = 0
+		}
+	}
+	for i := range d.oldE {
+		d.oldE[i] = 0
+		d.oldLogE[i] = -28
+		d.oldLogE2[i] = -28
+		d.background[i] = 0
+	}
+	d.preemphMem = [2]float64{}
+	d.rng = 0
+	d.lossDuration, d.skipPLC = 0, true
+	d.pfPeriod, d.pfPeriodOld = 0, 0
+	d.pfGain, d.pfGainOld = 0, 0
+	d.pfTapset, d.pfTapsetOld = 0, 0
+}
+
+// decode decodes the CELT frame data of stream channels sc, LM lm and
+// bands start to end, putting the samples of channel c at
+// dst[c*stride+i], i < 120<<lm.  A frame of at most 1 byte is lost, and
+// concealed.
+func (d *celtDecoder) decode(data []byte, sc, lm, start, end int, dst []float64, stride int) error {
+	if len(data) <= 1 {
+		d.conceal(lm, start, end, dst, stride)
+		return nil
+	}
+	d.dec.init(data)
+	return d.decodeFrom(&d.dec, sc, lm, start, end, dst, stride)
+}
+
+// decodeFrom is decode reading the frame from dec, which may have
+// decoded the SILK frames of a hybrid frame before.
+func (d *celtDecoder) decodeFrom(dec *rangeDecoder, sc, lm, start, end int, dst []float64, stride int) error {
+	n := shortMdctSize << uint(lm)
+	nBytes := len(dec.buf)
+	// The pitch concealment needs two frames received in a row.
+	d.skipPLC = d.lossDuration != 0
+	d.ec.dec = dec
+	nC := sc
+	oldE := d.oldE[:]
+	if nC == 1 {
+		for i := 0; i < nbEBands; i++ {
+			oldE[i] = math.Max(oldE[i], oldE[nbEBands+i])
+		}
+	}
+	totalBits := nBytes * 8
+	tell := dec.tell()
+	silence := false
+	if tell >= totalBits {
+		silence = true
+	} else if tell == 1 {
+		silence = dec.bitLogp(15) == 1
+	}
+	if silence {
+		tell = totalBits
+		dec.nBitsTotal += tell - dec.tell()
+	}
+	pfGain := 0.0
+	pfPitch, pfTapset := 0, 0
+	if start == 0 && tell+16 <= totalBits {
+		if dec.bitLogp(1) == 1 {
+			octave := dec.uint(6)
+			pfPitch = 16<<octave + int(dec.bits(4+uint(octave))) - 1
+			qg := dec.bits(3)
+			if dec.tell()+2 <= totalBits {
+				pfTapset = dec.icdf(tapsetICDF, 2)
+			}
+			pfGain = 0.09375 * float64(qg+1)
+		}
+		tell = dec.tell()
+	}
+	transient := false
+	if lm > 0 && tell+3 <= totalBits {
+		transient = dec.bitLogp(3) == 1
+		tell = dec.tell()
+	}
+	intra := false
+	if tell+3 <= totalBits {
+		intra = dec.bitLogp(3) == 1
+	}
+	dec.unquantCoarseEnergy(oldE, start, end, intra, nC, lm)
+	var tfRes [nbEBands]int
+	dec.tfDecode(tfRes[:], start, end, transient, lm)
+	spread := spreadNormal
+	if dec.tell()+4 <= totalBits {
+		spread = dec.icdf(spreadICDF, 5)
+	}
+	caps := initBandCaps(lm, nC)
+	var offsets [nbEBands]int
+	dynallocLogp := uint(6)
+	totalBits <<= bitRes
+	tellF := dec.tellFrac()
+	for i := start; i < end; i++ {
+		width := nC * (eBands[i+1] - eBands[i]) << uint(lm)
+		quanta := minInt(width<<bitRes, maxInt(6<<bitRes, width))
+		loopLogp := dynallocLogp
+		boost := 0
+		for tellF+int(loopLogp<<bitRes) < totalBits && boost < caps[i] {
+			flag := dec.bitLogp(loopLogp)
+			tellF = dec.tellFrac()
+			if flag == 0 {
+				break
+			}
+			boost += quanta
+			totalBits -= quanta
+			loopLogp = 1
+		}
+		offsets[i] = boost
+		if boost > 0 && dynallocLogp > 2 {
+			dynallocLogp--
+		}
+	}
+	trim := 5
+	if tellF+6<<bitRes <= totalBits {
+		trim = dec.icdf(trimICDF, 7)
+	}
+	bits := nBytes*8<<bitRes - dec.tellFrac() - 1
+	antiCollapseRsv := 0
+	if transient && lm >= 2 && bits >= (lm+2)<<bitRes {
+		antiCollapseRsv = 1 << bitRes
+	}
+	bits -= antiCollapseRsv
+	a := &d.alloc
+	a.compute(&d.ec, start, end, &offsets, &caps, trim, bits, nC, lm)
+	dec.unquantFineEnergy(oldE, start, end, a.fine[:], nC)
+	for c := 0; c < d.nC; c++ {
+		copy(d.mem[c], d.mem[c][n:decodeBufferSize+overlap/2])
+	}
+	x := d.x[:nC*n]
+	var y []float64
+	if nC == 2 {
+		y = x[n:]
+	}
+	seed := d.rng
+	quantAllBands(&d.ec, start, end, x[:n], y, nil, d.masks[:], a, transient, spread, tfRes[:], nBytes*8<<bitRes-antiCollapseRsv, lm, &seed, d.nC == 1)
+	antiCollapse := false
+	if antiCollapseRsv > 0 {
+		antiCollapse = dec.bits(1) == 1
+	}
+	dec.unquantEnergyFinalise(oldE, start, end, a.fine[:], a.finePrio[:], nBytes*8-dec.tell(), nC)
+	if antiCollapse {
+		d.antiCollapse(x, nC, n, lm, start, end, a, seed)
+	}
+	if silence {
+		for i := 0; i < nC*nbEBands; i++ {
+			oldE[i] = -28
+		}
+	}
+	d.synthesize(x, nC, lm, start, end, transient, silence)
+	for c := 0; c < d.nC; c++ {
+		d.pfPeriod = maxInt(d.pfPeriod, combMinPeriod)
+		d.pfPeriodOld = maxInt(d.pfPeriodOld, combMinPeriod)
+		off := decodeBufferSize - n
+		combFilter(d.mem[c], off, d.pfPeriodOld, d.pfPeriod, shortMdctSize, d.pfGainOld, d.pfGain, d.pfTapsetOld, d.pfTapset)
+		if lm != 0 {
+			combFilter(d.mem[c], off+shortMdctSize, d.pfPeriod, pfPitch, n-shortMdctSize, d.pfGain, pfGain, d.pfTapset, pfTapset)
+		}
+	}
+	d.pfPeriodOld, d.pfGainOld, d.pfTapsetOld = d.pfPeriod, d.pfGain, d.pfTapset
+	d.pfPeriod, d.pfGain, d.pfTapset = pfPitch, pfGain, pfTapset
+	if lm != 0 {
+		d.pfPeriodOld, d.pfGainOld, d.pfTapsetOld = d.pfPeriod, d.pfGain, d.pfTapset
+	}
+	if nC == 1 {
+		copy(oldE[nbEBands:], oldE[:nbEBands])
+	}
+	if !transient {
+		d.oldLogE2 = d.oldLogE
+		d.oldLogE = d.oldE
+	} else {
+		for i := range d.oldLogE {
+			d.oldLogE[i] = math.Min(d.oldLogE[i], d.oldE[i])
+		}
+	}
+	inc := 0.001 * float64(minInt(160, d.lossDuration+1<<uint(lm)))
+	for i := range d.background {
+		d.background[i] = math.Min(d.background[i]+inc, d.oldE[i])
+	}
+	for c := 0; c < 2; c++ {
+		for i := 0; i < start; i++ {
+			d.oldE[c*nbEBands+i] = 0
+			d.oldLogE[c*nbEBands+i], d.oldLogE2[c*nbEBands+i] = -28, -28
+		}
+		for i := end; i < nbEBands; i++ {
+			d.oldE[c*nbEBands+i] = 0
+			d.oldLogE[c*nbEBands+i], d.oldLogE2[c*nbEBands+i] = -28, -28
+		}
+	}
+	d.rng = dec.rng
+	d.deemphasis(n, dst, stride)
+	d.lossDuration = 0
+	if dec.tell() > 8*nBytes {
+		return errs.Decode(codec.ErrCorrupt, -1, "packet", "CELT frame overrun")
+	}
+	return nil
+}
+
+// tfDecode decodes the time-frequency resolution changes of the bands.
+func (d *rangeDecoder) tfDecode(tfRes []int, start, end int, transient bool, lm int) {
+	budget := len(d.buf) * 8
+	tell := d.tell()
+	logp := 4
+	tr := 0
+	if transient {
+		logp, tr = 2, 1
+	}
+	selRsv := 0
+	if lm > 0 && tell+logp+1 <= budget {
+		selRsv = 1
+	}
+	budget -= selRsv
+	changed, curr := 0, 0
+	for i := start; i < end; i++ {
+		if tell+logp <= budget {
+			curr ^= d.bitLogp(uint(logp))
+			tell = d.tell()
+			changed |= curr
+		}
+		tfRes[i] = curr
+		logp = 5
+		if transient {
+			logp = 4
+		}
+	}
+	sel := 0
+	if selRsv != 0 && tfSelectTable[lm][4*tr+changed] != tfSelectTable[lm][4*tr+2+changed] {
+		sel = d.bitLogp(1)
+	}
+	for i := start; i < end; i++ {
+		tfRes[i] = tfSelectTable[lm][4*tr+2*sel+tfRes[i]]
+	}
+}
+
+// antiCollapse fills the short blocks of transient frames left without
+// pulses with noise of the energy of the previous frames.
+func (d *celtDecoder) antiCollapse(x []float64, nC, n, lm, start, end int, a *allocation, seed uint32) {
+	for i := start; i < end; i++ {
+		n0 := eBands[i+1] - eBands[i]
+		depth := (1 + a.pulses[i]) / n0 >> uint(lm)
+		thresh := 0.5 * math.Exp2(-0.125*float64(depth))
+		sqrt1 := 1 / math.Sqrt(float64(n0<<uint(lm)))
+		for c := 0; c < nC; c++ {
+			prev1 := d.oldLogE[c*nbEBands+i]
+			prev2 := d.oldLogE2[c*nbEBands+i]
+			if nC == 1 {
+				prev1 = math.Max(prev1, d.oldLogE[nbEBands+i])
+				prev2 = math.Max(prev2, d.oldLogE2[nbEBands+i])
+			}
+			ediff := math.Max(0, d.oldE[c*nbEBands+i]-math.Min(prev1, prev2))
+			r := 2 * math.Exp2(-ediff)
+			if lm == 3 {
+				r *= math.Sqrt2
+			}
+			r = math.Min(thresh, r) * sqrt1
+			xb := x[c*n+eBands[i]<<uint(lm) : c*n+eBands[i+1]<<uint(lm)]
+			renorm := false
+			for k := 0; k < 1<<uint(lm); k++ {
+				if d.masks[i*nC+c]&(1<<uint(k)) != 0 {
+					continue
+				}
+				for j := 0; j < n0; j++ {
+					seed = lcgRand(seed)
+					if seed&0x8000 != 0 {
+						xb[j<<uint(lm)+k] = r
+					} else {
+						xb[j<<uint(lm)+k] = -r
+					}
+				}
+				renorm = true
+			}
+			if renorm {
+				renormalize(xb, 1)
+			}
+		}
+	}
+}
+
+// synthesize computes the samples of the frame from the normalized
+// bands x of nC channels and their energies, into d.mem.
+func (d *celtDecoder) synthesize(x []float64, nC, lm, start, end int, transient, silence bool) {
+	n := shortMdctSize << uint(lm)
+	nb, mLM, nbLen := 1, lm, n
+	if transient {
+		nb, mLM, nbLen = 1<<uint(lm), 0, shortMdctSize
+	}
+	md := mdcts[mLM]
+	freq := d.freq[:n]
+	imdct := func(c int) {
+		out := d.mem[c][decodeBufferSize-n:]
+		for b := 0; b < nb; b++ {
+			md.backward(freq[b:], nb, out[nbLen*b:])
+		}
+	}
+	switch {
+	case d.nC == 2 && nC == 1:
+		denormalize(x[:n], freq, d.oldE[:], start, end, lm, silence)
+		imdct(0)
+		imdct(1)
+	case d.nC == 1 && nC == 2:
+		freq2 := d.freq[n : 2*n]
+		denormalize(x[:n], freq, d.oldE[:], start, end, lm, silence)
+		denormalize(x[n:], freq2, d.oldE[nbEBands:], start, end, lm, silence)
+		for i := range freq {
+			freq[i] = 0.5*freq[i] + 0.5*freq2[i]
+		}
+		imdct(0)
+	default:
+		for c := 0; c < nC; c++ {
+			denormalize(x[c*n:], freq, d.oldE[c*nbEBands:], start, end, lm, silence)
+			imdct(c)
+		}
+	}
+}
+
+// denormalize scales the normalized bands x by their energies into the
+// MDCT coefficients freq.
+func denormalize(x, freq, e []float64, start, end, lm int, silence bool) {
+	m := 1 << uint(lm)
+	if silence {
+		start, end = 0, 0
+	}
+	for i := range freq {
+		freq[i] = 0
+	}
+	for i := start; i < end; i++ {
+		g := math.Exp2(math.Min(32, e[i]+eMeans[i]))
+		for j := m * eBands[i]; j < m*eBands[i+1]; j++ {
+			freq[j] = x[j] * g
+		}
+	}
+}
+
+// deemphasis filters the n samples of the frame into dst, scaled to
+// [-1, 1].
+func (d *celtDecoder) deemphasis(n int, dst []float64, stride int) {
+	for c := 0; c < d.nC; c++ {
+		x := d.mem[c][decodeBufferSize-n : decodeBufferSize]
+		m := d.preemphMem[c]
+		out := dst[c*stride:]
+		for j, v := range x {
+			t := v + 1e-30 + m
+			m = preemph * t
+			out[j] = t / sigScale
+		}
+		d.preemphMem[c] = m
+	}
+}
+
+var combGains = [3][3]float64{
+	{0.3066406250, 0.2170410156, 0.1296386719},
+	{0.4638671875, 0.2680664062, 0},
+	{0.7998046875, 0.1000976562, 0}}
+
+// combFilter applies in place to x[off:off+n] the pitch postfilter of
+// period t1, gain g1 and taps tap1, cross-fading over the overlap from
+// the filter of period t0, gain g0 and taps tap0.
+func combFilter(x []float64, off, t0, t1, n int, g0, g1 float64, tap0, tap1 int) {
+	if g0 == 0 && g1 == 0 {
+		return
+	}
+	t0 = maxInt(t0, combMinPeriod)
+	t1 = maxInt(t1, combMinPeriod)
+	g00, g01, g02 := g0*combGains[tap0][0], g0*combGains[tap0][1], g0*combGains[tap0][2]
+	g10, g11, g12 := g1*combGains[tap1][0], g1*combGains[tap1][1], g1*combGains[tap1][2]
+	x1, x2, x3, x4 := x[off-t1+1], x[off-t1], x[off-t1-1], x[off-t1-2]
+	ov := overlap
+	if g0 == g1 && t0 == t1 && tap0 == tap1 {
+		ov = 0
+	}
+	i := 0
+	for ; i < ov; i++ {
+		p := off + i
+		x0 := x[p-t1+2]
+		f := window[i] * window[i]
+		x[p] = x[p] +
+			(1-f)*g00*x[p-t0] +
+			(1-f)*g01*(x[p-t0+1]+x[p-t0-1]) +
+			(1-f)*g02*(x[p-t0+2]+x[p-t0-2]) +
+			f*g10*x2 +
+			f*g11*(x1+x3) +
+			f*g12*(x0+x4)
+		x4, x3, x2, x1 = x3, x2, x1, x0
+	}
+	if g1 == 0 {
+		return
+	}
+	for ; i < n; i++ {
+		p := off + i
+		x[p] = x[p] + g10*x[p-t1] + g11*(x[p-t1+1]+x[p-t1-1]) + g12*(x[p-t1+2]+x[p-t1-2])
+	}
+}
@@ -0,0 +1,639 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"errors"
+	"math"
+)
+
+// frameEncoder is the FrameEncoder of package opus, which encodes CELT
+// frames of long blocks at a constant bit rate.
+type frameEncoder struct {
+	celt   *celtEncoder
+	toc    TOC
+	lm     int
+	frames int // CELT frames per packet
+	n      int // samples per channel of a CELT frame at the input rate
+	bufs   [][]byte
+	out    [][]byte
+}
+
+// Default bit rates of the CELT encoder.
+const (
+	defaultMonoBitrate   = 64000
+	defaultStereoBitrate = 96000
+)
+
+func newCELTFrameEncoder(rate, channels int, opts *EncoderOptions) (FrameEncoder, error) {
+	bitrate := opts.Bitrate
+	if bitrate == 0 {
+		bitrate = defaultMonoBitrate
+		if channels == 2 {
+			bitrate = defaultStereoBitrate
+		}
+	}
+	frames, fs := 1, opts.FrameSize
+	if fs > 960 {
+		frames, fs = fs/960, 960
+	}
+	lm := 0
+	for shortMdctSize<<uint(lm) != fs {
+		lm++
+	}
+	overhead := 1
+	if frames > 1 {
+		overhead = 2
+	}
+	size := (bitrate*opts.FrameSize/(8*SampleRate) - overhead) / frames
+	size = minInt(maxFrameSize, maxInt(2, size))
+	var bw Bandwidth
+	switch rate {
+	case 8000:
+		bw = Narrowband
+	case 12000, 16000:
+		bw = Wideband
+	case 24000:
+		bw = SuperWideband
+	default:
+		bw = Fullband
+	}
+	upsample := SampleRate / rate
+	toc := TOC(16+4*(int(bw)-1)+lm) << 3
+	if bw == Narrowband {
+		toc = TOC(16+lm) << 3
+	}
+	if channels == 2 {
+		toc |= 4
+	}
+	e := &frameEncoder{
+		celt:   newCELTEncoder(channels, upsample, endBands[bw], opts.Complexity),
+		toc:    toc,
+		lm:     lm,
+		frames: frames,
+		n:      fs / upsample,
+		bufs:   make([][]byte, frames),
+		out:    make([][]byte, frames)}
+	for i := range e.bufs {
+		e.bufs[i] = make([]byte, size)
+	}
+	return e, nil
+}
+
+func (e *frameEncoder) Encode(pcm []float64, dst []byte) ([]byte, error) {
+	stride := e.frames * e.n
+	if len(pcm) != e.celt.nC*stride {
+		return dst, errors.New("opus: frame encoder given wrong number of samples")
+	}
+	for i := range e.bufs {
+		f, err := e.celt.encode(pcm[i*e.n:], stride, e.lm, e.bufs[i])
+		if err != nil {
+			return dst, err
+		}
+		e.out[i] = f
+	}
+	return appendPacket(dst, e.toc, e.out), nil
+}
+
+func (e *frameEncoder) Lookahead() int {
+	return overlap
+}
+
+// celtEncoder encodes CELT frames of long blocks without postfilter.
+//
+// Its complexity, as in EncoderOptions, selects the coding of spreading
+// and energies.  From 4, the coarse energies of inter frames are also
+// coded intra, and the better coding kept.  From 3, the spreading is
+// chosen by analysis of the bands.  Below 3 it is normal, or none at 0.
+// At all complexities, bands are boosted and the allocation trimmed by
+// analysis of the band energies.
+type celtEncoder struct {
+	nC         int
+	upsample   int // 48kHz over the input rate
+	end        int // coded bands
+	complexity int
+
+	inMem      [2][]float64 // last overlap pre-emphasized samples
+	preemphMem [2]float64
+	oldE       [2 * nbEBands]float64
+	energyErr  [2 * nbEBands]float64 // last remainders of fine energies
+	rng        uint32
+	intra      bool // whether the next frame is coded intra
+	intensity  int
+	lastCoded  int
+	spread     int
+	tonalAvg   int // running average of spreadDecision
+
+	enc   rangeEncoder
+	ec    celtCoder
+	alloc allocation
+	in    []float64
+	freq  []float64
+	x     []float64
+	bandE [2 * nbEBands]float64
+	logE  [2 * nbEBands]float64
+	errE  [2 * nbEBands]float64
+	masks [2 * nbEBands]uint
+}
+
+func newCELTEncoder(nC, upsample, end, complexity int) *celtEncoder {
+	e := &celtEncoder{
+		nC:         nC,
+		upsample:   upsample,
+		end:        end,
+		complexity: complexity,
+		intra:      true,
+		spread:     spreadNormal,
+		tonalAvg:   256,
+		in:         make([]float64, shortMdctSize<<maxLM+overlap),
+		freq:       make([]float64, shortMdctSize<<maxLM),
+		x:          make([]float64, 2*shortMdctSize<<maxLM)}
+	for c := 0; c < nC; c++ {
+		e.inMem[c] = make([]float64, overlap)
+	}
+	e.ec.enc = &e.enc
+	return e
+}
+
+// Intensity stereo thresholds and hysteresis in kb/s, by band.
+var (
+	intensityThresholds = [nbEBands]int{1, 2, 3, 4, 5, 6, 7, 8, 16, 24, 36, 44, 50, 56, 62, 67, 72, 79, 88, 106, 134}
+	intensityHysteresis = [nbEBands]int{1, 1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2, 2, 2, 3, 3, 4, 5, 6, 8, 8}
+)
+
+// encode encodes into buf the CELT frame of LM lm of the samples of
+// channel c at pcm[c*stride+i], i < (120<<lm)/e.upsample, returning the
+// frame, which is shortened for silence.
+func (e *celtEncoder) encode(pcm []float64, stride, lm int, buf []byte) ([]byte, error) {
+	n := shortMdctSize << uint(lm)
+	nu := n / e.upsample
+	nC, end := e.nC, e.end
+	m := 1 << uint(lm)
+	silence := true
+	for c := 0; c < nC; c++ {
+		for _, v := range pcm[c*stride : c*stride+nu] {
+			silence = silence && v == 0
+		}
+	}
+	equivRate := len(buf)*8*50<<uint(3-lm) - (40*nC+20)*(400>>uint(lm)-50)
+	if silence {
+		buf = buf[:2]
+	}
+	x := e.x[:nC*n]
+	for c := 0; c < nC; c++ {
+		in := e.in[:n+overlap]
+		copy(in, e.inMem[c])
+		e.preemphasis(c, pcm[c*stride:c*stride+nu], in[overlap:])
+		copy(e.inMem[c], in[n:])
+		freq := e.freq[:n]
+		mdcts[lm].forward(in, freq, 1)
+		if e.upsample != 1 {
+			for j := range freq {
+				if j < nu {
+					freq[j] *= float64(e.upsample)
+				} else {
+					freq[j] = 0
+				}
+			}
+		}
+		xc := x[c*n : (c+1)*n]
+		for j := range xc {
+			xc[j] = 0
+		}
+		for i := 0; i < end; i++ {
+			sum := 1e-27
+			for _, v := range freq[m*eBands[i] : m*eBands[i+1]] {
+				sum += v * v
+			}
+			be := math.Sqrt(sum)
+			e.bandE[i+c*nbEBands] = be
+			e.logE[i+c*nbEBands] = math.Log2(be) - eMeans[i]
+			g := 1 / (1e-27 + be)
+			for j := m * eBands[i]; j < m*eBands[i+1]; j++ {
+				xc[j] = freq[j] * g
+			}
+		}
+	}
+	enc := &e.enc
+	enc.init(buf)
+	totalBits := len(buf) * 8
+	tell := enc.tell()
+	if tell == 1 {
+		enc.bitLogp(boolInt(silence), 15)
+	}
+	if silence {
+		tell = totalBits
+		enc.nBitsTotal += tell - enc.tell()
+	}
+	if tell+16 <= totalBits {
+		enc.bitLogp(0, 1)
+		tell = enc.tell()
+	}
+	if lm > 0 && tell+3 <= totalBits {
+		enc.bitLogp(0, 3)
+	}
+	var offsets [nbEBands]int
+	weights := e.dynallocAnalysis(offsets[:], end, lm, len(buf))
+	for c := 0; c < nC; c++ {
+		for i := 0; i < end; i++ {
+			// bias stable energies towards the previous error, as a
+			// constant offset is heard less than fluctuations.
+			j := i + c*nbEBands
+			if math.Abs(e.logE[j]-e.oldE[j]) < 2 {
+				e.logE[j] -= 0.25 * e.energyErr[j]
+			}
+		}
+	}
+	maxDecay := math.Min(16, 0.125*float64(len(buf)))
+	if e.complexity >= 4 && !e.intra {
+		enc.quantCoarseEnergyTwoPass(e.logE[:], e.oldE[:], e.errE[:], 0, end, totalBits, nC, lm, maxDecay)
+	} else {
+		enc.quantCoarseEnergy(e.logE[:], e.oldE[:], e.errE[:], 0, end, e.intra, totalBits, nC, lm, maxDecay)
+	}
+	var tfRes [nbEBands]int
+	enc.tfEncode(0, end, lm)
+	spread := spreadNormal
+	if enc.tell()+4 <= totalBits {
+		switch {
+		case e.complexity == 0:
+			e.spread = spreadNone
+		case e.complexity < 3 || len(buf) < 10*nC:
+			e.spread = spreadNormal
+		default:
+			e.spread = e.spreadDecision(x, weights[:], end, lm, n)
+		}
+		spread = e.spread
+		enc.icdf(spread, spreadICDF, 5)
+	}
+	caps := initBandCaps(lm, nC)
+	dynallocLogp := uint(6)
+	totalBits <<= bitRes
+	tellF := enc.tellFrac()
+	for i := 0; i < end; i++ {
+		width := nC * (eBands[i+1] - eBands[i]) << uint(lm)
+		quanta := minInt(width<<bitRes, maxInt(6<<bitRes, width))
+		loopLogp := dynallocLogp
+		boost := 0
+		for j := 0; tellF+int(loopLogp<<bitRes) < totalBits && boost < caps[i]; j++ {
+			flag := j < offsets[i]
+			enc.bitLogp(boolInt(flag), loopLogp)
+			tellF = enc.tellFrac()
+			if !flag {
+				break
+			}
+			boost += quanta
+			totalBits -= quanta
+			loopLogp = 1
+		}
+		offsets[i] = boost
+		if boost > 0 && dynallocLogp > 2 {
+			dynallocLogp--
+		}
+	}
+	a := &e.alloc
+	a.intensity, a.dualStereo = 0, false
+	if nC == 2 {
+		a.dualStereo = lm != 0 && stereoAnalysis(x, lm, n)
+		e.intensity = hysteresis(equivRate/1000, intensityThresholds[:], intensityHysteresis[:], e.intensity)
+		e.intensity = minInt(end, e.intensity)
+		a.intensity = e.intensity
+	}
+	trim := 5
+	if tellF+6<<bitRes <= totalBits {
+		trim = e.allocTrim(x, end, lm, n, equivRate)
+		enc.icdf(trim, trimICDF, 7)
+	}
+	bits := len(buf)*8<<bitRes - enc.tellFrac() - 1
+	a.prevCoded = e.lastCoded
+	a.signalBW = end - 1
+	a.compute(&e.ec, 0, end, &offsets, &caps, trim, bits, nC, lm)
+	if e.lastCoded != 0 {
+		e.lastCoded = minInt(e.lastCoded+1, maxInt(e.lastCoded-1, a.codedBands))
+	} else {
+		e.lastCoded = a.codedBands
+	}
+	enc.quantFineEnergy(e.oldE[:], e.errE[:], 0, end, a.fine[:], nC)
+	var y []float64
+	if nC == 2 {
+		y = x[n:]
+	}
+	seed := e.rng
+	quantAllBands(&e.ec, 0, end, x[:n], y, e.bandE[:], e.masks[:], a, false, spread, tfRes[:], len(buf)*8<<bitRes, lm, &seed, false)
+	enc.quantEnergyFinalise(e.oldE[:], e.errE[:], 0, end, a.fine[:], a.finePrio[:], len(buf)*8-enc.tell(), nC)
+	for i := range e.energyErr {
+		e.energyErr[i] = 0
+	}
+	for c := 0; c < nC; c++ {
+		for i := 0; i < end; i++ {
+			j := i + c*nbEBands
+			e.energyErr[j] = math.Max(-0.5, math.Min(0.5, e.errE[j]))
+		}
+	}
+	if silence {
+		for i := 0; i < nC*nbEBands; i++ {
+			e.oldE[i] = -28
+		}
+	}
+	for c := 0; c < 2; c++ {
+		for i := end; i < nbEBands; i++ {
+			e.oldE[c*nbEBands+i] = 0
+		}
+	}
+	if !enc.done() {
+		return nil, errors.New("opus: CELT frame overflow")
+	}
+	e.rng = enc.rng
+	e.intra = false
+	return buf, nil
+}
+
+// preemphasis scales the samples pcm of channel c to the signal scale
+// of CELT, upsamples them to 48kHz by inserting zeros, and filters them
+// into dst.
+func (e *celtEncoder) preemphasis(c int, pcm, dst []float64) {
+	for i := range dst {
+		dst[i] = 0
+	}
+	for i, v := range pcm {
+		dst[i*e.upsample] = v * sigScale
+	}
+	mem := e.preemphMem[c]
+	for i, v := range dst {
+		dst[i] = v - mem
+		mem = preemph * v
+	}
+	e.preemphMem[c] = mem
+}
+
+// dynallocAnalysis sets offsets to the numbers of boosts of the bands
+// [0, end) of a frame of LM lm and nBytes bytes whose energies stand out
+// from those of their neighbours, and returns the weights of the bands
+// in spreadDecision, which are lower for masked bands.
+func (e *celtEncoder) dynallocAnalysis(offsets []int, end, lm, nBytes int) [nbEBands]int {
+	nC := e.nC
+	logE := e.logE[:]
+	var floor, mask, sig [nbEBands]float64
+	maxDepth := -31.9
+	for i := 0; i < end; i++ {
+		// the noise floor of 24 bit samples, allowing for the width of
+		// the band and for pre-emphasis.
+		floor[i] = 0.0625*float64(logN[i]) + 0.5 + (9 - 24) - eMeans[i] + 0.0062*float64((i+5)*(i+5))
+		mask[i] = logE[i] - floor[i]
+		if nC == 2 {
+			mask[i] = math.Max(mask[i], logE[nbEBands+i]-floor[i])
+		}
+		maxDepth = math.Max(maxDepth, mask[i])
+	}
+	sig = mask
+	for i := 1; i < end; i++ {
+		mask[i] = math.Max(mask[i], mask[i-1]-2)
+	}
+	for i := end - 2; i >= 0; i-- {
+		mask[i] = math.Max(mask[i], mask[i+1]-3)
+	}
+	var weights [nbEBands]int
+	for i := 0; i < end; i++ {
+		smr := sig[i] - math.Max(math.Max(0, maxDepth-12), mask[i])
+		shift := minInt(5, maxInt(0, -int(math.Floor(0.5+smr))))
+		weights[i] = 32 >> uint(shift)
+	}
+	for i := range offsets {
+		offsets[i] = 0
+	}
+	if nBytes <= 50 || lm < 1 {
+		return weights
+	}
+	var follower [2 * nbEBands]float64
+	for c := 0; c < nC; c++ {
+		f := follower[c*nbEBands : (c+1)*nbEBands]
+		le := logE[c*nbEBands : (c+1)*nbEBands]
+		last := 0
+		f[0] = le[0]
+		for i := 1; i < end; i++ {
+			if le[i] > le[i-1]+0.5 {
+				last = i
+			}
+			f[i] = math.Min(f[i-1]+1.5, le[i])
+		}
+		for i := last - 1; i >= 0; i-- {
+			f[i] = math.Min(f[i], math.Min(f[i+1]+2, le[i]))
+		}
+		// a median filter keeps isolated bands from being boosted
+		// unnecessarily.
+		for i := 2; i < end-2; i++ {
+			f[i] = math.Max(f[i], median(le[i-2:i+3])-1)
+		}
+		m := median(le[:3]) - 1
+		f[0], f[1] = math.Max(f[0], m), math.Max(f[1], m)
+		m = median(le[end-3:end]) - 1
+		f[end-2], f[end-1] = math.Max(f[end-2], m), math.Max(f[end-1], m)
+		for i := 0; i < end; i++ {
+			f[i] = math.Max(f[i], floor[i])
+		}
+	}
+	for i := 0; i < end; i++ {
+		if nC == 2 {
+			// allow for cross-talk of 24dB between the channels.
+			follower[nbEBands+i] = math.Max(follower[nbEBands+i], follower[i]-4)
+			follower[i] = math.Max(follower[i], follower[nbEBands+i]-4)
+			follower[i] = (math.Max(0, logE[i]-follower[i]) + math.Max(0, logE[nbEBands+i]-follower[nbEBands+i])) / 2
+		} else {
+			follower[i] = math.Max(0, logE[i]-follower[i])
+		}
+		// frames of constant bit rate take half the boost.
+		follower[i] /= 2
+		if i < 8 {
+			follower[i] *= 2
+		}
+		if i >= 12 {
+			follower[i] /= 2
+		}
+	}
+	total := 0
+	limit := 2 * nBytes / 3 << bitRes << 3
+	for i := 0; i < end; i++ {
+		f := math.Min(follower[i], 4)
+		width := nC * (eBands[i+1] - eBands[i]) << uint(lm)
+		var boost, bits int
+		switch {
+		case width < 6:
+			boost = int(f)
+			bits = boost * width << bitRes
+		case width > 48:
+			boost = int(f * 8)
+			bits = boost * width << bitRes / 8
+		default:
+			boost = int(f * float64(width) / 6)
+			bits = boost * 6 << bitRes
+		}
+		// boosts take no more than 2/3 of the frame.
+		if total+bits > limit {
+			offsets[i] = (limit - total) >> bitRes >> 3
+			break
+		}
+		offsets[i] = boost
+		total += bits
+	}
+	return weights
+}
+
+// median returns the median of the 3 or 5 values x.
+func median(x []float64) float64 {
+	var s [5]float64
+	n := copy(s[:], x)
+	for i := 1; i < n; i++ {
+		for j := i; j > 0 && s[j] < s[j-1]; j-- {
+			s[j], s[j-1] = s[j-1], s[j]
+		}
+	}
+	return s[n/2]
+}
+
+// allocTrim returns the allocation trim of the normalized bands x of a
+// frame of n samples per channel, lower where the channels of stereo
+// frames are correlated and where the spectrum falls less with frequency.
+func (e *celtEncoder) allocTrim(x []float64, end, lm, n, equivRate int) int {
+	trim := 5.0
+	switch {
+	case equivRate < 64000:
+		trim = 4
+	case equivRate < 80000:
+		trim = 4 + float64((equivRate-64000)>>10)/16
+	}
+	if e.nC == 2 {
+		sum := 0.0
+		for i := 0; i < 8; i++ {
+			lo, hi := eBands[i]<<uint(lm), eBands[i+1]<<uint(lm)
+			for j := lo; j < hi; j++ {
+				sum += x[j] * x[n+j]
+			}
+		}
+		sum = math.Min(1, math.Abs(sum/8))
+		trim += math.Max(-4, 0.75*math.Log2(1.001-sum*sum))
+	}
+	diff := 0.0
+	for c := 0; c < e.nC; c++ {
+		for i := 0; i < end-1; i++ {
+			diff += e.logE[i+c*nbEBands] * float64(2+2*i-end)
+		}
+	}
+	diff /= float64(e.nC * (end - 1))
+	trim -= math.Max(-2, math.Min(2, (diff+1)/6))
+	return maxInt(0, minInt(10, int(math.Floor(0.5+trim))))
+}
+
+// spreadDecision returns the spreading of the normalized bands x of a
+// frame of n samples per channel, chosen by the proportion of their
+// coefficients which are small in the bands, weighted by weights, with
+// hysteresis.
+func (e *celtEncoder) spreadDecision(x []float64, weights []int, end, lm, n int) int {
+	m := 1 << uint(lm)
+	if m*(eBands[end]-eBands[end-1]) <= 8 {
+		return spreadNone
+	}
+	sum, bands := 0, 0
+	for c := 0; c < e.nC; c++ {
+		for i := 0; i < end; i++ {
+			nb := m * (eBands[i+1] - eBands[i])
+			if nb <= 8 {
+				continue
+			}
+			var count [3]int
+			for _, v := range x[c*n+m*eBands[i] : c*n+m*eBands[i+1]] {
+				x2n := v * v * float64(nb)
+				if x2n < 0.25 {
+					count[0]++
+				}
+				if x2n < 0.0625 {
+					count[1]++
+				}
+				if x2n < 0.015625 {
+					count[2]++
+				}
+			}
+			for _, k := range count {
+				if 2*k >= nb {
+					sum += weights[i]
+				}
+			}
+			bands += weights[i]
+		}
+	}
+	sum = sum << 8 / bands
+	sum = (sum + e.tonalAvg) >> 1
+	e.tonalAvg = sum
+	sum = (3*sum + (3-e.spread)<<7 + 64 + 2) >> 2
+	switch {
+	case sum < 80:
+		return spreadAggressive
+	case sum < 256:
+		return spreadNormal
+	case sum < 384:
+		return spreadLight
+	}
+	return spreadNone
+}
+
+// tfEncode encodes the time-frequency resolution of the bands of a frame
+// of long blocks as unchanged.
+func (e *rangeEncoder) tfEncode(start, end, lm int) {
+	budget := len(e.buf) * 8
+	tell := e.tell()
+	logp := 4
+	selRsv := 0
+	if lm > 0 && tell+logp+1 <= budget {
+		selRsv = 1
+	}
+	budget -= selRsv
+	for i := start; i < end; i++ {
+		if tell+logp <= budget {
+			e.bitLogp(0, uint(logp))
+			tell = e.tell()
+		}
+		logp = 5
+	}
+	if selRsv != 0 && tfSelectTable[lm][0] != tfSelectTable[lm][2] {
+		e.bitLogp(0, 1)
+	}
+}
+
+// stereoAnalysis returns whether the left and right channels x[:n] and
+// x[n:] of the lower bands are cheaper to code than their mid and side.
+func stereoAnalysis(x []float64, lm, n int) bool {
+	sumLR, sumMS := 1e-15, 1e-15
+	for j := 0; j < eBands[13]<<uint(lm); j++ {
+		l, r := x[j], x[n+j]
+		sumLR += math.Abs(l) + math.Abs(r)
+		sumMS += math.Abs(l+r) + math.Abs(l-r)
+	}
+	sumMS *= math.Sqrt2 / 2
+	thetas := 13
+	if lm <= 1 {
+		thetas -= 8
+	}
+	return float64(eBands[13]<<uint(lm+1)+thetas)*sumMS > float64(eBands[13]<<uint(lm+1))*sumLR
+}
+
+// hysteresis returns the index of the interval of thresholds in which
+// v falls, keeping prev while v is within the hysteresis of its bounds.
+func hysteresis(v int, thresholds, hyst []int, prev int) int {
+	i := 0
+	for i < len(thresholds) && v >= thresholds[i] {
+		i++
+	}
+	if i > prev && v < thresholds[prev]+hyst[prev] {
+		i = prev
+	}
+	if i < prev && v > thresholds[prev-1]-hyst[prev-1] {
+		i = prev
+	}
+	return i
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,366 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import "math"
+
+// Constants of the concealment of lost CELT frames.
+const (
+	plcPitchLagMax = 720
+	plcPitchLagMin = 100
+	maxPeriod      = 1024
+	celtLPCOrder   = 24
+)
+
+// conceal replaces a lost frame of LM lm and bands start to end, as
+// celt_decode_lost of the reference implementation does: by noise of the
+// energy of the last frames after long losses, for hybrid frames and
+// before two frames were received, and otherwise by the extrapolation of
+// the pitch period of the last samples.
+func (d *celtDecoder) conceal(lm, start, end int, dst []float64, stride int) {
+	n := shortMdctSize << uint(lm)
+	if d.lossDuration >= 40 || start != 0 || d.skipPLC {
+		d.concealNoise(lm, start, end)
+	} else {
+		d.concealPitch(n)
+	}
+	d.lossDuration = minInt(10000, d.lossDuration+1<<uint(lm))
+	d.deemphasis(n, dst, stride)
+}
+
+// concealNoise synthesizes the frame from noise, with the energies of the
+// last frame decaying to the background energies.
+func (d *celtDecoder) concealNoise(lm, start, end int) {
+	n := shortMdctSize << uint(lm)
+	for c := 0; c < d.nC; c++ {
+		copy(d.mem[c], d.mem[c][n:decodeBufferSize+overlap/2])
+	}
+	decay := 0.5
+	if d.lossDuration == 0 {
+		decay = 1.5
+	}
+	for c := 0; c < d.nC; c++ {
+		for i := start; i < end; i++ {
+			j := c*nbEBands + i
+			d.oldE[j] = math.Max(d.background[j], d.oldE[j]-decay)
+		}
+	}
+	seed := d.rng
+	for c := 0; c < d.nC; c++ {
+		for i := start; i < end; i++ {
+			xb := d.x[c*n+eBands[i]<<uint(lm) : c*n+eBands[i+1]<<uint(lm)]
+			for j := range xb {
+				seed = lcgRand(seed)
+				xb[j] = float64(int32(seed) >> 20)
+			}
+			renormalize(xb, 1)
+		}
+	}
+	d.rng = seed
+	d.synthesize(d.x, d.nC, lm, start, end, false, false)
+}
+
+// concealPitch extrapolates the excitation of the last pitch period,
+// decaying as the last samples do, through the LPC filter of the last
+// samples.
+func (d *celtDecoder) concealPitch(n int) {
+	fade := 1.0
+	if d.lossDuration == 0 {
+		d.lastPitch = plcPitchSearch(d.mem[:d.nC])
+	} else {
+		fade = 0.8
+	}
+	pitch := d.lastPitch
+	excLen := minInt(2*pitch, maxPeriod)
+	var exc [maxPeriod + celtLPCOrder]float64
+	var etmp [overlap]float64
+	fir := make([]float64, excLen)
+	for c := 0; c < d.nC; c++ {
+		buf := d.mem[c]
+		copy(exc[:], buf[decodeBufferSize-maxPeriod-celtLPCOrder:])
+		e := exc[celtLPCOrder:]
+		lpc := d.lpc[c][:]
+		if d.lossDuration == 0 {
+			var ac [celtLPCOrder + 1]float64
+			autocorr(e, ac[:], window[:], overlap, celtLPCOrder, maxPeriod)
+			ac[0] *= 1.0001
+			for i := 1; i <= celtLPCOrder; i++ {
+				ac[i] -= ac[i] * (0.008 * 0.008) * float64(i*i)
+			}
+			lpcFromAutocorr(lpc, ac[:])
+		}
+		firFilter(exc[maxPeriod-excLen:], lpc, fir)
+		copy(e[maxPeriod-excLen:], fir)
+
+		// The attenuation of each period follows the decay of the
+		// excitation.
+		e1, e2 := 1.0, 1.0
+		dl := excLen >> 1
+		for i := 0; i < dl; i++ {
+			v := e[maxPeriod-dl+i]
+			e1 += v * v
+			v = e[maxPeriod-2*dl+i]
+			e2 += v * v
+		}
+		decay := math.Sqrt(math.Min(e1, e2) / e2)
+
+		copy(buf, buf[n:decodeBufferSize])
+		off := maxPeriod - pitch
+		extLen := n + overlap
+		att := fade * decay
+		s1 := 0.0
+		for i, j := 0, 0; i < extLen; i, j = i+1, j+1 {
+			if j >= pitch {
+				j -= pitch
+				att *= decay
+			}
+			buf[decodeBufferSize-n+i] = att * e[off+j]
+			v := buf[decodeBufferSize-maxPeriod-n+off+j]
+			s1 += v * v
+		}
+		var mem [celtLPCOrder]float64
+		for i := range mem {
+			mem[i] = buf[decodeBufferSize-n-1-i]
+		}
+		iirFilter(buf[decodeBufferSize-n:decodeBufferSize-n+extLen], lpc, mem[:])
+
+		// The extrapolation is attenuated to the energy of the samples
+		// it was extrapolated from, and dropped if it exploded.
+		s2 := 0.0
+		for _, v := range buf[decodeBufferSize-n : decodeBufferSize-n+extLen] {
+			s2 += v * v
+		}
+		if !(s1 > 0.2*s2) {
+			for i := 0; i < extLen; i++ {
+				buf[decodeBufferSize-n+i] = 0
+			}
+		} else if s1 < s2 {
+			ratio := math.Sqrt((s1 + 1) / (s2 + 1))
+			for i := 0; i < extLen; i++ {
+				g := ratio
+				if i < overlap {
+					g = 1 - window[i]*(1-ratio)
+				}
+				buf[decodeBufferSize-n+i] *= g
+			}
+		}
+
+		// The overlap is prefiltered, to be postfiltered again by the next
+		// frame, and folded as by the MDCT.
+		t := maxInt(d.pfPeriod, combMinPeriod)
+		g := -d.pfGain
+		g0, g1, g2 := g*combGains[d.pfTapset][0], g*combGains[d.pfTapset][1], g*combGains[d.pfTapset][2]
+		for i := range etmp {
+			p := decodeBufferSize + i
+			etmp[i] = buf[p] + g0*buf[p-t] + g1*(buf[p-t+1]+buf[p-t-1]) + g2*(buf[p-t+2]+buf[p-t-2])
+		}
+		for i := 0; i < overlap/2; i++ {
+			buf[decodeBufferSize+i] = window[i]*etmp[overlap-1-i] + window[overlap-1-i]*etmp[i]
+		}
+	}
+}
+
+// plcPitchSearch returns the pitch period of the past samples x of each
+// channel.
+func plcPitchSearch(x [][]float64) int {
+	var lp [decodeBufferSize >> 1]float64
+	pitchDownsample(x, lp[:])
+	p := pitchSearch(lp[plcPitchLagMax>>1:], lp[:], decodeBufferSize-plcPitchLagMax, plcPitchLagMax-plcPitchLagMin)
+	return plcPitchLagMax - p
+}
+
+// pitchDownsample low-passes and downsamples by 2 the sum of the channels
+// x into xlp, whitened by a 4th order LPC filter.
+func pitchDownsample(x [][]float64, xlp []float64) {
+	for i := range xlp {
+		xlp[i] = 0
+	}
+	for _, xc := range x {
+		for i := 1; i < len(xlp); i++ {
+			xlp[i] += 0.25*xc[2*i-1] + 0.25*xc[2*i+1] + 0.5*xc[2*i]
+		}
+		xlp[0] += 0.25*xc[1] + 0.5*xc[0]
+	}
+	var ac [5]float64
+	autocorr(xlp, ac[:], nil, 0, 4, len(xlp))
+	ac[0] *= 1.0001
+	for i := 1; i <= 4; i++ {
+		ac[i] -= ac[i] * (0.008 * float64(i)) * (0.008 * float64(i))
+	}
+	var lpc [4]float64
+	lpcFromAutocorr(lpc[:], ac[:])
+	tmp := 1.0
+	for i := range lpc {
+		tmp *= 0.9
+		lpc[i] *= tmp
+	}
+	const c1 = 0.8
+	num := [5]float64{lpc[0] + 0.8, lpc[1] + c1*lpc[0], lpc[2] + c1*lpc[1], lpc[3] + c1*lpc[2], c1 * lpc[3]}
+	var mem [5]float64
+	for i, v := range xlp {
+		s := v
+		for k := range num {
+			s += num[k] * mem[k]
+		}
+		copy(mem[1:], mem[:4])
+		mem[0] = v
+		xlp[i] = s
+	}
+}
+
+// pitchSearch returns the lag, less than maxPitch, of the best correlation
+// of the n samples x with y, searched with 4x and then 2x decimation.
+func pitchSearch(x, y []float64, n, maxPitch int) int {
+	lag := n + maxPitch
+	x4 := make([]float64, n>>2)
+	y4 := make([]float64, lag>>2)
+	xcorr := make([]float64, maxPitch>>1)
+	for j := range x4 {
+		x4[j] = x[2*j]
+	}
+	for j := range y4 {
+		y4[j] = y[2*j]
+	}
+	for i := 0; i < maxPitch>>2; i++ {
+		xcorr[i] = innerProd(x4, y4[i:])
+	}
+	best := findBestPitch(xcorr, y4, n>>2, maxPitch>>2)
+
+	for i := range xcorr {
+		xcorr[i] = 0
+		if absInt(i-2*best[0]) > 2 && absInt(i-2*best[1]) > 2 {
+			continue
+		}
+		xcorr[i] = math.Max(-1, innerProd(x[:n>>1], y[i:]))
+	}
+	best = findBestPitch(xcorr, y, n>>1, maxPitch>>1)
+
+	// The lag is refined by pseudo-interpolation.
+	offset := 0
+	if b := best[0]; b > 0 && b < maxPitch>>1-1 {
+		a, b, c := xcorr[b-1], xcorr[b], xcorr[b+1]
+		if c-a > 0.7*(b-a) {
+			offset = 1
+		} else if a-c > 0.7*(b-c) {
+			offset = -1
+		}
+	}
+	return 2*best[0] - offset
+}
+
+// findBestPitch returns the 2 lags less than maxPitch of the best
+// normalized correlations xcorr of n samples with y.
+func findBestPitch(xcorr, y []float64, n, maxPitch int) [2]int {
+	syy := 1.0
+	bestNum := [2]float64{-1, -1}
+	bestDen := [2]float64{0, 0}
+	best := [2]int{0, 1}
+	for _, v := range y[:n] {
+		syy += v * v
+	}
+	for i := 0; i < maxPitch; i++ {
+		if xcorr[i] > 0 {
+			xc := xcorr[i] * 1e-12
+			num := xc * xc
+			if num*bestDen[1] > bestNum[1]*syy {
+				if num*bestDen[0] > bestNum[0]*syy {
+					bestNum[1], bestDen[1], best[1] = bestNum[0], bestDen[0], best[0]
+					bestNum[0], bestDen[0], best[0] = num, syy, i
+				} else {
+					bestNum[1], bestDen[1], best[1] = num, syy, i
+				}
+			}
+		}
+		syy += y[i+n]*y[i+n] - y[i]*y[i]
+		syy = math.Max(1, syy)
+	}
+	return best
+}
+
+// autocorr computes the autocorrelation ac of lags 0 to lag of the n
+// samples x, windowed over ovl samples at both ends by win.
+func autocorr(x, ac, win []float64, ovl, lag, n int) {
+	xx := x[:n]
+	if ovl != 0 {
+		xx = make([]float64, n)
+		copy(xx, x)
+		for i := 0; i < ovl; i++ {
+			xx[i] = x[i] * win[i]
+			xx[n-i-1] = x[n-i-1] * win[i]
+		}
+	}
+	for k := 0; k <= lag; k++ {
+		ac[k] = innerProd(xx[k:], xx)
+	}
+}
+
+// lpcFromAutocorr computes the LPC coefficients lpc from the
+// autocorrelation ac, by the Levinson-Durbin recursion.
+func lpcFromAutocorr(lpc, ac []float64) {
+	for i := range lpc {
+		lpc[i] = 0
+	}
+	e := ac[0]
+	if ac[0] <= 1e-10 {
+		return
+	}
+	for i := range lpc {
+		rr := 0.0
+		for j := 0; j < i; j++ {
+			rr += lpc[j] * ac[i-j]
+		}
+		rr += ac[i+1]
+		r := -rr / e
+		lpc[i] = r
+		for j := 0; j < (i+1)>>1; j++ {
+			t1, t2 := lpc[j], lpc[i-1-j]
+			lpc[j] = t1 + r*t2
+			lpc[i-1-j] = t2 + r*t1
+		}
+		e -= r * r * e
+		if e <= 0.001*ac[0] {
+			break
+		}
+	}
+}
+
+// firFilter filters x[len(num):] by the FIR filter 1 + num into y, with
+// the history x[:len(num)].
+func firFilter(x, num, y []float64) {
+	ord := len(num)
+	for i := range y {
+		s := x[ord+i]
+		for k, v := range num {
+			s += v * x[ord+i-k-1]
+		}
+		y[i] = s
+	}
+}
+
+// iirFilter filters x in place by the IIR filter 1/(1 + den), with the
+// past outputs mem, the last first.
+func iirFilter(x, den, mem []float64) {
+	ord := len(den)
+	y := make([]float64, ord+len(x))
+	for i := 0; i < ord; i++ {
+		y[i] = mem[ord-i-1]
+	}
+	for i, v := range x {
+		s := v
+		for k := 0; k < ord; k++ {
+			s -= den[k] * y[ord+i-k-1]
+		}
+		y[ord+i] = s
+		x[i] = s
+	}
+}
+
+// innerProd returns the inner product of x and y[:len(x)].
+func innerProd(x, y []float64) float64 {
+	s := 0.0
+	for i, v := range x {
+		s += v * y[i]
+	}
+	return s
+}
@@ -0,0 +1,226 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"bufio"
+	"io"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/ogg"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Codec is the opus codec.Codec, registered with package codec when
+// package opus is imported, together with the ogg.Mapping of Opus.
+var Codec codec.Codec = opusCodec{}
+
+func init() {
+	codec.RegisterCodec(Codec)
+	ogg.RegisterMapping(mapping{})
+}
+
+// sampleCodec is the sample codec of decoded streams.
+const sampleCodec = sample.SFloat32L
+
+type opusCodec struct {
+	codec.NullCodec
+}
+
+var (
+	_ codec.Prober            = opusCodec{}
+	_ codec.ConfidenceSniffer = opusCodec{}
+	_ codec.Describer         = opusCodec{}
+	_ codec.OptionsEncoder    = opusCodec{}
+	_ codec.FormNegotiator    = opusCodec{}
+)
+
+// Keys of codec.EncoderOptions.Extra understood by Codec, whose values
+// are ints.
+const (
+	// ExtraComplexity is the Complexity of EncoderOptions.
+	ExtraComplexity = "complexity"
+	// ExtraFrameSize is the FrameSize of EncoderOptions.
+	ExtraFrameSize = "framesize"
+)
+
+// sampleRates are the sample rates of encoders in Hz, ending with the
+// one chosen by NegotiateForm for other rates.
+var sampleRates = [...]int{8000, 12000, 16000, 24000, SampleRate}
+
+// Describe implements codec.Describer.  Decoding and encoding are
+// available while a FrameDecoder and a FrameEncoder are registered.
+func (c opusCodec) Describe() codec.Description {
+	var caps codec.Capabilities
+	if canDecode() {
+		caps |= codec.CanDecode | codec.CanSeek
+	}
+	if canEncode() {
+		caps |= codec.CanEncode
+	}
+	return codec.Description{
+		Name:         "opus",
+		MIMETypes:    []string{"audio/opus"},
+		Capabilities: caps,
+		SampleCodecs: []sample.Codec{sampleCodec}}
+}
+
+func (c opusCodec) Extensions() []string {
+	return []string{".opus"}
+}
+
+func (c opusCodec) Sniff(br *bufio.Reader) bool {
+	return c.SniffConfidence(br) > codec.SniffNone
+}
+
+// SniffConfidence implements codec.ConfidenceSniffer, recognizing Ogg
+// streams whose first logical stream is Opus.
+func (c opusCodec) SniffConfidence(br *bufio.Reader) int {
+	if !isHead(ogg.FirstPacket(br)) {
+		return codec.SniffNone
+	}
+	return codec.SniffStrong
+}
+
+func (c opusCodec) DefaultSampleCodec() sample.Codec {
+	return sampleCodec
+}
+
+func (c opusCodec) Decoder(r io.ReadCloser) (sound.Source, sample.Codec, error) {
+	d, err := NewStreamDecoder(r)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	return d, sampleCodec, nil
+}
+
+func (c opusCodec) SeekingDecoder(r codec.IoReadSeekCloser) (sound.SourceSeeker, sample.Codec, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	return d, sampleCodec, nil
+}
+
+// Encoder returns an encoder with the default options.  Encoded streams
+// are decoded with sample codec sample.SFloat32L, the only one accepted
+// besides codec.AnySampleCodec.
+func (c opusCodec) Encoder(w io.WriteCloser, v sound.Form, sc sample.Codec) (sound.Sink, error) {
+	if sc != codec.AnySampleCodec && sc != sampleCodec {
+		return nil, codec.ErrUnsupportedSampleCodec
+	}
+	return NewEncoder(w, v, nil)
+}
+
+// EncoderWithOptions implements codec.OptionsEncoder.  BitRate is the
+// Bitrate of EncoderOptions, and the Extra keys ExtraComplexity and
+// ExtraFrameSize give its Complexity and FrameSize.  Other options are
+// not understood.
+func (c opusCodec) EncoderWithOptions(w io.WriteCloser, v sound.Form, sc sample.Codec, opts *codec.EncoderOptions) (sound.Sink, error) {
+	if err := opts.Check(codec.OptBitRate, ExtraComplexity, ExtraFrameSize); err != nil {
+		return nil, err
+	}
+	if sc != codec.AnySampleCodec && sc != sampleCodec {
+		return nil, codec.ErrUnsupportedSampleCodec
+	}
+	o := &EncoderOptions{Complexity: DefaultComplexity}
+	if opts == nil {
+		return NewEncoder(w, v, o)
+	}
+	if b := opts.BitRate; b != 0 {
+		if b < 6000 || b > 510000 {
+			return nil, &codec.OptionError{Option: codec.OptBitRate, Reason: "not in [6000, 510000]"}
+		}
+		o.Bitrate = b
+	}
+	if x, ok := opts.Extra[ExtraComplexity]; ok {
+		n, ok := x.(int)
+		if !ok || n < 0 || n > 10 {
+			return nil, &codec.OptionError{Option: ExtraComplexity, Reason: "not an int in [0, 10]"}
+		}
+		o.Complexity = n
+	}
+	if x, ok := opts.Extra[ExtraFrameSize]; ok {
+		n, ok := x.(int)
+		if !ok || !validFrameSize(n) {
+			return nil, &codec.OptionError{Option: ExtraFrameSize, Reason: "not 120, 240, 480, 960, 1920 or 2880"}
+		}
+		o.FrameSize = n
+	}
+	return NewEncoder(w, v, o)
+}
+
+// NegotiateForm implements codec.FormNegotiator, keeping a sample rate
+// of 8, 12, 16, 24 or 48kHz and choosing 48kHz otherwise, limiting the
+// number of channels to 2 and choosing sample codec sample.SFloat32L.
+func (c opusCodec) NegotiateForm(v sound.Form, sc sample.Codec) (sound.Form, sample.Codec) {
+	rate := SampleRate
+	for _, r := range sampleRates {
+		if v.SampleRate() == freq.T(r)*freq.Hertz {
+			rate = r
+		}
+	}
+	nC := v.Channels()
+	if nC > 2 {
+		nC = 2
+	}
+	if rate != int(v.SampleRate()/freq.Hertz) || nC != v.Channels() {
+		v = sound.NewForm(freq.T(rate)*freq.Hertz, nC)
+	}
+	return v, sampleCodec
+}
+
+// Probe implements codec.Prober, reading the headers and the granule
+// position of the last page of the first Opus stream of r.  It does not
+// require a FrameDecoder.
+func (c opusCodec) Probe(r io.ReadSeeker) (*codec.StreamInfo, error) {
+	s, err := ogg.OpenStream(readSeeker{r}, isHead)
+	if err != nil {
+		return nil, err
+	}
+	h, _, _, err := readHeaders(s)
+	if err != nil {
+		return nil, err
+	}
+	g, err := s.LastGranule()
+	if err != nil {
+		return nil, err
+	}
+	frames := g - int64(h.PreSkip)
+	if frames < 0 {
+		frames = 0
+	}
+	return &codec.StreamInfo{
+		Container:   "ogg",
+		Channels:    h.Channels,
+		SampleRate:  SampleRate * freq.Hertz,
+		SampleCodec: sampleCodec,
+		Frames:      frames}, nil
+}
+
+// readSeeker hides the Close method of an io.ReadSeeker.
+type readSeeker struct {
+	io.ReadSeeker
+}
+
+// mapping is the ogg.Mapping of Opus.
+type mapping struct{}
+
+func (m mapping) Name() string {
+	return "opus"
+}
+
+func (m mapping) Identify(first []byte) bool {
+	return isHead(first)
+}
+
+func (m mapping) Decoder(s *ogg.Stream) (sound.Source, sample.Codec, error) {
+	d, err := newDecoder(s)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	return d, sampleCodec, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+	"zikichombo.org/codec/ogg"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+func TestRegistered(t *testing.T) {
+	c, err := codec.CodecFor("x.opus", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != Codec {
+		t.Errorf("got codec %v", c)
+	}
+	caps := Codec.(codec.Describer).Describe().Capabilities
+	if caps != codec.CanDecode|codec.CanSeek|codec.CanEncode {
+		t.Errorf("capabilities %v", caps)
+	}
+}
+
+func TestSniffProbe(t *testing.T) {
+	s := &streams[2]
+	data := s.bytes(t)
+	cs := Codec.(codec.ConfidenceSniffer)
+	if got := cs.SniffConfidence(bufio.NewReader(bytes.NewReader(data))); got != codec.SniffStrong {
+		t.Errorf("sniff confidence %d", got)
+	}
+	if Codec.Sniff(bufio.NewReader(bytes.NewReader([]byte("fLaC\x00\x00\x00\x22")))) {
+		t.Errorf("sniffed flac as opus")
+	}
+	info, err := Codec.(codec.Prober).Probe(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := codec.StreamInfo{
+		Container:   "ogg",
+		Channels:    5,
+		SampleRate:  48000 * freq.Hertz,
+		SampleCodec: sampleCodec,
+		Frames:      s.len()}
+	if *info != want {
+		t.Errorf("probe got %+v want %+v", *info, want)
+	}
+	src, sc, err := Codec.SeekingDecoder(codectest.NewFile(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc != sampleCodec || src.Channels() != 5 || src.Len() != s.len() {
+		t.Errorf("seeking decoder codec %s channels %d len %d", sc, src.Channels(), src.Len())
+	}
+}
+
+// TestOgg checks that the ogg Codec decodes Opus through its mapping.
+func TestOgg(t *testing.T) {
+	useTestDecoder(t)
+	s := &streams[0]
+	data := s.bytes(t)
+	if !ogg.Codec.Sniff(bufio.NewReader(bytes.NewReader(data))) {
+		t.Errorf("ogg codec did not sniff opus")
+	}
+	src, sc, err := ogg.Codec.Decoder(ioutil.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, ok := src.(*Decoder)
+	if !ok || sc != sampleCodec {
+		t.Fatalf("got decoder %T codec %s", src, sc)
+	}
+	check(t, d, s, 0)
+}
+
+func TestEncoderOptions(t *testing.T) {
+	useTestEncoder(t)
+	oe := Codec.(codec.OptionsEncoder)
+	v := form{48000, 2}
+	for _, tc := range []struct {
+		opts *codec.EncoderOptions
+		want EncoderOptions
+	}{
+		{nil, EncoderOptions{Complexity: DefaultComplexity, FrameSize: DefaultFrameSize}},
+		{&codec.EncoderOptions{BitRate: 32000}, EncoderOptions{Bitrate: 32000, Complexity: DefaultComplexity, FrameSize: DefaultFrameSize}},
+		{&codec.EncoderOptions{Extra: map[string]interface{}{ExtraComplexity: 0, ExtraFrameSize: 1920}}, EncoderOptions{FrameSize: 1920}},
+	} {
+		snk, err := oe.EncoderWithOptions(codectest.NewFile(nil), v, codec.AnySampleCodec, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := snk.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if lastOpts.Bitrate != tc.want.Bitrate || lastOpts.Complexity != tc.want.Complexity || lastOpts.FrameSize != tc.want.FrameSize {
+			t.Errorf("%+v: got options %+v", tc.opts, lastOpts)
+		}
+	}
+	for _, tc := range []struct {
+		opts   *codec.EncoderOptions
+		option string
+	}{
+		{&codec.EncoderOptions{BitRate: 1000}, codec.OptBitRate},
+		{&codec.EncoderOptions{CompressionLevel: 5}, codec.OptCompressionLevel},
+		{&codec.EncoderOptions{Metadata: map[string]string{"TITLE": "t"}}, codec.OptMetadata},
+		{&codec.EncoderOptions{Extra: map[string]interface{}{ExtraComplexity: 11}}, ExtraComplexity},
+		{&codec.EncoderOptions{Extra: map[string]interface{}{ExtraFrameSize: "960"}}, ExtraFrameSize},
+		{&codec.EncoderOptions{Extra: map[string]interface{}{"x": 1}}, "x"},
+	} {
+		_, err := oe.EncoderWithOptions(codectest.NewFile(nil), v, codec.AnySampleCodec, tc.opts)
+		if e, ok := err.(*codec.OptionError); !ok || e.Option != tc.option {
+			t.Errorf("%+v: got %v", tc.opts, err)
+		}
+	}
+}
+
+func TestNegotiateForm(t *testing.T) {
+	fn := Codec.(codec.FormNegotiator)
+	for _, tc := range []struct {
+		in, want form
+	}{
+		{form{48000, 2}, form{48000, 2}},
+		{form{16000, 1}, form{16000, 1}},
+		{form{44100, 2}, form{48000, 2}},
+		{form{22050, 6}, form{48000, 2}},
+	} {
+		v, sc := fn.NegotiateForm(tc.in, sample.SInt16L)
+		if v.SampleRate() != tc.want.SampleRate() || v.Channels() != tc.want.nC || sc != sampleCodec {
+			t.Errorf("%+v: got %s %d channels %s", tc.in, v.SampleRate(), v.Channels(), sc)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+const (
+	maxPVQN = 176 // widest band
+	maxPVQK = 128 // pulses of maxPseudo
+	pvqSat  = 1 << 40
+)
+
+// pvqTable holds V(n, k), the number of vectors of n integers whose
+// absolute values sum to k, saturated at pvqSat.
+var pvqTable = newPVQTable()
+
+func newPVQTable() *[maxPVQN + 1][maxPVQK + 2]uint64 {
+	pvqTable := new([maxPVQN + 1][maxPVQK + 2]uint64)
+	pvqTable[0][0] = 1
+	for n := 1; n <= maxPVQN; n++ {
+		pvqTable[n][0] = 1
+		for k := 1; k < len(pvqTable[n]); k++ {
+			v := pvqTable[n-1][k] + pvqTable[n][k-1] + pvqTable[n-1][k-1]
+			if v > pvqSat {
+				v = pvqSat
+			}
+			pvqTable[n][k] = v
+		}
+	}
+	return pvqTable
+}
+
+// pvqFits32 returns whether V(n, k) is less than 1<<32.
+func pvqFits32(n, k int) bool {
+	if n > maxPVQN || k > maxPVQK {
+		return false
+	}
+	return pvqTable[n][k] < 1<<32
+}
+
+// pvqV returns V(n, k), which must fit in 32 bits.
+func pvqV(n, k int) uint32 {
+	return uint32(pvqTable[n][k])
+}
+
+// decodePulses decodes the vector y of k pulses, as in RFC 6716 section
+// 4.3.4.2.
+func (d *rangeDecoder) decodePulses(y []int, k int) {
+	pvqVector(y, k, uint64(d.uint(pvqV(len(y), k))))
+}
+
+// pvqVector sets y to the vector of k pulses of index i.
+func pvqVector(y []int, k int, i uint64) {
+	n := len(y)
+	for j := range y {
+		m := n - j
+		p := (pvqTable[m-1][k] + pvqTable[m][k]) >> 1
+		sgn := 1
+		if i >= p {
+			sgn = -1
+			i -= p
+		}
+		k0 := k
+		p -= pvqTable[m-1][k]
+		for p > i {
+			k--
+			p -= pvqTable[m-1][k]
+		}
+		y[j] = sgn * (k0 - k)
+		i -= p
+	}
+}
+
+// encodePulses encodes the vector y of k pulses, as the index of
+// pvqVector.
+func (e *rangeEncoder) encodePulses(y []int, k int) {
+	e.uint(uint32(pvqIndex(y, k)), pvqV(len(y), k))
+}
+
+// pvqIndex returns the index of the vector y of k pulses.
+func pvqIndex(y []int, k int) uint64 {
+	n := len(y)
+	var i uint64
+	for j, v := range y {
+		m := n - j
+		a := v
+		if v < 0 {
+			a = -v
+			i += (pvqTable[m-1][k] + pvqTable[m][k]) >> 1
+		}
+		for u := 0; u < k-a; u++ {
+			i += pvqTable[m-1][u]
+		}
+		k -= a
+	}
+	return i
+}
@@ -0,0 +1,345 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"fmt"
+	"io"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/ogg"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// preRoll is the number of samples decoded before the target of a seek,
+// for the decoder to converge, as RFC 7845 section 4.6 recommends.
+const preRoll = 3840
+
+// Decoder decodes an Ogg Opus stream.
+type Decoder struct {
+	s        *ogg.Stream
+	head     *Head
+	vendor   string
+	comments []string
+	gain     float64
+
+	decs []FrameDecoder // decoders of the elementary streams
+	sbuf [][]float64    // samples of the elementary streams
+	pkt  []byte
+
+	buf    []float64 // samples of the current packet
+	stride int       // samples per channel of buf
+	bOff   int       // position in buf
+	bLen   int       // samples per channel of buf to output
+
+	gran int64 // granule position after the current packet
+	skip int64 // samples to discard before output
+	pos  int64
+	nFrm int64 // number of frames, or -1 if unknown
+	err  error // error ending the frames decoded
+}
+
+// ReadSeekerCloser is the source of a seeking Decoder.
+type ReadSeekerCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// NewDecoder creates a decoder from the first Opus stream of the Ogg
+// stream starting at the current offset of r.
+func NewDecoder(r ReadSeekerCloser) (*Decoder, error) {
+	s, err := ogg.OpenStream(r, isHead)
+	if err != nil {
+		return nil, err
+	}
+	return newDecoder(s)
+}
+
+// NewStreamDecoder creates a decoder from the first Opus stream of the
+// Ogg stream read sequentially from r.  The decoder cannot seek.
+func NewStreamDecoder(r io.ReadCloser) (*Decoder, error) {
+	s, err := ogg.OpenStream(readCloser{r}, isHead)
+	if err != nil {
+		return nil, err
+	}
+	return newDecoder(s)
+}
+
+// readCloser hides the methods of an io.ReadCloser other than Read and
+// Close, so that an ogg.Stream does not seek it.
+type readCloser struct {
+	io.ReadCloser
+}
+
+// newDecoder creates a decoder of s, whose next packet is the
+// identification header.
+func newDecoder(s *ogg.Stream) (*Decoder, error) {
+	h, vendor, comments, err := readHeaders(s)
+	if err != nil {
+		return nil, err
+	}
+	d := &Decoder{
+		s:        s,
+		head:     h,
+		vendor:   vendor,
+		comments: comments,
+		gain:     h.gain(),
+		skip:     int64(h.PreSkip),
+		nFrm:     -1,
+		buf:      make([]float64, h.Channels*MaxPacketDuration)}
+	for i := 0; i < h.Streams; i++ {
+		nC := 1
+		if i < h.Coupled {
+			nC = 2
+		}
+		dec, err := newFrameDecoder(nC)
+		if err != nil {
+			return nil, err
+		}
+		d.decs = append(d.decs, dec)
+		d.sbuf = append(d.sbuf, make([]float64, nC*MaxPacketDuration))
+	}
+	if s.CanSeek() {
+		g, err := s.LastGranule()
+		if err != nil {
+			return nil, err
+		}
+		if d.nFrm = g - int64(h.PreSkip); d.nFrm < 0 {
+			d.nFrm = 0
+		}
+	}
+	return d, nil
+}
+
+// readHeaders reads the identification and comment headers of s.
+func readHeaders(s *ogg.Stream) (*Head, string, []string, error) {
+	p, err := s.ReadPacket()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	h, err := parseHead(p.Data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	p, err = s.ReadPacket()
+	if err == io.EOF {
+		return nil, "", nil, errs.Decode(codec.ErrTruncated, -1, "OpusTags", "no comment header")
+	}
+	if err != nil {
+		return nil, "", nil, err
+	}
+	vendor, comments, err := parseTags(p.Data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return h, vendor, comments, nil
+}
+
+var _ sound.SourceSeeker = (*Decoder)(nil)
+
+// Head returns the identification header.
+func (d *Decoder) Head() Head {
+	return *d.head
+}
+
+// Vendor returns the vendor string of the comment header.
+func (d *Decoder) Vendor() string {
+	return d.vendor
+}
+
+// Comments returns the comments of the comment header, of the form
+// "NAME=value".
+func (d *Decoder) Comments() []string {
+	return d.comments
+}
+
+// Codec returns sample.SFloat32L, as Opus decodes to floating point
+// samples.
+func (d *Decoder) Codec() sample.Codec {
+	return sampleCodec
+}
+
+func (d *Decoder) SampleRate() freq.T {
+	return SampleRate * freq.Hertz
+}
+
+func (d *Decoder) Channels() int {
+	return d.head.Channels
+}
+
+func (d *Decoder) Receive(dst []float64) (int, error) {
+	nC := d.Channels()
+	if len(dst)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := len(dst) / nC
+	n := 0
+	for n < nF {
+		if d.bOff == d.bLen {
+			if d.err == nil {
+				d.err = d.next()
+			}
+			if d.err != nil {
+				break
+			}
+		}
+		k := d.bLen - d.bOff
+		if k > nF-n {
+			k = nF - n
+		}
+		for c := 0; c < nC; c++ {
+			copy(dst[c*nF+n:c*nF+n+k], d.buf[c*d.stride+d.bOff:])
+		}
+		n += k
+		d.bOff += k
+		d.pos += int64(k)
+	}
+	if n == 0 {
+		return 0, d.err
+	}
+	for c := 1; c < nC; c++ {
+		copy(dst[c*n:(c+1)*n], dst[c*nF:c*nF+n])
+	}
+	return n, nil
+}
+
+// next decodes the next packet with samples to output, trimming the end
+// of the last packet to the granule position of its page.
+func (d *Decoder) next() error {
+	for {
+		p, err := d.s.ReadPacket()
+		if err == io.EOF {
+			if d.nFrm < 0 {
+				d.nFrm = d.pos
+			}
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+		n, err := d.decode(p.Data)
+		if err != nil {
+			return err
+		}
+		start := d.gran
+		d.gran += int64(n)
+		d.stride, d.bOff, d.bLen = n, 0, n
+		if p.EOS && p.Granule >= 0 && p.Granule < d.gran {
+			d.bLen = 0
+			if p.Granule > start {
+				d.bLen = int(p.Granule - start)
+			}
+		}
+		if d.skip > 0 {
+			k := int64(d.bLen)
+			if k > d.skip {
+				k = d.skip
+			}
+			d.bOff = int(k)
+			d.skip -= k
+		}
+		if d.bOff < d.bLen {
+			return nil
+		}
+	}
+}
+
+// decode decodes the packet p of the elementary streams into d.buf,
+// returning its number of samples per channel.
+func (d *Decoder) decode(p []byte) (int, error) {
+	n := -1
+	for i, dec := range d.decs {
+		sd := i < len(d.decs)-1
+		toc, frames, size, err := parsePacket(p, sd)
+		if err != nil {
+			return 0, err
+		}
+		sub := p[:size]
+		if sd {
+			d.pkt = appendPacket(d.pkt[:0], toc, frames)
+			sub = d.pkt
+		}
+		p = p[size:]
+		k := len(frames) * toc.FrameSize()
+		if n >= 0 && k != n {
+			return 0, errs.Decode(codec.ErrCorrupt, -1, "packet", "stream %d of %d samples, not %d", i, k, n)
+		}
+		n = k
+		m, err := dec.Decode(sub, d.sbuf[i])
+		if err != nil {
+			return 0, err
+		}
+		if m != n {
+			return 0, errs.Decode(codec.ErrCorrupt, -1, "packet", "stream %d decoded %d of %d samples", i, m, n)
+		}
+	}
+	nCoupled := 2 * d.head.Coupled
+	for c, m := range d.head.Mapping {
+		out := d.buf[c*n : (c+1)*n]
+		var in []float64
+		switch {
+		case m == 255:
+			for i := range out {
+				out[i] = 0
+			}
+			continue
+		case int(m) < nCoupled:
+			in = d.sbuf[m/2][int(m%2)*n:]
+		default:
+			in = d.sbuf[d.head.Coupled+int(m)-nCoupled]
+		}
+		for i := range out {
+			out[i] = in[i] * d.gain
+		}
+	}
+	return n, nil
+}
+
+// Len returns the number of frames, or -1 if it is unknown because the
+// decoder cannot seek and has not reached the end of the stream.
+func (d *Decoder) Len() int64 {
+	return d.nFrm
+}
+
+func (d *Decoder) Pos() int64 {
+	return d.pos
+}
+
+// Seek seeks to frame f, returning an error if f is negative or the
+// decoder cannot seek.  Decoding starts 80ms before f, for the decoder to
+// converge.  Seeking beyond the end is allowed, after which Receive
+// returns io.EOF.
+func (d *Decoder) Seek(f int64) error {
+	if !d.s.CanSeek() {
+		return codec.ErrUnsupportedFunction
+	}
+	if f < 0 {
+		return fmt.Errorf("opus: seek to negative frame %d", f)
+	}
+	d.bOff, d.bLen, d.err = 0, 0, nil
+	if f >= d.nFrm {
+		d.pos, d.err = f, io.EOF
+		return nil
+	}
+	t := f + int64(d.head.PreSkip)
+	g := t - preRoll
+	if g < 0 {
+		g = 0
+	}
+	g, err := d.s.SeekGranule(g)
+	if err != nil {
+		return err
+	}
+	for _, dec := range d.decs {
+		dec.Reset()
+	}
+	d.gran, d.skip, d.pos = g, t-g, f
+	return nil
+}
+
+func (d *Decoder) Close() error {
+	return d.s.Close()
+}
@@ -0,0 +1,295 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+	"zikichombo.org/codec/ogg"
+)
+
+// testDecoder is a FrameDecoder of test packets, each frame of which
+// holds either a byte v, decoded as samples v/1024+c/4 for each channel
+// c, or the samples of each channel in turn as signed bytes, as written
+// by testEncoder.
+type testDecoder struct {
+	nC     int
+	resets int
+}
+
+func newTestDecoder(nC int) (FrameDecoder, error) {
+	return &testDecoder{nC: nC}, nil
+}
+
+// useTestDecoder registers newTestDecoder until t and its subtests
+// complete, when the FrameDecoder creator registered before is restored.
+func useTestDecoder(t *testing.T) {
+	frameDecoders.Lock()
+	f := frameDecoders.f
+	frameDecoders.Unlock()
+	RegisterFrameDecoder(newTestDecoder)
+	t.Cleanup(func() { RegisterFrameDecoder(f) })
+}
+
+func (d *testDecoder) Decode(p []byte, dst []float64) (int, error) {
+	toc, frames, err := ParsePacket(p)
+	if err != nil {
+		return 0, err
+	}
+	if toc.Stereo() != (d.nC == 2) {
+		return 0, errs.Decode(codec.ErrCorrupt, -1, "packet", "stereo %t", toc.Stereo())
+	}
+	fs := toc.FrameSize()
+	n := len(frames) * fs
+	for c := 0; c < d.nC; c++ {
+		for i, f := range frames {
+			for j := 0; j < fs; j++ {
+				if len(f) == fs*d.nC {
+					dst[c*n+i*fs+j] = float64(int8(f[c*fs+j])) / 128
+				} else {
+					dst[c*n+i*fs+j] = value(f[0], c)
+				}
+			}
+		}
+	}
+	return n, nil
+}
+
+func (d *testDecoder) Reset() {
+	d.resets++
+}
+
+func value(v byte, c int) float64 {
+	return float64(v)/1024 + float64(c)/4
+}
+
+// testStream describes an Ogg Opus stream of test packets.
+type testStream struct {
+	head    Head
+	packets int   // number of packets of 20ms
+	trim    int64 // samples trimmed from the end
+}
+
+// packet returns packet k of s, of 1 or 2 frames of 10ms of byte k%256
+// for each elementary stream.
+func (s *testStream) packet(k int) []byte {
+	var p []byte
+	for i := 0; i < s.head.Streams; i++ {
+		toc := TOC(30 << 3)
+		if i < s.head.Coupled {
+			toc |= 4
+		}
+		fs := [][]byte{{byte(k)}, {byte(k)}}
+		if k%3 == 0 {
+			toc += 1 << 3
+			fs = [][]byte{{byte(k), 0, 0}}
+		}
+		sub := appendPacket(nil, toc, fs)
+		if i < s.head.Streams-1 {
+			sub = selfDelimit(sub, fs)
+		}
+		p = append(p, sub...)
+	}
+	return p
+}
+
+// headPacket returns the identification header h.
+func headPacket(h *Head) []byte {
+	return appendHead(nil, h)
+}
+
+func tagsPacket(vendor string, comments ...string) []byte {
+	return appendTags(nil, vendor, comments)
+}
+
+func (s *testStream) bytes(t *testing.T) []byte {
+	var b bytes.Buffer
+	w := ogg.NewWriter(&b, 7)
+	if err := w.WritePacket(headPacket(&s.head), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(tagsPacket("test", "TITLE=x"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < s.packets; k++ {
+		g := int64(k+1) * 960
+		if k == s.packets-1 {
+			g -= s.trim
+		}
+		if err := w.WritePacket(s.packet(k), g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// want returns the sample of channel c at frame f of s.
+func (s *testStream) want(f int64, c int) float64 {
+	m := int(s.head.Mapping[c])
+	switch {
+	case m == 255:
+		return 0
+	case m < 2*s.head.Coupled:
+		c = m % 2
+	default:
+		c = 0
+	}
+	k := (f + int64(s.head.PreSkip)) / 960
+	return value(byte(k), c) * s.head.gain()
+}
+
+func (s *testStream) len() int64 {
+	return int64(s.packets)*960 - s.trim - int64(s.head.PreSkip)
+}
+
+// check decodes d to its end and checks its samples against s from
+// frame f.
+func check(t *testing.T, d *Decoder, s *testStream, f int64) {
+	t.Helper()
+	nC := d.Channels()
+	buf := make([]float64, 700*nC)
+	for {
+		n, err := d.Receive(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for c := 0; c < nC; c++ {
+			for i, v := range buf[c*n : (c+1)*n] {
+				if w := s.want(f+int64(i), c); math.Abs(v-w) > 1e-9 {
+					t.Fatalf("frame %d channel %d: got %g want %g", f+int64(i), c, v, w)
+				}
+			}
+		}
+		f += int64(n)
+	}
+	if f != s.len() || d.Pos() != f || d.Len() != f {
+		t.Errorf("decoded %d frames, pos %d, len %d, of %d", f, d.Pos(), d.Len(), s.len())
+	}
+}
+
+var streams = []testStream{
+	{head: Head{Version: 1, Channels: 1, PreSkip: 312, Mapping: []byte{0}, Streams: 1}, packets: 100, trim: 500},
+	{head: Head{Version: 1, Channels: 2, PreSkip: 3840, Mapping: []byte{0, 1}, Streams: 1, Coupled: 1, OutputGain: -6 * 256}, packets: 400},
+	{head: Head{Version: 1, Channels: 5, PreSkip: 100, Family: 1, Streams: 3, Coupled: 1, Mapping: []byte{0, 3, 1, 255, 2}}, packets: 300, trim: 959},
+}
+
+func TestDecode(t *testing.T) {
+	useTestDecoder(t)
+	for i := range streams {
+		s := &streams[i]
+		data := s.bytes(t)
+		d, err := NewStreamDecoder(ioutil.NopCloser(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Len() != -1 {
+			t.Errorf("stream %d: len %d", i, d.Len())
+		}
+		if err := d.Seek(0); err != codec.ErrUnsupportedFunction {
+			t.Errorf("stream %d: seek: %v", i, err)
+		}
+		h := d.Head()
+		if h.Channels != s.head.Channels || h.Streams != s.head.Streams || h.Coupled != s.head.Coupled || !bytes.Equal(h.Mapping, s.head.Mapping) {
+			t.Errorf("stream %d: head %+v", i, h)
+		}
+		if d.Vendor() != "test" || len(d.Comments()) != 1 || d.Comments()[0] != "TITLE=x" {
+			t.Errorf("stream %d: vendor %q comments %q", i, d.Vendor(), d.Comments())
+		}
+		check(t, d, s, 0)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	useTestDecoder(t)
+	for i := range streams {
+		s := &streams[i]
+		d, err := NewDecoder(codectest.NewFile(s.bytes(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Len() != s.len() {
+			t.Errorf("stream %d: len %d of %d", i, d.Len(), s.len())
+		}
+		for _, f := range []int64{s.len() / 2, 0, 1, s.len() - 1, 12345, 959, s.len()} {
+			if err := d.Seek(f); err != nil {
+				t.Fatal(err)
+			}
+			check(t, d, s, f)
+		}
+		if resets := d.decs[0].(*testDecoder).resets; resets != 6 {
+			t.Errorf("stream %d: %d resets", i, resets)
+		}
+		if err := d.Seek(-1); err == nil {
+			t.Errorf("stream %d: seek to -1", i)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	useTestDecoder(t)
+	s := &testStream{head: streams[0].head, packets: 10}
+	data := s.bytes(t)
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not ogg", []byte("RIFF0000WAVEfmt ")},
+		{"truncated", data[:len(data)-10]},
+	} {
+		d, err := NewStreamDecoder(ioutil.NopCloser(bytes.NewReader(tc.data)))
+		if err == nil {
+			_, err = ioutil.ReadAll(&reader{d})
+		}
+		if _, ok := err.(*codec.DecodeError); !ok {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+	}
+	for _, h := range []Head{
+		{Version: 16, Channels: 1, Mapping: []byte{0}},
+		{Channels: 0, Mapping: []byte{}},
+		{Channels: 3, Mapping: []byte{0, 1, 2}},
+		{Channels: 2, Family: 2, Streams: 2, Mapping: []byte{0, 1}},
+		{Channels: 2, Family: 1, Streams: 1, Coupled: 2, Mapping: []byte{0, 1}},
+		{Channels: 2, Family: 1, Streams: 1, Mapping: []byte{0, 1}},
+	} {
+		if _, err := parseHead(headPacket(&h)); err == nil {
+			t.Errorf("head %+v: no error", h)
+		}
+	}
+	if _, _, err := parseTags(tagsPacket("x", "a")[:20]); err == nil {
+		t.Errorf("truncated tags: no error")
+	}
+	RegisterFrameDecoder(nil)
+	_, err := NewStreamDecoder(ioutil.NopCloser(bytes.NewReader(data)))
+	if de, ok := err.(*codec.DecodeError); !ok || de.Err != codec.ErrUnsupportedFormat {
+		t.Errorf("no frame decoder: got %v", err)
+	}
+}
+
+// reader reads the first channel of a decoder as bytes, for draining it.
+type reader struct {
+	d *Decoder
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	buf := make([]float64, len(p)*r.d.Channels())
+	n, err := r.d.Receive(buf)
+	return n, err
+}
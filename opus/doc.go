@@ -0,0 +1,50 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package opus provides decoding and encoding of Ogg Opus files, as
+// specified in RFC 7845.
+//
+// A Decoder reads the identification and comment headers, splits the
+// packets of multistream files of channel mapping families 0 and 1 into
+// those of their elementary streams, maps the decoded channels, applies
+// the output gain, discards the pre-skip and trims the end of the stream
+// to the granule position of its last page.  Decoders seek by bisection
+// on granule positions, decoding 80ms before the target.  Decoded streams
+// are at 48kHz.
+//
+// An Encoder of mono or stereo samples at 8, 12, 16, 24 or 48kHz writes
+// the headers, with the delay of the encoder as pre-skip, and packets of
+// a fixed duration, the last one with the granule position at which
+// decoders trim the stream to the samples sent.
+//
+// Package opus parses Opus packets as in RFC 6716 section 3, and the
+// frames of the packets are decoded and encoded by the FrameDecoder and
+// FrameEncoder registered with RegisterFrameDecoder and
+// RegisterFrameEncoder.  Creating a Decoder or Encoder fails while there
+// is none.  Probing a file does not need one.
+//
+// The FrameDecoder of package opus implements the decoder of RFC 6716
+// section 4: the range decoder, the SILK decoder with its resampler, the
+// CELT decoder with the postfilter and anti-collapse, hybrid frames and
+// the transitions between modes, with their redundant CELT frames.  It
+// conceals lost frames as the reference implementation does.  It decodes
+// the final ranges of the reference decoder, and its samples within 1 in
+// 32768, from the streams of testdata, coded by the reference encoder in
+// all modes and bandwidths; concealing lost CELT frames by pitch
+// extrapolation is sensitive to rounding and differs slightly more.
+//
+// The FrameEncoder of package opus encodes CELT frames at a constant bit
+// rate, by default 64kb/s for mono and 96kb/s for stereo, with a
+// lookahead of 2.5ms.  It codes long blocks only, without postfilter,
+// and boosts bands and trims the allocation by analysis of the band
+// energies.  Complexity 3 and over choose the spreading by analysis and
+// 4 and over also try coding coarse energies intra.  Samples at rates below
+// 48kHz are upsampled and coded with the bandwidth they have.  Packets
+// of 40 and 60ms hold 2 and 3 frames of 20ms.
+//
+// Importing package opus registers it with zikichombo.org/codec for the
+// extension .opus, and registers the ogg.Mapping of Opus with package
+// ogg.
+//
+// Package opus is part of http://zikichombo.org
+package opus /* import "zikichombo.org/codec/opus" */
@@ -0,0 +1,243 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+
+	"zikichombo.org/codec/ogg"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+)
+
+const (
+	// DefaultComplexity is the complexity of nil EncoderOptions.
+	DefaultComplexity = 10
+	// DefaultFrameSize is the frame size of EncoderOptions which give
+	// none, 20ms.
+	DefaultFrameSize = 960
+)
+
+// Vendor is the vendor string of the comment headers written.
+const Vendor = "zikichombo.org/codec/opus"
+
+// EncoderOptions are the options of an Encoder.
+type EncoderOptions struct {
+	// Bitrate is the target bit rate in bits per second, from 6000 to
+	// 510000, or 0 to let the FrameEncoder choose.
+	Bitrate int
+	// Complexity is the computational complexity, from 0, the fastest,
+	// to 10, the best quality.
+	Complexity int
+	// FrameSize is the number of samples per channel of each packet at
+	// 48kHz: 120, 240, 480, 960, 1920 or 2880, or 0 for
+	// DefaultFrameSize.
+	FrameSize int
+	// Comments are the comments of the comment header, of the form
+	// "NAME=value".
+	Comments []string
+}
+
+// Encoder encodes an Ogg Opus stream.
+type Encoder struct {
+	c  io.Closer
+	ow *ogg.Writer
+	fe FrameEncoder
+	nC int
+
+	ratio   int64 // samples at 48kHz per input sample
+	preSkip int64
+	frame48 int64 // samples per channel of a packet at 48kHz
+
+	buf     []float64 // samples of the current packet, by channel
+	frame   int       // samples per channel of buf
+	n       int       // samples per channel in buf
+	total   int64     // samples per channel sent
+	packets int64     // number of packets encoded
+	held    []byte    // last packet encoded, not yet written
+	pkt     []byte
+	closed  bool
+}
+
+var _ sound.Sink = (*Encoder)(nil)
+
+// NewEncoder creates an encoder of samples of form v to w, with options
+// opts or the defaults if opts is nil.  v must have 1 or 2 channels and
+// a sample rate of 8, 12, 16, 24 or 48kHz.  NewEncoder returns
+// codec.ErrUnsupportedFunction if no FrameEncoder is registered.
+//
+// The encoder writes the identification and comment headers, the
+// packets, and at Close the final granule position, from which decoders
+// trim the end of the stream to the samples sent.  w need not be an
+// io.Seeker.
+func NewEncoder(w io.WriteCloser, v sound.Form, opts *EncoderOptions) (*Encoder, error) {
+	o := EncoderOptions{Complexity: DefaultComplexity}
+	if opts != nil {
+		o = *opts
+	}
+	if o.FrameSize == 0 {
+		o.FrameSize = DefaultFrameSize
+	}
+	rate := 0
+	for _, r := range sampleRates {
+		if v.SampleRate() == freq.T(r)*freq.Hertz {
+			rate = r
+		}
+	}
+	switch {
+	case rate == 0:
+		return nil, fmt.Errorf("opus: unsupported sample rate %s", v.SampleRate())
+	case v.Channels() < 1 || v.Channels() > 2:
+		return nil, fmt.Errorf("opus: unsupported number of channels %d", v.Channels())
+	case o.Bitrate != 0 && (o.Bitrate < 6000 || o.Bitrate > 510000):
+		return nil, fmt.Errorf("opus: invalid bit rate %d", o.Bitrate)
+	case o.Complexity < 0 || o.Complexity > 10:
+		return nil, fmt.Errorf("opus: invalid complexity %d", o.Complexity)
+	case !validFrameSize(o.FrameSize):
+		return nil, fmt.Errorf("opus: invalid frame size %d", o.FrameSize)
+	}
+	fe, err := newFrameEncoder(rate, v.Channels(), &o)
+	if err != nil {
+		return nil, err
+	}
+	ratio := int64(SampleRate / rate)
+	e := &Encoder{
+		c:       w,
+		ow:      ogg.NewWriter(w, rand.Uint32()),
+		fe:      fe,
+		nC:      v.Channels(),
+		ratio:   ratio,
+		preSkip: int64(fe.Lookahead()),
+		frame48: int64(o.FrameSize),
+		frame:   o.FrameSize / int(ratio),
+		buf:     make([]float64, v.Channels()*o.FrameSize/int(ratio))}
+	h := &Head{
+		Version:   1,
+		Channels:  v.Channels(),
+		PreSkip:   fe.Lookahead(),
+		InputRate: rate}
+	if err := e.ow.WritePacket(appendHead(nil, h), 0); err != nil {
+		return nil, err
+	}
+	if err := e.ow.WritePacket(appendTags(nil, Vendor, o.Comments), 0); err != nil {
+		return nil, err
+	}
+	if err := e.ow.Flush(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func validFrameSize(n int) bool {
+	switch n {
+	case 120, 240, 480, 960, 1920, 2880:
+		return true
+	}
+	return false
+}
+
+func (e *Encoder) SampleRate() freq.T {
+	return freq.T(SampleRate/e.ratio) * freq.Hertz
+}
+
+func (e *Encoder) Channels() int {
+	return e.nC
+}
+
+func (e *Encoder) Send(src []float64) error {
+	if len(src)%e.nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	if e.closed {
+		return errors.New("opus: send to closed encoder")
+	}
+	nF := len(src) / e.nC
+	for f := 0; f < nF; {
+		k := e.frame - e.n
+		if k > nF-f {
+			k = nF - f
+		}
+		for c := 0; c < e.nC; c++ {
+			copy(e.buf[c*e.frame+e.n:], src[c*nF+f:c*nF+f+k])
+		}
+		e.n += k
+		f += k
+		e.total += int64(k)
+		if e.n == e.frame {
+			if err := e.encode(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// encode encodes the current packet, padded with silence, and writes the
+// one before it, whose granule position is then known.
+func (e *Encoder) encode() error {
+	for c := 0; c < e.nC; c++ {
+		pad := e.buf[c*e.frame+e.n : (c+1)*e.frame]
+		for i := range pad {
+			pad[i] = 0
+		}
+	}
+	e.n = 0
+	pkt, err := e.fe.Encode(e.buf[:e.nC*e.frame], e.pkt[:0])
+	if err != nil {
+		return err
+	}
+	n, err := Duration(pkt)
+	if err != nil {
+		return err
+	}
+	if int64(n) != e.frame48 {
+		return fmt.Errorf("opus: frame encoder gave a packet of %d samples, not %d", n, e.frame48)
+	}
+	if e.packets > 0 {
+		if err := e.ow.WritePacket(e.held, e.packets*e.frame48); err != nil {
+			return err
+		}
+	}
+	e.pkt, e.held = e.held, pkt
+	e.packets++
+	return nil
+}
+
+// Close encodes the samples sent and the delay of the encoder, writes the
+// last packet with the final granule position, and closes the
+// destination.
+func (e *Encoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	if err := e.finish(); err != nil {
+		e.c.Close()
+		return err
+	}
+	return e.c.Close()
+}
+
+func (e *Encoder) finish() error {
+	end := e.preSkip + e.total*e.ratio
+	if e.n > 0 {
+		if err := e.encode(); err != nil {
+			return err
+		}
+	}
+	for e.packets*e.frame48 < end {
+		if err := e.encode(); err != nil {
+			return err
+		}
+	}
+	if e.packets > 0 {
+		if err := e.ow.WritePacket(e.held, end); err != nil {
+			return err
+		}
+	}
+	return e.ow.Close()
+}
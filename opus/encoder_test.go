@@ -0,0 +1,270 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// testEncoder is a FrameEncoder of test packets of frames of 10ms, which
+// hold the samples repeated to 48kHz as signed bytes, delayed by
+// testLookahead samples.
+type testEncoder struct {
+	nC    int
+	ratio int
+	delay [][]float64
+	opts  EncoderOptions
+}
+
+const testLookahead = 312
+
+// lastOpts holds the options of the last testEncoder created.
+var lastOpts EncoderOptions
+
+func newTestEncoder(rate, nC int, opts *EncoderOptions) (FrameEncoder, error) {
+	if opts.FrameSize < 480 {
+		return nil, fmt.Errorf("frame size %d", opts.FrameSize)
+	}
+	lastOpts = *opts
+	e := &testEncoder{nC: nC, ratio: SampleRate / rate, delay: make([][]float64, nC)}
+	for c := range e.delay {
+		e.delay[c] = make([]float64, testLookahead)
+	}
+	return e, nil
+}
+
+// useTestEncoder registers newTestEncoder until t and its subtests
+// complete, when the FrameEncoder creator registered before is restored.
+func useTestEncoder(t *testing.T) {
+	frameEncoders.Lock()
+	f := frameEncoders.f
+	frameEncoders.Unlock()
+	RegisterFrameEncoder(newTestEncoder)
+	t.Cleanup(func() { RegisterFrameEncoder(f) })
+}
+
+func (e *testEncoder) Encode(pcm []float64, dst []byte) ([]byte, error) {
+	n := len(pcm) / e.nC
+	n48 := n * e.ratio
+	frames := make([][]byte, n48/480)
+	for i := range frames {
+		frames[i] = make([]byte, 480*e.nC)
+	}
+	for c := 0; c < e.nC; c++ {
+		for _, x := range pcm[c*n : (c+1)*n] {
+			for k := 0; k < e.ratio; k++ {
+				e.delay[c] = append(e.delay[c], x)
+			}
+		}
+		for j, x := range e.delay[c][:n48] {
+			v := math.Floor(x*128 + 0.5)
+			if v > 127 {
+				v = 127
+			} else if v < -128 {
+				v = -128
+			}
+			frames[j/480][c*480+j%480] = byte(int8(v))
+		}
+		e.delay[c] = append(e.delay[c][:0], e.delay[c][n48:]...)
+	}
+	toc := TOC(30 << 3)
+	if e.nC == 2 {
+		toc |= 4
+	}
+	return appendPacket(dst, toc, frames), nil
+}
+
+func (e *testEncoder) Lookahead() int {
+	return testLookahead
+}
+
+type form struct {
+	rate, nC int
+}
+
+func (v form) SampleRate() freq.T { return freq.T(v.rate) * freq.Hertz }
+func (v form) Channels() int      { return v.nC }
+
+// TestConformance runs the conformance suite at 48kHz, the rate of
+// decoded streams.
+func TestConformance(t *testing.T) {
+	useTestDecoder(t)
+	useTestEncoder(t)
+	codectest.Run(t, Codec, &codectest.Options{
+		Forms:     []sound.Form{form{48000, 1}, form{48000, 2}},
+		Tolerance: 1.0 / 128})
+}
+
+// signal returns n samples of nC channels of a tone.
+func signal(nC, n, rate int) []float64 {
+	res := make([]float64, nC*n)
+	for c := 0; c < nC; c++ {
+		for i := 0; i < n; i++ {
+			res[c*n+i] = 0.5 * math.Sin(float64(i)*2*math.Pi*440/float64(rate)+float64(c))
+		}
+	}
+	return res
+}
+
+// encode encodes the planar samples x to w, sending them in blocks of
+// irregular size.
+func encode(t *testing.T, w io.WriteCloser, v form, x []float64, opts *EncoderOptions) {
+	t.Helper()
+	e, err := NewEncoder(w, v, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len(x) / v.nC
+	for i, sz := 0, 1; i < n; i, sz = i+sz, sz*2+3 {
+		j := i + sz
+		if j > n {
+			j = n
+		}
+		var part []float64
+		for c := 0; c < v.nC; c++ {
+			part = append(part, x[c*n+i:c*n+j]...)
+		}
+		if err := e.Send(part); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// decode decodes d to its end, checking its samples against x at rate,
+// and returns the number of frames.
+func decode(t *testing.T, d *Decoder, x []float64, rate int) int64 {
+	t.Helper()
+	nC := d.Channels()
+	n := int64(len(x) / nC)
+	ratio := int64(SampleRate / rate)
+	buf := make([]float64, 1000*nC)
+	f := int64(0)
+	for {
+		k, err := d.Receive(buf)
+		if err == io.EOF {
+			return f
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for c := 0; c < nC; c++ {
+			for i, v := range buf[c*k : (c+1)*k] {
+				j := (f + int64(i)) / ratio
+				if j >= n || math.Abs(v-x[int64(c)*n+j]) > 1.0/128 {
+					t.Fatalf("frame %d channel %d: got %g", f+int64(i), c, v)
+				}
+			}
+		}
+		f += int64(k)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	useTestDecoder(t)
+	useTestEncoder(t)
+	for _, v := range []form{{48000, 1}, {48000, 2}, {16000, 1}, {8000, 2}} {
+		for _, fs := range []int{480, 960, 2880} {
+			const n = 12345
+			x := signal(v.nC, n, v.rate)
+			w := codectest.NewFile(nil)
+			opts := &EncoderOptions{Bitrate: 24000, Complexity: 3, FrameSize: fs, Comments: []string{"TITLE=note"}}
+			encode(t, w, v, x, opts)
+			if lastOpts.Bitrate != 24000 || lastOpts.Complexity != 3 || lastOpts.FrameSize != fs {
+				t.Errorf("frame encoder options %+v", lastOpts)
+			}
+			d, err := NewDecoder(codectest.NewFile(w.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := int64(n * SampleRate / v.rate)
+			if d.Len() != want {
+				t.Errorf("%+v frame size %d: len %d of %d", v, fs, d.Len(), want)
+			}
+			h := d.Head()
+			if h.PreSkip != testLookahead || h.InputRate != v.rate || h.Channels != v.nC || h.Family != 0 {
+				t.Errorf("%+v: head %+v", v, h)
+			}
+			if d.Vendor() != Vendor || len(d.Comments()) != 1 || d.Comments()[0] != "TITLE=note" {
+				t.Errorf("%+v: vendor %q comments %q", v, d.Vendor(), d.Comments())
+			}
+			if got := decode(t, d, x, v.rate); got != want {
+				t.Errorf("%+v frame size %d: decoded %d of %d frames", v, fs, got, want)
+			}
+		}
+	}
+}
+
+type writeCloser struct {
+	io.Writer
+}
+
+func (w writeCloser) Close() error {
+	return nil
+}
+
+// TestEncodeStream checks that a stream encoded without seeking is
+// complete, and that encoding nothing gives an empty stream.
+func TestEncodeStream(t *testing.T) {
+	useTestDecoder(t)
+	useTestEncoder(t)
+	for _, n := range []int{0, 1, 960, 100000} {
+		x := signal(2, n, 24000)
+		var b bytes.Buffer
+		encode(t, writeCloser{&b}, form{24000, 2}, x, nil)
+		if lastOpts.Complexity != DefaultComplexity || lastOpts.FrameSize != DefaultFrameSize {
+			t.Errorf("default options %+v", lastOpts)
+		}
+		d, err := NewStreamDecoder(ioutil.NopCloser(bytes.NewReader(b.Bytes())))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := decode(t, d, x, 24000); got != int64(2*n) || d.Len() != got {
+			t.Errorf("%d samples: decoded %d, len %d", n, got, d.Len())
+		}
+	}
+}
+
+func TestEncoderErrors(t *testing.T) {
+	for _, tc := range []struct {
+		v    form
+		opts *EncoderOptions
+	}{
+		{form{44100, 2}, nil},
+		{form{48000, 3}, nil},
+		{form{48000, 0}, nil},
+		{form{48000, 2}, &EncoderOptions{Bitrate: 5000}},
+		{form{48000, 2}, &EncoderOptions{Bitrate: 600000}},
+		{form{48000, 2}, &EncoderOptions{Complexity: 11}},
+		{form{48000, 2}, &EncoderOptions{FrameSize: 1000}},
+	} {
+		if _, err := NewEncoder(codectest.NewFile(nil), tc.v, tc.opts); err == nil {
+			t.Errorf("%+v %+v: no error", tc.v, tc.opts)
+		}
+	}
+	if _, err := Codec.Encoder(codectest.NewFile(nil), form{48000, 1}, sample.SInt16L); err != codec.ErrUnsupportedSampleCodec {
+		t.Errorf("sample codec: got %v", err)
+	}
+	useTestEncoder(t)
+	RegisterFrameEncoder(nil)
+	if _, err := NewEncoder(codectest.NewFile(nil), form{48000, 1}, nil); err != codec.ErrUnsupportedFunction {
+		t.Errorf("no frame encoder: got %v", err)
+	}
+	if caps := Codec.(codec.Describer).Describe().Capabilities; caps&codec.CanEncode != 0 {
+		t.Errorf("capabilities %v", caps)
+	}
+}
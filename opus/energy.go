@@ -0,0 +1,263 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import "math"
+
+// eProbModel are the Laplace parameters of coarse energy, by LM, intra
+// flag and band: the probability of 0 and the decay, in 1/256 and 1/128.
+var eProbModel = [4][2][42]uint8{
+	{
+		{72, 127, 65, 129, 66, 128, 65, 128, 64, 128, 62, 128, 64, 128,
+			64, 128, 92, 78, 92, 79, 92, 78, 90, 79, 116, 41, 115, 40,
+			114, 40, 132, 26, 132, 26, 145, 17, 161, 12, 176, 10, 177, 11},
+		{24, 179, 48, 138, 54, 135, 54, 132, 53, 134, 56, 133, 55, 132,
+			55, 132, 61, 114, 70, 96, 74, 88, 75, 88, 87, 74, 89, 66,
+			91, 67, 100, 59, 108, 50, 120, 40, 122, 37, 97, 43, 78, 50}},
+	{
+		{83, 78, 84, 81, 88, 75, 86, 74, 87, 71, 90, 73, 93, 74,
+			93, 74, 109, 40, 114, 36, 117, 34, 117, 34, 143, 17, 145, 18,
+			146, 19, 162, 12, 165, 10, 178, 7, 189, 6, 190, 8, 177, 9},
+		{23, 178, 54, 115, 63, 102, 66, 98, 69, 99, 74, 89, 71, 91,
+			73, 91, 78, 89, 86, 80, 92, 66, 93, 64, 102, 59, 103, 60,
+			104, 60, 117, 52, 123, 44, 138, 35, 133, 31, 97, 38, 77, 45}},
+	{
+		{61, 90, 93, 60, 105, 42, 107, 41, 110, 45, 116, 38, 113, 38,
+			112, 38, 124, 26, 132, 27, 136, 19, 140, 20, 155, 14, 159, 16,
+			158, 18, 170, 13, 177, 10, 187, 8, 192, 6, 175, 9, 159, 10},
+		{21, 178, 59, 110, 71, 86, 75, 85, 84, 83, 91, 66, 88, 73,
+			87, 72, 92, 75, 98, 72, 105, 58, 107, 54, 115, 52, 114, 55,
+			112, 56, 129, 51, 132, 40, 150, 33, 140, 29, 98, 35, 77, 42}},
+	{
+		{42, 121, 96, 66, 108, 43, 111, 40, 117, 44, 123, 32, 120, 36,
+			119, 33, 127, 33, 134, 34, 139, 21, 147, 23, 152, 20, 158, 25,
+			154, 26, 166, 21, 173, 16, 184, 13, 184, 10, 150, 13, 139, 15},
+		{22, 178, 63, 114, 74, 82, 84, 83, 92, 82, 103, 62, 96, 72,
+			96, 67, 101, 73, 107, 72, 113, 55, 118, 52, 125, 52, 118, 52,
+			117, 55, 135, 49, 137, 39, 157, 32, 145, 29, 97, 33, 77, 40}}}
+
+var smallEnergyICDF = []uint8{2, 1, 0}
+
+// Prediction coefficients of coarse energy, by LM.
+var (
+	predCoef  = [4]float64{29440.0 / 32768, 26112.0 / 32768, 21248.0 / 32768, 16384.0 / 32768}
+	betaCoef  = [4]float64{30147.0 / 32768, 22282.0 / 32768, 12124.0 / 32768, 6554.0 / 32768}
+	betaIntra = 4915.0 / 32768
+)
+
+// unquantCoarseEnergy decodes the coarse energies of bands [start, end)
+// of nC channels into oldE, predicted from their previous values or,
+// if intra, from the band below only.
+func (d *rangeDecoder) unquantCoarseEnergy(oldE []float64, start, end int, intra bool, nC, lm int) {
+	in := 0
+	coef, beta := predCoef[lm], betaCoef[lm]
+	if intra {
+		in, coef, beta = 1, 0, betaIntra
+	}
+	prob := &eProbModel[lm][in]
+	var prev [2]float64
+	budget := len(d.buf) * 8
+	for i := start; i < end; i++ {
+		for c := 0; c < nC; c++ {
+			var qi int
+			switch t := d.tell(); {
+			case budget-t >= 15:
+				pi := 2 * minInt(i, 20)
+				qi = d.laplace(uint32(prob[pi])<<7, int(prob[pi+1])<<6)
+			case budget-t >= 2:
+				qi = d.icdf(smallEnergyICDF, 2)
+				qi = qi>>1 ^ -(qi & 1)
+			case budget-t >= 1:
+				qi = -d.bitLogp(1)
+			default:
+				qi = -1
+			}
+			q := float64(qi)
+			e := &oldE[i+c*nbEBands]
+			if *e < -9 {
+				*e = -9
+			}
+			*e = coef**e + prev[c] + q
+			prev[c] += q - beta*q
+		}
+	}
+}
+
+// unquantFineEnergy decodes the fine energies of bands with fine bits.
+func (d *rangeDecoder) unquantFineEnergy(oldE []float64, start, end int, fine []int, nC int) {
+	for i := start; i < end; i++ {
+		if fine[i] <= 0 {
+			continue
+		}
+		for c := 0; c < nC; c++ {
+			q := d.bits(uint(fine[i]))
+			oldE[i+c*nbEBands] += fineOffsetOf(int(q), fine[i])
+		}
+	}
+}
+
+// fineOffsetOf returns the energy offset of fine energy q of b bits.
+func fineOffsetOf(q, b int) float64 {
+	return (float64(q)+0.5)*float64(int(1)<<uint(14-b))/16384 - 0.5
+}
+
+// unquantEnergyFinalise decodes one more bit of fine energy for the
+// bands of each priority in turn while bits remain.
+func (d *rangeDecoder) unquantEnergyFinalise(oldE []float64, start, end int, fine, priority []int, left, nC int) {
+	for prio := 0; prio < 2; prio++ {
+		for i := start; i < end && left >= nC; i++ {
+			if fine[i] >= maxFineBits || priority[i] != prio {
+				continue
+			}
+			for c := 0; c < nC; c++ {
+				q := d.bits(1)
+				oldE[i+c*nbEBands] += (float64(q) - 0.5) * float64(int(1)<<uint(14-fine[i]-1)) / 16384
+				left--
+			}
+		}
+	}
+}
+
+// quantCoarseEnergy encodes the coarse energies e of bands [start, end)
+// of nC channels, as unquantCoarseEnergy decodes them into oldE, coding
+// the intra flag if it fits the budget of budget bits, and leaves in
+// errE the remainders for the fine energies.  No energy decreases by
+// more than maxDecay from its previous value.  quantCoarseEnergy returns
+// the sum of the changes to the quantized energies made to fit the
+// budget.
+func (enc *rangeEncoder) quantCoarseEnergy(e, oldE, errE []float64, start, end int, intra bool, budget, nC, lm int, maxDecay float64) int {
+	if enc.tell()+3 <= budget {
+		b := 0
+		if intra {
+			b = 1
+		}
+		enc.bitLogp(b, 3)
+	} else {
+		intra = false
+	}
+	in := 0
+	coef, beta := predCoef[lm], betaCoef[lm]
+	if intra {
+		in, coef, beta = 1, 0, betaIntra
+	}
+	prob := &eProbModel[lm][in]
+	var prev [2]float64
+	badness := 0
+	for i := start; i < end; i++ {
+		for c := 0; c < nC; c++ {
+			x := e[i+c*nbEBands]
+			old := oldE[i+c*nbEBands]
+			oldC := math.Max(-9, old)
+			f := x - coef*oldC - prev[c]
+			qi := int(math.Floor(0.5 + f))
+			decayBound := math.Max(-28, old) - maxDecay
+			if qi < 0 && x < decayBound {
+				qi += int(decayBound - x)
+				if qi > 0 {
+					qi = 0
+				}
+			}
+			qi0 := qi
+			t := enc.tell()
+			if bitsLeft := budget - t - 3*nC*(end-i); i != start && bitsLeft < 30 {
+				if bitsLeft < 24 {
+					qi = minInt(1, qi)
+				}
+				if bitsLeft < 16 {
+					qi = maxInt(-1, qi)
+				}
+			}
+			switch {
+			case budget-t >= 15:
+				pi := 2 * minInt(i, 20)
+				qi = enc.laplace(qi, uint32(prob[pi])<<7, int(prob[pi+1])<<6)
+			case budget-t >= 2:
+				qi = maxInt(-1, minInt(qi, 1))
+				s := 2 * qi
+				if qi < 0 {
+					s = -s - 1
+				}
+				enc.icdf(s, smallEnergyICDF, 2)
+			case budget-t >= 1:
+				qi = minInt(0, qi)
+				enc.bitLogp(-qi, 1)
+			default:
+				qi = -1
+			}
+			badness += absInt(qi0 - qi)
+			q := float64(qi)
+			errE[i+c*nbEBands] = f - q
+			oldE[i+c*nbEBands] = coef*oldC + prev[c] + q
+			prev[c] += q - beta*q
+		}
+	}
+	return badness
+}
+
+// quantCoarseEnergyTwoPass is like quantCoarseEnergy for inter frames,
+// but also tries coding the energies intra, keeping the coding which
+// changes the energies less to fit the budget, or else takes fewer bits.
+func (enc *rangeEncoder) quantCoarseEnergyTwoPass(e, oldE, errE []float64, start, end int, budget, nC, lm int, maxDecay float64) {
+	var intraOld, intraErr [2 * nbEBands]float64
+	copy(intraOld[:], oldE)
+	saved := *enc
+	buf := append([]byte(nil), enc.buf...)
+	intraBadness := enc.quantCoarseEnergy(e, intraOld[:], intraErr[:], start, end, true, budget, nC, lm, maxDecay)
+	intraTell := enc.tellFrac()
+	intra := *enc
+	intraBuf := append([]byte(nil), enc.buf...)
+
+	*enc = saved
+	copy(enc.buf, buf)
+	badness := enc.quantCoarseEnergy(e, oldE, errE, start, end, false, budget, nC, lm, maxDecay)
+	if intraBadness < badness || intraBadness == badness && enc.tellFrac() > intraTell {
+		*enc = intra
+		copy(enc.buf, intraBuf)
+		copy(oldE, intraOld[:])
+		copy(errE, intraErr[:])
+	}
+}
+
+// quantFineEnergy encodes the fine energies of bands with fine bits.
+func (enc *rangeEncoder) quantFineEnergy(oldE, errE []float64, start, end int, fine []int, nC int) {
+	for i := start; i < end; i++ {
+		if fine[i] <= 0 {
+			continue
+		}
+		frac := 1 << uint(fine[i])
+		for c := 0; c < nC; c++ {
+			j := i + c*nbEBands
+			q := int(math.Floor((errE[j] + 0.5) * float64(frac)))
+			q = maxInt(0, minInt(q, frac-1))
+			enc.bits(uint32(q), uint(fine[i]))
+			off := fineOffsetOf(q, fine[i])
+			oldE[j] += off
+			errE[j] -= off
+		}
+	}
+}
+
+// quantEnergyFinalise encodes one more bit of fine energy for the bands
+// of each priority in turn while bits remain.
+func (enc *rangeEncoder) quantEnergyFinalise(oldE, errE []float64, start, end int, fine, priority []int, left, nC int) {
+	for prio := 0; prio < 2; prio++ {
+		for i := start; i < end && left >= nC; i++ {
+			if fine[i] >= maxFineBits || priority[i] != prio {
+				continue
+			}
+			for c := 0; c < nC; c++ {
+				j := i + c*nbEBands
+				q := 0
+				if errE[j] >= 0 {
+					q = 1
+				}
+				enc.bits(uint32(q), 1)
+				off := (float64(q) - 0.5) * float64(int(1)<<uint(14-fine[i]-1)) / 16384
+				oldE[j] += off
+				errE[j] -= off
+				left--
+			}
+		}
+	}
+}
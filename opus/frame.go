@@ -0,0 +1,139 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"sync"
+
+	"zikichombo.org/codec"
+)
+
+// FrameDecoder decodes the packets of an elementary Opus stream of 1 or
+// 2 channels, of SILK, CELT or hybrid frames.  Package opus provides one.
+type FrameDecoder interface {
+	// Decode decodes the packet p into dst, which holds at least
+	// MaxPacketDuration samples per channel, returning the number of
+	// samples per channel at 48kHz.  The samples of channel c are
+	// placed at dst[c*n:(c+1)*n].
+	Decode(p []byte, dst []float64) (int, error)
+	// Reset resets the state of the decoder, as after seeking.
+	Reset()
+}
+
+var frameDecoders = struct {
+	sync.Mutex
+	f func(channels int) (FrameDecoder, error)
+}{f: newOpusFrameDecoder}
+
+// RegisterFrameDecoder registers f as the creator of the FrameDecoders
+// with which Decoders decode elementary streams, replacing the one
+// registered before, initially that of package opus.  Decoders cannot
+// be created while f is nil.
+func RegisterFrameDecoder(f func(channels int) (FrameDecoder, error)) {
+	frameDecoders.Lock()
+	defer frameDecoders.Unlock()
+	frameDecoders.f = f
+}
+
+// canDecode returns whether a FrameDecoder creator is registered.
+func canDecode() bool {
+	frameDecoders.Lock()
+	defer frameDecoders.Unlock()
+	return frameDecoders.f != nil
+}
+
+func newFrameDecoder(channels int) (FrameDecoder, error) {
+	frameDecoders.Lock()
+	f := frameDecoders.f
+	frameDecoders.Unlock()
+	if f == nil {
+		return nil, errs.Decode(codec.ErrUnsupportedFormat, -1, "packet", "no frame decoder registered")
+	}
+	return f(channels)
+}
+
+// FrameEncoder encodes the packets of an elementary Opus stream of 1 or
+// 2 channels.  Package opus provides one encoding CELT frames only.
+type FrameEncoder interface {
+	// Encode appends to dst the packet coding pcm, of equal numbers of
+	// samples of each channel at the rate of the encoder, channel c at
+	// pcm[c*n:(c+1)*n], and returns the result.  The number of samples
+	// per channel is that of the frame size of EncoderOptions.
+	Encode(pcm []float64, dst []byte) ([]byte, error)
+	// Lookahead returns the delay of the encoder, in samples at 48kHz,
+	// which decoders skip.
+	Lookahead() int
+}
+
+var frameEncoders = struct {
+	sync.Mutex
+	f func(rate, channels int, opts *EncoderOptions) (FrameEncoder, error)
+}{f: newCELTFrameEncoder}
+
+// RegisterFrameEncoder registers f as the creator of the FrameEncoders
+// with which Encoders encode, replacing the one registered before,
+// initially that of package opus.  f is called with a sample rate of 8,
+// 12, 16, 24 or 48kHz, 1 or 2 channels, and options with defaults
+// filled in.  Encoders cannot be created while f is nil.
+func RegisterFrameEncoder(f func(rate, channels int, opts *EncoderOptions) (FrameEncoder, error)) {
+	frameEncoders.Lock()
+	defer frameEncoders.Unlock()
+	frameEncoders.f = f
+}
+
+// canEncode returns whether a FrameEncoder creator is registered.
+func canEncode() bool {
+	frameEncoders.Lock()
+	defer frameEncoders.Unlock()
+	return frameEncoders.f != nil
+}
+
+func newFrameEncoder(rate, channels int, opts *EncoderOptions) (FrameEncoder, error) {
+	frameEncoders.Lock()
+	f := frameEncoders.f
+	frameEncoders.Unlock()
+	if f == nil {
+		return nil, codec.ErrUnsupportedFunction
+	}
+	return f(rate, channels, opts)
+}
+
+// appendPacket appends to dst the packet of the frames coded as toc
+// indicates, with the frame count code suiting their number and sizes.
+func appendPacket(dst []byte, toc TOC, frames [][]byte) []byte {
+	toc &^= 3
+	cbr := true
+	for _, f := range frames[1:] {
+		cbr = cbr && len(f) == len(frames[0])
+	}
+	switch {
+	case len(frames) == 1:
+		dst = append(dst, byte(toc))
+	case len(frames) == 2 && cbr:
+		dst = append(dst, byte(toc|1))
+	case len(frames) == 2:
+		dst = append(dst, byte(toc|2))
+		dst = appendFrameLen(dst, len(frames[0]))
+	case cbr:
+		dst = append(dst, byte(toc|3), byte(len(frames)))
+	default:
+		dst = append(dst, byte(toc|3), byte(len(frames))|0x80)
+		for _, f := range frames[:len(frames)-1] {
+			dst = appendFrameLen(dst, len(f))
+		}
+	}
+	for _, f := range frames {
+		dst = append(dst, f...)
+	}
+	return dst
+}
+
+// appendFrameLen appends the encoding of frame length n to dst.
+func appendFrameLen(dst []byte, n int) []byte {
+	if n < 252 {
+		return append(dst, byte(n))
+	}
+	k := 252 + (n-252)&3
+	return append(dst, byte(k), byte((n-k)/4))
+}
@@ -0,0 +1,294 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+// Frame sizes at 48kHz.
+const (
+	frameSize20ms  = 960
+	frameSize10ms  = 480
+	frameSize5ms   = 240
+	frameSize2_5ms = 120
+)
+
+// noMode is the mode of a frameDecoder which decoded no frame since it
+// was reset.
+const noMode Mode = -1
+
+// frameDecoder is the FrameDecoder of package opus, which decodes SILK,
+// CELT and hybrid frames, with the transitions between their modes, as
+// in RFC 6716 section 4.5.
+type frameDecoder struct {
+	nC   int // output channels
+	silk silkDecoder
+	celt *celtDecoder
+	dec  rangeDecoder
+	rng  uint32 // final range of the last frame
+
+	// The TOC of the packet of the frames decoded.
+	mode      Mode
+	bandwidth Bandwidth
+	frameSize int
+	sc        int // stream channels
+
+	// The internal channels and sample rate of the SILK frames, and the
+	// end band of the CELT frames, kept for concealing lost frames.
+	silkChannels, silkRate int
+	end                    int
+
+	prevMode       Mode
+	prevRedundancy bool // whether the last frame ended with a CELT frame
+
+	silkOut    [2][]int16
+	redundant  []float64 // redundant CELT frame, by channel
+	transition []float64 // concealment of the mode transition, by channel
+}
+
+func newOpusFrameDecoder(channels int) (FrameDecoder, error) {
+	d := &frameDecoder{
+		nC:         channels,
+		celt:       newCELTDecoder(channels),
+		end:        nbEBands,
+		redundant:  make([]float64, 2*frameSize5ms),
+		transition: make([]float64, 2*frameSize5ms)}
+	for c := range d.silkOut {
+		d.silkOut[c] = make([]int16, 3*frameSize20ms)
+	}
+	d.Reset()
+	return d, nil
+}
+
+// Decode decodes the frames of p.  Lost frames, of at most 1 byte, are
+// concealed.
+func (d *frameDecoder) Decode(p []byte, dst []float64) (int, error) {
+	toc, frames, err := ParsePacket(p)
+	if err != nil {
+		return 0, err
+	}
+	fs := toc.FrameSize()
+	d.mode, d.bandwidth, d.frameSize = toc.Mode(), toc.Bandwidth(), fs
+	d.sc = 1
+	if toc.Stereo() {
+		d.sc = 2
+	}
+	n := len(frames) * fs
+	for i, f := range frames {
+		if _, err := d.decodeFrame(f, dst[i*fs:], n, fs); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func (d *frameDecoder) Reset() {
+	d.celt.reset()
+	d.silk.reset()
+	d.rng = 0
+	d.sc = d.nC
+	d.frameSize = frameSize2_5ms
+	d.prevMode, d.prevRedundancy = noMode, false
+}
+
+// decodeFrame decodes the frame data, or conceals it if it has at most 1
+// byte, into the frameSize samples of each channel c at pcm[c*stride:],
+// and returns the number of samples decoded, as opus_decode_frame of the
+// reference implementation does.
+func (d *frameDecoder) decodeFrame(data []byte, pcm []float64, stride, frameSize int) (int, error) {
+	lost := len(data) <= 1
+	audioSize, mode := d.frameSize, d.mode
+	if lost {
+		frameSize = minInt(frameSize, d.frameSize)
+		audioSize, mode = frameSize, d.prevMode
+		if d.prevRedundancy {
+			mode = CELT
+		}
+		if mode == noMode {
+			for c := 0; c < d.nC; c++ {
+				for i := range pcm[c*stride : c*stride+audioSize] {
+					pcm[c*stride+i] = 0
+				}
+			}
+			return audioSize, nil
+		}
+		// The concealment runs on frames of 2.5, 5, 10 or 20ms.
+		if audioSize > frameSize20ms {
+			for i := 0; i < audioSize; {
+				n, err := d.decodeFrame(nil, pcm[i:], stride, minInt(audioSize-i, frameSize20ms))
+				if err != nil {
+					return 0, err
+				}
+				i += n
+			}
+			return frameSize, nil
+		}
+		if audioSize > frameSize10ms && audioSize < frameSize20ms {
+			audioSize = frameSize10ms
+		} else if mode != SILK && audioSize > frameSize5ms && audioSize < frameSize10ms {
+			audioSize = frameSize5ms
+		}
+	} else {
+		d.dec.init(data)
+	}
+	transition := !lost && d.prevMode != noMode &&
+		(mode == CELT && d.prevMode != CELT && !d.prevRedundancy || mode != CELT && d.prevMode == CELT)
+	if transition && mode == CELT {
+		d.decodeFrame(nil, d.transition, frameSize5ms, minInt(frameSize5ms, audioSize))
+	}
+	frameSize = audioSize
+
+	if mode != CELT {
+		if d.prevMode == CELT {
+			d.silk.reset()
+		}
+		if !lost {
+			d.silkChannels, d.silkRate = d.sc, 16000
+			if mode == SILK {
+				switch d.bandwidth {
+				case Narrowband:
+					d.silkRate = 8000
+				case Mediumband:
+					d.silkRate = 12000
+				}
+			}
+		}
+		// The concealment of SILK frames is of at least 10ms.
+		ms := maxInt(10, audioSize/48)
+		for i := 0; i < frameSize; {
+			out := [2][]int16{d.silkOut[0][i:], d.silkOut[1][i:]}
+			i += d.silk.decode(&d.dec, lost, i == 0, d.nC, d.silkChannels, d.silkRate, ms, out)
+		}
+	}
+
+	// SILK and hybrid frames may end with a redundant CELT frame, of the
+	// transition from or to CELT frames.
+	n := len(data)
+	if lost {
+		n = 0
+	}
+	redundancy, celtToSilk := false, false
+	redundantBytes := 0
+	hybrid := 0
+	if mode == Hybrid {
+		hybrid = 1
+	}
+	if !lost && mode != CELT && d.dec.tell()+17+20*hybrid <= 8*n {
+		redundancy = mode == SILK || d.dec.bitLogp(12) == 1
+		if redundancy {
+			celtToSilk = d.dec.bitLogp(1) == 1
+			if mode == Hybrid {
+				redundantBytes = int(d.dec.uint(256)) + 2
+			} else {
+				redundantBytes = n - (d.dec.tell()+7)>>3
+			}
+			n -= redundantBytes
+			if n*8 < d.dec.tell() {
+				n, redundantBytes, redundancy = 0, 0, false
+			} else {
+				d.dec.buf = d.dec.buf[:n]
+			}
+		}
+	}
+	start := 0
+	if mode != CELT {
+		start = 17
+	}
+	if redundancy {
+		transition = false
+	}
+	if transition && mode != CELT {
+		d.decodeFrame(nil, d.transition, frameSize5ms, minInt(frameSize5ms, audioSize))
+	}
+	if !lost {
+		d.end = endBands[d.bandwidth]
+	}
+
+	// The redundant frames are decoded as the reference decoder does,
+	// ignoring their errors.
+	red := d.redundant
+	redundantRng := uint32(0)
+	if redundancy && celtToSilk {
+		d.celt.decode(data[n:n+redundantBytes], d.sc, 1, 0, d.end, red, frameSize5ms)
+		redundantRng = d.celt.rng
+	}
+	if mode != SILK {
+		if mode != d.prevMode && d.prevMode != noMode && !d.prevRedundancy {
+			d.celt.reset()
+		}
+		lm := 0
+		for shortMdctSize<<uint(lm) < minInt(frameSize20ms, frameSize) {
+			lm++
+		}
+		var err error
+		if n <= 1 {
+			err = d.celt.decode(nil, d.sc, lm, start, d.end, pcm, stride)
+		} else {
+			err = d.celt.decodeFrom(&d.dec, d.sc, lm, start, d.end, pcm, stride)
+		}
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		for c := 0; c < d.nC; c++ {
+			for i := range pcm[c*stride : c*stride+frameSize] {
+				pcm[c*stride+i] = 0
+			}
+		}
+		// The CELT frames of hybrid frames fade out in a silent frame.
+		if d.prevMode == Hybrid && !(redundancy && celtToSilk && d.prevRedundancy) {
+			d.celt.decode([]byte{0xff, 0xff}, d.sc, 0, 0, d.end, pcm, stride)
+		}
+	}
+	if mode != CELT {
+		for c := 0; c < d.nC; c++ {
+			for i, v := range d.silkOut[c][:frameSize] {
+				pcm[c*stride+i] += float64(v) / 32768
+			}
+		}
+	}
+
+	const f2_5 = frameSize2_5ms
+	if redundancy && !celtToSilk {
+		d.celt.reset()
+		d.celt.decode(data[n:n+redundantBytes], d.sc, 1, 0, d.end, red, frameSize5ms)
+		redundantRng = d.celt.rng
+		for c := 0; c < d.nC; c++ {
+			out := pcm[c*stride+frameSize-f2_5:]
+			smoothFade(out, red[c*frameSize5ms+f2_5:], out)
+		}
+	}
+	if redundancy && celtToSilk && (d.prevMode != SILK || d.prevRedundancy) {
+		for c := 0; c < d.nC; c++ {
+			out, r := pcm[c*stride:], red[c*frameSize5ms:]
+			copy(out[:f2_5], r)
+			smoothFade(r[f2_5:], out[f2_5:], out[f2_5:])
+		}
+	}
+	if transition {
+		for c := 0; c < d.nC; c++ {
+			out, t := pcm[c*stride:], d.transition[c*frameSize5ms:]
+			if audioSize >= frameSize5ms {
+				copy(out[:f2_5], t)
+				smoothFade(t[f2_5:], out[f2_5:], out[f2_5:])
+			} else {
+				smoothFade(t, out, out)
+			}
+		}
+	}
+
+	d.rng = 0
+	if n > 1 {
+		d.rng = d.dec.rng ^ redundantRng
+	}
+	d.prevMode = mode
+	d.prevRedundancy = redundancy && !celtToSilk
+	return audioSize, nil
+}
+
+// smoothFade fades over 2.5ms from in1 to in2 into out, with the square
+// of the window of the MDCT overlap.
+func smoothFade(in1, in2, out []float64) {
+	for i := 0; i < frameSize2_5ms; i++ {
+		w := window[i] * window[i]
+		out[i] = w*in2[i] + (1-w)*in1[i]
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"encoding/binary"
+	"math"
+
+	"zikichombo.org/codec"
+)
+
+// Head is the identification header of an Ogg Opus stream, as in RFC
+// 7845 section 5.1.
+type Head struct {
+	Version  int
+	Channels int
+	// PreSkip is the number of samples at 48kHz to discard from the
+	// start of the decoded stream.
+	PreSkip int
+	// InputRate is the sample rate in Hz of the original input, or 0.
+	// Decoded streams are at 48kHz regardless.
+	InputRate int
+	// OutputGain is the gain to apply to the decoded stream, in units of
+	// 1/256 dB.
+	OutputGain int
+	// Family is the channel mapping family, 0 or 1.
+	Family int
+	// Streams and Coupled are the numbers of elementary streams of the
+	// packets and of those which are stereo, which come first.
+	Streams, Coupled int
+	// Mapping gives for each output channel the channel of the decoded
+	// elementary streams, the 2 channels of each coupled stream followed
+	// by the channel of each other, or 255 for a silent channel.
+	Mapping []byte
+}
+
+// isHead returns whether p starts as an identification header.
+func isHead(p []byte) bool {
+	return len(p) >= 8 && string(p[:8]) == "OpusHead"
+}
+
+// parseHead parses the identification header p.
+func parseHead(p []byte) (*Head, error) {
+	if !isHead(p) {
+		return nil, errs.Decode(codec.ErrCorrupt, -1, "OpusHead", "no magic signature")
+	}
+	if len(p) < 19 {
+		return nil, errs.Decode(codec.ErrTruncated, -1, "OpusHead", "%d bytes", len(p))
+	}
+	h := &Head{
+		Version:    int(p[8]),
+		Channels:   int(p[9]),
+		PreSkip:    int(binary.LittleEndian.Uint16(p[10:])),
+		InputRate:  int(binary.LittleEndian.Uint32(p[12:])),
+		OutputGain: int(int16(binary.LittleEndian.Uint16(p[16:]))),
+		Family:     int(p[18])}
+	if h.Version>>4 != 0 {
+		return nil, errs.Decode(codec.ErrUnsupportedFormat, -1, "OpusHead", "version %d", h.Version)
+	}
+	if h.Channels == 0 {
+		return nil, errs.Decode(codec.ErrCorrupt, -1, "OpusHead", "no channels")
+	}
+	switch h.Family {
+	case 0:
+		if h.Channels > 2 {
+			return nil, errs.Decode(codec.ErrCorrupt, -1, "OpusHead", "%d channels of mapping family 0", h.Channels)
+		}
+		h.Streams, h.Coupled = 1, h.Channels-1
+		h.Mapping = []byte{0, 1}[:h.Channels]
+		return h, nil
+	case 1:
+		if h.Channels > 8 {
+			return nil, errs.Decode(codec.ErrCorrupt, -1, "OpusHead", "%d channels of mapping family 1", h.Channels)
+		}
+	default:
+		return nil, errs.Decode(codec.ErrUnsupportedFormat, -1, "OpusHead", "channel mapping family %d", h.Family)
+	}
+	if len(p) < 21+h.Channels {
+		return nil, errs.Decode(codec.ErrTruncated, -1, "OpusHead", "%d bytes", len(p))
+	}
+	h.Streams, h.Coupled = int(p[19]), int(p[20])
+	if h.Streams == 0 || h.Coupled > h.Streams || h.Streams+h.Coupled > 255 {
+		return nil, errs.Decode(codec.ErrCorrupt, -1, "OpusHead", "%d streams of which %d coupled", h.Streams, h.Coupled)
+	}
+	h.Mapping = append([]byte(nil), p[21:21+h.Channels]...)
+	for _, m := range h.Mapping {
+		if m != 255 && int(m) >= h.Streams+h.Coupled {
+			return nil, errs.Decode(codec.ErrCorrupt, -1, "OpusHead", "channel mapping %d of %d streams", m, h.Streams)
+		}
+	}
+	return h, nil
+}
+
+// appendHead appends the identification header h to p.
+func appendHead(p []byte, h *Head) []byte {
+	p = append(p, "OpusHead"...)
+	p = append(p, byte(h.Version), byte(h.Channels))
+	p = appendUint(p, uint64(h.PreSkip), 2)
+	p = appendUint(p, uint64(h.InputRate), 4)
+	p = appendUint(p, uint64(uint16(h.OutputGain)), 2)
+	p = append(p, byte(h.Family))
+	if h.Family != 0 {
+		p = append(p, byte(h.Streams), byte(h.Coupled))
+		p = append(p, h.Mapping...)
+	}
+	return p
+}
+
+// appendTags appends the comment header of vendor and comments to p.
+func appendTags(p []byte, vendor string, comments []string) []byte {
+	p = append(p, "OpusTags"...)
+	p = appendUint(p, uint64(len(vendor)), 4)
+	p = append(p, vendor...)
+	p = appendUint(p, uint64(len(comments)), 4)
+	for _, c := range comments {
+		p = appendUint(p, uint64(len(c)), 4)
+		p = append(p, c...)
+	}
+	return p
+}
+
+// appendUint appends the n byte little endian encoding of v to p.
+func appendUint(p []byte, v uint64, n int) []byte {
+	for i := 0; i < n; i++ {
+		p = append(p, byte(v>>uint(8*i)))
+	}
+	return p
+}
+
+// gain returns the factor of the output gain.
+func (h *Head) gain() float64 {
+	return math.Pow(10, float64(h.OutputGain)/(20*256))
+}
+
+// parseTags parses the comment header p, as in RFC 7845 section 5.2,
+// returning its vendor string and comments.
+func parseTags(p []byte) (string, []string, error) {
+	if len(p) < 8 || string(p[:8]) != "OpusTags" {
+		return "", nil, errs.Decode(codec.ErrCorrupt, -1, "OpusTags", "no magic signature")
+	}
+	p = p[8:]
+	str := func() (string, bool) {
+		if len(p) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(p)
+		if uint64(n) > uint64(len(p)-4) {
+			return "", false
+		}
+		s := string(p[4 : 4+n])
+		p = p[4+n:]
+		return s, true
+	}
+	vendor, ok := str()
+	if !ok || len(p) < 4 {
+		return "", nil, errs.Decode(codec.ErrTruncated, -1, "OpusTags", "vendor string")
+	}
+	n := binary.LittleEndian.Uint32(p)
+	p = p[4:]
+	if uint64(n) > uint64(len(p)/4) {
+		return "", nil, errs.Decode(codec.ErrCorrupt, -1, "OpusTags", "%d comments in %d bytes", n, len(p))
+	}
+	comments := make([]string, n)
+	for i := range comments {
+		if comments[i], ok = str(); !ok {
+			return "", nil, errs.Decode(codec.ErrTruncated, -1, "OpusTags", "comment %d of %d", i, n)
+		}
+	}
+	return vendor, comments, nil
+}
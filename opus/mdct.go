@@ -0,0 +1,168 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// mdct is the MDCT of n coefficients with the low overlap window of the
+// CELT mode, computed as in the reference implementation with an FFT of
+// n/2 points.
+type mdct struct {
+	n     int       // coefficients, half the transform size
+	trig  []float64 // cos(2*pi*(i+1/8)/(2*n)), i < n
+	twid  []complex128
+	buf   []complex128
+	tmp   []complex128
+	scale float64 // of the forward transform
+}
+
+// mdcts are the MDCTs of the frame sizes of LM 0 to maxLM.
+var mdcts [maxLM + 1]*mdct
+
+func init() {
+	for lm := range mdcts {
+		mdcts[lm] = newMDCT(shortMdctSize << uint(lm))
+	}
+}
+
+func newMDCT(n int) *mdct {
+	m := &mdct{
+		n:     n,
+		trig:  make([]float64, n),
+		twid:  make([]complex128, n/2),
+		buf:   make([]complex128, n/2),
+		tmp:   make([]complex128, n/2),
+		scale: 1 / float64(n/2)}
+	for i := range m.trig {
+		m.trig[i] = math.Cos(2 * math.Pi * (float64(i) + 0.125) / float64(2*n))
+	}
+	for i := range m.twid {
+		m.twid[i] = cmplx.Rect(1, -2*math.Pi*float64(i)/float64(n/2))
+	}
+	return m
+}
+
+// backward computes the inverse MDCT of the n coefficients in[i*stride],
+// and adds it to out[:n+overlap] with time domain aliasing cancellation
+// of the overlap with the previous block, whose last overlap/2 samples
+// are in out[:overlap/2].  out[overlap/2+n:] is overwritten.
+func (m *mdct) backward(in []float64, stride int, out []float64) {
+	n2 := m.n
+	n4 := n2 >> 1
+	t := m.trig
+	z := m.buf
+	for i := 0; i < n4; i++ {
+		x1 := in[2*i*stride]
+		x2 := in[(n2-1-2*i)*stride]
+		yr := x2*t[i] + x1*t[n4+i]
+		yi := x1*t[i] - x2*t[n4+i]
+		z[i] = complex(yi, yr)
+	}
+	m.fft(z)
+	y := out[overlap/2:]
+	for k := 0; k < n4; k++ {
+		re, im := imag(z[k]), real(z[k])
+		y[2*k] = re*t[k] + im*t[n4+k]
+		y[2*(n4-1-k)+1] = re*t[n4+k] - im*t[k]
+	}
+	for i := 0; i < overlap/2; i++ {
+		x1 := out[overlap-1-i]
+		x2 := out[i]
+		w1, w2 := window[i], window[overlap-1-i]
+		out[i] = w2*x2 - w1*x1
+		out[overlap-1-i] = w2*x1 + w1*x2
+	}
+}
+
+// forward computes into out[i*stride], i < n, the MDCT of the n+overlap
+// samples of in, windowed over overlap samples at each end.  It is the
+// inverse of backward, with time domain aliasing cancellation.
+func (m *mdct) forward(in []float64, out []float64, stride int) {
+	n2 := m.n
+	n4 := n2 >> 1
+	t := m.trig
+	z := m.buf
+	f := func(i int, re, im float64) {
+		t0, t1 := t[i], t[n4+i]
+		z[i] = complex((re*t0-im*t1)*m.scale, (im*t0+re*t1)*m.scale)
+	}
+	i1, i2 := overlap/2, n2-1+overlap/2
+	w1, w2 := overlap/2, overlap/2-1
+	i := 0
+	for ; i < (overlap+3)>>2; i++ {
+		f(i, window[w2]*in[i1+n2]+window[w1]*in[i2], window[w1]*in[i1]-window[w2]*in[i2-n2])
+		i1 += 2
+		i2 -= 2
+		w1 += 2
+		w2 -= 2
+	}
+	w1, w2 = 0, overlap-1
+	for ; i < n4-(overlap+3)>>2; i++ {
+		f(i, in[i2], in[i1])
+		i1 += 2
+		i2 -= 2
+	}
+	for ; i < n4; i++ {
+		f(i, window[w2]*in[i2]-window[w1]*in[i1-n2], window[w2]*in[i1]+window[w1]*in[i2+n2])
+		i1 += 2
+		i2 -= 2
+		w1 += 2
+		w2 -= 2
+	}
+	m.fft(z)
+	for k := 0; k < n4; k++ {
+		re, im := real(z[k]), imag(z[k])
+		out[2*k*stride] = im*t[n4+k] - re*t[k]
+		out[(n2-1-2*k)*stride] = re*t[n4+k] + im*t[k]
+	}
+}
+
+// fft computes in place the unscaled forward DFT of z, of n/2 points.
+func (m *mdct) fft(z []complex128) {
+	fft(z, m.tmp, m.twid, 1)
+}
+
+// fft computes the DFT of z, using tmp of the same size, where twid are
+// the twiddles of a DFT of len(z)*stride points.  The sizes have factors
+// 2, 3 and 5 only.
+func fft(z, tmp, twid []complex128, stride int) {
+	n := len(z)
+	if n == 1 {
+		return
+	}
+	p := 2
+	switch {
+	case n%4 == 0:
+		p = 4
+	case n%2 == 0:
+		p = 2
+	case n%3 == 0:
+		p = 3
+	case n%5 == 0:
+		p = 5
+	default:
+		panic("opus: fft size")
+	}
+	q := n / p
+	for r := 0; r < p; r++ {
+		for j := 0; j < q; j++ {
+			tmp[r*q+j] = z[j*p+r]
+		}
+	}
+	for r := 0; r < p; r++ {
+		fft(tmp[r*q:(r+1)*q], z[r*q:(r+1)*q], twid, stride*p)
+	}
+	for k := 0; k < q; k++ {
+		for s := 0; s < p; s++ {
+			var v complex128
+			for r := 0; r < p; r++ {
+				v += tmp[r*q+k] * twid[((s*q+k)*r*stride)%len(twid)]
+			}
+			z[s*q+k] = v
+		}
+	}
+}
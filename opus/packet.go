@@ -0,0 +1,259 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"zikichombo.org/codec"
+)
+
+// Mode is the coding mode of the frames of a packet.
+type Mode int
+
+// Modes of Opus frames.
+const (
+	SILK Mode = iota
+	Hybrid
+	CELT
+)
+
+func (m Mode) String() string {
+	switch m {
+	case SILK:
+		return "SILK"
+	case Hybrid:
+		return "Hybrid"
+	case CELT:
+		return "CELT"
+	}
+	return "unknown"
+}
+
+// Bandwidth is the audio bandwidth of the frames of a packet.
+type Bandwidth int
+
+// Bandwidths of Opus frames, of 4, 6, 8, 12 and 20kHz.
+const (
+	Narrowband Bandwidth = iota
+	Mediumband
+	Wideband
+	SuperWideband
+	Fullband
+)
+
+const (
+	// SampleRate is the rate in Hz of decoded Opus streams and of
+	// granule positions.
+	SampleRate = 48000
+	// MaxPacketDuration is the largest number of samples per channel of
+	// a packet, 120ms.
+	MaxPacketDuration = 5760
+	// maxFrameSize is the size of the largest frame in bytes.
+	maxFrameSize = 1275
+)
+
+// TOC is the table of contents byte starting an Opus packet.
+type TOC byte
+
+// Config returns the configuration number of t, in [0, 32).
+func (t TOC) Config() int {
+	return int(t >> 3)
+}
+
+// Mode returns the coding mode of the frames.
+func (t TOC) Mode() Mode {
+	switch c := t.Config(); {
+	case c < 12:
+		return SILK
+	case c < 16:
+		return Hybrid
+	}
+	return CELT
+}
+
+// Bandwidth returns the audio bandwidth of the frames.
+func (t TOC) Bandwidth() Bandwidth {
+	switch c := t.Config(); {
+	case c < 12:
+		return Bandwidth(c / 4)
+	case c < 16:
+		return SuperWideband + Bandwidth(c-12)/2
+	case c < 20:
+		return Narrowband
+	}
+	return Wideband + Bandwidth(t.Config()-20)/4
+}
+
+// FrameSize returns the number of samples per channel of each frame at
+// 48kHz.
+func (t TOC) FrameSize() int {
+	switch c := t.Config(); {
+	case c < 12:
+		return [4]int{480, 960, 1920, 2880}[c%4]
+	case c < 16:
+		return 480 << uint(c%2)
+	default:
+		return 120 << uint(c%4)
+	}
+}
+
+// Stereo returns whether the frames code 2 channels.
+func (t TOC) Stereo() bool {
+	return t&4 != 0
+}
+
+// code returns the frame count code of t.
+func (t TOC) code() int {
+	return int(t & 3)
+}
+
+// ParsePacket returns the table of contents and the frames of the Opus
+// packet p, as in RFC 6716 section 3.  Frames of 0 bytes denote lost
+// frames.  Malformed packets give a *codec.DecodeError.
+func ParsePacket(p []byte) (TOC, [][]byte, error) {
+	toc, frames, _, err := parsePacket(p, false)
+	return toc, frames, err
+}
+
+// Duration returns the number of samples per channel of the Opus packet
+// p at 48kHz.
+func Duration(p []byte) (int, error) {
+	toc, frames, err := ParsePacket(p)
+	if err != nil {
+		return 0, err
+	}
+	return len(frames) * toc.FrameSize(), nil
+}
+
+// parsePacket parses an Opus packet at the start of p, which uses self
+// delimiting framing if sd is true, as in RFC 6716 appendix B, and
+// otherwise takes all of p.  parsePacket returns the size of the packet.
+func parsePacket(p []byte, sd bool) (TOC, [][]byte, int, error) {
+	if len(p) == 0 {
+		return 0, nil, 0, packetError("empty packet")
+	}
+	toc := TOC(p[0])
+	i, end, pad := 1, len(p), 0
+	var sizes []int
+	switch toc.code() {
+	case 0:
+		sizes = []int{end - i}
+	case 1:
+		if !sd && (end-i)%2 != 0 {
+			return 0, nil, 0, packetError("odd size %d of 2 equal frames", end-i)
+		}
+		sizes = []int{(end - i) / 2, (end - i) / 2}
+	case 2:
+		n, k := frameLen(p[i:])
+		if k == 0 || n > end-i-k {
+			return 0, nil, 0, packetError("bad size of first of 2 frames")
+		}
+		i += k
+		sizes = []int{n, end - i - n}
+	case 3:
+		if end-i < 1 {
+			return 0, nil, 0, packetError("no frame count")
+		}
+		fc := p[i]
+		i++
+		m := int(fc & 0x3f)
+		if m == 0 || m*toc.FrameSize() > MaxPacketDuration {
+			return 0, nil, 0, packetError("%d frames of %d samples", m, toc.FrameSize())
+		}
+		if fc&0x40 != 0 {
+			for {
+				if i == end {
+					return 0, nil, 0, packetError("truncated padding length")
+				}
+				v := int(p[i])
+				i++
+				if v < 255 {
+					pad += v
+					break
+				}
+				pad += 254
+			}
+			if pad > end-i {
+				return 0, nil, 0, packetError("%d bytes of padding exceed the packet", pad)
+			}
+			if !sd {
+				end -= pad
+			}
+		}
+		sizes = make([]int, m)
+		if fc&0x80 != 0 {
+			total := 0
+			for j := 0; j < m-1; j++ {
+				n, k := frameLen(p[i:end])
+				if k == 0 {
+					return 0, nil, 0, packetError("truncated frame length")
+				}
+				i += k
+				sizes[j] = n
+				total += n
+			}
+			if total > end-i {
+				return 0, nil, 0, packetError("frame lengths exceed the packet")
+			}
+			sizes[m-1] = end - i - total
+		} else {
+			if !sd && (end-i)%m != 0 {
+				return 0, nil, 0, packetError("size %d of %d equal frames", end-i, m)
+			}
+			for j := range sizes {
+				sizes[j] = (end - i) / m
+			}
+		}
+	}
+	if sd {
+		n, k := frameLen(p[i:])
+		if k == 0 {
+			return 0, nil, 0, packetError("truncated self delimiting length")
+		}
+		i += k
+		if toc.code() == 1 || toc.code() == 3 && p[1]&0x80 == 0 {
+			for j := range sizes {
+				sizes[j] = n
+			}
+		} else {
+			sizes[len(sizes)-1] = n
+		}
+		total := pad
+		for _, n := range sizes {
+			total += n
+		}
+		if total > len(p)-i {
+			return 0, nil, 0, packetError("self delimited frames exceed the packet")
+		}
+	}
+	frames := make([][]byte, len(sizes))
+	for j, n := range sizes {
+		if n < 0 || n > maxFrameSize {
+			return 0, nil, 0, packetError("frame of %d bytes", n)
+		}
+		frames[j] = p[i : i+n]
+		i += n
+	}
+	if sd {
+		return toc, frames, i + pad, nil
+	}
+	return toc, frames, len(p), nil
+}
+
+// frameLen decodes a frame length of 1 or 2 bytes at the start of p,
+// returning it and the number of bytes it takes, or 0 if p is too short.
+func frameLen(p []byte) (int, int) {
+	switch {
+	case len(p) == 0:
+		return 0, 0
+	case p[0] < 252:
+		return int(p[0]), 1
+	case len(p) == 1:
+		return 0, 0
+	}
+	return int(p[0]) + 4*int(p[1]), 2
+}
+
+func packetError(format string, args ...interface{}) error {
+	return errs.Decode(codec.ErrCorrupt, -1, "packet", format, args...)
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"bytes"
+	"testing"
+
+	"zikichombo.org/codec"
+)
+
+func TestTOC(t *testing.T) {
+	for _, tc := range []struct {
+		config int
+		mode   Mode
+		bw     Bandwidth
+		size   int
+	}{
+		{0, SILK, Narrowband, 480},
+		{3, SILK, Narrowband, 2880},
+		{5, SILK, Mediumband, 960},
+		{10, SILK, Wideband, 1920},
+		{12, Hybrid, SuperWideband, 480},
+		{15, Hybrid, Fullband, 960},
+		{16, CELT, Narrowband, 120},
+		{22, CELT, Wideband, 480},
+		{25, CELT, SuperWideband, 240},
+		{31, CELT, Fullband, 960},
+	} {
+		toc := TOC(tc.config<<3 | 4)
+		if toc.Config() != tc.config || toc.Mode() != tc.mode || toc.Bandwidth() != tc.bw || toc.FrameSize() != tc.size || !toc.Stereo() {
+			t.Errorf("config %d: %s, bandwidth %d, %d samples", tc.config, toc.Mode(), toc.Bandwidth(), toc.FrameSize())
+		}
+	}
+}
+
+// frames returns n frames of the given sizes.
+func frames(sizes ...int) [][]byte {
+	res := make([][]byte, len(sizes))
+	for i, n := range sizes {
+		res[i] = bytes.Repeat([]byte{byte(i + 1)}, n)
+	}
+	return res
+}
+
+// selfDelimit returns packet p, of the given frames, with self
+// delimiting framing.
+func selfDelimit(p []byte, frames [][]byte) []byte {
+	toc := TOC(p[0])
+	last := frames[len(frames)-1]
+	hdr := len(p) - len(last)
+	for _, f := range frames[:len(frames)-1] {
+		hdr -= len(f)
+	}
+	res := append([]byte(nil), p[:hdr]...)
+	if toc.code() == 1 || toc.code() == 3 && p[1]&0x80 == 0 {
+		res = appendFrameLen(res, len(frames[0]))
+	} else {
+		res = appendFrameLen(res, len(last))
+	}
+	return append(res, p[hdr:]...)
+}
+
+func TestPacket(t *testing.T) {
+	toc := TOC(20 << 3)
+	for _, fs := range [][][]byte{
+		frames(10),
+		frames(0),
+		frames(1275),
+		frames(300, 300),
+		frames(251, 1000),
+		frames(100, 0),
+		frames(7, 7, 7),
+		frames(7, 300, 0, 7),
+		frames(make([]int, 48)...),
+	} {
+		p := appendPacket(nil, toc, fs)
+		gotTOC, got, err := ParsePacket(p)
+		if err != nil {
+			t.Fatalf("%d frames: %v", len(fs), err)
+		}
+		if gotTOC.Config() != 20 || len(got) != len(fs) {
+			t.Fatalf("%d frames: got %d", len(fs), len(got))
+		}
+		for i := range got {
+			if !bytes.Equal(got[i], fs[i]) {
+				t.Errorf("%d frames: frame %d of %d bytes", len(fs), i, len(got[i]))
+			}
+		}
+		if n, err := Duration(p); err != nil || n != len(fs)*120 {
+			t.Errorf("%d frames: duration %d, %v", len(fs), n, err)
+		}
+		sd := selfDelimit(p, fs)
+		_, got, n, err := parsePacket(append(sd, 1, 2, 3), true)
+		if err != nil || n != len(sd) || len(got) != len(fs) {
+			t.Fatalf("%d self delimited frames: %d bytes of %d, %v", len(fs), n, len(sd), err)
+		}
+		for i := range got {
+			if !bytes.Equal(got[i], fs[i]) {
+				t.Errorf("%d self delimited frames: frame %d of %d bytes", len(fs), i, len(got[i]))
+			}
+		}
+	}
+}
+
+func TestPacketPadding(t *testing.T) {
+	// 2 frames of 3 bytes of 2.5ms with 256 bytes of padding.
+	p := []byte{16<<3 | 3, 0x42, 255, 2, 1, 1, 1, 2, 2, 2}
+	p = append(p, make([]byte, 256)...)
+	_, fs, err := ParsePacket(p)
+	if err != nil || len(fs) != 2 || !bytes.Equal(fs[1], []byte{2, 2, 2}) {
+		t.Errorf("got %v, %v", fs, err)
+	}
+	sd := append([]byte{16<<3 | 3, 0x42, 255, 2, 3, 1, 1, 1, 2, 2, 2}, make([]byte, 256)...)
+	_, fs, n, err := parsePacket(append(sd, 9), true)
+	if err != nil || n != len(sd) || !bytes.Equal(fs[0], []byte{1, 1, 1}) {
+		t.Errorf("self delimited: got %v, %d, %v", fs, n, err)
+	}
+}
+
+func TestPacketErrors(t *testing.T) {
+	for _, p := range [][]byte{
+		{},
+		{1, 1, 2, 3},
+		{2},
+		{2, 253},
+		{2, 10, 1},
+		{3},
+		{3, 0},
+		{16<<3 | 3, 49},
+		{3, 3, 1, 2},
+		{3, 0x43, 255},
+		{3, 0x42, 10, 1, 1},
+		{3, 0x82, 5, 1, 1},
+		append([]byte{0}, make([]byte, 1276)...),
+	} {
+		_, _, err := ParsePacket(p)
+		if de, ok := err.(*codec.DecodeError); !ok || de.Err != codec.ErrCorrupt {
+			t.Errorf("% x: got %v", p, err)
+		}
+	}
+	if _, _, _, err := parsePacket([]byte{0, 5, 1, 2}, true); err == nil {
+		t.Errorf("self delimited frame exceeding the packet")
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import "math/bits"
+
+// Constants of the range coder of RFC 6716 section 4.1.
+const (
+	symBits   = 8
+	codeBits  = 32
+	symMax    = 1<<symBits - 1
+	codeShift = codeBits - symBits - 1
+	codeTop   = 1 << (codeBits - 1)
+	codeBot   = codeTop >> symBits
+	codeExtra = (codeBits-2)%symBits + 1
+	uintBits  = 8
+	bitRes    = 3 // bits of the fractions of bits of tellFrac
+)
+
+// rangeDecoder is the range decoder of RFC 6716 section 4.1, reading
+// range coded symbols from the start of a frame and raw bits from its
+// end.
+type rangeDecoder struct {
+	buf        []byte
+	offs       int
+	endOffs    int
+	endWindow  uint32
+	nEndBits   uint
+	nBitsTotal int
+	rng        uint32
+	val        uint32
+	ext        uint32
+	rem        int
+}
+
+// init starts decoding the frame buf.
+func (d *rangeDecoder) init(buf []byte) {
+	*d = rangeDecoder{
+		buf:        buf,
+		nBitsTotal: codeBits + 1 - (codeBits-codeExtra)/symBits*symBits,
+		rng:        1 << codeExtra}
+	d.rem = d.readByte()
+	d.val = d.rng - 1 - uint32(d.rem>>(symBits-codeExtra))
+	d.normalize()
+}
+
+func (d *rangeDecoder) readByte() int {
+	if d.offs < len(d.buf) {
+		d.offs++
+		return int(d.buf[d.offs-1])
+	}
+	return 0
+}
+
+func (d *rangeDecoder) readByteFromEnd() int {
+	if d.endOffs < len(d.buf) {
+		d.endOffs++
+		return int(d.buf[len(d.buf)-d.endOffs])
+	}
+	return 0
+}
+
+func (d *rangeDecoder) normalize() {
+	for d.rng <= codeBot {
+		d.nBitsTotal += symBits
+		d.rng <<= symBits
+		sym := d.rem
+		d.rem = d.readByte()
+		sym = (sym<<symBits | d.rem) >> (symBits - codeExtra)
+		d.val = ((d.val << symBits) + uint32(symMax&^sym)) & (codeTop - 1)
+	}
+}
+
+// decode returns the cumulative frequency of the next symbol of a
+// distribution of total frequency ft, which update must then follow.
+func (d *rangeDecoder) decode(ft uint32) uint32 {
+	d.ext = d.rng / ft
+	s := d.val / d.ext
+	return ft - minU32(s+1, ft)
+}
+
+// decodeBin is decode for ft = 1<<b.
+func (d *rangeDecoder) decodeBin(b uint) uint32 {
+	d.ext = d.rng >> b
+	s := d.val / d.ext
+	return 1<<b - minU32(s+1, 1<<b)
+}
+
+// update consumes the symbol of cumulative frequencies [fl, fh) of ft.
+func (d *rangeDecoder) update(fl, fh, ft uint32) {
+	s := d.ext * (ft - fh)
+	d.val -= s
+	if fl > 0 {
+		d.rng = d.ext * (fh - fl)
+	} else {
+		d.rng -= s
+	}
+	d.normalize()
+}
+
+// bitLogp decodes a bit which is 1 with probability 1/(1<<logp).
+func (d *rangeDecoder) bitLogp(logp uint) int {
+	r := d.rng
+	s := r >> logp
+	ret := 0
+	if d.val < s {
+		ret = 1
+		d.rng = s
+	} else {
+		d.val -= s
+		d.rng = r - s
+	}
+	d.normalize()
+	return ret
+}
+
+// icdf decodes a symbol of the inverse cumulative distribution icdf, in
+// units of 1/(1<<ftb).
+func (d *rangeDecoder) icdf(icdf []uint8, ftb uint) int {
+	s := d.rng
+	r := s >> ftb
+	ret := -1
+	var t uint32
+	for {
+		t = s
+		ret++
+		s = r * uint32(icdf[ret])
+		if d.val >= s {
+			break
+		}
+	}
+	d.val -= s
+	d.rng = t - s
+	d.normalize()
+	return ret
+}
+
+// uint decodes an integer uniformly distributed in [0, ft).
+func (d *rangeDecoder) uint(ft uint32) uint32 {
+	ft--
+	ftb := ilog(ft)
+	if ftb > uintBits {
+		ftb -= uintBits
+		ft1 := ft>>ftb + 1
+		s := d.decode(ft1)
+		d.update(s, s+1, ft1)
+		t := s<<ftb | d.bits(ftb)
+		if t <= ft {
+			return t
+		}
+		return ft
+	}
+	ft++
+	s := d.decode(ft)
+	d.update(s, s+1, ft)
+	return s
+}
+
+// bits reads n raw bits from the end of the frame.
+func (d *rangeDecoder) bits(n uint) uint32 {
+	w := d.endWindow
+	avail := d.nEndBits
+	if avail < n {
+		for avail <= 32-symBits {
+			w |= uint32(d.readByteFromEnd()) << avail
+			avail += symBits
+		}
+	}
+	ret := w & (1<<n - 1)
+	d.endWindow = w >> n
+	d.nEndBits = avail - n
+	d.nBitsTotal += int(n)
+	return ret
+}
+
+// laplace decodes a value of the Laplace-like distribution of
+// RFC 6716 section 4.3.2.1 with frequency fs of 0 and decay decay.
+func (d *rangeDecoder) laplace(fs uint32, decay int) int {
+	val := 0
+	fl := uint32(0)
+	fm := d.decodeBin(15)
+	if fm >= fs {
+		val++
+		fl = fs
+		fs = laplaceFreq1(fs, decay) + laplaceMinP
+		for fs > laplaceMinP && fm >= fl+2*fs {
+			fs *= 2
+			fl += fs
+			fs = (fs-2*laplaceMinP)*uint32(decay)>>15 + laplaceMinP
+			val++
+		}
+		if fs <= laplaceMinP {
+			di := (fm - fl) >> 1
+			val += int(di)
+			fl += 2 * di * laplaceMinP
+		}
+		if fm < fl+fs {
+			val = -val
+		} else {
+			fl += fs
+		}
+	}
+	d.update(fl, minU32(fl+fs, 32768), 32768)
+	return val
+}
+
+const (
+	laplaceMinP = 1
+	laplaceNMin = 16
+)
+
+// laplaceFreq1 returns the frequency of the value 1 of a Laplace
+// distribution with frequency fs0 of 0.
+func laplaceFreq1(fs0 uint32, decay int) uint32 {
+	ft := 32768 - laplaceMinP*2*laplaceNMin - fs0
+	return ft * uint32(16384-decay) >> 15
+}
+
+// tell returns the number of bits read.
+func (d *rangeDecoder) tell() int {
+	return d.nBitsTotal - int(ilog(d.rng))
+}
+
+// tellFrac returns the number of bits read in 1/8 bits.
+func (d *rangeDecoder) tellFrac() int {
+	return tellFrac(d.nBitsTotal, d.rng)
+}
+
+func tellFrac(nBitsTotal int, rng uint32) int {
+	nbits := nBitsTotal << bitRes
+	l := ilog(rng)
+	r := rng >> (l - 16)
+	for i := 3; i > 0; i-- {
+		r = r * r >> 15
+		b := r >> 16
+		l = l<<1 | uint(b)
+		r >>= b
+	}
+	return nbits - int(l)
+}
+
+// ilog returns the number of bits needed to represent x, 0 for 0.
+func ilog(x uint32) uint {
+	return uint(bits.Len32(x))
+}
+
+func minU32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
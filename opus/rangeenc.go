@@ -0,0 +1,252 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+// rangeEncoder is the range encoder of RFC 6716 section 5.1, writing
+// range coded symbols from the start of a frame of fixed size and raw
+// bits from its end.
+type rangeEncoder struct {
+	buf        []byte
+	offs       int
+	endOffs    int
+	endWindow  uint32
+	nEndBits   uint
+	nBitsTotal int
+	rng        uint32
+	val        uint32
+	ext        uint32
+	rem        int
+	err        bool // frame overflow
+}
+
+// init starts encoding the frame buf, whose size is that of the frame.
+func (e *rangeEncoder) init(buf []byte) {
+	*e = rangeEncoder{
+		buf:        buf,
+		nBitsTotal: codeBits + 1,
+		rng:        codeTop,
+		rem:        -1}
+}
+
+func (e *rangeEncoder) writeByte(v uint32) {
+	if e.offs+e.endOffs >= len(e.buf) {
+		e.err = true
+		return
+	}
+	e.buf[e.offs] = byte(v)
+	e.offs++
+}
+
+func (e *rangeEncoder) writeByteAtEnd(v uint32) {
+	if e.offs+e.endOffs >= len(e.buf) {
+		e.err = true
+		return
+	}
+	e.endOffs++
+	e.buf[len(e.buf)-e.endOffs] = byte(v)
+}
+
+// carryOut outputs the top symbol c of the low end of the range, of
+// symBits bits and a carry bit, holding back runs of symMax which a
+// carry may yet change.
+func (e *rangeEncoder) carryOut(c uint32) {
+	if c == symMax {
+		e.ext++
+		return
+	}
+	carry := c >> symBits
+	if e.rem >= 0 {
+		e.writeByte(uint32(e.rem) + carry)
+	}
+	for ; e.ext > 0; e.ext-- {
+		e.writeByte((symMax + carry) & symMax)
+	}
+	e.rem = int(c & symMax)
+}
+
+func (e *rangeEncoder) normalize() {
+	for e.rng <= codeBot {
+		e.carryOut(e.val >> codeShift)
+		e.val = (e.val << symBits) & (codeTop - 1)
+		e.rng <<= symBits
+		e.nBitsTotal += symBits
+	}
+}
+
+// encode encodes the symbol of cumulative frequencies [fl, fh) of ft.
+func (e *rangeEncoder) encode(fl, fh, ft uint32) {
+	r := e.rng / ft
+	if fl > 0 {
+		e.val += e.rng - r*(ft-fl)
+		e.rng = r * (fh - fl)
+	} else {
+		e.rng -= r * (ft - fh)
+	}
+	e.normalize()
+}
+
+// encodeBin is encode for ft = 1<<b.
+func (e *rangeEncoder) encodeBin(fl, fh uint32, b uint) {
+	r := e.rng >> b
+	if fl > 0 {
+		e.val += e.rng - r*(1<<b-fl)
+		e.rng = r * (fh - fl)
+	} else {
+		e.rng -= r * (1<<b - fh)
+	}
+	e.normalize()
+}
+
+// bitLogp encodes the bit v, which is 1 with probability 1/(1<<logp).
+func (e *rangeEncoder) bitLogp(v int, logp uint) {
+	r := e.rng
+	s := r >> logp
+	r -= s
+	if v != 0 {
+		e.val += r
+		e.rng = s
+	} else {
+		e.rng = r
+	}
+	e.normalize()
+}
+
+// icdf encodes the symbol s of the inverse cumulative distribution icdf,
+// in units of 1/(1<<ftb).
+func (e *rangeEncoder) icdf(s int, icdf []uint8, ftb uint) {
+	r := e.rng >> ftb
+	if s > 0 {
+		e.val += e.rng - r*uint32(icdf[s-1])
+		e.rng = r * uint32(icdf[s-1]-icdf[s])
+	} else {
+		e.rng -= r * uint32(icdf[s])
+	}
+	e.normalize()
+}
+
+// uint encodes v, uniformly distributed in [0, ft).
+func (e *rangeEncoder) uint(v, ft uint32) {
+	ft--
+	ftb := ilog(ft)
+	if ftb > uintBits {
+		ftb -= uintBits
+		ft1 := ft>>ftb + 1
+		fl := v >> ftb
+		e.encode(fl, fl+1, ft1)
+		e.bits(v&(1<<ftb-1), ftb)
+		return
+	}
+	e.encode(v, v+1, ft+1)
+}
+
+// bits writes the n raw bits v from the end of the frame.
+func (e *rangeEncoder) bits(v uint32, n uint) {
+	w := e.endWindow
+	used := e.nEndBits
+	if used+n > 32 {
+		for used >= symBits {
+			e.writeByteAtEnd(w & symMax)
+			w >>= symBits
+			used -= symBits
+		}
+	}
+	w |= v << used
+	used += n
+	e.endWindow = w
+	e.nEndBits = used
+	e.nBitsTotal += int(n)
+}
+
+// laplace encodes v with the Laplace-like distribution of RFC 6716
+// section 4.3.2.1 with frequency fs of 0 and decay decay, returning v
+// or, if it has too small a probability, the value encoded instead.
+func (e *rangeEncoder) laplace(v int, fs uint32, decay int) int {
+	fl := uint32(0)
+	if v != 0 {
+		s := 0
+		if v < 0 {
+			s = -1
+		}
+		a := (v + s) ^ s
+		fl = fs
+		fs = laplaceFreq1(fs, decay)
+		i := 1
+		for ; fs > 0 && i < a; i++ {
+			fs *= 2
+			fl += fs + 2*laplaceMinP
+			fs = fs * uint32(decay) >> 15
+		}
+		if fs == 0 {
+			ndiMax := int(32768-fl+laplaceMinP-1) / laplaceMinP
+			ndiMax = (ndiMax - s) >> 1
+			di := minInt(a-i, ndiMax-1)
+			fl += uint32(2*di+1+s) * laplaceMinP
+			fs = minU32(laplaceMinP, 32768-fl)
+			v = (i + di + s) ^ s
+		} else {
+			fs += laplaceMinP
+			if s == 0 {
+				fl += fs
+			}
+		}
+	}
+	e.encodeBin(fl, fl+fs, 15)
+	return v
+}
+
+// tell returns the number of bits written.
+func (e *rangeEncoder) tell() int {
+	return e.nBitsTotal - int(ilog(e.rng))
+}
+
+// tellFrac returns the number of bits written in 1/8 bits.
+func (e *rangeEncoder) tellFrac() int {
+	return tellFrac(e.nBitsTotal, e.rng)
+}
+
+// done flushes the range coded symbols and the raw bits, zeroing the
+// unused bytes between them.  It returns false if the frame overflowed.
+func (e *rangeEncoder) done() bool {
+	l := codeBits - int(ilog(e.rng))
+	msk := uint32(codeTop-1) >> uint(l)
+	end := (e.val + msk) &^ msk
+	if end|msk >= e.val+e.rng {
+		l++
+		msk >>= 1
+		end = (e.val + msk) &^ msk
+	}
+	for l > 0 {
+		e.carryOut(end >> codeShift)
+		end = (end << symBits) & (codeTop - 1)
+		l -= symBits
+	}
+	if e.rem >= 0 || e.ext > 0 {
+		e.carryOut(0)
+	}
+	w := e.endWindow
+	used := e.nEndBits
+	for used >= symBits {
+		e.writeByteAtEnd(w & symMax)
+		w >>= symBits
+		used -= symBits
+	}
+	if e.err {
+		return false
+	}
+	for i := e.offs; i < len(e.buf)-e.endOffs; i++ {
+		e.buf[i] = 0
+	}
+	if used > 0 {
+		if e.endOffs >= len(e.buf) {
+			return false
+		}
+		l = -l
+		if e.offs+e.endOffs >= len(e.buf) && l < int(used) {
+			w &= 1<<uint(l) - 1
+			e.err = true
+		}
+		e.buf[len(e.buf)-e.endOffs-1] |= byte(w)
+	}
+	return !e.err
+}
@@ -0,0 +1,376 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+// celtCoder is the range coder of a CELT frame, which encodes with enc
+// if it is not nil and decodes with dec otherwise.
+type celtCoder struct {
+	dec *rangeDecoder
+	enc *rangeEncoder
+}
+
+func (ec *celtCoder) tell() int {
+	if ec.enc != nil {
+		return ec.enc.tell()
+	}
+	return ec.dec.tell()
+}
+
+func (ec *celtCoder) tellFrac() int {
+	if ec.enc != nil {
+		return ec.enc.tellFrac()
+	}
+	return ec.dec.tellFrac()
+}
+
+// bit codes the bit v, which is 1 with probability 1/(1<<logp), and
+// returns the bit coded.
+func (ec *celtCoder) bit(v int, logp uint) int {
+	if ec.enc != nil {
+		ec.enc.bitLogp(v, logp)
+		return v
+	}
+	return ec.dec.bitLogp(logp)
+}
+
+// uint codes v, uniformly distributed in [0, ft), and returns the value
+// coded.
+func (ec *celtCoder) uint(v, ft uint32) uint32 {
+	if ec.enc != nil {
+		ec.enc.uint(v, ft)
+		return v
+	}
+	return ec.dec.uint(ft)
+}
+
+// bits codes the n raw bits v and returns the bits coded.
+func (ec *celtCoder) bits(v uint32, n uint) uint32 {
+	if ec.enc != nil {
+		ec.enc.bits(v, n)
+		return v
+	}
+	return ec.dec.bits(n)
+}
+
+// allocation is the bit allocation of the bands of a frame.
+type allocation struct {
+	codedBands  int
+	intensity   int
+	dualStereo  bool
+	balance     int
+	pulses      [nbEBands]int // 1/8 bits of the shapes
+	fine        [nbEBands]int // fine energy bits
+	finePrio    [nbEBands]int
+	skipStart   int
+	thresh      [nbEBands]int
+	bits1       [nbEBands]int
+	bits2       [nbEBands]int
+	caps        [nbEBands]int
+	allocFloor  int
+	intRsv      int
+	dualRsv     int
+	skipRsv     int
+	total       int
+	start, end  int
+	nC, lm      int
+	trimOffsets [nbEBands]int
+
+	// Choices of the encoder: the bands coded in the previous frame and
+	// the highest band with signal.
+	prevCoded int
+	signalBW  int
+}
+
+// compute computes the allocation of total 1/8 bits to the bands
+// [start, end) of nC channels of a frame of LM lm, with boosts offsets,
+// maximums caps and allocation trim trim, as in RFC 6716 section
+// 4.3.3, coding the skipped bands, intensity and dual stereo with ec.
+// When encoding, a.intensity and a.dualStereo are those wanted, and
+// a.prevCoded and a.signalBW guide the skipping of bands.
+func (a *allocation) compute(ec *celtCoder, start, end int, offsets, caps *[nbEBands]int, trim, total, nC, lm int) {
+	a.start, a.end, a.nC, a.lm = start, end, nC, lm
+	a.caps = *caps
+	if total < 0 {
+		total = 0
+	}
+	a.skipStart = start
+	a.skipRsv = 0
+	if total >= 1<<bitRes {
+		a.skipRsv = 1 << bitRes
+	}
+	total -= a.skipRsv
+	a.intRsv, a.dualRsv = 0, 0
+	if nC == 2 {
+		a.intRsv = log2FracTable[end-start]
+		if a.intRsv > total {
+			a.intRsv = 0
+		} else {
+			total -= a.intRsv
+			if total >= 1<<bitRes {
+				a.dualRsv = 1 << bitRes
+			}
+			total -= a.dualRsv
+		}
+	}
+	for j := start; j < end; j++ {
+		w := eBands[j+1] - eBands[j]
+		a.thresh[j] = maxInt(nC<<bitRes, (3*w<<uint(lm)<<bitRes)>>4)
+		a.trimOffsets[j] = nC * w * (trim - 5 - lm) * (end - j - 1) * (1 << uint(lm+bitRes)) >> 6
+		if w<<uint(lm) == 1 {
+			a.trimOffsets[j] -= nC << bitRes
+		}
+	}
+	lo, hi := 1, len(allocVectors)-1
+	for lo <= hi {
+		done := false
+		psum := 0
+		mid := (lo + hi) >> 1
+		for j := end - 1; j >= start; j-- {
+			b := a.vectorBits(mid, j) + offsets[j]
+			if b >= a.thresh[j] || done {
+				done = true
+				psum += minInt(b, caps[j])
+			} else if b >= nC<<bitRes {
+				psum += nC << bitRes
+			}
+		}
+		if psum > total {
+			hi = mid - 1
+		} else {
+			lo = mid + 1
+		}
+	}
+	hi = lo
+	lo--
+	for j := start; j < end; j++ {
+		b1 := a.vectorBits(lo, j)
+		b2 := a.trimmed(caps[j], j)
+		if hi < len(allocVectors) {
+			b2 = a.vectorBits(hi, j)
+		}
+		if lo > 0 {
+			b1 += offsets[j]
+		}
+		b2 += offsets[j]
+		if offsets[j] > 0 {
+			a.skipStart = j
+		}
+		a.bits1[j] = b1
+		a.bits2[j] = maxInt(0, b2-b1)
+	}
+	a.total = total
+	a.interpolate(ec)
+}
+
+// vectorBits returns the bits of band j of allocation vector v with the
+// trim offset.
+func (a *allocation) vectorBits(v, j int) int {
+	n := eBands[j+1] - eBands[j]
+	return a.trimmed(a.nC*n*allocVectors[v][j]<<uint(a.lm)>>2, j)
+}
+
+// trimmed returns the bits b of band j with the trim offset.
+func (a *allocation) trimmed(b, j int) int {
+	if b > 0 {
+		b = maxInt(0, b+a.trimOffsets[j])
+	}
+	return b
+}
+
+// interpolate interpolates between the allocation vectors bits1 and
+// bits1+bits2, codes the skipped bands, and splits the bits of each band
+// into fine energy and shape bits.
+func (a *allocation) interpolate(ec *celtCoder) {
+	start, end, nC, lm := a.start, a.end, a.nC, a.lm
+	bits := &a.pulses
+	total := a.total
+	a.allocFloor = nC << bitRes
+	stereo := uint(0)
+	if nC > 1 {
+		stereo = 1
+	}
+	logM := lm << bitRes
+	lo, hi := 0, 1<<allocSteps
+	for i := 0; i < allocSteps; i++ {
+		mid := (lo + hi) >> 1
+		psum := 0
+		done := false
+		for j := end - 1; j >= start; j-- {
+			t := a.bits1[j] + mid*a.bits2[j]>>allocSteps
+			if t >= a.thresh[j] || done {
+				done = true
+				psum += minInt(t, a.caps[j])
+			} else if t >= a.allocFloor {
+				psum += a.allocFloor
+			}
+		}
+		if psum > total {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	psum := 0
+	done := false
+	for j := end - 1; j >= start; j-- {
+		t := a.bits1[j] + lo*a.bits2[j]>>allocSteps
+		if t < a.thresh[j] && !done {
+			if t >= a.allocFloor {
+				t = a.allocFloor
+			} else {
+				t = 0
+			}
+		} else {
+			done = true
+		}
+		t = minInt(t, a.caps[j])
+		bits[j] = t
+		psum += t
+	}
+	intRsv := a.intRsv
+	codedBands := end
+	for ; ; codedBands-- {
+		j := codedBands - 1
+		if j <= a.skipStart {
+			total += a.skipRsv
+			break
+		}
+		left := total - psum
+		perCoeff := left / (eBands[codedBands] - eBands[start])
+		left -= (eBands[codedBands] - eBands[start]) * perCoeff
+		rem := maxInt(left-(eBands[j]-eBands[start]), 0)
+		bandWidth := eBands[codedBands] - eBands[j]
+		bandBits := bits[j] + perCoeff*bandWidth + rem
+		if bandBits >= maxInt(a.thresh[j], a.allocFloor+1<<bitRes) {
+			if ec.bit(a.keep(codedBands, j, bandBits), 1) == 1 {
+				break
+			}
+			psum += 1 << bitRes
+			bandBits -= 1 << bitRes
+		}
+		psum -= bits[j] + intRsv
+		if intRsv > 0 {
+			intRsv = log2FracTable[j-start]
+		}
+		psum += intRsv
+		if bandBits >= a.allocFloor {
+			psum += a.allocFloor
+			bits[j] = a.allocFloor
+		} else {
+			bits[j] = 0
+		}
+	}
+	if intRsv > 0 {
+		a.intensity = minInt(maxInt(a.intensity, start), codedBands)
+		a.intensity = start + int(ec.uint(uint32(a.intensity-start), uint32(codedBands+1-start)))
+	} else {
+		a.intensity = 0
+	}
+	dualRsv := a.dualRsv
+	if a.intensity <= start {
+		total += dualRsv
+		dualRsv = 0
+	}
+	if dualRsv > 0 {
+		d := 0
+		if a.dualStereo {
+			d = 1
+		}
+		a.dualStereo = ec.bit(d, 1) == 1
+	} else {
+		a.dualStereo = false
+	}
+	left := total - psum
+	perCoeff := left / (eBands[codedBands] - eBands[start])
+	left -= (eBands[codedBands] - eBands[start]) * perCoeff
+	for j := start; j < codedBands; j++ {
+		bits[j] += perCoeff * (eBands[j+1] - eBands[j])
+	}
+	for j := start; j < codedBands; j++ {
+		t := minInt(left, eBands[j+1]-eBands[j])
+		bits[j] += t
+		left -= t
+	}
+	balance := 0
+	j := start
+	for ; j < codedBands; j++ {
+		n0 := eBands[j+1] - eBands[j]
+		n := n0 << uint(lm)
+		bit := bits[j] + balance
+		var excess int
+		if n > 1 {
+			excess = maxInt(bit-a.caps[j], 0)
+			bits[j] = bit - excess
+			den := nC * n
+			if nC == 2 && n > 2 && !a.dualStereo && j < a.intensity {
+				den++
+			}
+			nClogN := den * (logN[j] + logM)
+			offset := nClogN>>1 - den*fineOffset
+			if n == 2 {
+				offset += den << bitRes >> 2
+			}
+			if bits[j]+offset < den*2<<bitRes {
+				offset += nClogN >> 2
+			} else if bits[j]+offset < den*3<<bitRes {
+				offset += nClogN >> 3
+			}
+			e := maxInt(0, bits[j]+offset+den<<(bitRes-1))
+			e = e / den >> bitRes
+			if nC*e > bits[j]>>bitRes {
+				e = bits[j] >> stereo >> bitRes
+			}
+			e = minInt(e, maxFineBits)
+			a.fine[j] = e
+			a.finePrio[j] = 0
+			if e*(den<<bitRes) >= bits[j]+offset {
+				a.finePrio[j] = 1
+			}
+			bits[j] -= nC * e << bitRes
+		} else {
+			excess = maxInt(0, bit-nC<<bitRes)
+			bits[j] = bit - excess
+			a.fine[j] = 0
+			a.finePrio[j] = 1
+		}
+		if excess > 0 {
+			extra := minInt(excess>>(stereo+bitRes), maxFineBits-a.fine[j])
+			a.fine[j] += extra
+			extraBits := extra * nC << bitRes
+			a.finePrio[j] = 0
+			if extraBits >= excess-balance {
+				a.finePrio[j] = 1
+			}
+			excess -= extraBits
+		}
+		balance = excess
+	}
+	a.balance = balance
+	for ; j < end; j++ {
+		a.fine[j] = bits[j] >> stereo >> bitRes
+		bits[j] = 0
+		a.finePrio[j] = 0
+		if a.fine[j] < 1 {
+			a.finePrio[j] = 1
+		}
+	}
+	a.codedBands = codedBands
+}
+
+// keep returns 1 if the encoder keeps coding the bands below codedBands,
+// of which j, of bandBits 1/8 bits, is the highest, and 0 if it skips j.
+func (a *allocation) keep(codedBands, j, bandBits int) int {
+	depth := 0
+	if codedBands > 17 {
+		depth = 9
+		if j < a.prevCoded {
+			depth = 7
+		}
+	}
+	bandWidth := eBands[codedBands] - eBands[j]
+	if codedBands <= a.start+2 || (bandBits > (depth*bandWidth<<uint(a.lm)<<bitRes)>>4 && j <= a.signalBW) {
+		return 1
+	}
+	return 0
+}
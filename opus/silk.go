@@ -0,0 +1,502 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import "math/bits"
+
+// The SILK decoder of RFC 6716 section 4.2 is specified by the fixed
+// point arithmetic of the reference implementation, which the functions
+// below reproduce, including the wrapping of its 32 bit integers.
+
+// silkNLSFCodebook is a codebook of normalized line spectral
+// frequencies, with a first stage vector quantizer and the entropy coding
+// of the residuals of its second stage.
+type silkNLSFCodebook struct {
+	n           int // first stage vectors
+	order       int
+	stepQ16     int
+	cb1Q8       []uint8
+	weightQ9    []int32
+	cb1ICDF     []uint8
+	predQ8      []uint8
+	ecSel       []uint8
+	ecICDF      []uint8
+	deltaMinQ15 []int32
+}
+
+// smulwb returns (a * int16(b)) >> 16.
+func smulwb(a, b int32) int32 {
+	return int32((int64(a) * int64(int16(b))) >> 16)
+}
+
+// smlawb returns a + (b * int16(c)) >> 16.
+func smlawb(a, b, c int32) int32 {
+	return a + smulwb(b, c)
+}
+
+// smulww returns (a * b) >> 16.
+func smulww(a, b int32) int32 {
+	return int32((int64(a) * int64(b)) >> 16)
+}
+
+// smlaww returns a + (b * c) >> 16.
+func smlaww(a, b, c int32) int32 {
+	return a + smulww(b, c)
+}
+
+// smulbb returns int16(a) * int16(b).
+func smulbb(a, b int32) int32 {
+	return int32(int16(a)) * int32(int16(b))
+}
+
+// smlabb returns a + int16(b) * int16(c).
+func smlabb(a, b, c int32) int32 {
+	return a + smulbb(b, c)
+}
+
+// smultt returns (a >> 16) * (b >> 16).
+func smultt(a, b int32) int32 {
+	return (a >> 16) * (b >> 16)
+}
+
+// smmul returns (a * b) >> 32.
+func smmul(a, b int32) int32 {
+	return int32((int64(a) * int64(b)) >> 32)
+}
+
+// rshiftRound returns a >> s rounded, for s > 0.
+func rshiftRound(a int32, s uint) int32 {
+	if s == 1 {
+		return a>>1 + a&1
+	}
+	return (a>>(s-1) + 1) >> 1
+}
+
+// rshiftRound64 is rshiftRound of 64 bit integers.
+func rshiftRound64(a int64, s uint) int64 {
+	if s == 1 {
+		return a>>1 + a&1
+	}
+	return (a>>(s-1) + 1) >> 1
+}
+
+// addSat32 returns a + b saturated to 32 bits.
+func addSat32(a, b int32) int32 {
+	s := int64(a) + int64(b)
+	return int32(limit64(s, -1<<31, 1<<31-1))
+}
+
+// subSat32 returns a - b saturated to 32 bits.
+func subSat32(a, b int32) int32 {
+	s := int64(a) - int64(b)
+	return int32(limit64(s, -1<<31, 1<<31-1))
+}
+
+func limit64(a, lo, hi int64) int64 {
+	if a < lo {
+		return lo
+	}
+	if a > hi {
+		return hi
+	}
+	return a
+}
+
+// sat16 returns a saturated to 16 bits.
+func sat16(a int32) int32 {
+	return limit32(a, -1<<15, 1<<15-1)
+}
+
+// limit32 returns a limited to the range between l1 and l2, in either
+// order.
+func limit32(a, l1, l2 int32) int32 {
+	if l1 > l2 {
+		l1, l2 = l2, l1
+	}
+	if a < l1 {
+		return l1
+	}
+	if a > l2 {
+		return l2
+	}
+	return a
+}
+
+// lshiftSat32 returns a << s saturated to 32 bits.
+func lshiftSat32(a int32, s uint) int32 {
+	return limit32(a, -1<<31>>s, (1<<31-1)>>s) << s
+}
+
+// abs32 returns the absolute value of a, wrapping for -1<<31.
+func abs32(a int32) int32 {
+	if a > 0 {
+		return a
+	}
+	return -a
+}
+
+// clz32 returns the number of leading zero bits of a.
+func clz32(a int32) int {
+	return bits.LeadingZeros32(uint32(a))
+}
+
+// clzFrac returns the number of leading zero bits of a and the 7 bits
+// after its leading one.
+func clzFrac(a int32) (lz int, frac int32) {
+	lz = clz32(a)
+	frac = int32(bits.RotateLeft32(uint32(a), -(24-lz))) & 0x7f
+	return lz, frac
+}
+
+// silkRand returns the next value of the linear congruential generator
+// of seed.
+func silkRand(seed int32) int32 {
+	return int32(907633515 + uint32(seed)*196314165)
+}
+
+// sqrtApprox approximates the square root of x.
+func sqrtApprox(x int32) int32 {
+	if x <= 0 {
+		return 0
+	}
+	lz, frac := clzFrac(x)
+	y := int32(46214) // sqrt(2) * 32768
+	if lz&1 != 0 {
+		y = 32768
+	}
+	y >>= uint(lz >> 1)
+	return smlawb(y, y, smulbb(213, frac))
+}
+
+// div32VarQ approximates (a << q) / b.
+func div32VarQ(a, b int32, q int) int32 {
+	aHeadroom := clz32(abs32(a)) - 1
+	aNorm := a << uint(aHeadroom)
+	bHeadroom := clz32(abs32(b)) - 1
+	bNorm := b << uint(bHeadroom)
+	bInv := (1<<31 - 1) >> 2 / (bNorm >> 16)
+	r := smulwb(aNorm, bInv)
+	aNorm -= smmul(bNorm, r) << 3
+	r = smlawb(r, aNorm, bInv)
+	shift := 29 + aHeadroom - bHeadroom - q
+	switch {
+	case shift < 0:
+		return lshiftSat32(r, uint(-shift))
+	case shift < 32:
+		return r >> uint(shift)
+	}
+	return 0
+}
+
+// inverse32VarQ approximates (1 << q) / b.
+func inverse32VarQ(b int32, q int) int32 {
+	bHeadroom := clz32(abs32(b)) - 1
+	bNorm := b << uint(bHeadroom)
+	bInv := (1<<31 - 1) >> 2 / (bNorm >> 16)
+	r := bInv << 16
+	errQ32 := (1<<29 - smulwb(bNorm, bInv)) << 3
+	r = smlaww(r, errQ32, bInv)
+	shift := 61 - bHeadroom - q
+	switch {
+	case shift <= 0:
+		return lshiftSat32(r, uint(-shift))
+	case shift < 32:
+		return r >> uint(shift)
+	}
+	return 0
+}
+
+// log2lin approximates 2^(in/128).
+func log2lin(in int32) int32 {
+	if in < 0 {
+		return 0
+	}
+	if in >= 3967 {
+		return 1<<31 - 1
+	}
+	out := int32(1) << uint(in>>7)
+	frac := in & 0x7f
+	p := smlawb(frac, smulbb(frac, 128-frac), -174)
+	if in < 2048 {
+		return out + (out*p)>>7
+	}
+	return out + (out>>7)*p
+}
+
+// lin2log approximates 128 * log2(in).
+func lin2log(in int32) int32 {
+	lz, frac := clzFrac(in)
+	return smlawb(frac, frac*(128-frac), 179) + int32(31-lz)<<7
+}
+
+// sumSqrShift returns the energy of x, shifted right by shift bits to
+// fit in 31 bits with headroom.
+func sumSqrShift(x []int16) (energy int32, shift int) {
+	n := len(x)
+	sum := func(shift uint) int32 {
+		nrg := uint32(0)
+		i := 0
+		for ; i < n-1; i += 2 {
+			t := uint32(smulbb(int32(x[i]), int32(x[i])) + smulbb(int32(x[i+1]), int32(x[i+1])))
+			nrg += t >> shift
+		}
+		if i < n {
+			nrg += uint32(smulbb(int32(x[i]), int32(x[i]))) >> shift
+		}
+		return int32(nrg)
+	}
+	shift = 31 - clz32(int32(n))
+	nrg := int32(n) + sum(uint(shift))
+	shift = maxInt(0, shift+3-clz32(nrg))
+	return sum(uint(shift)), shift
+}
+
+// nlsf2aOrder10 and nlsf2aOrder16 are the orders in which the
+// polynomials of nlsf2a are built from the frequencies, for accuracy.
+var (
+	nlsf2aOrder10 = [10]int{0, 9, 6, 3, 4, 5, 8, 1, 2, 7}
+	nlsf2aOrder16 = [16]int{0, 15, 8, 7, 4, 11, 12, 3, 2, 13, 10, 5, 6, 9, 14, 1}
+)
+
+// nlsf2a converts the normalized line spectral frequencies nlsf in Q15
+// to the coefficients a of a stable LPC filter in Q12.
+func nlsf2a(a []int16, nlsf []int16) {
+	const qa = 16
+	d := len(nlsf)
+	order := nlsf2aOrder16[:]
+	if d == 10 {
+		order = nlsf2aOrder10[:]
+	}
+	var cos [silkMaxLPCOrder]int32
+	for k, f := range nlsf {
+		fInt := int32(f) >> (15 - 7)
+		fFrac := int32(f) - fInt<<(15-7)
+		c := silkLSFCosQ12[fInt]
+		delta := silkLSFCosQ12[fInt+1] - c
+		cos[order[k]] = rshiftRound(c<<8+delta*fFrac, 20-qa)
+	}
+	dd := d >> 1
+	var p, q [silkMaxLPCOrder/2 + 1]int32
+	findPoly := func(out []int32, c []int32) {
+		out[0] = 1 << qa
+		out[1] = -c[0]
+		for k := 1; k < dd; k++ {
+			t := int64(c[2*k])
+			out[k+1] = out[k-1]<<1 - int32(rshiftRound64(t*int64(out[k]), qa))
+			for n := k; n > 1; n-- {
+				out[n] += out[n-2] - int32(rshiftRound64(t*int64(out[n-1]), qa))
+			}
+			out[1] -= int32(t)
+		}
+	}
+	findPoly(p[:], cos[:])
+	findPoly(q[:], cos[1:])
+	var a32 [silkMaxLPCOrder]int32
+	for k := 0; k < dd; k++ {
+		pt := p[k+1] + p[k]
+		qt := q[k+1] - q[k]
+		a32[k] = -qt - pt
+		a32[d-k-1] = qt - pt
+	}
+	lpcFit(a, a32[:d], 12, qa+1)
+	for i := 0; lpcInversePredGain(a) == 0 && i < 16; i++ {
+		bwexpander32(a32[:d], 65536-2<<uint(i))
+		for k := range a {
+			a[k] = int16(rshiftRound(a32[k], qa+1-12))
+		}
+	}
+}
+
+// lpcFit converts the coefficients in of Q qin to those of out of Q
+// qout, expanding their bandwidth until they fit in 16 bits.
+func lpcFit(out []int16, in []int32, qout, qin uint) {
+	i := 0
+	for ; i < 10; i++ {
+		maxabs, idx := int32(0), 0
+		for k, a := range in {
+			if a := abs32(a); a > maxabs {
+				maxabs, idx = a, k
+			}
+		}
+		maxabs = rshiftRound(maxabs, qin-qout)
+		if maxabs <= 1<<15-1 {
+			break
+		}
+		maxabs = minInt32(maxabs, 163838)
+		chirp := int32(65470) - ((maxabs-(1<<15-1))<<14)/((maxabs*int32(idx+1))>>2)
+		bwexpander32(in, chirp)
+	}
+	if i == 10 {
+		for k := range in {
+			out[k] = int16(sat16(rshiftRound(in[k], qin-qout)))
+			in[k] = int32(out[k]) << (qin - qout)
+		}
+		return
+	}
+	for k := range in {
+		out[k] = int16(rshiftRound(in[k], qin-qout))
+	}
+}
+
+// bwexpander expands the bandwidth of the LPC filter a by chirp in Q16.
+func bwexpander(a []int16, chirp int32) {
+	m := chirp - 65536
+	d := len(a)
+	for i := 0; i < d-1; i++ {
+		a[i] = int16(rshiftRound(chirp*int32(a[i]), 16))
+		chirp += rshiftRound(chirp*m, 16)
+	}
+	a[d-1] = int16(rshiftRound(chirp*int32(a[d-1]), 16))
+}
+
+// bwexpander32 is bwexpander of 32 bit coefficients.
+func bwexpander32(a []int32, chirp int32) {
+	m := chirp - 65536
+	d := len(a)
+	for i := 0; i < d-1; i++ {
+		a[i] = smulww(chirp, a[i])
+		chirp += rshiftRound(chirp*m, 16)
+	}
+	a[d-1] = smulww(chirp, a[d-1])
+}
+
+// nlsfStabilize makes the distances between the frequencies nlsf, and
+// from 0 and 1, at least those of deltaMin, in Q15.
+func nlsfStabilize(nlsf []int16, deltaMin []int32) {
+	l := len(nlsf)
+	for loops := 0; loops < 20; loops++ {
+		minDiff := int32(nlsf[0]) - deltaMin[0]
+		j := 0
+		for i := 1; i < l; i++ {
+			if diff := int32(nlsf[i]) - (int32(nlsf[i-1]) + deltaMin[i]); diff < minDiff {
+				minDiff, j = diff, i
+			}
+		}
+		if diff := 1<<15 - (int32(nlsf[l-1]) + deltaMin[l]); diff < minDiff {
+			minDiff, j = diff, l
+		}
+		if minDiff >= 0 {
+			return
+		}
+		switch j {
+		case 0:
+			nlsf[0] = int16(deltaMin[0])
+		case l:
+			nlsf[l-1] = int16(1<<15 - deltaMin[l])
+		default:
+			minCenter := int32(0)
+			for k := 0; k < j; k++ {
+				minCenter += deltaMin[k]
+			}
+			minCenter += deltaMin[j] >> 1
+			maxCenter := int32(1 << 15)
+			for k := l; k > j; k-- {
+				maxCenter -= deltaMin[k]
+			}
+			maxCenter -= deltaMin[j] >> 1
+			center := int16(limit32(rshiftRound(int32(nlsf[j-1])+int32(nlsf[j]), 1), minCenter, maxCenter))
+			nlsf[j-1] = center - int16(deltaMin[j]>>1)
+			nlsf[j] = nlsf[j-1] + int16(deltaMin[j])
+		}
+	}
+	for i := 1; i < l; i++ {
+		for k := i; k > 0 && nlsf[k] < nlsf[k-1]; k-- {
+			nlsf[k], nlsf[k-1] = nlsf[k-1], nlsf[k]
+		}
+	}
+	nlsf[0] = int16(maxInt32(int32(nlsf[0]), deltaMin[0]))
+	for i := 1; i < l; i++ {
+		nlsf[i] = int16(maxInt32(int32(nlsf[i]), int32(int16(sat16(int32(nlsf[i-1])+deltaMin[i])))))
+	}
+	nlsf[l-1] = int16(minInt32(int32(nlsf[l-1]), 1<<15-deltaMin[l]))
+	for i := l - 2; i >= 0; i-- {
+		nlsf[i] = int16(minInt32(int32(nlsf[i]), int32(nlsf[i+1])-deltaMin[i+1]))
+	}
+}
+
+// lpcInversePredGain returns the inverse of the prediction gain of the
+// LPC filter a in Q12, in Q30, or 0 if the filter is unstable.
+func lpcInversePredGain(a []int16) int32 {
+	const qa = 24
+	const aLimit = 16773022 // 0.99975 in Q24
+	var t [silkMaxLPCOrder]int32
+	dc := int32(0)
+	for k, c := range a {
+		dc += int32(c)
+		t[k] = int32(c) << (qa - 12)
+	}
+	if dc >= 4096 {
+		return 0
+	}
+	aq := t[:len(a)]
+	invGain := int32(1 << 30)
+	k := len(a) - 1
+	for ; k > 0; k-- {
+		if aq[k] > aLimit || aq[k] < -aLimit {
+			return 0
+		}
+		rc := -(aq[k] << (31 - qa))
+		rcMult1 := 1<<30 - smmul(rc, rc)
+		invGain = smmul(invGain, rcMult1) << 2
+		if invGain < 107374 { // 1/1e4 in Q30
+			return 0
+		}
+		mult2Q := 32 - clz32(abs32(rcMult1))
+		rcMult2 := int64(inverse32VarQ(rcMult1, mult2Q+30))
+		for n := 0; n < (k+1)>>1; n++ {
+			t1, t2 := aq[n], aq[k-n-1]
+			v := rshiftRound64(int64(subSat32(t1, int32(rshiftRound64(int64(t2)*int64(rc), 31))))*rcMult2, uint(mult2Q))
+			if v > 1<<31-1 || v < -1<<31 {
+				return 0
+			}
+			aq[n] = int32(v)
+			v = rshiftRound64(int64(subSat32(t2, int32(rshiftRound64(int64(t1)*int64(rc), 31))))*rcMult2, uint(mult2Q))
+			if v > 1<<31-1 || v < -1<<31 {
+				return 0
+			}
+			aq[k-n-1] = int32(v)
+		}
+	}
+	if aq[0] > aLimit || aq[0] < -aLimit {
+		return 0
+	}
+	rc := -(aq[0] << (31 - qa))
+	rcMult1 := 1<<30 - smmul(rc, rc)
+	invGain = smmul(invGain, rcMult1) << 2
+	if invGain < 107374 {
+		return 0
+	}
+	return invGain
+}
+
+// lpcAnalysisFilter filters in by the LPC filter b in Q12 into out,
+// whose first len(b) samples are zero.
+func lpcAnalysisFilter(out, in []int16, b []int16) {
+	d := len(b)
+	for i := d; i < len(in); i++ {
+		acc := int32(0)
+		for j := 0; j < d; j++ {
+			acc += smulbb(int32(in[i-1-j]), int32(b[j]))
+		}
+		acc = int32(in[i])<<12 - acc
+		out[i] = int16(sat16(rshiftRound(acc, 12)))
+	}
+	for i := 0; i < d; i++ {
+		out[i] = 0
+	}
+}
+
+func minInt32(a, b int32) int32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt32(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// TestSILKReference checks that the frame decoder decodes the final
+// ranges and samples of the reference decoder from the SILK, hybrid and
+// mode switching streams of testdata, described in testdata/README.md.
+func TestSILKReference(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		nC   int
+	}{
+		{"silk-nb-mono-20ms", 1},
+		{"silk-mb-stereo-40ms", 2},
+		{"silk-wb-mono-60ms", 1},
+		{"silk-wb-stereo-10ms", 2},
+		{"hybrid-swb-mono-20ms", 1},
+		{"hybrid-fb-stereo-10ms", 2},
+		{"switch-mono-10ms", 1},
+		{"switch-stereo-20ms", 2},
+	} {
+		bit, err := ioutil.ReadFile(filepath.Join("testdata", tc.name+".bit"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pcm, err := ioutil.ReadFile(filepath.Join("testdata", tc.name+".pcm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nC := tc.nC
+		fd, _ := newOpusFrameDecoder(nC)
+		dst := make([]float64, nC*MaxPacketDuration)
+		pos := 0
+		for p := 0; len(bit) >= 8; p++ {
+			n, rng := binary.BigEndian.Uint32(bit), binary.BigEndian.Uint32(bit[4:])
+			pkt := bit[8 : 8+n]
+			bit = bit[8+n:]
+			fs, err := fd.Decode(pkt, dst)
+			if err != nil {
+				t.Fatalf("%s: packet %d: %v", tc.name, p, err)
+			}
+			if got := fd.(*frameDecoder).rng; got != rng {
+				t.Fatalf("%s: packet %d final range %x want %x", tc.name, p, got, rng)
+			}
+			if len(pcm) < 2*nC*(pos+fs) {
+				t.Fatalf("%s: packet %d past the samples", tc.name, p)
+			}
+			for c := 0; c < nC; c++ {
+				for i, v := range dst[c*fs : (c+1)*fs] {
+					ref := float64(int16(binary.LittleEndian.Uint16(pcm[2*((pos+i)*nC+c):])))
+					got := math.Max(-32768, math.Min(32767, math.Floor(0.5+v*32768)))
+					if math.Abs(got-ref) > 1 {
+						t.Fatalf("%s: sample %d channel %d got %g want %g", tc.name, pos+i, c, got, ref)
+					}
+				}
+			}
+			pos += fs
+		}
+		if len(pcm) != 2*nC*pos {
+			t.Errorf("%s: %d samples decoded of %d", tc.name, pos, len(pcm)/2/nC)
+		}
+	}
+}
+
+// TestSILKDecodeRandom checks that the frame decoder decodes arbitrary
+// SILK and hybrid packets of all sizes, bandwidths and channels to finite
+// samples.
+func TestSILKDecodeRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for nC := 1; nC <= 2; nC++ {
+		fd, _ := newOpusFrameDecoder(nC)
+		dst := make([]float64, nC*MaxPacketDuration)
+		for i := 0; i < 400; i++ {
+			toc := byte(rnd.Intn(16)) << 3
+			if rnd.Intn(2) == 1 {
+				toc |= 4
+			}
+			p := make([]byte, 1+rnd.Intn(300))
+			rnd.Read(p[1:])
+			p[0] = toc
+			n, err := fd.Decode(p, dst)
+			if err != nil {
+				t.Fatalf("packet %d: %v", i, err)
+			}
+			if n != TOC(toc).FrameSize() {
+				t.Fatalf("packet %d: %d samples", i, n)
+			}
+			for _, v := range dst[:nC*n] {
+				if math.IsNaN(v) || math.IsInf(v, 0) {
+					t.Fatalf("packet %d: sample %v", i, v)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,779 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+// Constants of the SILK decoder of RFC 6716 section 4.2.
+const (
+	silkMaxLPCOrder     = 16
+	silkLTPOrder        = 5
+	silkMaxNbSubfr      = 4
+	silkMaxFrameLength  = 320 // 20ms at 16kHz
+	silkMaxSubfrLength  = 80
+	silkMaxLTPMemLength = 320
+	silkMaxFramesPerPkt = 3
+	silkMaxPulses       = 16
+	silkShellLength     = 16
+
+	silkNoVoiceActivity = 0
+	silkUnvoiced        = 1
+	silkVoiced          = 2
+
+	silkCodeIndependently             = 0
+	silkCodeIndependentlyNoLTPScaling = 1
+	silkCodeConditionally             = 2
+)
+
+// silkDecoder decodes the SILK frames of 1 or 2 channels, as in RFC 6716
+// section 4.2, to 48kHz.
+type silkDecoder struct {
+	ch                   [2]silkChannel
+	stereo               silkStereo
+	nAPI, nInternal      int
+	prevDecodeOnlyMiddle bool
+	tmp                  [2][silkMaxFrameLength + 2]int16
+}
+
+// silkChannel is the state of the decoder of one coded channel.
+type silkChannel struct {
+	prevGainQ16          int32
+	excQ14               [silkMaxFrameLength]int32
+	sLPCQ14              [silkMaxLPCOrder]int32
+	outBuf               [silkMaxFrameLength + 2*silkMaxSubfrLength]int16
+	lagPrev              int
+	lastGainIndex        int
+	fsKHz                int
+	nbSubfr              int
+	frameLength          int
+	subfrLength          int
+	ltpMemLength         int
+	lpcOrder             int
+	prevNLSFQ15          [silkMaxLPCOrder]int16
+	firstFrameAfterReset bool
+	lagLowBitsICDF       []uint8
+	contourICDF          []uint8
+	nFramesDecoded       int
+	nFramesPerPacket     int
+	ecPrevSignalType     int
+	ecPrevLagIndex       int
+	vad                  [silkMaxFramesPerPkt]bool
+	lbrr                 bool
+	lbrrFlags            [silkMaxFramesPerPkt]bool
+	cb                   *silkNLSFCodebook
+	indices              silkIndices
+	lossCnt              int
+	prevSignalType       int
+	resampler            silkResampler
+	plc                  silkPLC
+	cng                  silkCNG
+}
+
+// silkIndices are the quantization indices of a frame.
+type silkIndices struct {
+	gains           [silkMaxNbSubfr]int
+	nlsf            [silkMaxLPCOrder + 1]int
+	lagIndex        int
+	contourIndex    int
+	signalType      int
+	quantOffsetType int
+	nlsfInterpQ2    int
+	perIndex        int
+	ltpIndex        [silkMaxNbSubfr]int
+	ltpScaleIndex   int
+	seed            int
+}
+
+// silkParams are the parameters of a frame, decoded from its indices.
+type silkParams struct {
+	pitchL      [silkMaxNbSubfr]int
+	gainsQ16    [silkMaxNbSubfr]int32
+	predCoefQ12 [2][silkMaxLPCOrder]int16
+	ltpCoefQ14  [silkLTPOrder * silkMaxNbSubfr]int16
+	ltpScaleQ14 int32
+}
+
+// silkStereo is the state of the mid-side to left-right conversion.
+type silkStereo struct {
+	predPrevQ13 [2]int32
+	sMid, sSide [2]int16
+}
+
+func (d *silkDecoder) reset() {
+	for i := range d.ch {
+		d.ch[i].init()
+	}
+	d.stereo = silkStereo{}
+	d.prevDecodeOnlyMiddle = false
+}
+
+func (c *silkChannel) init() {
+	*c = silkChannel{firstFrameAfterReset: true, prevGainQ16: 65536}
+	c.cngReset()
+	c.plcReset()
+}
+
+// decode decodes from rd, or conceals if lost, a SILK frame of the
+// nInternal coded channels, of internal sample rate rate, into the
+// nAPI channels of out at 48kHz, returning the number of samples per
+// channel.  payloadMs is the duration of the Opus frame of the SILK
+// frames, and newPacket is set for its first SILK frame.
+func (d *silkDecoder) decode(rd *rangeDecoder, lost, newPacket bool, nAPI, nInternal, rate, payloadMs int, out [2][]int16) int {
+	ch := &d.ch
+	if newPacket {
+		for n := 0; n < nInternal; n++ {
+			ch[n].nFramesDecoded = 0
+		}
+	}
+	if nInternal > d.nInternal {
+		ch[1].init()
+	}
+	stereoToMono := nInternal == 1 && d.nInternal == 2 && rate == 1000*ch[0].fsKHz
+	if ch[0].nFramesDecoded == 0 {
+		for n := 0; n < nInternal; n++ {
+			switch payloadMs {
+			case 0, 10:
+				ch[n].nFramesPerPacket, ch[n].nbSubfr = 1, 2
+			case 20:
+				ch[n].nFramesPerPacket, ch[n].nbSubfr = 1, 4
+			case 40:
+				ch[n].nFramesPerPacket, ch[n].nbSubfr = 2, 4
+			case 60:
+				ch[n].nFramesPerPacket, ch[n].nbSubfr = 3, 4
+			}
+			ch[n].setFs(rate>>10 + 1)
+		}
+	}
+	if nAPI == 2 && nInternal == 2 && (d.nAPI == 1 || d.nInternal == 1) {
+		d.stereo.predPrevQ13 = [2]int32{}
+		d.stereo.sSide = [2]int16{}
+		ch[1].resampler = ch[0].resampler
+	}
+	d.nAPI, d.nInternal = nAPI, nInternal
+	var predQ13 [2]int32
+	decodeOnlyMiddle := false
+	if !lost && ch[0].nFramesDecoded == 0 {
+		for n := 0; n < nInternal; n++ {
+			for i := 0; i < ch[n].nFramesPerPacket; i++ {
+				ch[n].vad[i] = rd.bitLogp(1) == 1
+			}
+			ch[n].lbrr = rd.bitLogp(1) == 1
+		}
+		for n := 0; n < nInternal; n++ {
+			c := &ch[n]
+			c.lbrrFlags = [silkMaxFramesPerPkt]bool{}
+			if !c.lbrr {
+				continue
+			}
+			if c.nFramesPerPacket == 1 {
+				c.lbrrFlags[0] = true
+				continue
+			}
+			sym := rd.icdf(silkLBRRFlagsICDF[c.nFramesPerPacket-2], 8) + 1
+			for i := 0; i < c.nFramesPerPacket; i++ {
+				c.lbrrFlags[i] = sym>>uint(i)&1 == 1
+			}
+		}
+		// The redundant frames are skipped.
+		var pulses [silkMaxFrameLength]int16
+		for i := 0; i < ch[0].nFramesPerPacket; i++ {
+			for n := 0; n < nInternal; n++ {
+				if !ch[n].lbrrFlags[i] {
+					continue
+				}
+				if nInternal == 2 && n == 0 {
+					silkStereoDecodePred(rd, &predQ13)
+					if !ch[1].lbrrFlags[i] {
+						decodeOnlyMiddle = rd.icdf(silkStereoMidOnlyICDF, 8) == 1
+					}
+				}
+				cond := silkCodeIndependently
+				if i > 0 && ch[n].lbrrFlags[i-1] {
+					cond = silkCodeConditionally
+				}
+				ch[n].decodeIndices(rd, i, true, cond)
+				ch[n].decodePulses(rd, pulses[:])
+			}
+		}
+	}
+	if nInternal == 2 {
+		if !lost {
+			silkStereoDecodePred(rd, &predQ13)
+			decodeOnlyMiddle = false
+			if !ch[1].vad[ch[0].nFramesDecoded] {
+				decodeOnlyMiddle = rd.icdf(silkStereoMidOnlyICDF, 8) == 1
+			}
+		} else {
+			predQ13 = d.stereo.predPrevQ13
+		}
+	}
+	if nInternal == 2 && !decodeOnlyMiddle && d.prevDecodeOnlyMiddle {
+		c := &ch[1]
+		c.outBuf = [len(c.outBuf)]int16{}
+		c.sLPCQ14 = [silkMaxLPCOrder]int32{}
+		c.lagPrev = 100
+		c.lastGainIndex = 10
+		c.prevSignalType = silkNoVoiceActivity
+		c.firstFrameAfterReset = true
+	}
+	hasSide := !decodeOnlyMiddle
+	if lost {
+		hasSide = !d.prevDecodeOnlyMiddle
+	}
+	nDec := 0
+	for n := 0; n < nInternal; n++ {
+		if n == 0 || hasSide {
+			frameIndex := ch[0].nFramesDecoded - n
+			cond := silkCodeConditionally
+			switch {
+			case frameIndex <= 0:
+				cond = silkCodeIndependently
+			case n > 0 && d.prevDecodeOnlyMiddle:
+				cond = silkCodeIndependentlyNoLTPScaling
+			}
+			nDec = ch[n].decodeFrame(rd, d.tmp[n][2:], lost, cond)
+		} else {
+			for i := range d.tmp[n][2 : 2+nDec] {
+				d.tmp[n][2+i] = 0
+			}
+		}
+		ch[n].nFramesDecoded++
+	}
+	if nAPI == 2 && nInternal == 2 {
+		d.stereo.msToLR(d.tmp[0][:nDec+2], d.tmp[1][:nDec+2], &predQ13, ch[0].fsKHz)
+	} else {
+		copy(d.tmp[0][:2], d.stereo.sMid[:])
+		copy(d.stereo.sMid[:], d.tmp[0][nDec:nDec+2])
+	}
+	nOut := nDec * 48 / ch[0].fsKHz
+	for n := 0; n < minInt(nAPI, nInternal); n++ {
+		ch[n].resampler.resample(out[n][:nOut], d.tmp[n][1:1+nDec])
+	}
+	if nAPI == 2 && nInternal == 1 {
+		if stereoToMono {
+			ch[1].resampler.resample(out[1][:nOut], d.tmp[0][1:1+nDec])
+		} else {
+			copy(out[1][:nOut], out[0][:nOut])
+		}
+	}
+	if lost {
+		for n := 0; n < d.nInternal; n++ {
+			ch[n].lastGainIndex = 10
+		}
+	} else {
+		d.prevDecodeOnlyMiddle = decodeOnlyMiddle
+	}
+	return nOut
+}
+
+// setFs sets the internal sample rate of the channel to fsKHz, with the
+// number of subframes set.
+func (c *silkChannel) setFs(fsKHz int) {
+	c.subfrLength = 5 * fsKHz
+	frameLength := c.nbSubfr * c.subfrLength
+	if c.fsKHz != fsKHz {
+		c.resampler.init(fsKHz)
+	}
+	if c.fsKHz == fsKHz && frameLength == c.frameLength {
+		return
+	}
+	switch {
+	case fsKHz == 8 && c.nbSubfr == silkMaxNbSubfr:
+		c.contourICDF = silkPitchContourNBICDF
+	case fsKHz == 8:
+		c.contourICDF = silkPitchContour10msNBICDF
+	case c.nbSubfr == silkMaxNbSubfr:
+		c.contourICDF = silkPitchContourICDF
+	default:
+		c.contourICDF = silkPitchContour10msICDF
+	}
+	if c.fsKHz != fsKHz {
+		c.ltpMemLength = 20 * fsKHz
+		c.lpcOrder, c.cb = 10, silkNLSFCodebookNBMB
+		if fsKHz == 16 {
+			c.lpcOrder, c.cb = silkMaxLPCOrder, silkNLSFCodebookWB
+		}
+		switch fsKHz {
+		case 8:
+			c.lagLowBitsICDF = silkUniform4ICDF
+		case 12:
+			c.lagLowBitsICDF = silkUniform6ICDF
+		default:
+			c.lagLowBitsICDF = silkUniform8ICDF
+		}
+		c.firstFrameAfterReset = true
+		c.lagPrev = 100
+		c.lastGainIndex = 10
+		c.prevSignalType = silkNoVoiceActivity
+		c.outBuf = [len(c.outBuf)]int16{}
+		c.sLPCQ14 = [silkMaxLPCOrder]int32{}
+	}
+	c.fsKHz = fsKHz
+	c.frameLength = frameLength
+}
+
+// decodeFrame decodes from rd, or conceals if lost, a frame of the
+// channel into out, returning its length.
+func (c *silkChannel) decodeFrame(rd *rangeDecoder, out []int16, lost bool, cond int) int {
+	l := c.frameLength
+	out = out[:l]
+	var p silkParams
+	if !lost {
+		var pulses [silkMaxFrameLength]int16
+		c.decodeIndices(rd, c.nFramesDecoded, false, cond)
+		c.decodePulses(rd, pulses[:])
+		c.decodeParameters(&p, cond)
+		c.decodeCore(&p, out, pulses[:])
+		c.plcFrame(&p, out, false)
+		c.lossCnt = 0
+		c.prevSignalType = c.indices.signalType
+		c.firstFrameAfterReset = false
+	} else {
+		c.plcFrame(&p, out, true)
+	}
+	mv := c.ltpMemLength - l
+	copy(c.outBuf[:mv], c.outBuf[l:l+mv])
+	copy(c.outBuf[mv:], out)
+	c.cngApply(&p, out)
+	c.glueFrames(out)
+	c.lagPrev = p.pitchL[c.nbSubfr-1]
+	return l
+}
+
+// decodeIndices decodes the quantization indices of frame i, or of its
+// low bit rate redundancy if lbrr.
+func (c *silkChannel) decodeIndices(rd *rangeDecoder, i int, lbrr bool, cond int) {
+	x := &c.indices
+	var ix int
+	if lbrr || c.vad[i] {
+		ix = rd.icdf(silkTypeOffsetVADICDF, 8) + 2
+	} else {
+		ix = rd.icdf(silkTypeOffsetNoVADICDF, 8)
+	}
+	x.signalType, x.quantOffsetType = ix>>1, ix&1
+	if cond == silkCodeConditionally {
+		x.gains[0] = rd.icdf(silkDeltaGainICDF, 8)
+	} else {
+		x.gains[0] = rd.icdf(silkGainICDF[x.signalType], 8) << 3
+		x.gains[0] += rd.icdf(silkUniform8ICDF, 8)
+	}
+	for k := 1; k < c.nbSubfr; k++ {
+		x.gains[k] = rd.icdf(silkDeltaGainICDF, 8)
+	}
+	cb := c.cb
+	x.nlsf[0] = rd.icdf(cb.cb1ICDF[(x.signalType>>1)*cb.n:], 8)
+	var ecIx [silkMaxLPCOrder]int
+	var predQ8 [silkMaxLPCOrder]uint8
+	cb.unpack(ecIx[:], predQ8[:], x.nlsf[0])
+	for k := 0; k < cb.order; k++ {
+		ix := rd.icdf(cb.ecICDF[ecIx[k]:], 8)
+		if ix == 0 {
+			ix -= rd.icdf(silkNLSFExtICDF, 8)
+		} else if ix == 8 {
+			ix += rd.icdf(silkNLSFExtICDF, 8)
+		}
+		x.nlsf[k+1] = ix - 4
+	}
+	x.nlsfInterpQ2 = 4
+	if c.nbSubfr == silkMaxNbSubfr {
+		x.nlsfInterpQ2 = rd.icdf(silkNLSFInterpICDF, 8)
+	}
+	if x.signalType == silkVoiced {
+		absolute := true
+		if cond == silkCodeConditionally && c.ecPrevSignalType == silkVoiced {
+			if delta := rd.icdf(silkPitchDeltaICDF, 8); delta > 0 {
+				x.lagIndex = c.ecPrevLagIndex + delta - 9
+				absolute = false
+			}
+		}
+		if absolute {
+			x.lagIndex = rd.icdf(silkPitchLagICDF, 8) * (c.fsKHz >> 1)
+			x.lagIndex += rd.icdf(c.lagLowBitsICDF, 8)
+		}
+		c.ecPrevLagIndex = x.lagIndex
+		x.contourIndex = rd.icdf(c.contourICDF, 8)
+		x.perIndex = rd.icdf(silkLTPPerIndexICDF, 8)
+		for k := 0; k < c.nbSubfr; k++ {
+			x.ltpIndex[k] = rd.icdf(silkLTPGainICDF[x.perIndex], 8)
+		}
+		x.ltpScaleIndex = 0
+		if cond == silkCodeIndependently {
+			x.ltpScaleIndex = rd.icdf(silkLTPScaleICDF, 8)
+		}
+	}
+	c.ecPrevSignalType = x.signalType
+	x.seed = rd.icdf(silkUniform4ICDF, 8)
+}
+
+// decodePulses decodes the excitation pulses of the frame into pulses.
+func (c *silkChannel) decodePulses(rd *rangeDecoder, pulses []int16) {
+	x := &c.indices
+	rateLevel := rd.icdf(silkRateLevelICDF[x.signalType>>1], 8)
+	iter := (c.frameLength + silkShellLength - 1) / silkShellLength
+	var sum, nLshifts [silkMaxFrameLength / silkShellLength]int
+	for i := 0; i < iter; i++ {
+		sum[i] = rd.icdf(silkPulsesPerBlockICDF[rateLevel][:], 8)
+		for sum[i] == silkMaxPulses+1 {
+			nLshifts[i]++
+			t := silkPulsesPerBlockICDF[9][:]
+			if nLshifts[i] == 10 {
+				t = t[1:]
+			}
+			sum[i] = rd.icdf(t, 8)
+		}
+	}
+	for i := 0; i < iter; i++ {
+		p := pulses[i*silkShellLength : (i+1)*silkShellLength]
+		if sum[i] > 0 {
+			shellDecode(rd, p, sum[i])
+		} else {
+			for k := range p {
+				p[k] = 0
+			}
+		}
+	}
+	for i := 0; i < iter; i++ {
+		if nLshifts[i] == 0 {
+			continue
+		}
+		p := pulses[i*silkShellLength : (i+1)*silkShellLength]
+		for k := range p {
+			q := int(p[k])
+			for j := 0; j < nLshifts[i]; j++ {
+				q = q<<1 + rd.icdf(silkLSBICDF, 8)
+			}
+			p[k] = int16(q)
+		}
+		sum[i] |= nLshifts[i] << 5
+	}
+	signs := silkSignICDF[7*(x.quantOffsetType+x.signalType<<1):]
+	icdf := []uint8{0, 0}
+	for i := 0; i < (c.frameLength+silkShellLength/2)/silkShellLength; i++ {
+		if sum[i] <= 0 {
+			continue
+		}
+		icdf[0] = signs[minInt(sum[i]&0x1f, 6)]
+		p := pulses[i*silkShellLength : (i+1)*silkShellLength]
+		for k := range p {
+			if p[k] > 0 {
+				p[k] *= int16(rd.icdf(icdf, 8)<<1 - 1)
+			}
+		}
+	}
+}
+
+// shellDecode decodes the n pulses of a shell block into p, by recursive
+// binary splits.
+func shellDecode(rd *rangeDecoder, p []int16, n int) {
+	split := func(p int, t int) (int, int) {
+		if p == 0 {
+			return 0, 0
+		}
+		c1 := rd.icdf(silkShellCodeTables[t][silkShellCodeOffsets[p]:], 8)
+		return c1, p - c1
+	}
+	var p3 [2]int
+	var p2 [4]int
+	var p1 [8]int
+	var p0 [16]int
+	p3[0], p3[1] = split(n, 3)
+	p2[0], p2[1] = split(p3[0], 2)
+	p1[0], p1[1] = split(p2[0], 1)
+	p0[0], p0[1] = split(p1[0], 0)
+	p0[2], p0[3] = split(p1[1], 0)
+	p1[2], p1[3] = split(p2[1], 1)
+	p0[4], p0[5] = split(p1[2], 0)
+	p0[6], p0[7] = split(p1[3], 0)
+	p2[2], p2[3] = split(p3[1], 2)
+	p1[4], p1[5] = split(p2[2], 1)
+	p0[8], p0[9] = split(p1[4], 0)
+	p0[10], p0[11] = split(p1[5], 0)
+	p1[6], p1[7] = split(p2[3], 1)
+	p0[12], p0[13] = split(p1[6], 0)
+	p0[14], p0[15] = split(p1[7], 0)
+	for i, v := range p0 {
+		p[i] = int16(v)
+	}
+}
+
+// unpack returns in ecIx and predQ8 the offsets in ecICDF of the
+// distributions of the residuals of first stage vector i, and their
+// prediction coefficients.
+func (cb *silkNLSFCodebook) unpack(ecIx []int, predQ8 []uint8, i int) {
+	sel := cb.ecSel[i*cb.order/2:]
+	for k := 0; k < cb.order; k += 2 {
+		e := int(sel[k/2])
+		ecIx[k] = (e >> 1 & 7) * 9
+		predQ8[k] = cb.predQ8[k+(e&1)*(cb.order-1)]
+		ecIx[k+1] = (e >> 5 & 7) * 9
+		predQ8[k+1] = cb.predQ8[k+(e>>4&1)*(cb.order-1)+1]
+	}
+}
+
+// decode returns in nlsf the frequencies of the indices x, in Q15.
+func (cb *silkNLSFCodebook) decode(nlsf []int16, x []int) {
+	var ecIx [silkMaxLPCOrder]int
+	var predQ8 [silkMaxLPCOrder]uint8
+	cb.unpack(ecIx[:], predQ8[:], x[0])
+	var resQ10 [silkMaxLPCOrder]int16
+	out := int32(0)
+	for i := cb.order - 1; i >= 0; i-- {
+		pred := smulbb(out, int32(predQ8[i])) >> 8
+		out = int32(x[i+1]) << 10
+		if out > 0 {
+			out -= 102
+		} else if out < 0 {
+			out += 102
+		}
+		out = smlawb(pred, out, int32(cb.stepQ16))
+		resQ10[i] = int16(out)
+	}
+	cb1 := cb.cb1Q8[x[0]*cb.order:]
+	w := cb.weightQ9[x[0]*cb.order:]
+	for i := 0; i < cb.order; i++ {
+		v := (int32(resQ10[i])<<14)/w[i] + int32(cb1[i])<<7
+		nlsf[i] = int16(limit32(v, 0, 32767))
+	}
+	nlsfStabilize(nlsf[:cb.order], cb.deltaMinQ15)
+}
+
+// decodeParameters decodes the parameters p of the frame from its
+// indices.
+func (c *silkChannel) decodeParameters(p *silkParams, cond int) {
+	x := &c.indices
+	prev := c.lastGainIndex
+	for k := 0; k < c.nbSubfr; k++ {
+		if k == 0 && cond != silkCodeConditionally {
+			prev = maxInt(x.gains[k], prev-16)
+		} else {
+			t := x.gains[k] - 4
+			thr := 2*36 - 64 + prev
+			if t > thr {
+				prev += t<<1 - thr
+			} else {
+				prev += t
+			}
+		}
+		prev = minInt(maxInt(prev, 0), 63)
+		const invScaleQ16 = (65536 * ((88 - 2) * 128 / 6)) / 63
+		const offset = 2*128/6 + 16*128
+		p.gainsQ16[k] = log2lin(minInt32(smulwb(invScaleQ16, int32(prev))+offset, 3967))
+	}
+	c.lastGainIndex = prev
+	order := c.lpcOrder
+	var nlsf, nlsf0 [silkMaxLPCOrder]int16
+	c.cb.decode(nlsf[:], x.nlsf[:])
+	nlsf2a(p.predCoefQ12[1][:order], nlsf[:order])
+	if c.firstFrameAfterReset {
+		x.nlsfInterpQ2 = 4
+	}
+	if x.nlsfInterpQ2 < 4 {
+		for i := 0; i < order; i++ {
+			nlsf0[i] = c.prevNLSFQ15[i] + int16((int32(x.nlsfInterpQ2)*(int32(nlsf[i])-int32(c.prevNLSFQ15[i])))>>2)
+		}
+		nlsf2a(p.predCoefQ12[0][:order], nlsf0[:order])
+	} else {
+		p.predCoefQ12[0] = p.predCoefQ12[1]
+	}
+	c.prevNLSFQ15 = nlsf
+	if c.lossCnt != 0 {
+		bwexpander(p.predCoefQ12[0][:order], 63570)
+		bwexpander(p.predCoefQ12[1][:order], 63570)
+	}
+	if x.signalType != silkVoiced {
+		p.pitchL = [silkMaxNbSubfr]int{}
+		p.ltpCoefQ14 = [len(p.ltpCoefQ14)]int16{}
+		x.perIndex = 0
+		p.ltpScaleQ14 = 0
+		return
+	}
+	c.decodePitch(p.pitchL[:c.nbSubfr])
+	cbk := silkLTPFilterQ7[x.perIndex]
+	for k := 0; k < c.nbSubfr; k++ {
+		for i := 0; i < silkLTPOrder; i++ {
+			p.ltpCoefQ14[k*silkLTPOrder+i] = int16(cbk[x.ltpIndex[k]][i]) << 7
+		}
+	}
+	p.ltpScaleQ14 = silkLTPScalesQ14[x.ltpScaleIndex]
+}
+
+// decodePitch returns in pitchL the pitch lags of the subframes.
+func (c *silkChannel) decodePitch(pitchL []int) {
+	x := &c.indices
+	minLag, maxLag := 2*c.fsKHz, 18*c.fsKHz
+	lag := minLag + x.lagIndex
+	for k := range pitchL {
+		var d int8
+		switch {
+		case c.fsKHz == 8 && len(pitchL) == silkMaxNbSubfr:
+			d = silkPitchLagsNB[k][x.contourIndex]
+		case c.fsKHz == 8:
+			d = silkPitchLags10msNB[k][x.contourIndex]
+		case len(pitchL) == silkMaxNbSubfr:
+			d = silkPitchLags[k][x.contourIndex]
+		default:
+			d = silkPitchLags10ms[k][x.contourIndex]
+		}
+		pitchL[k] = minInt(maxInt(lag+int(d), minLag), maxLag)
+	}
+}
+
+// decodeCore synthesizes the frame from its excitation pulses and
+// parameters p into xq.
+func (c *silkChannel) decodeCore(p *silkParams, xq []int16, pulses []int16) {
+	x := &c.indices
+	offsetQ10 := silkQuantOffsetsQ10[x.signalType>>1][x.quantOffsetType]
+	interp := x.nlsfInterpQ2 < 4
+	seed := int32(x.seed)
+	for i := 0; i < c.frameLength; i++ {
+		seed = silkRand(seed)
+		e := int32(pulses[i]) << 14
+		if e > 0 {
+			e -= 80 << 4
+		} else if e < 0 {
+			e += 80 << 4
+		}
+		e += offsetQ10 << 4
+		if seed < 0 {
+			e = -e
+		}
+		c.excQ14[i] = e
+		seed += int32(pulses[i])
+	}
+	var sLTP [silkMaxLTPMemLength]int16
+	var sLTPQ15 [silkMaxLTPMemLength + silkMaxFrameLength]int32
+	var sLPC [silkMaxSubfrLength + silkMaxLPCOrder]int32
+	var resQ14 [silkMaxSubfrLength]int32
+	copy(sLPC[:], c.sLPCQ14[:])
+	bufIdx := c.ltpMemLength
+	lag := 0
+	order := c.lpcOrder
+	for k := 0; k < c.nbSubfr; k++ {
+		exc := c.excQ14[k*c.subfrLength : (k+1)*c.subfrLength]
+		a := p.predCoefQ12[k>>1][:order]
+		b := p.ltpCoefQ14[k*silkLTPOrder : (k+1)*silkLTPOrder]
+		signalType := x.signalType
+		gainQ10 := p.gainsQ16[k] >> 6
+		invGainQ31 := inverse32VarQ(p.gainsQ16[k], 47)
+		gainAdjQ16 := int32(1 << 16)
+		if p.gainsQ16[k] != c.prevGainQ16 {
+			gainAdjQ16 = div32VarQ(c.prevGainQ16, p.gainsQ16[k], 16)
+			for i := 0; i < silkMaxLPCOrder; i++ {
+				sLPC[i] = smulww(gainAdjQ16, sLPC[i])
+			}
+		}
+		c.prevGainQ16 = p.gainsQ16[k]
+		if c.lossCnt != 0 && c.prevSignalType == silkVoiced && x.signalType != silkVoiced && k < silkMaxNbSubfr/2 {
+			for i := range b {
+				b[i] = 0
+			}
+			b[silkLTPOrder/2] = 1 << 12 // 0.25 in Q14
+			signalType = silkVoiced
+			p.pitchL[k] = c.lagPrev
+		}
+		res := exc
+		if signalType == silkVoiced {
+			lag = p.pitchL[k]
+			if k == 0 || (k == 2 && interp) {
+				start := c.ltpMemLength - lag - order - silkLTPOrder/2
+				if k == 2 {
+					copy(c.outBuf[c.ltpMemLength:], xq[:2*c.subfrLength])
+				}
+				lpcAnalysisFilter(sLTP[start:c.ltpMemLength], c.outBuf[start+k*c.subfrLength:start+k*c.subfrLength+c.ltpMemLength-start], a)
+				if k == 0 {
+					invGainQ31 = smulwb(invGainQ31, p.ltpScaleQ14) << 2
+				}
+				for i := 0; i < lag+silkLTPOrder/2; i++ {
+					sLTPQ15[bufIdx-i-1] = smulwb(invGainQ31, int32(sLTP[c.ltpMemLength-i-1]))
+				}
+			} else if gainAdjQ16 != 1<<16 {
+				for i := 0; i < lag+silkLTPOrder/2; i++ {
+					sLTPQ15[bufIdx-i-1] = smulww(gainAdjQ16, sLTPQ15[bufIdx-i-1])
+				}
+			}
+			res = resQ14[:c.subfrLength]
+			j := bufIdx - lag + silkLTPOrder/2
+			for i := range res {
+				pred := int32(2)
+				for t := 0; t < silkLTPOrder; t++ {
+					pred = smlawb(pred, sLTPQ15[j+i-t], int32(b[t]))
+				}
+				res[i] = exc[i] + pred<<1
+				sLTPQ15[bufIdx] = res[i] << 1
+				bufIdx++
+			}
+		}
+		out := xq[k*c.subfrLength : (k+1)*c.subfrLength]
+		lpcSynthesize(sLPC[:silkMaxLPCOrder+c.subfrLength], res, a, func(i int, s int32) {
+			out[i] = int16(sat16(rshiftRound(smulww(s, gainQ10), 8)))
+		})
+		copy(sLPC[:silkMaxLPCOrder], sLPC[c.subfrLength:c.subfrLength+silkMaxLPCOrder])
+	}
+	copy(c.sLPCQ14[:], sLPC[:silkMaxLPCOrder])
+}
+
+// lpcSynthesize filters the residual res by the LPC synthesis filter a
+// in Q12 into s[silkMaxLPCOrder:], whose first silkMaxLPCOrder values are
+// the filter state, calling out with each result.  res may alias
+// s[silkMaxLPCOrder:].
+func lpcSynthesize(s, res []int32, a []int16, out func(i int, s int32)) {
+	for i := range res {
+		pred := int32(len(a) >> 1)
+		for j, c := range a {
+			pred = smlawb(pred, s[silkMaxLPCOrder+i-j-1], int32(c))
+		}
+		s[silkMaxLPCOrder+i] = addSat32(res[i], lshiftSat32(pred, 4))
+		out(i, s[silkMaxLPCOrder+i])
+	}
+}
+
+// silkStereoDecodePred decodes the mid-side prediction weights.
+func silkStereoDecodePred(rd *rangeDecoder, predQ13 *[2]int32) {
+	var ix [2][3]int
+	n := rd.icdf(silkStereoPredJointICDF, 8)
+	ix[0][2] = n / 5
+	ix[1][2] = n - 5*ix[0][2]
+	for n := 0; n < 2; n++ {
+		ix[n][0] = rd.icdf(silkUniform3ICDF, 8)
+		ix[n][1] = rd.icdf(silkUniform5ICDF, 8)
+	}
+	for n := 0; n < 2; n++ {
+		ix[n][0] += 3 * ix[n][2]
+		low := silkStereoPredQ13[ix[n][0]]
+		step := smulwb(silkStereoPredQ13[ix[n][0]+1]-low, 6554) // 0.1 in Q16
+		predQ13[n] = smlabb(low, step, int32(2*ix[n][1]+1))
+	}
+	predQ13[0] -= predQ13[1]
+}
+
+// msToLR converts the mid and side signals x1 and x2, each with 2
+// samples of history, to left and right, in place.
+func (st *silkStereo) msToLR(x1, x2 []int16, predQ13 *[2]int32, fsKHz int) {
+	n := len(x1) - 2
+	copy(x1[:2], st.sMid[:])
+	copy(x2[:2], st.sSide[:])
+	copy(st.sMid[:], x1[n:])
+	copy(st.sSide[:], x2[n:])
+	pred0, pred1 := st.predPrevQ13[0], st.predPrevQ13[1]
+	denomQ16 := int32((1 << 16) / (8 * fsKHz))
+	delta0 := rshiftRound(smulbb(predQ13[0]-st.predPrevQ13[0], denomQ16), 16)
+	delta1 := rshiftRound(smulbb(predQ13[1]-st.predPrevQ13[1], denomQ16), 16)
+	for i := 0; i < n; i++ {
+		if i < 8*fsKHz {
+			pred0 += delta0
+			pred1 += delta1
+		} else {
+			pred0, pred1 = predQ13[0], predQ13[1]
+		}
+		sum := (int32(x1[i]) + int32(x1[i+2]) + int32(x1[i+1])<<1) << 9
+		sum = smlawb(int32(x2[i+1])<<8, sum, pred0)
+		sum = smlawb(sum, int32(x1[i+1])<<11, pred1)
+		x2[i+1] = int16(sat16(rshiftRound(sum, 8)))
+	}
+	st.predPrevQ13 = *predQ13
+	for i := 0; i < n; i++ {
+		sum := int32(x1[i+1]) + int32(x2[i+1])
+		diff := int32(x1[i+1]) - int32(x2[i+1])
+		x1[i+1] = int16(sat16(sum))
+		x2[i+1] = int16(sat16(diff))
+	}
+}
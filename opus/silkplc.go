@@ -0,0 +1,323 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+// silkPLC is the state of the concealment of lost SILK frames.
+type silkPLC struct {
+	pitchLQ8        int32
+	ltpCoefQ14      [silkLTPOrder]int16
+	prevLPCQ12      [silkMaxLPCOrder]int16
+	lastFrameLost   bool
+	randSeed        int32
+	randScaleQ14    int16
+	concEnergy      int32
+	concEnergyShift int
+	prevLTPScaleQ14 int32
+	prevGainQ16     [2]int32
+	fsKHz           int
+	nbSubfr         int
+	subfrLength     int
+}
+
+// silkCNG is the state of the comfort noise generation.
+type silkCNG struct {
+	excBufQ14   [silkMaxFrameLength]int32
+	smthNLSFQ15 [silkMaxLPCOrder]int16
+	synthState  [silkMaxLPCOrder]int32
+	smthGainQ16 int32
+	randSeed    int32
+	fsKHz       int
+}
+
+// Constants of the concealment.
+var (
+	plcHarmAttQ15   = [2]int32{32440, 31130}
+	plcRandAttVQ15  = [2]int32{31130, 26214}
+	plcRandAttUVQ15 = [2]int32{32440, 29491}
+)
+
+const (
+	plcPitchGainStartMinQ14 = 11469
+	plcPitchGainStartMaxQ14 = 15565
+	plcRandBufSize          = 128
+)
+
+func (c *silkChannel) plcReset() {
+	c.plc.pitchLQ8 = int32(c.frameLength) << 7
+	c.plc.prevGainQ16 = [2]int32{1 << 16, 1 << 16}
+	c.plc.subfrLength = 20
+	c.plc.nbSubfr = 2
+}
+
+// plcFrame conceals into frame the lost frame, or updates the state of the
+// concealment from the parameters p of a received one.
+func (c *silkChannel) plcFrame(p *silkParams, frame []int16, lost bool) {
+	if c.fsKHz != c.plc.fsKHz {
+		c.plcReset()
+		c.plc.fsKHz = c.fsKHz
+	}
+	if lost {
+		c.plcConceal(p, frame)
+		c.lossCnt++
+		return
+	}
+	c.plcUpdate(p)
+}
+
+func (c *silkChannel) plcUpdate(p *silkParams) {
+	s := &c.plc
+	c.prevSignalType = c.indices.signalType
+	ltpGainQ14 := int32(0)
+	if c.indices.signalType == silkVoiced {
+		last := c.nbSubfr - 1
+		for j := 0; j*c.subfrLength < p.pitchL[last] && j < c.nbSubfr; j++ {
+			b := p.ltpCoefQ14[(last-j)*silkLTPOrder : (last-j+1)*silkLTPOrder]
+			g := int32(0)
+			for _, v := range b {
+				g += int32(v)
+			}
+			if g > ltpGainQ14 {
+				ltpGainQ14 = g
+				copy(s.ltpCoefQ14[:], b)
+				s.pitchLQ8 = int32(p.pitchL[last-j]) << 8
+			}
+		}
+		s.ltpCoefQ14 = [silkLTPOrder]int16{}
+		s.ltpCoefQ14[silkLTPOrder/2] = int16(ltpGainQ14)
+		if ltpGainQ14 < plcPitchGainStartMinQ14 {
+			scaleQ10 := int32(plcPitchGainStartMinQ14<<10) / maxInt32(ltpGainQ14, 1)
+			for i := range s.ltpCoefQ14 {
+				s.ltpCoefQ14[i] = int16(smulbb(int32(s.ltpCoefQ14[i]), scaleQ10) >> 10)
+			}
+		} else if ltpGainQ14 > plcPitchGainStartMaxQ14 {
+			scaleQ14 := int32(plcPitchGainStartMaxQ14<<14) / maxInt32(ltpGainQ14, 1)
+			for i := range s.ltpCoefQ14 {
+				s.ltpCoefQ14[i] = int16(smulbb(int32(s.ltpCoefQ14[i]), scaleQ14) >> 14)
+			}
+		}
+	} else {
+		s.pitchLQ8 = int32(c.fsKHz*18) << 8
+		s.ltpCoefQ14 = [silkLTPOrder]int16{}
+	}
+	copy(s.prevLPCQ12[:], p.predCoefQ12[1][:c.lpcOrder])
+	s.prevLTPScaleQ14 = p.ltpScaleQ14
+	copy(s.prevGainQ16[:], p.gainsQ16[c.nbSubfr-2:c.nbSubfr])
+	s.subfrLength = c.subfrLength
+	s.nbSubfr = c.nbSubfr
+}
+
+// plcEnergy returns the energies, and their shifts, of the excitation of
+// the last two subframes, scaled by the gains prevGainQ10.
+func (c *silkChannel) plcEnergy(prevGainQ10 *[2]int32) (e1 int32, s1 int, e2 int32, s2 int) {
+	var buf [2 * silkMaxSubfrLength]int16
+	l := c.subfrLength
+	for k := 0; k < 2; k++ {
+		exc := c.excQ14[(k+c.nbSubfr-2)*l:]
+		for i := 0; i < l; i++ {
+			buf[k*l+i] = int16(sat16(smulww(exc[i], prevGainQ10[k]) >> 8))
+		}
+	}
+	e1, s1 = sumSqrShift(buf[:l])
+	e2, s2 = sumSqrShift(buf[l : 2*l])
+	return e1, s1, e2, s2
+}
+
+func (c *silkChannel) plcConceal(p *silkParams, frame []int16) {
+	s := &c.plc
+	var sLTP [silkMaxLTPMemLength]int16
+	var sLTPQ14 [silkMaxLTPMemLength + silkMaxFrameLength]int32
+	prevGainQ10 := [2]int32{s.prevGainQ16[0] >> 6, s.prevGainQ16[1] >> 6}
+	if c.firstFrameAfterReset {
+		s.prevLPCQ12 = [silkMaxLPCOrder]int16{}
+	}
+	e1, s1, e2, s2 := c.plcEnergy(&prevGainQ10)
+	var randBuf []int32
+	if e1>>uint(s2) < e2>>uint(s1) {
+		randBuf = c.excQ14[maxInt(0, (s.nbSubfr-1)*s.subfrLength-plcRandBufSize):]
+	} else {
+		randBuf = c.excQ14[maxInt(0, s.nbSubfr*s.subfrLength-plcRandBufSize):]
+	}
+	b := &s.ltpCoefQ14
+	randScaleQ14 := s.randScaleQ14
+	att := minInt(1, c.lossCnt)
+	harmGainQ15 := plcHarmAttQ15[att]
+	randGainQ15 := plcRandAttUVQ15[att]
+	if c.prevSignalType == silkVoiced {
+		randGainQ15 = plcRandAttVQ15[att]
+	}
+	order := c.lpcOrder
+	bwexpander(s.prevLPCQ12[:order], 64881) // 0.99 in Q16
+	a := s.prevLPCQ12[:order]
+	if c.lossCnt == 0 {
+		randScaleQ14 = 1 << 14
+		if c.prevSignalType == silkVoiced {
+			for _, v := range b {
+				randScaleQ14 -= v
+			}
+			if randScaleQ14 < 3277 {
+				randScaleQ14 = 3277
+			}
+			randScaleQ14 = int16(smulbb(int32(randScaleQ14), s.prevLTPScaleQ14) >> 14)
+		} else {
+			invGainQ30 := lpcInversePredGain(a)
+			down := minInt32(1<<30>>3, invGainQ30)
+			down = maxInt32(1<<30>>8, down) << 3
+			randGainQ15 = smulwb(down, randGainQ15) >> 14
+		}
+	}
+	seed := s.randSeed
+	lag := int(rshiftRound(s.pitchLQ8, 8))
+	bufIdx := c.ltpMemLength
+	idx := c.ltpMemLength - lag - order - silkLTPOrder/2
+	lpcAnalysisFilter(sLTP[idx:c.ltpMemLength], c.outBuf[idx:c.ltpMemLength], a)
+	invGainQ30 := minInt32(inverse32VarQ(s.prevGainQ16[1], 46), (1<<31-1)>>1)
+	for i := idx + order; i < c.ltpMemLength; i++ {
+		sLTPQ14[i] = smulwb(invGainQ30, int32(sLTP[i]))
+	}
+	for k := 0; k < c.nbSubfr; k++ {
+		j := bufIdx - lag + silkLTPOrder/2
+		for i := 0; i < c.subfrLength; i++ {
+			pred := int32(2)
+			for t := 0; t < silkLTPOrder; t++ {
+				pred = smlawb(pred, sLTPQ14[j+i-t], int32(b[t]))
+			}
+			seed = silkRand(seed)
+			r := seed >> 25 & (plcRandBufSize - 1)
+			sLTPQ14[bufIdx] = smlawb(pred, randBuf[r], int32(randScaleQ14)) << 2
+			bufIdx++
+		}
+		for t := range b {
+			b[t] = int16(smulbb(harmGainQ15, int32(b[t])) >> 15)
+		}
+		randScaleQ14 = int16(smulbb(int32(randScaleQ14), randGainQ15) >> 15)
+		s.pitchLQ8 = smlawb(s.pitchLQ8, s.pitchLQ8, 655)
+		s.pitchLQ8 = minInt32(s.pitchLQ8, int32(18*c.fsKHz)<<8)
+		lag = int(rshiftRound(s.pitchLQ8, 8))
+	}
+	sLPC := sLTPQ14[c.ltpMemLength-silkMaxLPCOrder : c.ltpMemLength+c.frameLength]
+	copy(sLPC, c.sLPCQ14[:])
+	lpcSynthesize(sLPC, sLPC[silkMaxLPCOrder:], a, func(i int, v int32) {
+		frame[i] = int16(sat16(rshiftRound(smulww(v, prevGainQ10[1]), 8)))
+	})
+	copy(c.sLPCQ14[:], sLPC[c.frameLength:])
+	s.randSeed = seed
+	s.randScaleQ14 = randScaleQ14
+	for i := range p.pitchL {
+		p.pitchL[i] = lag
+	}
+}
+
+// glueFrames smooths the transition from concealed frames to the
+// received frame.
+func (c *silkChannel) glueFrames(frame []int16) {
+	s := &c.plc
+	if c.lossCnt != 0 {
+		s.concEnergy, s.concEnergyShift = sumSqrShift(frame)
+		s.lastFrameLost = true
+		return
+	}
+	if s.lastFrameLost {
+		energy, shift := sumSqrShift(frame)
+		if shift > s.concEnergyShift {
+			s.concEnergy >>= uint(shift - s.concEnergyShift)
+		} else if shift < s.concEnergyShift {
+			energy >>= uint(s.concEnergyShift - shift)
+		}
+		if energy > s.concEnergy {
+			lz := clz32(s.concEnergy) - 1
+			s.concEnergy <<= uint(lz)
+			energy >>= uint(maxInt(24-lz, 0))
+			fracQ24 := s.concEnergy / maxInt32(energy, 1)
+			gainQ16 := sqrtApprox(fracQ24) << 4
+			slopeQ16 := ((1<<16 - gainQ16) / int32(len(frame))) << 2
+			for i := range frame {
+				frame[i] = int16(smulwb(gainQ16, int32(frame[i])))
+				gainQ16 += slopeQ16
+				if gainQ16 > 1<<16 {
+					break
+				}
+			}
+		}
+	}
+	s.lastFrameLost = false
+}
+
+func (c *silkChannel) cngReset() {
+	step := int32(1<<15-1) / int32(c.lpcOrder+1)
+	acc := int32(0)
+	for i := 0; i < c.lpcOrder; i++ {
+		acc += step
+		c.cng.smthNLSFQ15[i] = int16(acc)
+	}
+	c.cng.smthGainQ16 = 0
+	c.cng.randSeed = 3176576
+}
+
+// cngApply updates the state of the comfort noise generation from the
+// parameters p of a received frame, or adds comfort noise to the
+// concealed frame.
+func (c *silkChannel) cngApply(p *silkParams, frame []int16) {
+	s := &c.cng
+	if c.fsKHz != s.fsKHz {
+		c.cngReset()
+		s.fsKHz = c.fsKHz
+	}
+	order := c.lpcOrder
+	if c.lossCnt == 0 && c.prevSignalType == silkNoVoiceActivity {
+		for i := 0; i < order; i++ {
+			s.smthNLSFQ15[i] += int16(smulwb(int32(c.prevNLSFQ15[i])-int32(s.smthNLSFQ15[i]), 16348))
+		}
+		maxGain, subfr := int32(0), 0
+		for i := 0; i < c.nbSubfr; i++ {
+			if p.gainsQ16[i] > maxGain {
+				maxGain, subfr = p.gainsQ16[i], i
+			}
+		}
+		l := c.subfrLength
+		copy(s.excBufQ14[l:c.nbSubfr*l], s.excBufQ14[:(c.nbSubfr-1)*l])
+		copy(s.excBufQ14[:l], c.excQ14[subfr*l:(subfr+1)*l])
+		for i := 0; i < c.nbSubfr; i++ {
+			s.smthGainQ16 += smulwb(p.gainsQ16[i]-s.smthGainQ16, 4634)
+			if smulww(s.smthGainQ16, 46396) > p.gainsQ16[i] {
+				s.smthGainQ16 = p.gainsQ16[i]
+			}
+		}
+	}
+	if c.lossCnt == 0 {
+		for i := 0; i < order; i++ {
+			s.synthState[i] = 0
+		}
+		return
+	}
+	gainQ16 := smulww(int32(c.plc.randScaleQ14), c.plc.prevGainQ16[1])
+	if gainQ16 >= 1<<21 || s.smthGainQ16 > 1<<23 {
+		gainQ16 = smultt(gainQ16, gainQ16)
+		gainQ16 = smultt(s.smthGainQ16, s.smthGainQ16) - gainQ16<<5
+		gainQ16 = sqrtApprox(gainQ16) << 16
+	} else {
+		gainQ16 = smulww(gainQ16, gainQ16)
+		gainQ16 = smulww(s.smthGainQ16, s.smthGainQ16) - gainQ16<<5
+		gainQ16 = sqrtApprox(gainQ16) << 8
+	}
+	gainQ10 := gainQ16 >> 6
+	var sig [silkMaxFrameLength + silkMaxLPCOrder]int32
+	mask := int32(255)
+	for int(mask) > len(frame) {
+		mask >>= 1
+	}
+	seed := s.randSeed
+	for i := range frame {
+		seed = silkRand(seed)
+		sig[silkMaxLPCOrder+i] = s.excBufQ14[seed>>24&mask]
+	}
+	s.randSeed = seed
+	var a [silkMaxLPCOrder]int16
+	nlsf2a(a[:order], s.smthNLSFQ15[:order])
+	copy(sig[:], s.synthState[:])
+	n := len(frame)
+	lpcSynthesize(sig[:silkMaxLPCOrder+n], sig[silkMaxLPCOrder:silkMaxLPCOrder+n], a[:order], func(i int, v int32) {
+		frame[i] = int16(sat16(int32(frame[i]) + sat16(rshiftRound(smulww(v, gainQ10), 8))))
+	})
+	copy(s.synthState[:], sig[n:n+silkMaxLPCOrder])
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package opus
+
+// silkResampler upsamples the output of the SILK decoder from 8, 12 or
+// 16kHz to 48kHz, by a 2x allpass interpolator followed by a fractional
+// FIR interpolator, as the reference implementation does.
+type silkResampler struct {
+	sIIR        [6]int32
+	sFIR        [8]int16
+	delayBuf    [16]int16
+	fsInKHz     int
+	inputDelay  int
+	batchSize   int
+	invRatioQ16 int32
+}
+
+// Coefficients of the allpass sections of the two phases of the 2x
+// interpolator, in Q16.
+var (
+	silkUp2HQ0 = [3]int32{1746, 14986, 39083 - 65536}
+	silkUp2HQ1 = [3]int32{6854, 25769, 55542 - 65536}
+)
+
+func (r *silkResampler) init(fsInKHz int) {
+	*r = silkResampler{fsInKHz: fsInKHz, batchSize: 10 * fsInKHz}
+	switch fsInKHz {
+	case 12:
+		r.inputDelay = 4
+	case 16:
+		r.inputDelay = 7
+	}
+	in, out := int32(fsInKHz*1000), int32(48000)
+	r.invRatioQ16 = (in << 15 / out) << 2
+	for smulww(r.invRatioQ16, out) < in<<1 {
+		r.invRatioQ16++
+	}
+}
+
+// resample resamples in, of a multiple of 1ms, into out.
+func (r *silkResampler) resample(out, in []int16) {
+	n := r.fsInKHz - r.inputDelay
+	copy(r.delayBuf[r.inputDelay:r.fsInKHz], in[:n])
+	r.iirFIR(out, r.delayBuf[:r.fsInKHz])
+	r.iirFIR(out[48:], in[n:len(in)-r.inputDelay])
+	copy(r.delayBuf[:r.inputDelay], in[len(in)-r.inputDelay:])
+}
+
+func (r *silkResampler) iirFIR(out, in []int16) {
+	var buf [2*10*16 + 8]int16
+	copy(buf[:8], r.sFIR[:])
+	nIn := 0
+	for {
+		nIn = minInt(len(in), r.batchSize)
+		r.up2HQ(buf[8:], in[:nIn])
+		maxIndexQ16 := int32(nIn) << 17
+		for idx := int32(0); idx < maxIndexQ16; idx += r.invRatioQ16 {
+			t := smulwb(idx&0xffff, 12)
+			p := buf[idx>>16:]
+			f0, f1 := &silkResamplerFracFIR[t], &silkResamplerFracFIR[11-t]
+			res := smulbb(int32(p[0]), f0[0])
+			res = smlabb(res, int32(p[1]), f0[1])
+			res = smlabb(res, int32(p[2]), f0[2])
+			res = smlabb(res, int32(p[3]), f0[3])
+			res = smlabb(res, int32(p[4]), f1[3])
+			res = smlabb(res, int32(p[5]), f1[2])
+			res = smlabb(res, int32(p[6]), f1[1])
+			res = smlabb(res, int32(p[7]), f1[0])
+			out[0] = int16(sat16(rshiftRound(res, 15)))
+			out = out[1:]
+		}
+		in = in[nIn:]
+		if len(in) == 0 {
+			break
+		}
+		copy(buf[:8], buf[nIn<<1:nIn<<1+8])
+	}
+	copy(r.sFIR[:], buf[nIn<<1:nIn<<1+8])
+}
+
+// up2HQ upsamples in by 2 into out.
+func (r *silkResampler) up2HQ(out, in []int16) {
+	s := &r.sIIR
+	for k, v := range in {
+		in32 := int32(v) << 10
+		y := in32 - s[0]
+		x := smulwb(y, silkUp2HQ0[0])
+		o1 := s[0] + x
+		s[0] = in32 + x
+		y = o1 - s[1]
+		x = smulwb(y, silkUp2HQ0[1])
+		o2 := s[1] + x
+		s[1] = o1 + x
+		y = o2 - s[2]
+		x = smlawb(y, y, silkUp2HQ0[2])
+		o1 = s[2] + x
+		s[2] = o2 + x
+		out[2*k] = int16(sat16(rshiftRound(o1, 10)))
+
+		y = in32 - s[3]
+		x = smulwb(y, silkUp2HQ1[0])
+		o1 = s[3] + x
+		s[3] = in32 + x
+		y = o1 - s[4]
+		x = smulwb(y, silkUp2HQ1[1])
+		o2 = s[4] + x
+		s[4] = o1 + x
+		y = o2 - s[5]
+		x = smlawb(y, y, silkUp2HQ1[2])
+		o1 = s[5] + x
+		s[5] = o2 + x
+		out[2*k+1] = int16(sat16(rshiftRound(o1, 10)))
+	}
+}
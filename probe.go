@@ -0,0 +1,58 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codec
+
+import (
+	"io"
+	"time"
+
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// StreamInfo summarizes an encoded sound stream without decoding it.
+type StreamInfo struct {
+	// Container names the container format, such as "wav".
+	Container string
+
+	// Channels is the number of channels in the stream.
+	Channels int
+
+	// SampleRate is the sample rate of the stream.
+	SampleRate freq.T
+
+	// SampleCodec is the sample codec of the stream, or AnySampleCodec if the
+	// stream does not have a single defined sample codec.
+	SampleCodec sample.Codec
+
+	// Frames is the number of frames in the stream, or -1 if unknown.
+	Frames int64
+}
+
+// Duration returns the duration of the stream, or -1 if the number of frames
+// is unknown.
+func (s *StreamInfo) Duration() time.Duration {
+	if s.Frames < 0 || s.SampleRate <= 0 {
+		return -1
+	}
+	return time.Duration(s.Frames) * s.SampleRate.Period()
+}
+
+// Prober is an optional interface which a Codec may implement to
+// supply a StreamInfo without constructing a decoder.
+type Prober interface {
+	// Probe reads only as much of r as is needed to describe the stream.
+	// r is positioned at the start of the encoded data, which need not
+	// be offset 0.
+	Probe(r io.ReadSeeker) (*StreamInfo, error)
+}
+
+// Probe tries to describe the stream in r without decoding it.
+//
+// The function pkgSel selects codecs as in Decoder.  Probe returns
+// ErrUnknownCodec if no codec recognizes r, and ErrUnsupportedFunction
+// if the codec which recognizes r does not implement Prober.
+func Probe(r io.ReadSeeker, pkgSel func(string) bool) (*StreamInfo, error) {
+	return defaultRegistry.Probe(r, pkgSel)
+}
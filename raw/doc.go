@@ -0,0 +1,25 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package raw provides codecs for headerless PCM data, whose form and
+// sample codec are known to the caller rather than stored with the data.
+//
+// A raw codec is obtained in one of three ways:
+//
+//   - programmatically, with New;
+//   - by extension, where codec.CodecFor selects the sample codec from
+//     extensions such as ".s16le" or ".f32be", and ".raw" and ".pcm" give
+//     16 bit little endian samples;
+//   - by MIME type, where codec.CodecForMIME handles "audio/L16" and
+//     "audio/L24", configured by parameters such as
+//     "audio/L16;rate=48000;channels=2".
+//
+// The codecs found by extension and MIME type are mono at 44.1kHz until
+// configured by their WithMIMEParams method, which takes the "rate" and
+// "channels" parameters.
+//
+// Importing package raw registers its codecs with zikichombo.org/codec.
+// Since headerless data cannot be recognized, they are never sniffed.
+//
+// Package raw is part of http://zikichombo.org
+package raw /* import "zikichombo.org/codec/raw" */
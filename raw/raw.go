@@ -0,0 +1,193 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package raw
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// registered lists the codecs registered with package codec.
+var registered = []*rawCodec{
+	{sc: sample.SInt16L, exts: []string{".raw", ".pcm", ".s16le"}},
+	{sc: sample.SInt16B, exts: []string{".s16be"}, mimeTypes: []string{"audio/L16"}},
+	{sc: sample.SInt24L, exts: []string{".s24le"}},
+	{sc: sample.SInt24B, exts: []string{".s24be"}, mimeTypes: []string{"audio/L24"}},
+	{sc: sample.SInt32L, exts: []string{".s32le"}},
+	{sc: sample.SInt32B, exts: []string{".s32be"}},
+	{sc: sample.SFloat32L, exts: []string{".f32le"}},
+	{sc: sample.SFloat32B, exts: []string{".f32be"}},
+	{sc: sample.SFloat64L, exts: []string{".f64le"}},
+	{sc: sample.SFloat64B, exts: []string{".f64be"}},
+	{sc: sample.SByte, exts: []string{".s8"}}}
+
+func init() {
+	for _, c := range registered {
+		c.rate, c.channels = 44100*freq.Hertz, 1
+		codec.RegisterCodec(c)
+	}
+}
+
+// rawCodec is a codec for headerless data of a given form and sample
+// codec.  Registered codecs are pointers, so that they are comparable.
+type rawCodec struct {
+	rate      freq.T
+	channels  int
+	sc        sample.Codec
+	exts      []string
+	mimeTypes []string
+}
+
+var (
+	_ codec.Describer         = (*rawCodec)(nil)
+	_ codec.MIMEParameterizer = (*rawCodec)(nil)
+)
+
+// New returns a codec for headerless data of form v with sample codec sc.
+// It claims no extensions or MIME types, and is not registered.
+func New(v sound.Form, sc sample.Codec) codec.Codec {
+	return &rawCodec{rate: v.SampleRate(), channels: v.Channels(), sc: sc}
+}
+
+// sampleCodecs lists the sample codecs which can be encoded.
+var sampleCodecs = []sample.Codec{
+	sample.SByte,
+	sample.SInt16L, sample.SInt16B,
+	sample.SInt24L, sample.SInt24B,
+	sample.SInt32L, sample.SInt32B,
+	sample.SFloat32L, sample.SFloat32B,
+	sample.SFloat64L, sample.SFloat64B}
+
+// Describe implements codec.Describer.  Decoding uses the form and sample
+// codec of c, whereas encoding and random access use those given.
+func (c *rawCodec) Describe() codec.Description {
+	return codec.Description{
+		Name:         "raw",
+		MIMETypes:    c.mimeTypes,
+		Capabilities: codec.CanDecode | codec.CanSeek | codec.CanEncode | codec.CanRandomAccess,
+		SampleCodecs: sampleCodecs}
+}
+
+// WithMIMEParams implements codec.MIMEParameterizer, returning a copy of c
+// with the sample rate of the "rate" parameter, in Hz, and the number of
+// channels of the "channels" parameter.  Absent parameters are unchanged.
+func (c *rawCodec) WithMIMEParams(params map[string]string) (codec.Codec, error) {
+	res := *c
+	if s, ok := params["rate"]; ok {
+		rate, err := strconv.ParseUint(s, 10, 32)
+		if err != nil || rate == 0 {
+			return nil, fmt.Errorf("raw: invalid rate %q", s)
+		}
+		res.rate = freq.T(rate) * freq.Hertz
+	}
+	if s, ok := params["channels"]; ok {
+		channels, err := strconv.ParseUint(s, 10, 16)
+		if err != nil || channels == 0 {
+			return nil, fmt.Errorf("raw: invalid channels %q", s)
+		}
+		res.channels = int(channels)
+	}
+	return &res, nil
+}
+
+func (c *rawCodec) SampleRate() freq.T {
+	return c.rate
+}
+
+func (c *rawCodec) Channels() int {
+	return c.channels
+}
+
+func (c *rawCodec) Extensions() []string {
+	return c.exts
+}
+
+// Sniff returns false, as headerless data cannot be recognized.
+func (c *rawCodec) Sniff(*bufio.Reader) bool {
+	return false
+}
+
+func (c *rawCodec) DefaultSampleCodec() sample.Codec {
+	return c.sc
+}
+
+// Decoder returns a decoder of r, whose Len is -1 until it reaches the end
+// of r.  Any partial frame at the end is ignored.
+func (c *rawCodec) Decoder(r io.ReadCloser) (sound.Source, sample.Codec, error) {
+	s := newStream(c, c.sc, 0)
+	s.r, s.c, s.n = r, r, -1
+	return s, c.sc, nil
+}
+
+// SeekingDecoder returns a decoder of r from its current offset to its end.
+func (c *rawCodec) SeekingDecoder(r codec.IoReadSeekCloser) (sound.SourceSeeker, sample.Codec, error) {
+	s, err := seekingStream(c, c.sc, r)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	s.r, s.c = r, r
+	return s, c.sc, nil
+}
+
+func (c *rawCodec) Encoder(w io.WriteCloser, v sound.Form, sc sample.Codec) (sound.Sink, error) {
+	if sc == codec.AnySampleCodec {
+		sc = c.sc
+	}
+	if !supported(sc) {
+		return nil, codec.ErrUnsupportedSampleCodec
+	}
+	if err := checkForm(v); err != nil {
+		return nil, err
+	}
+	s := newStream(v, sc, 0)
+	s.w, s.c = w, w
+	return s, nil
+}
+
+// RandomAccess returns random access to rw from its current offset, over
+// any data it already contains.
+func (c *rawCodec) RandomAccess(rw codec.IoReadWriteSeekCloser, v sound.Form, sc sample.Codec) (sound.RandomAccess, error) {
+	if sc == codec.AnySampleCodec {
+		sc = c.sc
+	}
+	if !supported(sc) {
+		return nil, codec.ErrUnsupportedSampleCodec
+	}
+	if err := checkForm(v); err != nil {
+		return nil, err
+	}
+	s, err := seekingStream(v, sc, rw)
+	if err != nil {
+		return nil, err
+	}
+	s.r, s.w, s.c = rw, rw, rw
+	return s, nil
+}
+
+// checkForm returns an error if v has no channels or no sample rate.
+func checkForm(v sound.Form) error {
+	if v.Channels() < 1 {
+		return fmt.Errorf("raw: unsupported channel count %d", v.Channels())
+	}
+	if v.SampleRate() <= 0 {
+		return fmt.Errorf("raw: unsupported sample rate %s", v.SampleRate())
+	}
+	return nil
+}
+
+func supported(sc sample.Codec) bool {
+	for _, c := range sampleCodecs {
+		if c == sc {
+			return true
+		}
+	}
+	return false
+}
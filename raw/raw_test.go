@@ -0,0 +1,215 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package raw
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// TestConformance runs the conformance tests for each form and sample
+// codec with a raw codec of that form and sample codec, since decoding
+// depends on them.
+func TestConformance(t *testing.T) {
+	for _, v := range []sound.Form{sound.NewForm(8000*freq.Hertz, 1), sound.StereoCd()} {
+		for _, sc := range sampleCodecs {
+			v, sc := v, sc
+			t.Run(fmt.Sprintf("%dch/%s", v.Channels(), sc), func(t *testing.T) {
+				codectest.Run(t, New(v, sc), &codectest.Options{
+					Forms:        []sound.Form{v},
+					SampleCodecs: []sample.Codec{sc},
+					Frames:       1000,
+					NoSniff:      true})
+			})
+		}
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	for ext, sc := range map[string]sample.Codec{
+		"dump.raw":   sample.SInt16L,
+		"dump.PCM":   sample.SInt16L,
+		"x.s8":       sample.SByte,
+		"x.s16be":    sample.SInt16B,
+		"x.s24le":    sample.SInt24L,
+		"x.s32be":    sample.SInt32B,
+		"x.f32le":    sample.SFloat32L,
+		"mic.f64be":  sample.SFloat64B,
+		"take.s16le": sample.SInt16L,
+	} {
+		c, err := codec.CodecFor(ext, nil)
+		if err != nil {
+			t.Errorf("%s: %v", ext, err)
+			continue
+		}
+		if c.DefaultSampleCodec() != sc {
+			t.Errorf("%s: sample codec %s not %s", ext, c.DefaultSampleCodec(), sc)
+		}
+	}
+}
+
+func TestCodecForMIME(t *testing.T) {
+	for _, tc := range []struct {
+		mime     string
+		sc       sample.Codec
+		rate     freq.T
+		channels int
+	}{
+		{"audio/L16;rate=48000;channels=2", sample.SInt16B, 48000 * freq.Hertz, 2},
+		{"audio/l24; rate=96000", sample.SInt24B, 96000 * freq.Hertz, 1},
+		{"audio/L16", sample.SInt16B, 44100 * freq.Hertz, 1},
+	} {
+		c, err := codec.CodecForMIME(tc.mime, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tc.mime, err)
+			continue
+		}
+		if c.DefaultSampleCodec() != tc.sc {
+			t.Errorf("%s: sample codec %s", tc.mime, c.DefaultSampleCodec())
+		}
+		src, _, err := c.Decoder(ioutil.NopCloser(bytes.NewReader(nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src.SampleRate() != tc.rate || src.Channels() != tc.channels {
+			t.Errorf("%s: form %s %dch", tc.mime, src.SampleRate(), src.Channels())
+		}
+	}
+	for _, mt := range []string{"audio/L16;rate=0", "audio/L16;rate=fast", "audio/L16;channels=0", "audio/L16;channels=70000"} {
+		if _, err := codec.CodecForMIME(mt, nil); err == nil {
+			t.Errorf("%s: no error", mt)
+		}
+	}
+	// the registered codec is unchanged.
+	c, _ := codec.CodecFor(".s16be", nil)
+	if src, _, _ := c.Decoder(ioutil.NopCloser(bytes.NewReader(nil))); src.SampleRate() != 44100*freq.Hertz || src.Channels() != 1 {
+		t.Errorf("registered codec configured by CodecForMIME")
+	}
+}
+
+func TestDecoderLen(t *testing.T) {
+	c := New(sound.StereoCd(), sample.SInt16L)
+	// 3 frames and a partial frame.
+	data := make([]byte, 3*4+3)
+	src, _, err := c.Decoder(ioutil.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := src.(sound.SourceSeeker)
+	if ss.Len() != -1 {
+		t.Errorf("len %d before reading", ss.Len())
+	}
+	n, err := src.Receive(make([]float64, 20))
+	if n != 3 || err != nil {
+		t.Errorf("received %d frames, %v", n, err)
+	}
+	if ss.Len() != 3 {
+		t.Errorf("len %d after reading", ss.Len())
+	}
+	if _, err := src.Receive(make([]float64, 20)); err != io.EOF {
+		t.Errorf("received %v at end", err)
+	}
+	if err := ss.Seek(0); err != codec.ErrUnsupportedFunction {
+		t.Errorf("seek gave %v", err)
+	}
+}
+
+// file is an in memory codec.IoReadWriteSeekCloser.
+type file struct {
+	d   []byte
+	pos int
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.pos >= len(f.d) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.d[f.pos:])
+	f.pos += n
+	return n, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if n := f.pos + len(p); n > len(f.d) {
+		f.d = append(f.d, make([]byte, n-len(f.d))...)
+	}
+	copy(f.d[f.pos:], p)
+	f.pos += len(p)
+	return len(p), nil
+}
+
+func (f *file) Seek(off int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		off += int64(f.pos)
+	case io.SeekEnd:
+		off += int64(len(f.d))
+	}
+	f.pos = int(off)
+	return off, nil
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+// TestRandomAccessExisting checks random access to data following a
+// header which is not part of the sound.
+func TestRandomAccessExisting(t *testing.T) {
+	f := &file{d: []byte{'h', 'd', 'r', 0, 0x40, 0, 0xc0, 0, 0x20}}
+	f.pos = 4
+	ra, err := New(sound.MonoCd(), sample.SInt16B).RandomAccess(f, sound.MonoCd(), codec.AnySampleCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ra.Len() != 2 {
+		t.Errorf("len %d not 2", ra.Len())
+	}
+	if err := ra.Seek(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ra.Send([]float64{0.25, 0.125}); err != nil {
+		t.Fatal(err)
+	}
+	if ra.Len() != 3 || ra.Pos() != 3 {
+		t.Errorf("len %d pos %d after writing", ra.Len(), ra.Pos())
+	}
+	want := []byte{'h', 'd', 'r', 0, 0x40, 0, 0x20, 0, 0x10, 0}
+	if !bytes.Equal(f.d, want) {
+		t.Errorf("data % x not % x", f.d, want)
+	}
+	if err := ra.Seek(0); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]float64, 4)
+	n, err := ra.Receive(got)
+	if err != nil || n != 3 || got[0] != 0.5 || got[1] != 0.25 || got[2] != 0.125 {
+		t.Errorf("received %d frames %v, %v", n, got[:n], err)
+	}
+}
+
+func TestInvalidForm(t *testing.T) {
+	c := New(sound.MonoCd(), sample.SInt16L)
+	for _, v := range []sound.Form{
+		sound.NewForm(44100*freq.Hertz, 0),
+		sound.NewForm(0, 1),
+		sound.NewForm(-44100*freq.Hertz, 1),
+	} {
+		if _, err := c.Encoder(codectest.NewFile(nil), v, sample.SInt16L); err == nil {
+			t.Errorf("encoder: %d channels at %s: no error", v.Channels(), v.SampleRate())
+		}
+		if _, err := c.RandomAccess(codectest.NewFile(nil), v, sample.SInt16L); err == nil {
+			t.Errorf("random access: %d channels at %s: no error", v.Channels(), v.SampleRate())
+		}
+	}
+}
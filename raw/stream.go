@@ -0,0 +1,179 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package raw
+
+import (
+	"fmt"
+	"io"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// stream is the sound.RandomAccess underlying all raw codec functions, of
+// which only the methods supported by its reader, writer and seeker are
+// used.
+type stream struct {
+	rate     freq.T
+	channels int
+	bpf      int // bytes per frame
+	codec    sample.Codec
+
+	r    io.Reader
+	w    io.Writer
+	c    io.Closer
+	s    io.Seeker // nil unless seekable
+	base int64     // offset of frame 0 in s
+
+	pos int64
+	n   int64 // number of frames, or -1 if unknown
+
+	buf []byte
+	tmp []float64
+}
+
+var _ sound.RandomAccess = (*stream)(nil)
+
+func newStream(v sound.Form, sc sample.Codec, n int64) *stream {
+	return &stream{
+		rate:     v.SampleRate(),
+		channels: v.Channels(),
+		bpf:      v.Channels() * sc.Bytes(),
+		codec:    sc,
+		n:        n}
+}
+
+// seekingStream returns a stream over s from its current offset to its end.
+func seekingStream(v sound.Form, sc sample.Codec, s io.Seeker) (*stream, error) {
+	base, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.Seek(base, io.SeekStart); err != nil {
+		return nil, err
+	}
+	res := newStream(v, sc, 0)
+	res.s, res.base = s, base
+	if end > base {
+		res.n = (end - base) / int64(res.bpf)
+	}
+	return res, nil
+}
+
+func (s *stream) SampleRate() freq.T {
+	return s.rate
+}
+
+func (s *stream) Channels() int {
+	return s.channels
+}
+
+func (s *stream) Receive(dst []float64) (int, error) {
+	nC := s.channels
+	if len(dst)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := int64(len(dst) / nC)
+	if rem := s.n - s.pos; s.n >= 0 && nF > rem {
+		nF = rem
+	}
+	if nF <= 0 {
+		return 0, io.EOF
+	}
+	sz := int(nF) * s.bpf
+	if cap(s.buf) < sz {
+		s.buf = make([]byte, sz)
+	}
+	m, err := io.ReadFull(s.r, s.buf[:sz])
+	n := m / s.bpf
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// the data ends, early if its length is known.
+		s.n = s.pos + int64(n)
+	} else if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	if cap(s.tmp) < n*nC {
+		s.tmp = make([]float64, n*nC)
+	}
+	tmp := s.tmp[:n*nC]
+	s.codec.Decode(tmp, s.buf[:n*s.bpf])
+	for f := 0; f < n; f++ {
+		for c := 0; c < nC; c++ {
+			dst[c*n+f] = tmp[f*nC+c]
+		}
+	}
+	s.pos += int64(n)
+	return n, nil
+}
+
+func (s *stream) Send(src []float64) error {
+	nC := s.channels
+	if len(src)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	n := len(src) / nC
+	if cap(s.tmp) < len(src) {
+		s.tmp = make([]float64, len(src))
+	}
+	tmp := s.tmp[:len(src)]
+	for f := 0; f < n; f++ {
+		for c := 0; c < nC; c++ {
+			tmp[f*nC+c] = src[c*n+f]
+		}
+	}
+	sz := n * s.bpf
+	if cap(s.buf) < sz {
+		s.buf = make([]byte, sz)
+	}
+	buf := s.buf[:sz]
+	s.codec.Encode(buf, tmp)
+	if _, err := s.w.Write(buf); err != nil {
+		return err
+	}
+	s.pos += int64(n)
+	if s.pos > s.n {
+		s.n = s.pos
+	}
+	return nil
+}
+
+// Len returns the number of frames, or -1 if it is unknown because a
+// decoder which cannot seek has not reached the end of its data.
+func (s *stream) Len() int64 {
+	return s.n
+}
+
+func (s *stream) Pos() int64 {
+	return s.pos
+}
+
+// Seek seeks to frame f, returning an error if f is negative or the
+// stream cannot seek.  Seeking beyond the end is allowed, after which
+// Receive returns io.EOF and Send extends the data.
+func (s *stream) Seek(f int64) error {
+	if s.s == nil {
+		return codec.ErrUnsupportedFunction
+	}
+	if f < 0 {
+		return fmt.Errorf("raw: seek to negative frame %d", f)
+	}
+	if _, err := s.s.Seek(s.base+f*int64(s.bpf), io.SeekStart); err != nil {
+		return err
+	}
+	s.pos = f
+	return nil
+}
+
+func (s *stream) Close() error {
+	return s.c.Close()
+}
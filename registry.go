@@ -0,0 +1,289 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codec
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/ops"
+	"zikichombo.org/sound/sample"
+)
+
+// Registry is a set of codecs.  The package level functions of this package
+// use a default Registry, in which codec implementations register themselves.
+// Applications may create other registries, for example to restrict the
+// codecs used by part of an application, or to isolate tests.
+//
+// The zero value is an empty Registry ready to use.  A Registry is safe for
+// concurrent use.
+type Registry struct {
+	// mu protects codecs.  codecs is never modified in place, so readers
+	// may iterate over the slice returned by registered() without holding mu.
+	mu     sync.RWMutex
+	codecs []codec
+}
+
+var defaultRegistry = &Registry{}
+
+// DefaultRegistry returns the Registry used by the package level
+// functions, such as RegisterCodec and Decoder.
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// NewRegistry creates a Registry containing the codecs cs, in order.
+func NewRegistry(cs ...Codec) *Registry {
+	r := &Registry{}
+	for _, c := range cs {
+		r.Register(c)
+	}
+	return r
+}
+
+// registered returns a snapshot of the codecs in r.
+func (r *Registry) registered() []codec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.codecs
+}
+
+// Register adds c to r, as RegisterCodec does for the default registry.
+func (r *Registry) Register(c Codec) {
+	r.add(codec{Codec: c, pkgPath: getPkgPath(c)})
+}
+
+func (r *Registry) add(c codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cs := make([]codec, len(r.codecs), len(r.codecs)+1)
+	copy(cs, r.codecs)
+	r.codecs = append(cs, c)
+}
+
+// Unregister removes all registrations of c from r, returning whether c was
+// registered.  The dynamic type of c must be comparable.
+func (r *Registry) Unregister(c Codec) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cs := make([]codec, 0, len(r.codecs))
+	for i := range r.codecs {
+		if r.codecs[i].Codec != c {
+			cs = append(cs, r.codecs[i])
+		}
+	}
+	found := len(cs) != len(r.codecs)
+	r.codecs = cs
+	return found
+}
+
+// Filter returns a new Registry containing the codecs in r, in order, for
+// which keep returns true.  For example, a lossless only registry or a
+// registry restricted to some packages may be created with Filter.
+//
+// Later registrations in r are not reflected in the result.
+func (r *Registry) Filter(keep func(Info) bool) *Registry {
+	res := &Registry{}
+	cs := r.registered()
+	for i := range cs {
+		if keep(cs[i].info()) {
+			res.add(cs[i])
+		}
+	}
+	return res
+}
+
+// Codecs lists the codecs in r as the package level Codecs does.
+func (r *Registry) Codecs() []Info {
+	cs := r.registered()
+	res := make([]Info, 0, len(cs))
+	for i := range cs {
+		res = append(res, cs[i].info())
+	}
+	return res
+}
+
+// CodecFor is like the package level CodecFor but uses the codecs in r.
+func (r *Registry) CodecFor(ext string, pkgSel func(string) bool) (Codec, error) {
+	name := normExt(ext)
+	var res Codec
+	n := 0
+	cs := r.registered()
+	for i := range cs {
+		c := &cs[i]
+		if pkgSel != nil && !pkgSel(c.pkgPath) {
+			continue
+		}
+		for _, codExt := range c.Extensions() {
+			codExt = strings.ToLower(codExt)
+			if len(codExt) > n && strings.HasSuffix(name, codExt) {
+				res, n = c.Codec, len(codExt)
+			}
+		}
+	}
+	if res == nil {
+		return nil, ErrUnknownCodec
+	}
+	return res, nil
+}
+
+// normExt normalizes an extension or path for suffix matching against
+// codec extensions.
+func normExt(ext string) string {
+	name := strings.ToLower(filepath.Base(filepath.FromSlash(ext)))
+	if !strings.Contains(name, ".") {
+		name = "." + name
+	}
+	return name
+}
+
+// SniffAll is like the package level SniffAll but uses the codecs in r.
+func (r *Registry) SniffAll(br *bufio.Reader, pkgSel func(string) bool) []Candidate {
+	var res []Candidate
+	cs := r.registered()
+	for i := range cs {
+		c := &cs[i]
+		if pkgSel != nil && !pkgSel(c.pkgPath) {
+			continue
+		}
+		conf := sniffConfidence(c.Codec, br)
+		if conf <= SniffNone {
+			continue
+		}
+		res = append(res, Candidate{Codec: c.Codec, PkgPath: c.pkgPath, Confidence: conf})
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Confidence > res[j].Confidence
+	})
+	return res
+}
+
+// Sniff is like the package level Sniff but uses the codecs in r.
+func (r *Registry) Sniff(br *bufio.Reader, pkgSel func(string) bool) (Codec, error) {
+	cands := r.SniffAll(br, pkgSel)
+	if len(cands) == 0 {
+		return nil, ErrUnknownCodec
+	}
+	if len(cands) > 1 && cands[1].Confidence == cands[0].Confidence {
+		return nil, ErrAmbiguousCodec
+	}
+	return cands[0].Codec, nil
+}
+
+type brCloser struct {
+	*bufio.Reader
+	io.Closer
+}
+
+func (r *Registry) sniff(rc io.ReadCloser, pkgSel func(string) bool) (Codec, *brCloser) {
+	br := bufio.NewReader(rc)
+	var theCodec Codec
+	if cands := r.SniffAll(br, pkgSel); len(cands) > 0 {
+		theCodec = cands[0].Codec
+	}
+	return theCodec, &brCloser{Reader: br, Closer: rc}
+}
+
+// Decoder is like the package level Decoder but uses the codecs in r.
+func (r *Registry) Decoder(rc io.ReadCloser, pkgSel func(string) bool) (sound.Source, sample.Codec, error) {
+	theCodec, rr := r.sniff(rc, pkgSel)
+	if theCodec == nil {
+		return nil, AnySampleCodec, ErrUnknownCodec
+	}
+	return theCodec.Decoder(rr)
+}
+
+// SeekingDecoder is like the package level SeekingDecoder but uses the codecs
+// in r.
+func (r *Registry) SeekingDecoder(rs IoReadSeekCloser, pkgSel func(string) bool) (sound.SourceSeeker, sample.Codec, error) {
+	var theCodec Codec
+	err := peekSeeker(rs, func(br *bufio.Reader) {
+		if cands := r.SniffAll(br, pkgSel); len(cands) > 0 {
+			theCodec = cands[0].Codec
+		}
+	})
+	if err != nil {
+		return nil, AnySampleCodec, err
+	}
+	if theCodec == nil {
+		return nil, AnySampleCodec, ErrUnknownCodec
+	}
+	return theCodec.SeekingDecoder(rs)
+}
+
+// peekSeeker calls f with a *bufio.Reader reading from the current offset of
+// rs, and then restores that offset.  If rs is an io.ReaderAt, rs is read
+// without changing its offset.
+func peekSeeker(rs io.ReadSeeker, f func(*bufio.Reader)) error {
+	off, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if ra, ok := rs.(io.ReaderAt); ok {
+		f(bufio.NewReader(io.NewSectionReader(ra, off, math.MaxInt64-off)))
+		return nil
+	}
+	f(bufio.NewReader(rs))
+	_, err = rs.Seek(off, io.SeekStart)
+	return err
+}
+
+// Probe is like the package level Probe but uses the codecs in r.
+func (r *Registry) Probe(rs io.ReadSeeker, pkgSel func(string) bool) (*StreamInfo, error) {
+	var theCodec Codec
+	err := peekSeeker(rs, func(br *bufio.Reader) {
+		if cands := r.SniffAll(br, pkgSel); len(cands) > 0 {
+			theCodec = cands[0].Codec
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if theCodec == nil {
+		return nil, ErrUnknownCodec
+	}
+	p, ok := theCodec.(Prober)
+	if !ok {
+		return nil, ErrUnsupportedFunction
+	}
+	return p.Probe(rs)
+}
+
+// Encoder is like the package level Encoder but uses the codecs in r.
+func (r *Registry) Encoder(dst io.WriteCloser, ext string, v sound.Form) (sound.Sink, error) {
+	co, err := r.CodecFor(ext, nil)
+	if err != nil {
+		return nil, err
+	}
+	return co.Encoder(dst, v, co.DefaultSampleCodec())
+}
+
+// EncoderWith is like the package level EncoderWith but uses the codecs in r.
+func (r *Registry) EncoderWith(dst io.WriteCloser, ext string, v sound.Form, c sample.Codec) (sound.Sink, error) {
+	co, err := r.CodecFor(ext, nil)
+	if err != nil {
+		return nil, err
+	}
+	return co.Encoder(dst, v, c)
+}
+
+// Encode is like the package level Encode but uses the codecs in r.
+func (r *Registry) Encode(dst io.WriteCloser, src sound.Source, ext string) error {
+	return r.EncodeWith(dst, src, ext, AnySampleCodec)
+}
+
+// EncodeWith is like the package level EncodeWith but uses the codecs in r.
+func (r *Registry) EncodeWith(dst io.WriteCloser, src sound.Source, ext string, co sample.Codec) error {
+	snk, err := r.EncoderWith(dst, ext, src, co)
+	if err != nil {
+		return err
+	}
+	return ops.Copy(snk, src)
+}
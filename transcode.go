@@ -0,0 +1,241 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// FormNegotiator is an optional interface which a Codec may implement when it
+// can only encode some forms, for example some sample rates or numbers of
+// channels.
+type FormNegotiator interface {
+	// NegotiateForm returns the form and sample codec which the codec can
+	// encode and which are closest to v and c.  c may be AnySampleCodec.
+	NegotiateForm(v sound.Form, c sample.Codec) (sound.Form, sample.Codec)
+}
+
+// TranscodeOptions holds the options for Transcode.
+type TranscodeOptions struct {
+	// EncoderOptions are passed to the encoder.  EncoderOptions.Dither is
+	// applied by Transcode when reducing bit depth and is not passed on.
+	// When it is DitherDefault, triangular dither is used.
+	EncoderOptions
+
+	// SampleRate, if non-zero, is the desired sample rate of the encoding,
+	// otherwise that of the source is desired.
+	SampleRate freq.T
+
+	// Channels, if non-zero, is the desired number of channels of the
+	// encoding, otherwise that of the source is desired.
+	Channels int
+}
+
+// Conversions reports the conversions applied by Transcode.
+type Conversions struct {
+	// SrcRate and DstRate are the sample rates of the source and the encoding.
+	SrcRate, DstRate freq.T
+
+	// SrcChannels and DstChannels are the number of channels of the source
+	// and the encoding.
+	SrcChannels, DstChannels int
+
+	// SampleCodec is the sample codec of the encoding.
+	SampleCodec sample.Codec
+
+	// Dither is the dither applied when reducing bit depth, or DitherNone.
+	Dither Dither
+}
+
+// Resampled returns whether the sample rate was converted.
+func (c *Conversions) Resampled() bool {
+	return c.SrcRate != c.DstRate
+}
+
+// Remixed returns whether the number of channels was converted.
+func (c *Conversions) Remixed() bool {
+	return c.SrcChannels != c.DstChannels
+}
+
+// Dithered returns whether dither was applied.
+func (c *Conversions) Dithered() bool {
+	return c.Dither != DitherNone
+}
+
+var ditherNames = [...]string{"default", "none", "rectangular", "triangular"}
+
+func (c *Conversions) String() string {
+	var parts []string
+	if c.Remixed() {
+		parts = append(parts, fmt.Sprintf("channels %d->%d", c.SrcChannels, c.DstChannels))
+	}
+	if c.Resampled() {
+		parts = append(parts, fmt.Sprintf("rate %s->%s", c.SrcRate, c.DstRate))
+	}
+	if c.Dithered() {
+		parts = append(parts, fmt.Sprintf("%s dither to %s", ditherNames[c.Dither], c.SampleCodec))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+type form struct {
+	rate freq.T
+	nC   int
+}
+
+func (f form) SampleRate() freq.T {
+	return f.rate
+}
+
+func (f form) Channels() int {
+	return f.nC
+}
+
+// Transcode encodes src to dst like EncodeWithOptions, but converts src to a
+// form and sample codec which the codec selected by ext supports.
+//
+// The codec is asked for the form and sample codec closest to those desired
+// if it implements FormNegotiator.  Otherwise, the desired form is used, and
+// if the codec implements Describer and does not list the desired sample codec,
+// the listed sample codec closest to it is used.
+//
+// Transcode then converts the number of channels and sample rate as needed,
+// and applies dither when the encoding has fewer bits per sample than the
+// source.  The bit depth of src is taken from its Codec() method, if it has
+// one, and otherwise src is treated as floating point.
+//
+// Transcode closes the encoder when src is exhausted, and reports the
+// conversions applied.
+func Transcode(dst io.WriteCloser, src sound.Source, ext string, c sample.Codec, opts *TranscodeOptions) (*Conversions, error) {
+	return defaultRegistry.Transcode(dst, src, ext, c, opts)
+}
+
+// Transcode is like the package level Transcode but uses the codecs in r.
+func (r *Registry) Transcode(dst io.WriteCloser, src sound.Source, ext string, c sample.Codec, opts *TranscodeOptions) (*Conversions, error) {
+	return r.TranscodeContext(context.Background(), dst, src, ext, c, opts, nil)
+}
+
+// TranscodeContext is like the package level TranscodeContext but uses the
+// codecs in r.
+func (r *Registry) TranscodeContext(ctx context.Context, dst io.WriteCloser, src sound.Source, ext string, c sample.Codec, opts *TranscodeOptions, progress func(Progress)) (*Conversions, error) {
+	if opts == nil {
+		opts = &TranscodeOptions{}
+	}
+	co, err := r.CodecFor(ext, nil)
+	if err != nil {
+		return nil, err
+	}
+	want := form{rate: opts.SampleRate, nC: opts.Channels}
+	if want.rate == 0 {
+		want.rate = src.SampleRate()
+	}
+	if want.nC == 0 {
+		want.nC = src.Channels()
+	}
+	v, c := negotiate(co, want, c)
+	conv := &Conversions{
+		SrcRate:     src.SampleRate(),
+		DstRate:     v.SampleRate(),
+		SrcChannels: src.Channels(),
+		DstChannels: v.Channels(),
+		SampleCodec: c,
+		Dither:      DitherNone}
+
+	s := src
+	if conv.DstChannels < conv.SrcChannels {
+		s = newRemixer(s, conv.DstChannels)
+	}
+	if conv.Resampled() {
+		s = newResampler(s, conv.DstRate)
+	}
+	if conv.DstChannels > conv.SrcChannels {
+		s = newRemixer(s, conv.DstChannels)
+	}
+	if c != AnySampleCodec && !c.IsFloat() && c.Bits() < srcBits(src) {
+		conv.Dither = opts.Dither
+		if conv.Dither == DitherDefault {
+			conv.Dither = DitherTriangular
+		}
+		if conv.Dither != DitherNone {
+			s = newDitherer(s, conv.Dither, c.Bits())
+		}
+	}
+	encOpts := opts.EncoderOptions
+	encOpts.Dither = DitherDefault
+	cw, n := countWrites(dst)
+	snk, err := encoderWithOptions(co, cw, v, c, &encOpts)
+	if err != nil {
+		return nil, err
+	}
+	total := remaining(src)
+	if total > 0 && conv.Resampled() {
+		p, q := int64(conv.SrcRate), int64(conv.DstRate)
+		g := gcd(p, q)
+		p, q = p/g, q/g
+		total = (total*q + p - 1) / p
+	}
+	p, err := copyContext(ctx, snk, s, total, progress, n)
+	return conv, closeEncoder(snk, err, p, progress, n)
+}
+
+// negotiate chooses the form and sample codec to encode with co.
+func negotiate(co Codec, v sound.Form, c sample.Codec) (sound.Form, sample.Codec) {
+	if fn, ok := co.(FormNegotiator); ok {
+		v, c = fn.NegotiateForm(v, c)
+	} else if d, ok := co.(Describer); ok {
+		c = closestSampleCodec(d.Describe().SampleCodecs, c)
+	}
+	if c == AnySampleCodec {
+		c = co.DefaultSampleCodec()
+	}
+	return v, c
+}
+
+// closestSampleCodec returns c if it is in cs, otherwise the element of cs
+// of the same kind (integer or floating point) as c with the least number
+// of bits not less than c's, otherwise the element of cs with the most bits.
+// If cs is empty, c is returned.
+func closestSampleCodec(cs []sample.Codec, c sample.Codec) sample.Codec {
+	if len(cs) == 0 || c == AnySampleCodec {
+		return c
+	}
+	up, max := AnySampleCodec, cs[0]
+	for _, o := range cs {
+		if o == c {
+			return c
+		}
+		if o.IsFloat() == c.IsFloat() && o.Bits() >= c.Bits() && (up == AnySampleCodec || o.Bits() < up.Bits()) {
+			up = o
+		}
+		if o.Bits() > max.Bits() {
+			max = o
+		}
+	}
+	if up != AnySampleCodec {
+		return up
+	}
+	return max
+}
+
+// srcBits returns the number of bits per sample of src.
+func srcBits(src sound.Source) int {
+	if cs, ok := src.(interface {
+		Codec() sample.Codec
+	}); ok {
+		if c := cs.Codec(); c != AnySampleCodec && !c.IsFloat() {
+			return c.Bits()
+		}
+	}
+	return 64
+}
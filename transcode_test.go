@@ -0,0 +1,240 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package codec
+
+import (
+	"io"
+	"math"
+	"testing"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// sliceSource is a sound.Source of frames, where frames[f*nC+c] is the
+// sample of channel c in frame f.
+type sliceSource struct {
+	form
+	frames []float64
+	codec  sample.Codec
+}
+
+func (s *sliceSource) Codec() sample.Codec {
+	return s.codec
+}
+
+func (s *sliceSource) Receive(dst []float64) (int, error) {
+	nC := s.nC
+	if len(dst)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	n := len(dst) / nC
+	if m := len(s.frames) / nC; m < n {
+		n = m
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	for f := 0; f < n; f++ {
+		for c := 0; c < nC; c++ {
+			dst[c*n+f] = s.frames[f*nC+c]
+		}
+	}
+	s.frames = s.frames[n*nC:]
+	return n, nil
+}
+
+func (s *sliceSource) Close() error {
+	return nil
+}
+
+// sliceSink is a sound.Sink collecting frames as sliceSource represents them.
+type sliceSink struct {
+	form
+	frames []float64
+	closed bool
+}
+
+func (s *sliceSink) Send(src []float64) error {
+	nC := s.nC
+	if len(src)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	n := len(src) / nC
+	for f := 0; f < n; f++ {
+		for c := 0; c < nC; c++ {
+			s.frames = append(s.frames, src[c*n+f])
+		}
+	}
+	return nil
+}
+
+func (s *sliceSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func readAll(t *testing.T, src sound.Source, bufFrames int) []float64 {
+	snk := &sliceSink{form: form{rate: src.SampleRate(), nC: src.Channels()}}
+	buf := make([]float64, bufFrames*src.Channels())
+	for {
+		n, err := src.Receive(buf)
+		if n > 0 {
+			snk.Send(buf[:n*src.Channels()])
+		}
+		if err == io.EOF {
+			return snk.frames
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRemixer(t *testing.T) {
+	st := &sliceSource{form: form{rate: 8000 * freq.Hertz, nC: 2}, frames: []float64{1, 0, 0.5, 0.5, -1, 0}}
+	mono := readAll(t, newRemixer(st, 1), 2)
+	for i, want := range []float64{0.5, 0.5, -0.5} {
+		if mono[i] != want {
+			t.Errorf("mono frame %d: %f != %f", i, mono[i], want)
+		}
+	}
+	in := []float64{1, 0.25, -1}
+	mo := &sliceSource{form: form{rate: 8000 * freq.Hertz, nC: 1}, frames: in}
+	tri := readAll(t, newRemixer(mo, 3), 2)
+	if len(tri) != 9 {
+		t.Fatalf("got %d samples not 9", len(tri))
+	}
+	for i, v := range tri {
+		if v != in[i/3] {
+			t.Errorf("sample %d: %f != %f", i, v, in[i/3])
+		}
+	}
+}
+
+func sine(rate freq.T, hz float64, n int) []float64 {
+	d := make([]float64, n)
+	for i := range d {
+		d[i] = 0.5 * math.Sin(2*math.Pi*hz*float64(i)*float64(freq.Hertz)/float64(rate))
+	}
+	return d
+}
+
+func TestResampler(t *testing.T) {
+	for _, tc := range []struct {
+		from, to int
+	}{
+		{48000, 16000},
+		{44100, 48000},
+		{8000, 44100},
+	} {
+		from, to := freq.T(tc.from)*freq.Hertz, freq.T(tc.to)*freq.Hertz
+		n := tc.from / 2
+		src := &sliceSource{form: form{rate: from, nC: 1}, frames: sine(from, 1000, n)}
+		r := newResampler(src, to)
+		if r.SampleRate() != to {
+			t.Errorf("rate %s != %s", r.SampleRate(), to)
+		}
+		out := readAll(t, r, 333)
+		wantN := int(math.Ceil(float64(n) * float64(tc.to) / float64(tc.from)))
+		if len(out) != wantN {
+			t.Errorf("%d->%d: %d frames not %d", tc.from, tc.to, len(out), wantN)
+		}
+		want := sine(to, 1000, len(out))
+		// skip the edges, where the kernel sees the zero padding.
+		for i := 100; i < len(out)-100; i++ {
+			if math.Abs(out[i]-want[i]) > 0.01 {
+				t.Errorf("%d->%d: frame %d: %f != %f", tc.from, tc.to, i, out[i], want[i])
+				break
+			}
+		}
+	}
+}
+
+func TestClosestSampleCodec(t *testing.T) {
+	cs := []sample.Codec{sample.SInt16L, sample.SInt24L, sample.SFloat32L}
+	for _, tc := range []struct {
+		c, want sample.Codec
+	}{
+		{sample.SInt24L, sample.SInt24L},
+		{sample.SByte, sample.SInt16L},
+		{sample.SInt32L, sample.SFloat32L},
+		{sample.SFloat64L, sample.SFloat32L},
+		{AnySampleCodec, AnySampleCodec},
+	} {
+		if got := closestSampleCodec(cs, tc.c); got != tc.want {
+			t.Errorf("closest to %s: %s not %s", tc.c, got, tc.want)
+		}
+	}
+}
+
+// narrowCodec is a Codec which encodes only mono 16 bit audio at 8kHz.
+type narrowCodec struct {
+	NullCodec
+	snk *sliceSink
+}
+
+func (c *narrowCodec) Extensions() []string {
+	return []string{".nrw"}
+}
+
+func (c *narrowCodec) NegotiateForm(v sound.Form, sc sample.Codec) (sound.Form, sample.Codec) {
+	return form{rate: 8000 * freq.Hertz, nC: 1}, sample.SInt16L
+}
+
+func (c *narrowCodec) Encoder(w io.WriteCloser, v sound.Form, sc sample.Codec) (sound.Sink, error) {
+	if v.Channels() != 1 || v.SampleRate() != 8000*freq.Hertz || sc != sample.SInt16L {
+		return nil, ErrUnsupportedSampleCodec
+	}
+	c.snk = &sliceSink{form: form{rate: v.SampleRate(), nC: v.Channels()}}
+	return c.snk, nil
+}
+
+func TestTranscode(t *testing.T) {
+	nc := &narrowCodec{}
+	r := NewRegistry(nc)
+	rate := 16000 * freq.Hertz
+	mono := sine(rate, 440, 16000)
+	frames := make([]float64, 2*len(mono))
+	for i, v := range mono {
+		frames[2*i], frames[2*i+1] = v, v
+	}
+	src := &sliceSource{form: form{rate: rate, nC: 2}, frames: frames, codec: sample.SFloat32L}
+	conv, err := r.Transcode(nil, src, ".nrw", AnySampleCodec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conv.Resampled() || !conv.Remixed() || conv.Dither != DitherTriangular {
+		t.Errorf("conversions: %s", conv)
+	}
+	if conv.SampleCodec != sample.SInt16L {
+		t.Errorf("sample codec %s", conv.SampleCodec)
+	}
+	if !nc.snk.closed {
+		t.Errorf("sink not closed")
+	}
+	if len(nc.snk.frames) != 8000 {
+		t.Errorf("encoded %d frames not 8000", len(nc.snk.frames))
+	}
+	want := sine(8000*freq.Hertz, 440, 8000)
+	for i := 100; i < 7900; i++ {
+		if math.Abs(nc.snk.frames[i]-want[i]) > 0.01 {
+			t.Fatalf("frame %d: %f != %f", i, nc.snk.frames[i], want[i])
+		}
+	}
+
+	src = &sliceSource{form: form{rate: 8000 * freq.Hertz, nC: 1}, frames: want, codec: sample.SInt16L}
+	opts := &TranscodeOptions{EncoderOptions: EncoderOptions{Dither: DitherRectangular}}
+	conv, err = r.Transcode(nil, src, ".nrw", sample.SInt16L, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.Resampled() || conv.Remixed() || conv.Dithered() {
+		t.Errorf("unexpected conversions: %s", conv)
+	}
+	if conv.String() != "none" {
+		t.Errorf("conversions string %q", conv.String())
+	}
+}
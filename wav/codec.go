@@ -0,0 +1,140 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package wav
+
+import (
+	"bufio"
+	"io"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Codec is the wav codec.Codec, registered with package codec when
+// package wav is imported.
+var Codec codec.Codec = wavCodec{}
+
+func init() {
+	codec.RegisterCodec(Codec)
+}
+
+type wavCodec struct {
+	codec.NullCodec
+	strictness codec.Strictness
+}
+
+var (
+	_ codec.Prober            = wavCodec{}
+	_ codec.ConfidenceSniffer = wavCodec{}
+	_ codec.Describer         = wavCodec{}
+	_ codec.StrictnessSetter  = wavCodec{}
+)
+
+// Describe implements codec.Describer.  Encoding requires the destination
+// to be a WriteSeekerCloser.
+func (c wavCodec) Describe() codec.Description {
+	return codec.Description{
+		Name:         "wav",
+		MIMETypes:    []string{"audio/wav", "audio/x-wav", "audio/vnd.wave", "audio/wave"},
+		Capabilities: codec.CanDecode | codec.CanSeek | codec.CanEncode,
+		SampleCodecs: sampleCodecs}
+}
+
+// sampleCodecs lists the sample codecs which can be encoded.
+var sampleCodecs = []sample.Codec{sample.SByte, sample.SInt16L, sample.SInt24L, sample.SInt32L, sample.SFloat32L}
+
+func (c wavCodec) Extensions() []string {
+	return []string{".wav"}
+}
+
+func (c wavCodec) Sniff(br *bufio.Reader) bool {
+	return c.SniffConfidence(br) > codec.SniffNone
+}
+
+// SniffConfidence implements codec.ConfidenceSniffer.
+func (c wavCodec) SniffConfidence(br *bufio.Reader) int {
+	buf, err := br.Peek(hdrChunkSize)
+	if err != nil {
+		return codec.SniffNone
+	}
+	if string(buf[:4]) != string(_riff4Cc[:]) || string(buf[8:12]) != string(_wave4Cc[:]) {
+		return codec.SniffNone
+	}
+	return codec.SniffStrong
+}
+
+// WithStrictness implements codec.StrictnessSetter, see ParseFormatWith
+// and NewDecoderWith.
+func (c wavCodec) WithStrictness(s codec.Strictness) codec.Codec {
+	c.strictness = s
+	return c
+}
+
+func (c wavCodec) DefaultSampleCodec() sample.Codec {
+	return sample.SInt16L
+}
+
+func (c wavCodec) Decoder(r io.ReadCloser) (sound.Source, sample.Codec, error) {
+	d, err := newDecoder(noSeeker{r}, struct{ io.Reader }{r}, 0, c.strictness)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	return d, d.Codec(), nil
+}
+
+func (c wavCodec) SeekingDecoder(r codec.IoReadSeekCloser) (sound.SourceSeeker, sample.Codec, error) {
+	d, err := NewDecoderWith(r, c.strictness)
+	if err != nil {
+		return nil, codec.AnySampleCodec, err
+	}
+	return d, d.Codec(), nil
+}
+
+func (c wavCodec) Encoder(w io.WriteCloser, v sound.Form, sc sample.Codec) (sound.Sink, error) {
+	ws, ok := w.(WriteSeekerCloser)
+	if !ok {
+		return nil, codec.ErrUnsupportedFunction
+	}
+	if sc == codec.AnySampleCodec {
+		sc = c.DefaultSampleCodec()
+	}
+	if !supported(sc) {
+		return nil, codec.ErrUnsupportedSampleCodec
+	}
+	return NewEncoder(NewFormatForm(v, sc), ws)
+}
+
+// Probe implements codec.Prober, reading only the header chunks of r.
+func (c wavCodec) Probe(r io.ReadSeeker) (*codec.StreamInfo, error) {
+	f, dc, err := readHeaders(r, c.strictness)
+	if err != nil {
+		return nil, err
+	}
+	return &codec.StreamInfo{
+		Container:   "wav",
+		Channels:    f.Channels(),
+		SampleRate:  f.SampleRate(),
+		SampleCodec: f.Codec,
+		Frames:      int64(dc.length / (f.Bytes() * f.Channels()))}, nil
+}
+
+func supported(sc sample.Codec) bool {
+	for _, c := range sampleCodecs {
+		if c == sc {
+			return true
+		}
+	}
+	return false
+}
+
+// noSeeker gives a non-seekable io.ReadCloser the methods of
+// ReadSeekerCloser.
+type noSeeker struct {
+	io.ReadCloser
+}
+
+func (n noSeeker) Seek(int64, int) (int64, error) {
+	return 0, codec.ErrUnsupportedFunction
+}
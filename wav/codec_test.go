@@ -0,0 +1,325 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package wav
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/codectest"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+func TestProbe(t *testing.T) {
+	f, err := ioutil.TempFile(".", "wavtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	format := NewFormat(2, 44100*freq.Hertz, sample.SInt24L)
+	if err := encode(make([]float64, 2*441), format, f); err != nil {
+		t.Fatal(err)
+	}
+	f, err = os.Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := codec.Probe(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Container != "wav" {
+		t.Errorf("container %q != %q", info.Container, "wav")
+	}
+	if info.Channels != 2 {
+		t.Errorf("channels %d != 2", info.Channels)
+	}
+	if info.SampleRate != format.SampleRate() {
+		t.Errorf("sample rate %s != %s", info.SampleRate, format.SampleRate())
+	}
+	if info.SampleCodec != sample.SInt24L {
+		t.Errorf("sample codec %s != %s", info.SampleCodec, sample.SInt24L)
+	}
+	if info.Frames != 441 {
+		t.Errorf("frames %d != 441", info.Frames)
+	}
+	if info.Duration() != 441*format.SampleRate().Period() {
+		t.Errorf("duration %s != %s", info.Duration(), 441*format.SampleRate().Period())
+	}
+}
+
+func TestCodecDecoder(t *testing.T) {
+	f, err := ioutil.TempFile(".", "wavtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	d := make([]float64, 128)
+	for i := range d {
+		d[i] = float64(i) / 128
+	}
+	if err := encode(d, NewMonoFmt(), f); err != nil {
+		t.Fatal(err)
+	}
+	f, err = os.Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, sc, _, err := codec.DecoderWith(f, codec.DecodeOptions{Strictness: codec.Validate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if sc != sample.SInt16L {
+		t.Errorf("sample codec %s != %s", sc, sample.SInt16L)
+	}
+	buf := make([]float64, 256)
+	n, err := src.Receive(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 128 {
+		t.Fatalf("decoded %d/128 frames", n)
+	}
+	for i, v := range buf[:n] {
+		if math.Abs(v-d[i]) > 0.001 {
+			t.Fatalf("%d: decoded %f expected %f", i, v, d[i])
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	for _, inf := range codec.Codecs() {
+		if inf.Codec != Codec {
+			continue
+		}
+		if inf.Name != "wav" {
+			t.Errorf("name %q", inf.Name)
+		}
+		if inf.PkgPath != "zikichombo.org/codec/wav" {
+			t.Errorf("pkg path %q", inf.PkgPath)
+		}
+		if !inf.Capabilities.Has(codec.CanDecode | codec.CanSeek | codec.CanEncode) {
+			t.Errorf("capabilities %s", inf.Capabilities)
+		}
+		return
+	}
+	t.Errorf("wav not registered")
+}
+
+func TestCodecForMIME(t *testing.T) {
+	for _, mt := range []string{"audio/wav", "audio/x-wav", "audio/vnd.wave", "audio/WAV; codecs=1"} {
+		c, err := codec.CodecForMIME(mt, nil)
+		if err != nil {
+			t.Errorf("%s: %s", mt, err)
+			continue
+		}
+		if c != Codec {
+			t.Errorf("%s: got %v not wav", mt, c)
+		}
+	}
+	if codec.MIMEType(Codec) != "audio/wav" {
+		t.Errorf("MIMEType %q", codec.MIMEType(Codec))
+	}
+}
+
+func TestOpenCreate(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "wavtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "take1.WAV")
+	snk, err := codec.Create(path, sound.StereoCd(), sample.SInt24L)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := make([]float64, 2*100)
+	for i := range d {
+		d[i] = float64(i) / 200
+	}
+	if err := snk.Send(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := snk.Close(); err != nil {
+		t.Fatal(err)
+	}
+	src, sc, err := codec.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc != sample.SInt24L {
+		t.Errorf("sample codec %s != %s", sc, sample.SInt24L)
+	}
+	ss, ok := src.(sound.SourceSeeker)
+	if !ok {
+		t.Fatalf("wav source does not seek")
+	}
+	if ss.Len() != 100 {
+		t.Errorf("len %d != 100", ss.Len())
+	}
+	buf := make([]float64, 2*100)
+	n, err := src.Receive(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 100 {
+		t.Errorf("received %d/100 frames", n)
+	}
+	for i := range buf {
+		if math.Abs(buf[i]-d[i]) > 0.0001 {
+			t.Fatalf("%d: %f != %f", i, buf[i], d[i])
+		}
+	}
+	if err := src.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := codec.Create(filepath.Join(dir, "take1.xyz"), sound.StereoCd(), sample.SInt24L); err != codec.ErrUnknownCodec {
+		t.Errorf("expected ErrUnknownCodec got %v", err)
+	}
+	if _, err := codec.Create(filepath.Join(dir, "take2.wav"), sound.StereoCd(), sample.SFloat64L); err != codec.ErrUnsupportedSampleCodec {
+		t.Errorf("expected ErrUnsupportedSampleCodec got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "take2.wav")); !os.IsNotExist(err) {
+		t.Errorf("failed Create left a file")
+	}
+}
+
+type sectionCloser struct {
+	io.ReadSeeker
+}
+
+func (s sectionCloser) Close() error {
+	return nil
+}
+
+func TestEmbeddedSeekingDecoder(t *testing.T) {
+	f, err := ioutil.TempFile(".", "wavtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	N := 3000
+	d := make([]float64, N)
+	for i := range d {
+		d[i] = float64(i) / float64(N)
+	}
+	if err := encode(d, NewMonoFmt(), f); err != nil {
+		t.Fatal(err)
+	}
+	wavData, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const off = 101
+	data := append(make([]byte, off), wavData...)
+	data = append(data, make([]byte, 77)...)
+	sr := io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data)))
+	for _, rs := range []io.ReadSeeker{sr, struct{ io.ReadSeeker }{bytes.NewReader(data)}} {
+		if _, err := rs.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		info, err := codec.Probe(rs, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Frames != int64(N) {
+			t.Errorf("probed %d frames not %d", info.Frames, N)
+		}
+		if _, err := rs.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		src, _, err := codec.SeekingDecoder(sectionCloser{rs}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src.Len() != int64(N) {
+			t.Errorf("len %d not %d", src.Len(), N)
+		}
+		buf := make([]float64, 1)
+		for _, frm := range []int{0, 1, 1023, 1024, 2047, 2999, 17} {
+			if err := src.Seek(int64(frm)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := src.Receive(buf); err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(buf[0]-d[frm]) > 0.0001 {
+				t.Errorf("frame %d: %f != %f", frm, buf[0], d[frm])
+			}
+		}
+	}
+}
+
+func TestEncodeContext(t *testing.T) {
+	tmp, err := ioutil.TempFile(".", "wavtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := encode(make([]float64, 300), NewMonoFmt(), tmp); err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := NewDecoder(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	out, err := ioutil.TempFile(".", "wavtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	var last codec.Progress
+	err = codec.EncodeContext(context.Background(), out, dec, ".wav", sample.SInt24L, nil, func(p codec.Progress) {
+		last = p
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Frames != 300 || last.Percent() != 100 {
+		t.Errorf("last progress %+v", last)
+	}
+	if last.Bytes < 300*3 {
+		t.Errorf("wrote %d bytes", last.Bytes)
+	}
+	g, err := os.Open(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	info, err := codec.Probe(g, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Frames != 300 || info.SampleCodec != sample.SInt24L {
+		t.Errorf("encoded %+v", info)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	codectest.Run(t, Codec, nil)
+}
@@ -4,10 +4,11 @@
 package wav
 
 import (
-	"errors"
+	"fmt"
 	"io"
 	"time"
 
+	"zikichombo.org/codec"
 	"zikichombo.org/sound"
 	"zikichombo.org/sound/freq"
 	"zikichombo.org/sound/sample"
@@ -18,16 +19,13 @@ import (
 type Decoder struct {
 	fmt    *Format
 	dChunk *chunk
+	base   int64 // offset in r of the start of the wav data
 
 	r    ReadSeekerCloser
-	buf  []byte
-	p    int // buffer index
-	e    int // end of buffer (can be less than last bit of data)
-	n    int // number of buffers preceding current one
-	frms int // number of decoded frames
-	nFrm int // number of frames
-	//dFunc     func([]byte) float64
-	byteDepth int // byte depth
+	buf  []byte    // encoded frames
+	tmp  []float64 // interleaved decoded frames
+	pos  int64     // frame position
+	nFrm int64     // number of frames
 }
 
 type ReadSeekerCloser interface {
@@ -36,48 +34,82 @@ type ReadSeekerCloser interface {
 }
 
 // NewDecoder creates a decoder from a wav file (seekable, readable).
+//
+// The wav data starts at the current offset of r, which need not be 0.
 func NewDecoder(r ReadSeekerCloser) (*Decoder, error) {
-	riff, fcc, e := readRiff(r)
-	if e != nil {
-		return nil, e
-	}
-	if fcc != _wave4Cc {
-		return nil, errors.New("not a wave file")
-	}
-	fc, err := riff.findChunk(r, _fmt4Cc)
-	if err != nil {
-		return nil, err
-	}
+	return NewDecoderWith(r, codec.Strict)
+}
 
-	f, e := ParseFormat(r, fc.length)
+// NewDecoderWith is like NewDecoder but parses the headers with strictness
+// s, as ParseFormatWith does.  With codec.Validate, the data and RIFF chunk
+// sizes are also checked.
+func NewDecoderWith(r ReadSeekerCloser, s codec.Strictness) (*Decoder, error) {
+	base, e := r.Seek(0, io.SeekCurrent)
 	if e != nil {
 		return nil, e
 	}
-	dc, e := riff.findChunk(r, _dat4Cc)
+	return newDecoder(r, r, base, s)
+}
+
+// newDecoder creates a decoder reading the headers from hr, which
+// is either r or a non-seeking view of r.  base is the offset in r
+// of the start of the wav data.
+func newDecoder(r ReadSeekerCloser, hr io.Reader, base int64, s codec.Strictness) (*Decoder, error) {
+	f, dc, e := readHeaders(hr, s)
 	if e != nil {
 		return nil, e
 	}
-	nFrm := dc.length / (f.Bytes() * f.Channels())
-
-	//df := f.Decoder()
-	bd := int(f.Bytes())
 	frameSize := f.Bytes() * f.Channels()
-	bufSize := frameSize * 1024
-	buf := make([]byte, bufSize)
+	bufFrames := MaxChunkAlloc / frameSize
+	if bufFrames > 1024 {
+		bufFrames = 1024
+	}
 	res := &Decoder{
 		fmt:    f,
 		dChunk: dc,
+		base:   base,
 		r:      r,
-		buf:    buf,
-		p:      0,
-		e:      0,
-		n:      0,
-		nFrm:   nFrm,
-		//dFunc:     df,
-		byteDepth: bd}
+		buf:    make([]byte, frameSize*bufFrames),
+		nFrm:   int64(dc.length / frameSize)}
 	return res, nil
 }
 
+// readHeaders reads the riff header, format chunk, and the header of the data
+// chunk, leaving r positioned at the start of the sample data.  Violations
+// are handled according to s.
+func readHeaders(r io.Reader, s codec.Strictness) (*Format, *chunk, error) {
+	c := &checker{s: s}
+	riff, fcc, e := readRiff(r)
+	if e != nil {
+		return nil, nil, c.fatal(e)
+	}
+	if fcc != _wave4Cc {
+		return nil, nil, c.fatal(errs.Decode(codec.ErrUnsupportedFormat, 8, "RIFF", "form type %q is not WAVE", fcc[:]))
+	}
+	fc, err := riff.findChunk(r, _fmt4Cc)
+	if err != nil {
+		return nil, nil, c.fatal(err)
+	}
+	f, e := parseFormat(r, fc.length, fc.start+chunkHdrSize, c)
+	if e != nil {
+		return nil, nil, e
+	}
+	dc, e := riff.findChunk(r, _dat4Cc)
+	if e != nil {
+		return nil, nil, c.fatal(e)
+	}
+	if bpf := f.Bytes() * f.Channels(); dc.length%bpf != 0 {
+		c.pedantic(errs.Decode(codec.ErrCorrupt, dc.start+4, "data", "size %d is not a multiple of frame size %d", dc.length, bpf))
+	}
+	if end, rEnd := dc.start+chunkHdrSize+int64(dc.length), int64(riff.length)+chunkHdrSize; end > rEnd {
+		c.pedantic(errs.Decode(codec.ErrCorrupt, 4, "RIFF", "size %d ends before the data chunk ends at %d", riff.length, end))
+	}
+	if e := c.err(); e != nil {
+		return nil, nil, e
+	}
+	return f, dc, nil
+}
+
 // sound.Source methods
 
 var _ sound.Source = (*Decoder)(nil)
@@ -99,65 +131,53 @@ func (d *Decoder) Receive(dst []float64) (int, error) {
 	if len(dst)%nC != 0 {
 		return 0, sound.ErrChannelAlignment
 	}
-	var err error
-	var c, f int
-	nF := len(dst) / nC
-	for i := range dst {
-		dst[c*nF+f], err = d.sample()
-		if err == io.EOF {
-			if i == 0 {
-				return 0, io.EOF
-			}
-			return f, nil
+	nF := int64(len(dst) / nC)
+	if rem := d.nFrm - d.pos; nF > rem {
+		nF = rem
+	}
+	if nF <= 0 {
+		return 0, io.EOF
+	}
+	if cap(d.tmp) < int(nF)*nC {
+		d.tmp = make([]float64, int(nF)*nC)
+	}
+	tmp := d.tmp[:int(nF)*nC]
+	bpf := int(d.bpf())
+	n := 0
+	for n < int(nF) {
+		m := int(nF) - n
+		if mx := len(d.buf) / bpf; m > mx {
+			m = mx
+		}
+		k, err := io.ReadFull(d.r, d.buf[:m*bpf])
+		k /= bpf
+		d.Codec().Decode(tmp[n*nC:(n+k)*nC], d.buf[:k*bpf])
+		n += k
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// the data chunk is truncated.
+			d.nFrm = d.pos + int64(n)
+			break
 		}
 		if err != nil {
 			return 0, err
 		}
-		c++
-		if c == nC {
-			c = 0
-			f++
-		}
 	}
-	return f, nil
-}
-
-func (d *Decoder) sample() (float64, error) {
-	p, rd := d.p>>1, d.p&1 == 1
-	if !rd {
-		n, e := d.r.Read(d.buf)
-		if n > 0 {
-			d.e = n
-		} else if e == nil {
-			panic("read with 0 bytes no error")
-		} else {
-			return 0, e
-		}
+	if n == 0 {
+		return 0, io.EOF
 	}
-	// here we have successfully read sometime in the past.
-	q := p + d.byteDepth
-	var vs [1]float64
-	d.Codec().Decode(vs[:], d.buf[p:q])
-	s := vs[0]
-	//s := d.dFunc(d.buf[p:q])
-	//fmt.Printf("decoded %d from buffer[%d:%d]\n", s, p, q)
-	if q == d.e {
-		d.p = 0
-		d.n++ // more precise: d.n += d.e and reinterpret d.n elsewhere?
-	} else {
-		d.p = (q << 1) | 1
+	for f := 0; f < n; f++ {
+		for c := 0; c < nC; c++ {
+			dst[c*n+f] = tmp[f*nC+c]
+		}
 	}
-	d.frms++
-
-	return s, nil
+	d.pos += int64(n)
+	return n, nil
 }
 
 // sound.Seeker methods
 
 func (d *Decoder) Pos() int64 {
-	q := int64((d.p >> 1)) / d.bpf()
-	o := int64(d.n*len(d.buf)) / d.bpf()
-	return int64(q + o)
+	return d.pos
 }
 
 func (d *Decoder) When() time.Duration {
@@ -166,29 +186,23 @@ func (d *Decoder) When() time.Duration {
 }
 
 func (d *Decoder) Len() int64 {
-	return int64(d.nFrm)
+	return d.nFrm
 }
 
 func (d *Decoder) Duration() time.Duration {
 	return time.Duration(d.Len()) * d.fdur()
 }
 
+// Seek seeks to frame f, returning an error if f is negative.  Seeking
+// beyond the end is allowed, after which Receive returns io.EOF.
 func (d *Decoder) Seek(f int64) error {
-	bpf := d.bpf()
-	fpb := int64(len(d.buf)) / bpf
-	nBuf := f / fpb
-	m := f % fpb
-	rd := d.p&1 == 1
-
-	if e := d.dChunk.Seek(d.r, nBuf*int64(len(d.buf))); e != nil {
-		return e
+	if f < 0 {
+		return fmt.Errorf("wav: seek to negative frame %d", f)
 	}
-	if d.n == int(nBuf) && rd {
-		d.p = int(((m * bpf) << 1) | 1)
-		return nil
+	if e := d.dChunk.Seek(d.r, d.base+f*d.bpf()); e != nil {
+		return e
 	}
-	d.n = int(nBuf)
-	d.p = int((m * bpf) << 1)
+	d.pos = f
 	return nil
 }
 
@@ -5,6 +5,7 @@ package wav
 
 import (
 	"encoding/binary"
+	"io"
 	"os"
 
 	"zikichombo.org/sound"
@@ -14,7 +15,7 @@ import (
 
 // Encoder encapsulates state for encoding a (pcm) wav file.
 type Encoder struct {
-	w   *os.File
+	w   WriteSeekerCloser
 	h   *hdr
 	f   *Format
 	buf []byte
@@ -23,9 +24,16 @@ type Encoder struct {
 	//eFunc func([]byte, float64)
 }
 
+// WriteSeekerCloser is the destination of an Encoder, which seeks
+// back to the headers on Close.
+type WriteSeekerCloser interface {
+	io.WriteSeeker
+	io.Closer
+}
+
 // NewEncoder creates a new encoder with the specified
 // format to the writer/seeker w.
-func NewEncoder(f *Format, w *os.File) (*Encoder, error) {
+func NewEncoder(f *Format, w WriteSeekerCloser) (*Encoder, error) {
 	enc := &Encoder{w: w, f: f}
 	h := &hdr{}
 	enc.h = h
@@ -125,7 +133,8 @@ func (e *Encoder) Close() error {
 		return err
 	}
 	buf := make([]byte, 4)
-	binary.LittleEndian.PutUint32(buf, uint32(audioBytes)+uint32(e.f.chunkSize())+uint32(chunkHdrSize))
+	// the riff size includes the form type, the format chunk, and the data chunk.
+	binary.LittleEndian.PutUint32(buf, 4+uint32(audioBytes)+uint32(e.f.chunkSize())+uint32(chunkHdrSize))
 	_, err = e.w.Write(buf)
 	if err != nil {
 		return err
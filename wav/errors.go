@@ -0,0 +1,67 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package wav
+
+import (
+	"zikichombo.org/codec"
+	"zikichombo.org/codec/internal/decerr"
+)
+
+// errs makes the *codec.DecodeErrors of package wav.
+const errs = decerr.Codec("wav")
+
+// fieldOff returns the offset of a field k bytes after off, or -1 if off
+// is unknown.
+func fieldOff(off int64, k int) int64 {
+	if off < 0 {
+		return -1
+	}
+	return off + int64(k)
+}
+
+// checker applies a codec.Strictness to the violations found in parsing.
+type checker struct {
+	s    codec.Strictness
+	errs []*codec.DecodeError
+}
+
+// fatal returns err, which prevents decoding in any mode, along with any
+// violations already recorded.
+func (c *checker) fatal(err error) error {
+	de, ok := err.(*codec.DecodeError)
+	if c.s != codec.Validate || !ok {
+		return err
+	}
+	c.errs = append(c.errs, de)
+	return c.err()
+}
+
+// repair handles a violation err which lenient parsing can repair.  It
+// returns err if the caller should stop, and otherwise nil, in which case
+// the caller should repair the data.
+func (c *checker) repair(err error) error {
+	switch c.s {
+	case codec.Strict:
+		return err
+	case codec.Validate:
+		c.pedantic(err)
+	}
+	return nil
+}
+
+// pedantic records a violation err which only matters in validation.
+func (c *checker) pedantic(err error) {
+	if c.s == codec.Validate {
+		c.errs = append(c.errs, err.(*codec.DecodeError))
+	}
+}
+
+// err returns the recorded violations as a *codec.ValidationError, or nil if
+// there are none.
+func (c *checker) err() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return &codec.ValidationError{Errs: c.errs}
+}
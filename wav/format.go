@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 
+	"zikichombo.org/codec"
 	"zikichombo.org/sound"
 	"zikichombo.org/sound/freq"
 	"zikichombo.org/sound/sample"
@@ -77,6 +78,9 @@ func NewStereoFmt() *Format {
 // other cases can add more
 const fmtStartChunkSize = 2 + 2 + 4 + 4 + 2 + 2
 
+// maxBlockAlign is the largest block align, or frame size, of a format.
+const maxBlockAlign = 1<<16 - 1
+
 func (f *Format) chunkSize() int {
 	res := 4 + 4 + 2 + 2 + 4 + 4 + 2 + 2
 	if f.Codec.IsFloat() {
@@ -85,62 +89,117 @@ func (f *Format) chunkSize() int {
 	return res
 }
 
-// ParseFormat parses a format from a reader, returning a non-nil error
-// if there is a problem.
+// ParseFormat parses a format chunk of N bytes from a reader, returning a
+// non-nil error if there is a problem.  Errors in the format are reported
+// as a *codec.DecodeError.
 func ParseFormat(r io.Reader, N int) (*Format, error) {
+	return ParseFormatWith(r, N, codec.Strict)
+}
+
+// ParseFormatWith is like ParseFormat but applies strictness s.
+//
+// With codec.Lenient, inconsistent byte rate and block align fields are
+// recomputed from the other fields, and PCM format chunks longer than 16
+// bytes, such as the 18 byte chunks written by some tools, are accepted.
+//
+// With codec.Validate, a *codec.ValidationError listing every violation
+// found is returned if there are any.
+func ParseFormatWith(r io.Reader, N int, s codec.Strictness) (*Format, error) {
+	c := &checker{s: s}
+	f, err := parseFormat(r, N, -1, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// parseFormat is ParseFormatWith for a chunk whose data is at offset off,
+// or -1 if unknown.  Violations are handled by c.
+func parseFormat(r io.Reader, N int, off int64, c *checker) (*Format, error) {
+	fmtErr := func(err error, k int, format string, args ...interface{}) error {
+		return errs.Decode(err, fieldOff(off, k), "fmt ", format, args...)
+	}
 	if N < fmtStartChunkSize {
-		return nil, fmt.Errorf("format chunk too small: %d", N)
+		return nil, c.fatal(fmtErr(codec.ErrCorrupt, -4, "format chunk too small: %d", N))
 	}
-	buf := make([]byte, N)
-	n, e := r.Read(buf)
-	if e != nil && e != io.EOF {
+	// only the fields we use are read, so that the allocation does not
+	// depend on N.
+	var hdr [fmtStartChunkSize + 2]byte
+	buf := hdr[:]
+	if N < len(hdr) {
+		buf = hdr[:N]
+	}
+	if n, e := io.ReadFull(r, buf); e != nil {
+		if e == io.EOF || e == io.ErrUnexpectedEOF {
+			return nil, c.fatal(fmtErr(codec.ErrTruncated, n, "only read %d/%d bytes of format", n, N))
+		}
 		return nil, e
 	}
-	if n != N {
-		return nil, fmt.Errorf("only read %d/%d bytes of format", n, N)
+	if e := skip(r, N-len(buf)); e != nil {
+		return nil, c.fatal(errs.Read(e, fieldOff(off, len(buf)), "fmt "))
 	}
 	tag := binary.LittleEndian.Uint16(buf[:2])
 	if tag != _TAG_PCM && tag != _TAG_FLOAT32 {
-		return nil, fmt.Errorf("tag isn't for PCM wav data: %d", tag)
+		return nil, c.fatal(fmtErr(codec.ErrUnsupportedFormat, 0, "unsupported format tag: %d", tag))
 	}
 	channels := int(binary.LittleEndian.Uint16(buf[2:4]))
 	frq := int(binary.LittleEndian.Uint32(buf[4:8]))
 	bps := binary.LittleEndian.Uint32(buf[8:12])
 	block := int(binary.LittleEndian.Uint16(buf[12:14]))
 	bitDepth := binary.LittleEndian.Uint16(buf[14:16])
-	if bps != uint32(frq)*uint32(block) {
-		return nil, fmt.Errorf("bytes per sec is %d not %d", bps, frq*block)
+	if channels == 0 {
+		return nil, c.fatal(fmtErr(codec.ErrCorrupt, 2, "no channels"))
 	}
-	if block*8 != int(bitDepth)*channels {
-		return nil, fmt.Errorf("block align %d != %d", block, int(bitDepth)*channels/8)
+	if frq == 0 {
+		return nil, c.fatal(fmtErr(codec.ErrCorrupt, 4, "sample rate is 0"))
 	}
-	aFreq := freq.T(frq) * freq.Hertz
-	if tag == _TAG_FLOAT32 {
-		if N != fmtStartChunkSize+2 {
-			//return nil, fmt.Errorf("warning, wav format chunk too short but has full Float32 spec\n")
-		}
-		return &Format{channels: channels, freq: aFreq, Codec: sample.SFloat32L}, nil
-	}
-	if tag != _TAG_PCM {
-		return nil, fmt.Errorf("unsupported format tag: %d", tag)
-	}
-	if N != fmtStartChunkSize {
-		return nil, fmt.Errorf("bad format chunk size: %d", N)
-	}
-	var f *Format
-	switch bitDepth {
-	case 8:
-		f = &Format{channels: channels, freq: aFreq, Codec: sample.SByte}
-	case 16:
-		f = &Format{channels: channels, freq: aFreq, Codec: sample.SInt16L}
-	case 24:
-		f = &Format{channels: channels, freq: aFreq, Codec: sample.SInt24L}
-	case 32:
-		f = &Format{channels: channels, freq: aFreq, Codec: sample.SInt32L}
+	var sc sample.Codec
+	switch {
+	case tag == _TAG_FLOAT32 && bitDepth == 32:
+		sc = sample.SFloat32L
+	case tag == _TAG_FLOAT32:
+		return nil, c.fatal(fmtErr(codec.ErrUnsupportedFormat, 14, "unsupported float bit depth: %d", bitDepth))
+	case bitDepth == 8:
+		sc = sample.SByte
+	case bitDepth == 16:
+		sc = sample.SInt16L
+	case bitDepth == 24:
+		sc = sample.SInt24L
+	case bitDepth == 32:
+		sc = sample.SInt32L
 	default:
-		return nil, fmt.Errorf("unsupported bit depth: %d", bitDepth)
+		return nil, c.fatal(fmtErr(codec.ErrUnsupportedFormat, 14, "unsupported bit depth: %d", bitDepth))
 	}
-	return f, nil
+	if want := int(bitDepth) * channels / 8; want > maxBlockAlign {
+		return nil, c.fatal(fmtErr(codec.ErrCorrupt, 2, "%d channels of %d bits exceed the largest block align", channels, bitDepth))
+	} else if block != want {
+		if err := c.repair(fmtErr(codec.ErrCorrupt, 12, "block align %d != %d", block, want)); err != nil {
+			return nil, err
+		}
+		block = want
+	}
+	if want := uint64(frq) * uint64(block); uint64(bps) != want {
+		if err := c.repair(fmtErr(codec.ErrCorrupt, 8, "bytes per sec is %d not %d", bps, want)); err != nil {
+			return nil, err
+		}
+	}
+	if N >= fmtStartChunkSize+2 {
+		if cb := int(binary.LittleEndian.Uint16(buf[16:18])); cb != N-fmtStartChunkSize-2 {
+			c.pedantic(fmtErr(codec.ErrCorrupt, 16, "extension size %d in %d byte format", cb, N))
+		}
+	}
+	switch {
+	case tag == _TAG_PCM && N != fmtStartChunkSize:
+		if err := c.repair(fmtErr(codec.ErrCorrupt, -4, "bad format chunk size: %d", N)); err != nil {
+			return nil, err
+		}
+	case tag == _TAG_FLOAT32 && N != fmtStartChunkSize+2:
+		c.pedantic(fmtErr(codec.ErrCorrupt, -4, "float format chunk size %d not %d", N, fmtStartChunkSize+2))
+	}
+	return &Format{channels: channels, freq: freq.T(frq) * freq.Hertz, Codec: sc}, nil
 }
 
 // Write writes a wav format chunk to a writer, returning an error if there is an
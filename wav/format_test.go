@@ -5,8 +5,11 @@ package wav
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"testing"
 
+	"zikichombo.org/codec"
 	"zikichombo.org/sound"
 	"zikichombo.org/sound/sample"
 )
@@ -36,3 +39,128 @@ func testFormatIo(t *testing.T, f *Format) {
 		t.Errorf("codec mismatch got %s not %s\n", g.Codec, f.Codec)
 	}
 }
+
+// wavBytes returns a wav file with format chunk data fmtData followed by
+// a data chunk with data, or no data chunk if data is nil.
+func wavBytes(fmtData, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF\x00\x00\x00\x00WAVE")
+	chunk := func(id string, d []byte) {
+		var hdr [4]byte
+		binary.LittleEndian.PutUint32(hdr[:], uint32(len(d)))
+		buf.WriteString(id)
+		buf.Write(hdr[:])
+		buf.Write(d)
+	}
+	chunk("fmt ", fmtData)
+	if data != nil {
+		chunk("data", data)
+	}
+	b := buf.Bytes()
+	binary.LittleEndian.PutUint32(b[4:8], uint32(len(b)-8))
+	return b
+}
+
+// fmtBytes returns format chunk data with the given fields.
+func fmtBytes(tag, channels int, rate, bps uint32, block, bits int) []byte {
+	b := make([]byte, fmtStartChunkSize)
+	binary.LittleEndian.PutUint16(b[0:2], uint16(tag))
+	binary.LittleEndian.PutUint16(b[2:4], uint16(channels))
+	binary.LittleEndian.PutUint32(b[4:8], rate)
+	binary.LittleEndian.PutUint32(b[8:12], bps)
+	binary.LittleEndian.PutUint16(b[12:14], uint16(block))
+	binary.LittleEndian.PutUint16(b[14:16], uint16(bits))
+	return b
+}
+
+func TestDecodeErrors(t *testing.T) {
+	good := fmtBytes(_TAG_PCM, 2, 8000, 32000, 4, 16)
+	for _, tc := range []struct {
+		name  string
+		data  []byte
+		err   error
+		off   int64
+		chunk string
+	}{
+		{"short riff", []byte("RIFF\x10\x00"), codec.ErrTruncated, 0, ""},
+		{"not riff", []byte("RIFX\x10\x00\x00\x00WAVE"), codec.ErrUnsupportedFormat, 0, ""},
+		{"not wave", []byte("RIFF\x10\x00\x00\x00AVI "), codec.ErrUnsupportedFormat, 8, "RIFF"},
+		{"no data", wavBytes(good, nil), codec.ErrTruncated, 36, ""},
+		{"short fmt", wavBytes(good, nil)[:28], codec.ErrTruncated, 28, "fmt "},
+		{"short fmt header", wavBytes(good, nil)[:16], codec.ErrTruncated, 12, ""},
+		{"tag", wavBytes(fmtBytes(2, 2, 8000, 32000, 4, 16), []byte{}), codec.ErrUnsupportedFormat, 20, "fmt "},
+		{"bytes per sec", wavBytes(fmtBytes(_TAG_PCM, 2, 8000, 16000, 4, 16), []byte{}), codec.ErrCorrupt, 28, "fmt "},
+		{"block align", wavBytes(fmtBytes(_TAG_PCM, 2, 8000, 16000, 2, 16), []byte{}), codec.ErrCorrupt, 32, "fmt "},
+		{"channels", wavBytes(fmtBytes(_TAG_PCM, 0, 8000, 32000, 4, 16), []byte{}), codec.ErrCorrupt, 22, "fmt "},
+		{"bit depth", wavBytes(fmtBytes(_TAG_PCM, 1, 8000, 96000, 12, 96), []byte{}), codec.ErrUnsupportedFormat, 34, "fmt "},
+	} {
+		_, err := NewDecoder(sectionCloser{bytes.NewReader(tc.data)})
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: got %v not %v", tc.name, err, tc.err)
+			continue
+		}
+		var de *codec.DecodeError
+		if !errors.As(err, &de) {
+			t.Errorf("%s: %v is not a *codec.DecodeError", tc.name, err)
+			continue
+		}
+		if de.Codec != "wav" || de.Offset != tc.off || de.Chunk != tc.chunk {
+			t.Errorf("%s: got %q at %d in %q, expected %d in %q", tc.name, de.Codec, de.Offset, de.Chunk, tc.off, tc.chunk)
+		}
+	}
+	if _, err := NewDecoder(sectionCloser{bytes.NewReader(wavBytes(good, []byte{}))}); err != nil {
+		t.Errorf("valid header: %v", err)
+	}
+}
+
+func TestStrictness(t *testing.T) {
+	// an 18 byte PCM format chunk with block align and byte rate for 8 bit
+	// samples but 16 bit depth.
+	f := append(fmtBytes(_TAG_PCM, 2, 8000, 16000, 2, 16), 0, 0)
+	data := wavBytes(f, make([]byte, 40))
+
+	_, err := NewDecoderWith(sectionCloser{bytes.NewReader(data)}, codec.Strict)
+	if !errors.Is(err, codec.ErrCorrupt) {
+		t.Errorf("strict: expected codec.ErrCorrupt got %v", err)
+	}
+
+	d, err := NewDecoderWith(sectionCloser{bytes.NewReader(data)}, codec.Lenient)
+	if err != nil {
+		t.Fatalf("lenient: %v", err)
+	}
+	if d.Channels() != 2 || d.Codec() != sample.SInt16L || d.Len() != 10 {
+		t.Errorf("lenient: decoded %d channels of %s, %d frames", d.Channels(), d.Codec(), d.Len())
+	}
+
+	// a data size which is not a multiple of the frame size, and a riff size
+	// which ends before the data.
+	binary.LittleEndian.PutUint32(data[42:46], 39)
+	binary.LittleEndian.PutUint32(data[4:8], 20)
+	_, err = NewDecoderWith(sectionCloser{bytes.NewReader(data)}, codec.Validate)
+	var ve *codec.ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("validate: expected *codec.ValidationError got %v", err)
+	}
+	var offs []int64
+	for _, de := range ve.Errs {
+		offs = append(offs, de.Offset)
+	}
+	// block align, byte rate, fmt size, data size, and riff size.
+	want := []int64{32, 28, 16, 42, 4}
+	if len(offs) != len(want) {
+		t.Fatalf("validate: got %v", err)
+	}
+	for i := range want {
+		if offs[i] != want[i] {
+			t.Errorf("validate: violation %d at offset %d not %d: %v", i, offs[i], want[i], ve.Errs[i])
+		}
+	}
+	if !errors.Is(err, codec.ErrCorrupt) {
+		t.Errorf("validate: %v is not codec.ErrCorrupt", err)
+	}
+
+	_, err = NewDecoderWith(sectionCloser{bytes.NewReader(wavBytes(fmtBytes(_TAG_PCM, 1, 8000, 16000, 2, 16), []byte{1, 2}))}, codec.Validate)
+	if err != nil {
+		t.Errorf("validate valid data: %v", err)
+	}
+}
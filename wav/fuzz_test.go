@@ -0,0 +1,197 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+//go:build go1.18
+// +build go1.18
+
+package wav
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+
+	"zikichombo.org/codec"
+)
+
+var strictnesses = []codec.Strictness{codec.Strict, codec.Lenient, codec.Validate}
+
+// seedFormats returns format chunk data of valid and corrupt formats.
+func seedFormats() [][]byte {
+	return [][]byte{
+		fmtBytes(_TAG_PCM, 1, 8000, 16000, 2, 16),
+		fmtBytes(_TAG_PCM, 2, 44100, 44100*6, 6, 24),
+		fmtBytes(_TAG_PCM, 6, 48000, 48000*24, 24, 32),
+		fmtBytes(_TAG_PCM, 1, 8000, 8000, 1, 8),
+		append(fmtBytes(_TAG_FLOAT32, 2, 48000, 48000*8, 8, 32), 0, 0),
+		append(fmtBytes(_TAG_PCM, 2, 8000, 16000, 2, 16), 0, 0),
+		fmtBytes(_TAG_PCM, 0, 8000, 0, 0, 16),
+		fmtBytes(_TAG_PCM, 0xffff, 8000, 0, 0, 32),
+		fmtBytes(_TAG_FLOAT32, 1, 0, 0, 8, 64),
+		fmtBytes(2, 2, 8000, 32000, 4, 16)}
+}
+
+// seedFiles returns wav files, valid and corrupt.
+func seedFiles() [][]byte {
+	var res [][]byte
+	for i, f := range seedFormats() {
+		w := wavBytes(f, make([]byte, 48*i))
+		res = append(res, w, w[:len(w)/2], w[:hdrChunkSize+4])
+	}
+	// a list chunk before the format chunk and an odd sized data chunk.
+	lst := []byte("LIST\x04\x00\x00\x00INFO")
+	w := wavBytes(fmtBytes(_TAG_PCM, 1, 8000, 16000, 2, 16), make([]byte, 7))
+	w = append(append(append([]byte{}, w[:hdrChunkSize]...), lst...), w[hdrChunkSize:]...)
+	res = append(res, w)
+	// a chunk claiming 4GB.
+	res = append(res, []byte("RIFF\xff\xff\xff\xffWAVEfmt \xff\xff\xff\xff"))
+	return res
+}
+
+// allocated returns the number of bytes allocated while calling f.
+func allocated(f func()) uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	before := ms.TotalAlloc
+	f()
+	runtime.ReadMemStats(&ms)
+	return ms.TotalAlloc - before
+}
+
+// allocLimit is the number of bytes which parsing n bytes may allocate:
+// MaxChunkAlloc for buffers, and an amount proportional to n for the chunk
+// structure, with an allowance for the fuzzing machinery.
+func allocLimit(n int) uint64 {
+	return 2*MaxChunkAlloc + 64*uint64(n) + 64<<10
+}
+
+func FuzzParseFormat(f *testing.F) {
+	for _, d := range seedFormats() {
+		f.Add(d)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, s := range strictnesses {
+			for _, N := range []int{len(data), 1<<32 - 1} {
+				var fm *Format
+				var err error
+				a := allocated(func() {
+					fm, err = ParseFormatWith(bytes.NewReader(data), N, s)
+				})
+				if a > allocLimit(0) {
+					t.Errorf("%s: parsing %d byte format allocated %d bytes", s, N, a)
+				}
+				if err != nil {
+					continue
+				}
+				if fm.Channels() <= 0 || fm.Bytes()*fm.Channels() > maxBlockAlign || fm.SampleRate() <= 0 {
+					t.Errorf("%s: accepted format %s", s, fm)
+				}
+			}
+		}
+	})
+}
+
+func FuzzReadRiff(f *testing.F) {
+	for _, d := range seedFiles() {
+		f.Add(d)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+		a := allocated(func() {
+			riff, _, err := readRiff(r)
+			if err != nil {
+				return
+			}
+			end := int64(0)
+			for {
+				c, err := riff.readChunk(r)
+				if err != nil {
+					return
+				}
+				if c.start < end {
+					t.Fatalf("chunk at %d before end of previous chunk at %d", c.start, end)
+				}
+				end = c.start + chunkHdrSize + int64(c.length)
+				if err := skip(r, c.length); err != nil {
+					return
+				}
+			}
+		})
+		if a > allocLimit(len(data)) {
+			t.Errorf("reading %d byte riff allocated %d bytes", len(data), a)
+		}
+	})
+}
+
+func FuzzNewDecoder(f *testing.F) {
+	for _, d := range seedFiles() {
+		f.Add(d)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, s := range strictnesses {
+			var d *Decoder
+			var err error
+			a := allocated(func() {
+				d, err = NewDecoderWith(sectionCloser{bytes.NewReader(data)}, s)
+			})
+			if a > allocLimit(len(data)) {
+				t.Errorf("%s: decoding %d byte file allocated %d bytes", s, len(data), a)
+			}
+			if err != nil {
+				continue
+			}
+			n := receiveAll(t, d)
+			if n > d.Len() {
+				t.Errorf("%s: received %d frames of %d", s, n, d.Len())
+			}
+			if err := d.Seek(d.Len() / 2); err != nil {
+				t.Fatal(err)
+			}
+			if m := receiveAll(t, d); m > d.Len()-d.Len()/2 {
+				t.Errorf("%s: received %d frames after seeking to %d of %d", s, m, d.Len()/2, d.Len())
+			}
+		}
+	})
+}
+
+func FuzzCodecDecoder(f *testing.F) {
+	for _, d := range seedFiles() {
+		f.Add(d)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var src interface{}
+		var err error
+		a := allocated(func() {
+			src, _, err = codec.Decoder(ioutil.NopCloser(bytes.NewReader(data)), nil)
+		})
+		// the registry's sniffing buffers the input.
+		if a > allocLimit(len(data))+4096 {
+			t.Errorf("decoding %d byte file allocated %d bytes", len(data), a)
+		}
+		if err != nil {
+			return
+		}
+		receiveAll(t, src.(*Decoder))
+	})
+}
+
+// receiveAll receives the rest of d, returning the number of frames.
+func receiveAll(t *testing.T, d *Decoder) int64 {
+	buf := make([]float64, 100*d.Channels())
+	var n int64
+	for {
+		m, err := d.Receive(buf)
+		n += int64(m)
+		if err == io.EOF {
+			return n
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m == 0 {
+			t.Fatal("Receive returned 0 frames without error")
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+
+	"zikichombo.org/codec"
 )
 
 // Top level header of a WAV file
@@ -19,18 +21,14 @@ type hdr struct {
 // Read reads the header returning an error if the format is unexpected.
 func (h *hdr) Read(r io.Reader) error {
 	buf := make([]byte, 12)
-	n, e := r.Read(buf)
-	if e != nil && e != io.EOF {
-		return e
-	}
-	if n != 12 {
-		return fmt.Errorf("didn't read all of hdr")
+	if _, e := io.ReadFull(r, buf); e != nil {
+		return errs.Read(e, 0, "")
 	}
 	if buf[0] != 'R' || buf[1] != 'I' || buf[2] != 'F' || buf[3] != 'F' {
-		return fmt.Errorf("doesn't start with 'RIFF'")
+		return errs.Decode(codec.ErrUnsupportedFormat, 0, "", "doesn't start with 'RIFF'")
 	}
 	if buf[8] != 'W' || buf[9] != 'A' || buf[10] != 'V' || buf[11] != 'E' {
-		return fmt.Errorf("not wave header")
+		return errs.Decode(codec.ErrUnsupportedFormat, 8, "RIFF", "not wave header")
 	}
 	h.Length = binary.LittleEndian.Uint32(buf[4:8])
 	return nil
@@ -5,9 +5,11 @@ package wav
 
 import (
 	"encoding/binary"
-	"errors"
 	"io"
+	"io/ioutil"
 	"os"
+
+	"zikichombo.org/codec"
 )
 
 type fourCc [4]byte
@@ -22,6 +24,11 @@ var (
 
 const chunkHdrSize = 8
 
+// MaxChunkAlloc is the largest number of bytes allocated when decoding
+// because of a size read from the data, such as a chunk size.  Larger
+// chunks are skipped or read incrementally.
+const MaxChunkAlloc = 1 << 16
+
 func (f fourCc) isList() bool {
 	return f == _riff4Cc || f == _list4Cc
 }
@@ -34,16 +41,15 @@ type chunk struct {
 	children []*chunk
 }
 
+// readChunk reads the header of a chunk at offset off.  It returns io.EOF
+// if there is no data, and a *codec.DecodeError if the header is truncated.
 func readChunk(r io.Reader, off int64) (*chunk, error) {
 	var buf [8]byte
-	ttl, n := 0, 0
-	var err error
-	for ttl < 8 {
-		n, err = r.Read(buf[ttl:])
-		if err != nil {
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		if err == io.EOF {
 			return nil, err
 		}
-		ttl += n
+		return nil, errs.Read(err, off, "")
 	}
 	c := &chunk{}
 	copy(c.fourCc[:], buf[:4])
@@ -53,12 +59,7 @@ func readChunk(r io.Reader, off int64) (*chunk, error) {
 }
 
 func (c *chunk) readChunk(r io.Reader) (*chunk, error) {
-	start := c.start + 8
-	if len(c.children) != 0 {
-		p := c.children[len(c.children)-1]
-		start = p.start + int64(p.length) + 8
-	}
-	child, err := readChunk(r, start)
+	child, err := readChunk(r, c.end())
 	if err != nil {
 		return nil, err
 	}
@@ -70,14 +71,28 @@ func (c *chunk) readChunk(r io.Reader) (*chunk, error) {
 func (c *chunk) findChunk(r io.Reader, fcc fourCc) (*chunk, error) {
 	for {
 		nxt, err := c.readChunk(r)
+		if err == io.EOF {
+			return nil, errs.Decode(codec.ErrTruncated, c.end(), "", "no %q chunk", fcc[:])
+		}
 		if err != nil {
 			return nil, err
 		}
 		if string(nxt.fourCc[:]) == string(fcc[:]) {
 			return nxt, nil
 		}
-		skip(r, int(nxt.length))
+		if err := skip(r, int(nxt.length)); err != nil {
+			return nil, errs.Read(err, nxt.start+chunkHdrSize, string(nxt.fourCc[:]))
+		}
+	}
+}
+
+// end returns the offset just after the last child of c read so far.
+func (c *chunk) end() int64 {
+	if len(c.children) == 0 {
+		return c.start + chunkHdrSize
 	}
+	p := c.children[len(c.children)-1]
+	return p.start + int64(p.length) + chunkHdrSize
 }
 
 func skip(r io.Reader, n int) error {
@@ -85,17 +100,14 @@ func skip(r io.Reader, n int) error {
 		_, err := s.Seek(int64(n), os.SEEK_CUR)
 		return err
 	}
-	buf := make([]byte, n)
-	ttl, m := 0, 0
-	var err error
-	for ttl < n {
-		m, err = r.Read(buf[ttl:])
-		if err != nil {
-			return err
-		}
-		ttl += m
+	m, err := io.CopyN(ioutil.Discard, r, int64(n))
+	if m == int64(n) {
+		return nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
 	}
-	return nil
+	return err
 }
 
 func (c *chunk) Seek(s io.Seeker, off int64) error {
@@ -112,22 +124,18 @@ func (c *chunk) writeHdr(w io.Writer) error {
 }
 
 func readRiff(r io.Reader) (*chunk, fourCc, error) {
-	var riff *chunk
-	var err error
+	var buf [12]byte
 	var fcc fourCc
-	riff, err = readChunk(r, 4)
-	if err != nil {
-		return nil, fcc, err
-	}
-	if string(riff.fourCc[:]) != "RIFF" {
-		return nil, fcc, errors.New("not a riff file")
-	}
-	n, e := r.Read(fcc[:])
-	if e != nil {
-		return nil, fcc, e
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, fcc, errs.Read(err, 0, "")
 	}
-	if n != 4 {
-		return nil, fcc, errors.New("couldn't read 4")
+	// the riff chunk starts at 0, but its children start after the form
+	// type, so we pretend it starts at 4.
+	riff := &chunk{start: 4, length: int(binary.LittleEndian.Uint32(buf[4:8]))}
+	copy(riff.fourCc[:], buf[:4])
+	copy(fcc[:], buf[8:])
+	if riff.fourCc != _riff4Cc {
+		return nil, fcc, errs.Decode(codec.ErrUnsupportedFormat, 0, "", "not a RIFF file")
 	}
 	return riff, fcc, nil
 }
@@ -73,7 +73,7 @@ func TestStereo(t *testing.T) {
 
 func TestBitDepth(t *testing.T) {
 	for _, sc := range []sample.Codec{sample.SByte, sample.SInt16L, sample.SInt24L, sample.SInt32L, sample.SFloat32L} {
-		fmt := NewFormat(1, 44100, sc)
+		fmt := NewFormat(1, 44100*freq.Hertz, sc)
 		encodeDecode(fmt, 128, t)
 	}
 }